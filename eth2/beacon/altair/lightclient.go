@@ -67,6 +67,8 @@ func (lcs *LightClientSnapshot) HashTreeRoot(spec *common.Spec, hFn tree.HashFn)
 // This is padded to 32, a depth of 5 bits
 const syncCommitteeProofLen = 5
 
+const CURRENT_SYNC_COMMITTEE_INDEX = tree.Gindex64((1 << syncCommitteeProofLen) | _currentSyncCommittee)
+
 const NEXT_SYNC_COMMITTEE_INDEX = tree.Gindex64((1 << syncCommitteeProofLen) | _nextSyncCommittee)
 
 var SyncCommitteeProofBranchType = VectorType(RootType, syncCommitteeProofLen)
@@ -380,3 +382,22 @@ func (lcou *LightClientOptimisticUpdate) HashTreeRoot(spec *common.Spec, hFn tre
 		&lcou.SignatureSlot,
 	)
 }
+
+func (lcfu *LightClientFinalityUpdate) Envelope(spec *common.Spec) *common.LightClientUpdateEnvelope {
+	return &common.LightClientUpdateEnvelope{
+		AttestedSlot:     lcfu.AttestedHeader.Beacon.Slot,
+		FinalizedSlot:    lcfu.FinalizedHeader.Slot,
+		SignatureSlot:    lcfu.SignatureSlot,
+		SyncParticipants: lcfu.SyncAggregate.SyncCommitteeBits.OnesCount(),
+		UpdateRoot:       lcfu.HashTreeRoot(spec, tree.GetHashFn()),
+	}
+}
+
+func (lcou *LightClientOptimisticUpdate) Envelope(spec *common.Spec) *common.LightClientUpdateEnvelope {
+	return &common.LightClientUpdateEnvelope{
+		AttestedSlot:     lcou.AttestedHeader.Beacon.Slot,
+		SignatureSlot:    lcou.SignatureSlot,
+		SyncParticipants: lcou.SyncAggregate.SyncCommitteeBits.OnesCount(),
+		UpdateRoot:       lcou.HashTreeRoot(spec, tree.GetHashFn()),
+	}
+}
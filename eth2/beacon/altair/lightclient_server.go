@@ -0,0 +1,194 @@
+package altair
+
+import (
+	"fmt"
+
+	"github.com/protolambda/ztyp/tree"
+
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/protolambda/zrnt/eth2/util/merkle"
+)
+
+// https://github.com/ethereum/consensus-specs/blob/dev/specs/altair/light-client/full-node.md
+
+// LightClientStateData is the light client data of a post-block state of Altair or later,
+// with the merkle branches taken from the tree backing of the state.
+type LightClientStateData struct {
+	// Header of the block that resulted in the state, with the state root filled in.
+	Header                     common.BeaconBlockHeader
+	CurrentSyncCommittee       common.SyncCommittee
+	CurrentSyncCommitteeBranch SyncCommitteeProofBranch
+	NextSyncCommittee          common.SyncCommittee
+	NextSyncCommitteeBranch    SyncCommitteeProofBranch
+	FinalizedCheckpoint        common.Checkpoint
+	FinalityBranch             FinalizedRootProofBranch
+}
+
+// NewLightClientStateData collects the light client data of a post-block state.
+// The state must not have been processed past the slot of the block.
+func NewLightClientStateData(spec *common.Spec, state common.SyncCommitteeBeaconState) (*LightClientStateData, error) {
+	slot, err := state.Slot()
+	if err != nil {
+		return nil, err
+	}
+	if epoch := spec.SlotToEpoch(slot); epoch < spec.ALTAIR_FORK_EPOCH {
+		return nil, fmt.Errorf("state epoch %d is before altair", epoch)
+	}
+	header, err := state.LatestBlockHeader()
+	if err != nil {
+		return nil, err
+	}
+	if header.Slot != slot {
+		return nil, fmt.Errorf("state slot %d does not match latest block header slot %d", slot, header.Slot)
+	}
+	hFn := tree.GetHashFn()
+	header.StateRoot = state.HashTreeRoot(hFn)
+
+	var out LightClientStateData
+	out.Header = *header
+	currentView, err := state.CurrentSyncCommittee()
+	if err != nil {
+		return nil, err
+	}
+	current, err := currentView.Raw()
+	if err != nil {
+		return nil, err
+	}
+	out.CurrentSyncCommittee = *current
+	nextView, err := state.NextSyncCommittee()
+	if err != nil {
+		return nil, err
+	}
+	next, err := nextView.Raw()
+	if err != nil {
+		return nil, err
+	}
+	out.NextSyncCommittee = *next
+	out.FinalizedCheckpoint, err = state.FinalizedCheckpoint()
+	if err != nil {
+		return nil, err
+	}
+
+	backing := state.Backing()
+	if err := stateProof(backing, CURRENT_SYNC_COMMITTEE_INDEX, hFn, out.CurrentSyncCommitteeBranch[:]); err != nil {
+		return nil, fmt.Errorf("failed to compute current sync committee branch: %w", err)
+	}
+	if err := stateProof(backing, NEXT_SYNC_COMMITTEE_INDEX, hFn, out.NextSyncCommitteeBranch[:]); err != nil {
+		return nil, fmt.Errorf("failed to compute next sync committee branch: %w", err)
+	}
+	if err := stateProof(backing, FINALIZED_ROOT_INDEX, hFn, out.FinalityBranch[:]); err != nil {
+		return nil, fmt.Errorf("failed to compute finality branch: %w", err)
+	}
+	return &out, nil
+}
+
+func stateProof(backing tree.Node, gindex tree.Gindex64, hFn tree.HashFn, dst []common.Root) error {
+	branch, err := merkle.ComputeMerkleProof(backing, gindex, hFn)
+	if err != nil {
+		return err
+	}
+	if len(branch) != len(dst) {
+		return fmt.Errorf("expected branch of length %d, got %d", len(dst), len(branch))
+	}
+	copy(dst, branch)
+	return nil
+}
+
+// CheckFinalizedHeader checks that the finalized header matches the finalized checkpoint of the state.
+// A finalized header at the genesis slot is represented by the zero header, and a zero checkpoint root.
+func (d *LightClientStateData) CheckFinalizedHeader(finalizedHeader *common.BeaconBlockHeader) error {
+	if finalizedHeader.Slot == common.GENESIS_SLOT {
+		if d.FinalizedCheckpoint.Root != (common.Root{}) {
+			return fmt.Errorf("genesis finalized header, but finalized checkpoint root is %s", d.FinalizedCheckpoint.Root)
+		}
+		return nil
+	}
+	if root := finalizedHeader.HashTreeRoot(tree.GetHashFn()); root != d.FinalizedCheckpoint.Root {
+		return fmt.Errorf("finalized header root %s does not match finalized checkpoint root %s", root, d.FinalizedCheckpoint.Root)
+	}
+	return nil
+}
+
+// CheckSignature checks that a sync aggregate at the signature slot can be used to sign the state's block.
+// It does not verify the signature itself.
+func (d *LightClientStateData) CheckSignature(spec *common.Spec, syncAggregate *SyncAggregate, signatureSlot common.Slot) error {
+	if participants := syncAggregate.SyncCommitteeBits.OnesCount(); participants < uint64(spec.MIN_SYNC_COMMITTEE_PARTICIPANTS) {
+		return fmt.Errorf("not enough sync committee participants: %d", participants)
+	}
+	if signatureSlot <= d.Header.Slot {
+		return fmt.Errorf("signature slot %d is not after attested slot %d", signatureSlot, d.Header.Slot)
+	}
+	return nil
+}
+
+// NewLightClientBootstrap creates a bootstrap for the given block root, from the post-state of the block.
+func NewLightClientBootstrap(spec *common.Spec, blockRoot common.Root, state common.SyncCommitteeBeaconState) (*LightClientBootstrap, error) {
+	data, err := NewLightClientStateData(spec, state)
+	if err != nil {
+		return nil, err
+	}
+	if root := data.Header.HashTreeRoot(tree.GetHashFn()); root != blockRoot {
+		return nil, fmt.Errorf("state header root %s does not match block root %s", root, blockRoot)
+	}
+	return &LightClientBootstrap{
+		Header:                     LightClientHeader{Beacon: data.Header},
+		CurrentSyncCommittee:       data.CurrentSyncCommittee,
+		CurrentSyncCommitteeBranch: data.CurrentSyncCommitteeBranch,
+	}, nil
+}
+
+// NewLightClientUpdate creates an update for the block of the attested state,
+// signed with the sync aggregate of the block at the signature slot.
+// The finalized header is optional, if nil the update does not indicate finality.
+func NewLightClientUpdate(spec *common.Spec, attestedState common.SyncCommitteeBeaconState,
+	syncAggregate *SyncAggregate, signatureSlot common.Slot, finalizedHeader *common.BeaconBlockHeader) (*LightClientUpdate, error) {
+	data, err := NewLightClientStateData(spec, attestedState)
+	if err != nil {
+		return nil, err
+	}
+	if err := data.CheckSignature(spec, syncAggregate, signatureSlot); err != nil {
+		return nil, err
+	}
+	update := &LightClientUpdate{
+		AttestedHeader: LightClientHeader{Beacon: data.Header},
+		NextSyncCommittee: common.SyncCommittee{
+			Pubkeys: make(common.SyncCommitteePubkeys, spec.SYNC_COMMITTEE_SIZE),
+		},
+		SyncAggregate: *syncAggregate,
+		SignatureSlot: signatureSlot,
+	}
+	// The next sync committee is only useful if the message is signed by the current sync committee
+	if ComputeSyncCommitteePeriodAtSlot(spec, data.Header.Slot) == ComputeSyncCommitteePeriodAtSlot(spec, signatureSlot) {
+		update.NextSyncCommittee = data.NextSyncCommittee
+		update.NextSyncCommitteeBranch = data.NextSyncCommitteeBranch
+	}
+	// Indicate finality whenever possible
+	if finalizedHeader != nil {
+		if err := data.CheckFinalizedHeader(finalizedHeader); err != nil {
+			return nil, err
+		}
+		if finalizedHeader.Slot != common.GENESIS_SLOT {
+			update.FinalizedHeader = LightClientHeader{Beacon: *finalizedHeader}
+		}
+		update.FinalityBranch = data.FinalityBranch
+	}
+	return update, nil
+}
+
+func (lcu *LightClientUpdate) FinalityUpdate() *LightClientFinalityUpdate {
+	return &LightClientFinalityUpdate{
+		AttestedHeader:  lcu.AttestedHeader,
+		FinalizedHeader: lcu.FinalizedHeader.Beacon,
+		FinalityBranch:  lcu.FinalityBranch,
+		SyncAggregate:   lcu.SyncAggregate,
+		SignatureSlot:   lcu.SignatureSlot,
+	}
+}
+
+func (lcu *LightClientUpdate) OptimisticUpdate() *LightClientOptimisticUpdate {
+	return &LightClientOptimisticUpdate{
+		AttestedHeader: lcu.AttestedHeader,
+		SyncAggregate:  lcu.SyncAggregate,
+		SignatureSlot:  lcu.SignatureSlot,
+	}
+}
@@ -0,0 +1,188 @@
+package altair
+
+import (
+	"testing"
+
+	"github.com/protolambda/ztyp/tree"
+
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/protolambda/zrnt/eth2/beacon/phase0"
+	"github.com/protolambda/zrnt/eth2/configs"
+	"github.com/protolambda/zrnt/eth2/util/merkle"
+	"github.com/protolambda/zrnt/tests/benches"
+)
+
+// testLightClientState creates an altair state at the given slot, with the latest block header at that slot,
+// and the given finalized checkpoint.
+func testLightClientState(t *testing.T, spec *common.Spec, slot common.Slot, finalized common.Checkpoint) *BeaconStateView {
+	// The first test validator has the pubkey at infinity, which can not be aggregated into a sync committee.
+	validators := benches.CreateTestValidators(65, spec.MAX_EFFECTIVE_BALANCE)[1:]
+	pre, epc, err := phase0.KickStartState(spec, common.Root{123}, 1564000000, validators)
+	if err != nil {
+		t.Fatal(err)
+	}
+	state, err := UpgradeToAltair(spec, epc, pre)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := state.SetSlot(slot); err != nil {
+		t.Fatal(err)
+	}
+	if err := state.SetLatestBlockHeader(&common.BeaconBlockHeader{Slot: slot, ProposerIndex: 3, ParentRoot: common.Root{0xaa}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := state.SetFinalizedCheckpoint(finalized); err != nil {
+		t.Fatal(err)
+	}
+	return state
+}
+
+func TestNewLightClientStateData(t *testing.T) {
+	spec := *configs.Mainnet
+	spec.ALTAIR_FORK_EPOCH = 0
+	hFn := tree.GetHashFn()
+	finalized := common.Checkpoint{Epoch: 1, Root: common.Root{0xf1}}
+	state := testLightClientState(t, &spec, 40, finalized)
+
+	data, err := NewLightClientStateData(&spec, state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stateRoot := state.HashTreeRoot(hFn)
+	if data.Header.Slot != 40 || data.Header.ProposerIndex != 3 || data.Header.StateRoot != stateRoot {
+		t.Fatalf("unexpected header: %v", data.Header)
+	}
+	if data.FinalizedCheckpoint != finalized {
+		t.Errorf("expected finalized checkpoint %s, got %s", finalized, data.FinalizedCheckpoint)
+	}
+	currentView, err := state.CurrentSyncCommittee()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data.CurrentSyncCommittee.HashTreeRoot(&spec, hFn) != currentView.HashTreeRoot(hFn) {
+		t.Error("current sync committee does not match the state")
+	}
+	nextView, err := state.NextSyncCommittee()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data.NextSyncCommittee.HashTreeRoot(&spec, hFn) != nextView.HashTreeRoot(hFn) {
+		t.Error("next sync committee does not match the state")
+	}
+
+	branches := []struct {
+		name   string
+		leaf   common.Root
+		branch []common.Root
+		gindex tree.Gindex64
+	}{
+		{"current sync committee", data.CurrentSyncCommittee.HashTreeRoot(&spec, hFn), data.CurrentSyncCommitteeBranch[:], CURRENT_SYNC_COMMITTEE_INDEX},
+		{"next sync committee", data.NextSyncCommittee.HashTreeRoot(&spec, hFn), data.NextSyncCommitteeBranch[:], NEXT_SYNC_COMMITTEE_INDEX},
+		{"finality", finalized.Root, data.FinalityBranch[:], FINALIZED_ROOT_INDEX},
+	}
+	for _, b := range branches {
+		depth := uint64(len(b.branch))
+		index := uint64(b.gindex) ^ (1 << depth)
+		if !merkle.VerifyMerkleBranch(b.leaf, b.branch, depth, index, stateRoot) {
+			t.Errorf("%s branch does not verify against the state root", b.name)
+		}
+		if merkle.VerifyMerkleBranch(common.Root{0xff}, b.branch, depth, index, stateRoot) {
+			t.Errorf("%s branch verifies another leaf", b.name)
+		}
+	}
+
+	// The state is processed past the slot of the block.
+	if err := state.SetSlot(41); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewLightClientStateData(&spec, state); err == nil {
+		t.Error("expected error for state past the block slot")
+	}
+	preAltair := spec
+	preAltair.ALTAIR_FORK_EPOCH = 10
+	if _, err := NewLightClientStateData(&preAltair, testLightClientState(t, &spec, 40, finalized)); err == nil {
+		t.Error("expected error for state before altair")
+	}
+}
+
+func TestNewLightClientUpdate(t *testing.T) {
+	spec := *configs.Mainnet
+	spec.ALTAIR_FORK_EPOCH = 0
+	hFn := tree.GetHashFn()
+	finalizedHeader := common.BeaconBlockHeader{Slot: 32, ProposerIndex: 1, StateRoot: common.Root{0x51}}
+	finalized := common.Checkpoint{Epoch: 1, Root: finalizedHeader.HashTreeRoot(hFn)}
+	state := testLightClientState(t, &spec, 40, finalized)
+	attestedRoot := state.HashTreeRoot(hFn)
+
+	bits := make(SyncCommitteeBits, (spec.SYNC_COMMITTEE_SIZE+7)/8)
+	for i := uint64(0); i < uint64(spec.MIN_SYNC_COMMITTEE_PARTICIPANTS); i++ {
+		bits.SetBit(i, true)
+	}
+	syncAggregate := &SyncAggregate{SyncCommitteeBits: bits, SyncCommitteeSignature: common.BLSSignature{0xc0}}
+	nextPeriodSlot := common.Slot(spec.EPOCHS_PER_SYNC_COMMITTEE_PERIOD) * spec.SLOTS_PER_EPOCH
+
+	t.Run("finality and next sync committee", func(t *testing.T) {
+		update, err := NewLightClientUpdate(&spec, state, syncAggregate, 41, &finalizedHeader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if update.AttestedHeader.Beacon.StateRoot != attestedRoot || update.SignatureSlot != 41 {
+			t.Fatalf("unexpected attested header %v or signature slot %d", update.AttestedHeader.Beacon, update.SignatureSlot)
+		}
+		generic := update.Generic()
+		if !generic.IsFinalityUpdate() || !generic.IsSyncCommitteeUpdate() {
+			t.Fatal("expected finality and sync committee update")
+		}
+		if update.FinalizedHeader.Beacon != finalizedHeader {
+			t.Errorf("unexpected finalized header %v", update.FinalizedHeader.Beacon)
+		}
+		if !merkle.VerifyMerkleBranch(finalized.Root, update.FinalityBranch[:], uint64(len(update.FinalityBranch)),
+			uint64(FINALIZED_ROOT_INDEX)^(1<<len(update.FinalityBranch)), attestedRoot) {
+			t.Error("finality branch does not verify")
+		}
+		if !merkle.VerifyMerkleBranch(update.NextSyncCommittee.HashTreeRoot(&spec, hFn), update.NextSyncCommitteeBranch[:],
+			uint64(len(update.NextSyncCommitteeBranch)), uint64(NEXT_SYNC_COMMITTEE_INDEX)^(1<<len(update.NextSyncCommitteeBranch)), attestedRoot) {
+			t.Error("next sync committee branch does not verify")
+		}
+	})
+	t.Run("signed in the next period", func(t *testing.T) {
+		update, err := NewLightClientUpdate(&spec, state, syncAggregate, nextPeriodSlot, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		generic := update.Generic()
+		if generic.IsFinalityUpdate() || generic.IsSyncCommitteeUpdate() {
+			t.Fatal("expected update without finality and sync committee")
+		}
+		if !isEmptySyncCommittee(&update.NextSyncCommittee) || !update.FinalizedHeader.IsEmpty() {
+			t.Error("expected empty next sync committee and finalized header")
+		}
+	})
+	t.Run("genesis finality", func(t *testing.T) {
+		genesisState := testLightClientState(t, &spec, 40, common.Checkpoint{})
+		update, err := NewLightClientUpdate(&spec, genesisState, syncAggregate, 41, &common.BeaconBlockHeader{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !update.Generic().IsFinalityUpdate() || !update.FinalizedHeader.IsEmpty() {
+			t.Fatal("expected finality update with empty finalized header")
+		}
+	})
+
+	errorCases := []struct {
+		name            string
+		syncAggregate   *SyncAggregate
+		signatureSlot   common.Slot
+		finalizedHeader *common.BeaconBlockHeader
+	}{
+		{"too few participants", &SyncAggregate{SyncCommitteeBits: make(SyncCommitteeBits, (spec.SYNC_COMMITTEE_SIZE+7)/8)}, 41, nil},
+		{"signature slot not after attested slot", syncAggregate, 40, nil},
+		{"finalized header mismatch", syncAggregate, 41, &common.BeaconBlockHeader{Slot: 32}},
+		{"genesis finalized header with finalized root", syncAggregate, 41, &common.BeaconBlockHeader{}},
+	}
+	for _, c := range errorCases {
+		if _, err := NewLightClientUpdate(&spec, state, c.syncAggregate, c.signatureSlot, c.finalizedHeader); err == nil {
+			t.Errorf("%s: expected error", c.name)
+		}
+	}
+}
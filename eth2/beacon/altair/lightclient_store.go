@@ -0,0 +1,454 @@
+package altair
+
+import (
+	"errors"
+	"fmt"
+
+	blsu "github.com/protolambda/bls12-381-util"
+	"github.com/protolambda/ztyp/tree"
+
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/protolambda/zrnt/eth2/util/merkle"
+)
+
+// https://github.com/ethereum/consensus-specs/blob/dev/specs/altair/light-client/sync-protocol.md
+
+// LightClientHeaderLike is implemented by the LightClientHeader of each fork,
+// so the light client logic can be shared between forks.
+type LightClientHeaderLike interface {
+	BeaconHeader() *common.BeaconBlockHeader
+	// IsValid implements is_valid_light_client_header
+	IsValid(spec *common.Spec) bool
+	// IsEmpty returns true if the header is the zero value, i.e. equal to LightClientHeader()
+	IsEmpty() bool
+}
+
+func (lch *LightClientHeader) BeaconHeader() *common.BeaconBlockHeader {
+	return &lch.Beacon
+}
+
+// IsValid always returns true: the altair light client header has nothing but the beacon header.
+func (lch *LightClientHeader) IsValid(spec *common.Spec) bool {
+	return true
+}
+
+func (lch *LightClientHeader) IsEmpty() bool {
+	return lch.Beacon == common.BeaconBlockHeader{}
+}
+
+// GenericLightClientUpdate is a fork-agnostic LightClientUpdate.
+// Finality and optimistic updates are converted to this, with the missing fields left empty.
+type GenericLightClientUpdate struct {
+	AttestedHeader          LightClientHeaderLike
+	NextSyncCommittee       common.SyncCommittee
+	NextSyncCommitteeBranch SyncCommitteeProofBranch
+	FinalizedHeader         LightClientHeaderLike
+	FinalityBranch          FinalizedRootProofBranch
+	SyncAggregate           SyncAggregate
+	SignatureSlot           common.Slot
+}
+
+// IsSyncCommitteeUpdate returns true if the update has a next sync committee branch.
+func (u *GenericLightClientUpdate) IsSyncCommitteeUpdate() bool {
+	return u.NextSyncCommitteeBranch != SyncCommitteeProofBranch{}
+}
+
+// IsFinalityUpdate returns true if the update has a finality branch.
+func (u *GenericLightClientUpdate) IsFinalityUpdate() bool {
+	return u.FinalityBranch != FinalizedRootProofBranch{}
+}
+
+func (lcu *LightClientUpdate) Generic() *GenericLightClientUpdate {
+	return &GenericLightClientUpdate{
+		AttestedHeader:          &lcu.AttestedHeader,
+		NextSyncCommittee:       lcu.NextSyncCommittee,
+		NextSyncCommitteeBranch: lcu.NextSyncCommitteeBranch,
+		FinalizedHeader:         &lcu.FinalizedHeader,
+		FinalityBranch:          lcu.FinalityBranch,
+		SyncAggregate:           lcu.SyncAggregate,
+		SignatureSlot:           lcu.SignatureSlot,
+	}
+}
+
+func (lcfu *LightClientFinalityUpdate) Generic() *GenericLightClientUpdate {
+	return &GenericLightClientUpdate{
+		AttestedHeader:  &lcfu.AttestedHeader,
+		FinalizedHeader: &LightClientHeader{Beacon: lcfu.FinalizedHeader},
+		FinalityBranch:  lcfu.FinalityBranch,
+		SyncAggregate:   lcfu.SyncAggregate,
+		SignatureSlot:   lcfu.SignatureSlot,
+	}
+}
+
+func (lcou *LightClientOptimisticUpdate) Generic() *GenericLightClientUpdate {
+	return &GenericLightClientUpdate{
+		AttestedHeader:  &lcou.AttestedHeader,
+		FinalizedHeader: &LightClientHeader{},
+		SyncAggregate:   lcou.SyncAggregate,
+		SignatureSlot:   lcou.SignatureSlot,
+	}
+}
+
+// GenericLightClientBootstrap is a fork-agnostic LightClientBootstrap.
+type GenericLightClientBootstrap struct {
+	Header                     LightClientHeaderLike
+	CurrentSyncCommittee       common.SyncCommittee
+	CurrentSyncCommitteeBranch SyncCommitteeProofBranch
+}
+
+func (lcb *LightClientBootstrap) Generic() *GenericLightClientBootstrap {
+	return &GenericLightClientBootstrap{
+		Header:                     &lcb.Header,
+		CurrentSyncCommittee:       lcb.CurrentSyncCommittee,
+		CurrentSyncCommitteeBranch: lcb.CurrentSyncCommitteeBranch,
+	}
+}
+
+func ComputeSyncCommitteePeriodAtSlot(spec *common.Spec, slot common.Slot) uint64 {
+	return uint64(spec.SlotToEpoch(slot) / spec.EPOCHS_PER_SYNC_COMMITTEE_PERIOD)
+}
+
+// UpdateTimeout is the number of slots after which the best valid update is forced, i.e. UPDATE_TIMEOUT.
+func UpdateTimeout(spec *common.Spec) common.Slot {
+	return common.Slot(spec.SLOTS_PER_EPOCH) * common.Slot(spec.EPOCHS_PER_SYNC_COMMITTEE_PERIOD)
+}
+
+func isEmptySyncCommittee(c *common.SyncCommittee) bool {
+	if c.AggregatePubkey != (common.BLSPubkey{}) {
+		return false
+	}
+	for i := range c.Pubkeys {
+		if c.Pubkeys[i] != (common.BLSPubkey{}) {
+			return false
+		}
+	}
+	return true
+}
+
+func equalSyncCommittees(a *common.SyncCommittee, b *common.SyncCommittee) bool {
+	if a.AggregatePubkey != b.AggregatePubkey || len(a.Pubkeys) != len(b.Pubkeys) {
+		return false
+	}
+	for i := range a.Pubkeys {
+		if a.Pubkeys[i] != b.Pubkeys[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// IsBetterUpdate returns true if the new update is better than the old update, i.e. is_better_update.
+func IsBetterUpdate(spec *common.Spec, newUpdate *GenericLightClientUpdate, oldUpdate *GenericLightClientUpdate) bool {
+	// Compare supermajority (> 2/3) sync committee participation
+	maxActiveParticipants := uint64(spec.SYNC_COMMITTEE_SIZE)
+	newNumActiveParticipants := newUpdate.SyncAggregate.SyncCommitteeBits.OnesCount()
+	oldNumActiveParticipants := oldUpdate.SyncAggregate.SyncCommitteeBits.OnesCount()
+	newHasSupermajority := newNumActiveParticipants*3 >= maxActiveParticipants*2
+	oldHasSupermajority := oldNumActiveParticipants*3 >= maxActiveParticipants*2
+	if newHasSupermajority != oldHasSupermajority {
+		return newHasSupermajority
+	}
+	if !newHasSupermajority && newNumActiveParticipants != oldNumActiveParticipants {
+		return newNumActiveParticipants > oldNumActiveParticipants
+	}
+
+	// Compare presence of relevant sync committee
+	hasRelevantSyncCommittee := func(u *GenericLightClientUpdate) bool {
+		return u.IsSyncCommitteeUpdate() &&
+			ComputeSyncCommitteePeriodAtSlot(spec, u.AttestedHeader.BeaconHeader().Slot) ==
+				ComputeSyncCommitteePeriodAtSlot(spec, u.SignatureSlot)
+	}
+	newHasRelevantSyncCommittee := hasRelevantSyncCommittee(newUpdate)
+	oldHasRelevantSyncCommittee := hasRelevantSyncCommittee(oldUpdate)
+	if newHasRelevantSyncCommittee != oldHasRelevantSyncCommittee {
+		return newHasRelevantSyncCommittee
+	}
+
+	// Compare indication of any finality
+	newHasFinality := newUpdate.IsFinalityUpdate()
+	oldHasFinality := oldUpdate.IsFinalityUpdate()
+	if newHasFinality != oldHasFinality {
+		return newHasFinality
+	}
+
+	// Compare sync committee finality
+	if newHasFinality {
+		hasSyncCommitteeFinality := func(u *GenericLightClientUpdate) bool {
+			return ComputeSyncCommitteePeriodAtSlot(spec, u.FinalizedHeader.BeaconHeader().Slot) ==
+				ComputeSyncCommitteePeriodAtSlot(spec, u.AttestedHeader.BeaconHeader().Slot)
+		}
+		newHasSyncCommitteeFinality := hasSyncCommitteeFinality(newUpdate)
+		oldHasSyncCommitteeFinality := hasSyncCommitteeFinality(oldUpdate)
+		if newHasSyncCommitteeFinality != oldHasSyncCommitteeFinality {
+			return newHasSyncCommitteeFinality
+		}
+	}
+
+	// Tiebreaker 1: Sync committee participation beyond supermajority
+	if newNumActiveParticipants != oldNumActiveParticipants {
+		return newNumActiveParticipants > oldNumActiveParticipants
+	}
+
+	// Tiebreaker 2: Prefer older data (fewer changes to best)
+	newAttestedSlot := newUpdate.AttestedHeader.BeaconHeader().Slot
+	oldAttestedSlot := oldUpdate.AttestedHeader.BeaconHeader().Slot
+	if newAttestedSlot != oldAttestedSlot {
+		return newAttestedSlot < oldAttestedSlot
+	}
+	return newUpdate.SignatureSlot < oldUpdate.SignatureSlot
+}
+
+// LightClientStore is the state of a light client, it is not safe for concurrent use.
+type LightClientStore struct {
+	// Header that is finalized
+	FinalizedHeader LightClientHeaderLike
+	// Sync committees corresponding to the finalized header
+	CurrentSyncCommittee common.SyncCommittee
+	NextSyncCommittee    common.SyncCommittee
+	// Best available header to switch finalized head to if we see nothing else
+	BestValidUpdate *GenericLightClientUpdate
+	// Most recent available reasonably-safe header
+	OptimisticHeader LightClientHeaderLike
+	// Max number of active participants in a sync committee (used to calculate safety threshold)
+	PreviousMaxActiveParticipants uint64
+	CurrentMaxActiveParticipants  uint64
+}
+
+// InitializeLightClientStore creates a store from a bootstrap, after checking it against the trusted block root.
+func InitializeLightClientStore(spec *common.Spec, trustedBlockRoot common.Root, bootstrap *GenericLightClientBootstrap) (*LightClientStore, error) {
+	if !bootstrap.Header.IsValid(spec) {
+		return nil, errors.New("invalid bootstrap header")
+	}
+	beacon := bootstrap.Header.BeaconHeader()
+	hFn := tree.GetHashFn()
+	if root := beacon.HashTreeRoot(hFn); root != trustedBlockRoot {
+		return nil, fmt.Errorf("bootstrap header root %s does not match trusted block root %s", root, trustedBlockRoot)
+	}
+	if !merkle.VerifyMerkleBranch(bootstrap.CurrentSyncCommittee.HashTreeRoot(spec, hFn),
+		bootstrap.CurrentSyncCommitteeBranch[:], syncCommitteeProofLen,
+		uint64(_currentSyncCommittee), beacon.StateRoot) {
+		return nil, errors.New("invalid current sync committee branch")
+	}
+	return &LightClientStore{
+		FinalizedHeader:      bootstrap.Header,
+		CurrentSyncCommittee: bootstrap.CurrentSyncCommittee,
+		NextSyncCommittee: common.SyncCommittee{
+			Pubkeys: make(common.SyncCommitteePubkeys, spec.SYNC_COMMITTEE_SIZE),
+		},
+		OptimisticHeader: bootstrap.Header,
+	}, nil
+}
+
+func (store *LightClientStore) IsNextSyncCommitteeKnown() bool {
+	return !isEmptySyncCommittee(&store.NextSyncCommittee)
+}
+
+func (store *LightClientStore) SafetyThreshold() uint64 {
+	max := store.PreviousMaxActiveParticipants
+	if store.CurrentMaxActiveParticipants > max {
+		max = store.CurrentMaxActiveParticipants
+	}
+	return max / 2
+}
+
+// ValidateUpdate implements validate_light_client_update
+func (store *LightClientStore) ValidateUpdate(spec *common.Spec, update *GenericLightClientUpdate,
+	currentSlot common.Slot, genesisValidatorsRoot common.Root) error {
+	// Verify sync committee has sufficient participants
+	syncAggregate := &update.SyncAggregate
+	participants := syncAggregate.SyncCommitteeBits.OnesCount()
+	if participants < uint64(spec.MIN_SYNC_COMMITTEE_PARTICIPANTS) {
+		return fmt.Errorf("not enough sync committee participants: %d", participants)
+	}
+
+	// Verify update does not skip a sync committee period
+	if !update.AttestedHeader.IsValid(spec) {
+		return errors.New("invalid attested header")
+	}
+	attestedSlot := update.AttestedHeader.BeaconHeader().Slot
+	finalizedSlot := update.FinalizedHeader.BeaconHeader().Slot
+	if !(currentSlot >= update.SignatureSlot && update.SignatureSlot > attestedSlot && attestedSlot >= finalizedSlot) {
+		return fmt.Errorf("inconsistent update slots: current %d, signature %d, attested %d, finalized %d",
+			currentSlot, update.SignatureSlot, attestedSlot, finalizedSlot)
+	}
+	storePeriod := ComputeSyncCommitteePeriodAtSlot(spec, store.FinalizedHeader.BeaconHeader().Slot)
+	signaturePeriod := ComputeSyncCommitteePeriodAtSlot(spec, update.SignatureSlot)
+	if store.IsNextSyncCommitteeKnown() {
+		if signaturePeriod != storePeriod && signaturePeriod != storePeriod+1 {
+			return fmt.Errorf("signature period %d is not the store period %d or the next", signaturePeriod, storePeriod)
+		}
+	} else if signaturePeriod != storePeriod {
+		return fmt.Errorf("signature period %d is not the store period %d", signaturePeriod, storePeriod)
+	}
+
+	// Verify update is relevant
+	attestedPeriod := ComputeSyncCommitteePeriodAtSlot(spec, attestedSlot)
+	hasNextSyncCommittee := !store.IsNextSyncCommitteeKnown() &&
+		update.IsSyncCommitteeUpdate() && attestedPeriod == storePeriod
+	if !(attestedSlot > store.FinalizedHeader.BeaconHeader().Slot || hasNextSyncCommittee) {
+		return errors.New("update is not relevant")
+	}
+
+	hFn := tree.GetHashFn()
+	attestedStateRoot := update.AttestedHeader.BeaconHeader().StateRoot
+
+	// Verify that the finality branch, if present, confirms the finalized header
+	// to match the finalized checkpoint root saved in the state of the attested header.
+	// Note that the genesis finalized checkpoint root is represented as a zero hash.
+	if !update.IsFinalityUpdate() {
+		if !update.FinalizedHeader.IsEmpty() {
+			return errors.New("finalized header must be empty without finality branch")
+		}
+	} else {
+		var finalizedRoot common.Root
+		if finalizedSlot == common.GENESIS_SLOT {
+			if !update.FinalizedHeader.IsEmpty() {
+				return errors.New("genesis finalized header must be empty")
+			}
+		} else {
+			if !update.FinalizedHeader.IsValid(spec) {
+				return errors.New("invalid finalized header")
+			}
+			finalizedRoot = update.FinalizedHeader.BeaconHeader().HashTreeRoot(hFn)
+		}
+		if !merkle.VerifyMerkleBranch(finalizedRoot, update.FinalityBranch[:], finalizedRootProofLen,
+			uint64(FINALIZED_ROOT_INDEX)^(1<<finalizedRootProofLen), attestedStateRoot) {
+			return errors.New("invalid finality branch")
+		}
+	}
+
+	// Verify that the next sync committee, if present, actually is the next sync committee
+	// saved in the state of the attested header.
+	if !update.IsSyncCommitteeUpdate() {
+		if !isEmptySyncCommittee(&update.NextSyncCommittee) {
+			return errors.New("next sync committee must be empty without next sync committee branch")
+		}
+	} else {
+		if attestedPeriod == storePeriod && store.IsNextSyncCommitteeKnown() &&
+			!equalSyncCommittees(&update.NextSyncCommittee, &store.NextSyncCommittee) {
+			return errors.New("next sync committee does not match the known next sync committee")
+		}
+		if !merkle.VerifyMerkleBranch(update.NextSyncCommittee.HashTreeRoot(spec, hFn),
+			update.NextSyncCommitteeBranch[:], syncCommitteeProofLen,
+			uint64(_nextSyncCommittee), attestedStateRoot) {
+			return errors.New("invalid next sync committee branch")
+		}
+	}
+
+	// Verify sync committee aggregate signature
+	syncCommittee := &store.NextSyncCommittee
+	if signaturePeriod == storePeriod {
+		syncCommittee = &store.CurrentSyncCommittee
+	}
+	if uint64(len(syncCommittee.Pubkeys)) != uint64(spec.SYNC_COMMITTEE_SIZE) {
+		return fmt.Errorf("sync committee has %d pubkeys, expected %d", len(syncCommittee.Pubkeys), spec.SYNC_COMMITTEE_SIZE)
+	}
+	participantPubkeys := make([]*blsu.Pubkey, 0, participants)
+	for i := range syncCommittee.Pubkeys {
+		if syncAggregate.SyncCommitteeBits.GetBit(uint64(i)) {
+			pub, err := syncCommittee.Pubkeys[i].Pubkey()
+			if err != nil {
+				return fmt.Errorf("failed to decode sync committee pubkey %d: %w", i, err)
+			}
+			participantPubkeys = append(participantPubkeys, pub)
+		}
+	}
+	forkVersionSlot := update.SignatureSlot
+	if forkVersionSlot > 0 {
+		forkVersionSlot -= 1
+	}
+	domain := common.ComputeDomain(common.DOMAIN_SYNC_COMMITTEE, spec.ForkVersion(forkVersionSlot), genesisValidatorsRoot)
+	signingRoot := common.ComputeSigningRoot(update.AttestedHeader.BeaconHeader().HashTreeRoot(hFn), domain)
+	sig, err := syncAggregate.SyncCommitteeSignature.Signature()
+	if err != nil {
+		return fmt.Errorf("failed to decode sync committee signature: %w", err)
+	}
+	if !blsu.FastAggregateVerify(participantPubkeys, signingRoot[:], sig) {
+		return errors.New("invalid sync committee signature")
+	}
+	return nil
+}
+
+// applyUpdate implements apply_light_client_update
+func (store *LightClientStore) applyUpdate(spec *common.Spec, update *GenericLightClientUpdate) error {
+	storePeriod := ComputeSyncCommitteePeriodAtSlot(spec, store.FinalizedHeader.BeaconHeader().Slot)
+	finalizedPeriod := ComputeSyncCommitteePeriodAtSlot(spec, update.FinalizedHeader.BeaconHeader().Slot)
+	if !store.IsNextSyncCommitteeKnown() {
+		if finalizedPeriod != storePeriod {
+			return fmt.Errorf("finalized period %d does not match store period %d", finalizedPeriod, storePeriod)
+		}
+		store.NextSyncCommittee = update.NextSyncCommittee
+	} else if finalizedPeriod == storePeriod+1 {
+		store.CurrentSyncCommittee = store.NextSyncCommittee
+		store.NextSyncCommittee = update.NextSyncCommittee
+		store.PreviousMaxActiveParticipants = store.CurrentMaxActiveParticipants
+		store.CurrentMaxActiveParticipants = 0
+	}
+	if update.FinalizedHeader.BeaconHeader().Slot > store.FinalizedHeader.BeaconHeader().Slot {
+		store.FinalizedHeader = update.FinalizedHeader
+		if store.FinalizedHeader.BeaconHeader().Slot > store.OptimisticHeader.BeaconHeader().Slot {
+			store.OptimisticHeader = store.FinalizedHeader
+		}
+	}
+	return nil
+}
+
+// ProcessForceUpdate implements process_light_client_store_force_update:
+// if the update timeout has elapsed, the best valid update is applied, even without finality.
+func (store *LightClientStore) ProcessForceUpdate(spec *common.Spec, currentSlot common.Slot) error {
+	if currentSlot > store.FinalizedHeader.BeaconHeader().Slot+UpdateTimeout(spec) && store.BestValidUpdate != nil {
+		// Because the apply logic waits for the finalized header slot to indicate sync committee finality,
+		// the attested header may be treated as finalized header in extended periods of non-finality
+		// to guarantee progression into later sync committee periods according to IsBetterUpdate.
+		update := *store.BestValidUpdate
+		if update.FinalizedHeader.BeaconHeader().Slot <= store.FinalizedHeader.BeaconHeader().Slot {
+			update.FinalizedHeader = update.AttestedHeader
+		}
+		if err := store.applyUpdate(spec, &update); err != nil {
+			return err
+		}
+		store.BestValidUpdate = nil
+	}
+	return nil
+}
+
+// ProcessUpdate implements process_light_client_update.
+// Finality and optimistic updates can be processed by converting them with Generic() first.
+func (store *LightClientStore) ProcessUpdate(spec *common.Spec, update *GenericLightClientUpdate,
+	currentSlot common.Slot, genesisValidatorsRoot common.Root) error {
+	if err := store.ValidateUpdate(spec, update, currentSlot, genesisValidatorsRoot); err != nil {
+		return err
+	}
+	participants := update.SyncAggregate.SyncCommitteeBits.OnesCount()
+
+	// Update the best update in case we have to force-update to it if the timeout elapses
+	if store.BestValidUpdate == nil || IsBetterUpdate(spec, update, store.BestValidUpdate) {
+		store.BestValidUpdate = update
+	}
+
+	// Track the maximum number of active participants in the committee signatures
+	if participants > store.CurrentMaxActiveParticipants {
+		store.CurrentMaxActiveParticipants = participants
+	}
+
+	// Update the optimistic header
+	attestedSlot := update.AttestedHeader.BeaconHeader().Slot
+	if participants > store.SafetyThreshold() && attestedSlot > store.OptimisticHeader.BeaconHeader().Slot {
+		store.OptimisticHeader = update.AttestedHeader
+	}
+
+	// Update finalized header
+	finalizedSlot := update.FinalizedHeader.BeaconHeader().Slot
+	hasFinalizedNextSyncCommittee := !store.IsNextSyncCommitteeKnown() &&
+		update.IsSyncCommitteeUpdate() && update.IsFinalityUpdate() &&
+		ComputeSyncCommitteePeriodAtSlot(spec, finalizedSlot) == ComputeSyncCommitteePeriodAtSlot(spec, attestedSlot)
+	if participants*3 >= uint64(spec.SYNC_COMMITTEE_SIZE)*2 &&
+		(finalizedSlot > store.FinalizedHeader.BeaconHeader().Slot || hasFinalizedNextSyncCommittee) {
+		// Normal update through 2/3 threshold
+		if err := store.applyUpdate(spec, update); err != nil {
+			return err
+		}
+		store.BestValidUpdate = nil
+	}
+	return nil
+}
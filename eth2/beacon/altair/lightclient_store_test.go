@@ -0,0 +1,141 @@
+package altair
+
+import (
+	"testing"
+
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/protolambda/zrnt/eth2/configs"
+)
+
+type testUpdateDef struct {
+	participants  uint64
+	attestedSlot  common.Slot
+	finalizedSlot common.Slot
+	signatureSlot common.Slot
+	syncCommittee bool
+	finality      bool
+}
+
+func (d *testUpdateDef) update(spec *common.Spec) *GenericLightClientUpdate {
+	bits := make(SyncCommitteeBits, (spec.SYNC_COMMITTEE_SIZE+7)/8)
+	for i := uint64(0); i < d.participants; i++ {
+		bits.SetBit(i, true)
+	}
+	out := &GenericLightClientUpdate{
+		AttestedHeader:  &LightClientHeader{Beacon: common.BeaconBlockHeader{Slot: d.attestedSlot}},
+		FinalizedHeader: &LightClientHeader{Beacon: common.BeaconBlockHeader{Slot: d.finalizedSlot}},
+		SyncAggregate:   SyncAggregate{SyncCommitteeBits: bits},
+		SignatureSlot:   d.signatureSlot,
+	}
+	if d.syncCommittee {
+		out.NextSyncCommitteeBranch[0] = common.Root{1}
+	}
+	if d.finality {
+		out.FinalityBranch[0] = common.Root{1}
+	}
+	return out
+}
+
+func TestIsBetterUpdate(t *testing.T) {
+	// minimal: 32 sync committee members, sync committee periods of 64 slots.
+	spec := configs.Minimal
+	base := testUpdateDef{participants: 32, attestedSlot: 20, finalizedSlot: 8, signatureSlot: 21}
+	with := func(fn func(d *testUpdateDef)) testUpdateDef {
+		d := base
+		fn(&d)
+		return d
+	}
+	cases := []struct {
+		name     string
+		new, old testUpdateDef
+		expected bool
+	}{
+		{"supermajority", with(func(d *testUpdateDef) { d.participants = 22 }),
+			with(func(d *testUpdateDef) { d.participants = 21; d.finality = true }), true},
+		{"no supermajority", with(func(d *testUpdateDef) { d.participants = 21; d.finality = true }),
+			with(func(d *testUpdateDef) { d.participants = 22 }), false},
+		{"more participants without supermajority", with(func(d *testUpdateDef) { d.participants = 20 }),
+			with(func(d *testUpdateDef) { d.participants = 10; d.finality = true }), true},
+		{"relevant sync committee", with(func(d *testUpdateDef) { d.syncCommittee = true }),
+			with(func(d *testUpdateDef) { d.syncCommittee = true; d.attestedSlot = 63; d.signatureSlot = 64 }), true},
+		{"any sync committee is not relevant", with(func(d *testUpdateDef) { d.syncCommittee = true; d.attestedSlot = 63; d.signatureSlot = 64 }),
+			base, false},
+		{"finality", with(func(d *testUpdateDef) { d.finality = true }), base, true},
+		{"sync committee finality", with(func(d *testUpdateDef) {
+			d.finality = true
+			d.attestedSlot = 70
+			d.finalizedSlot = 64
+			d.signatureSlot = 71
+		}),
+			with(func(d *testUpdateDef) {
+				d.finality = true
+				d.attestedSlot = 70
+				d.finalizedSlot = 56
+				d.signatureSlot = 71
+			}), true},
+		{"participation beyond supermajority", base, with(func(d *testUpdateDef) { d.participants = 25 }), true},
+		{"older attested header", with(func(d *testUpdateDef) { d.attestedSlot = 10; d.signatureSlot = 30 }),
+			with(func(d *testUpdateDef) { d.signatureSlot = 30 }), true},
+		{"older signature", base, with(func(d *testUpdateDef) { d.signatureSlot = 22 }), true},
+		{"equal", base, base, false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := IsBetterUpdate(spec, c.new.update(spec), c.old.update(spec)); got != c.expected {
+				t.Errorf("expected %v, got %v", c.expected, got)
+			}
+		})
+	}
+}
+
+func TestProcessForceUpdate(t *testing.T) {
+	spec := configs.Minimal
+	newStore := func() *LightClientStore {
+		header := &LightClientHeader{Beacon: common.BeaconBlockHeader{Slot: 10}}
+		return &LightClientStore{
+			FinalizedHeader:   header,
+			OptimisticHeader:  header,
+			NextSyncCommittee: common.SyncCommittee{Pubkeys: make(common.SyncCommitteePubkeys, spec.SYNC_COMMITTEE_SIZE)},
+		}
+	}
+	timeout := 10 + UpdateTimeout(spec)
+
+	store := newStore()
+	if err := store.ProcessForceUpdate(spec, timeout+1); err != nil {
+		t.Fatal(err)
+	}
+	if slot := store.FinalizedHeader.BeaconHeader().Slot; slot != 10 {
+		t.Fatalf("finalized header changed without best valid update: slot %d", slot)
+	}
+
+	// Without newer finalized header, the attested header is applied as finalized header.
+	store.BestValidUpdate = (&testUpdateDef{participants: 20, attestedSlot: 20, signatureSlot: 21}).update(spec)
+	if err := store.ProcessForceUpdate(spec, timeout); err != nil {
+		t.Fatal(err)
+	}
+	if store.BestValidUpdate == nil || store.FinalizedHeader.BeaconHeader().Slot != 10 {
+		t.Fatal("best valid update was applied before the update timeout")
+	}
+	if err := store.ProcessForceUpdate(spec, timeout+1); err != nil {
+		t.Fatal(err)
+	}
+	if store.BestValidUpdate != nil {
+		t.Fatal("best valid update was not cleared")
+	}
+	if slot := store.FinalizedHeader.BeaconHeader().Slot; slot != 20 {
+		t.Fatalf("expected attested header at slot 20 to be finalized, got slot %d", slot)
+	}
+	if slot := store.OptimisticHeader.BeaconHeader().Slot; slot != 20 {
+		t.Fatalf("expected optimistic header at slot 20, got slot %d", slot)
+	}
+
+	// A newer finalized header is applied as is.
+	store = newStore()
+	store.BestValidUpdate = (&testUpdateDef{participants: 20, attestedSlot: 20, finalizedSlot: 16, signatureSlot: 21, finality: true}).update(spec)
+	if err := store.ProcessForceUpdate(spec, timeout+1); err != nil {
+		t.Fatal(err)
+	}
+	if slot := store.FinalizedHeader.BeaconHeader().Slot; slot != 16 {
+		t.Fatalf("expected finalized header at slot 16, got slot %d", slot)
+	}
+}
@@ -108,7 +108,7 @@ func ProcessSyncAggregate(ctx context.Context, spec *common.Spec, epc *common.Ep
 	if err != nil {
 		return fmt.Errorf("failed to decode and sub-group check sync committee signature: %v", err)
 	}
-	if !blsu.Eth2FastAggregateVerify(participantPubkeys, signingRoot[:], sig) {
+	if !epc.VerifyBlockAggregateSignature(participantPubkeys, signingRoot[:], sig) {
 		return errors.New("invalid sync committee signature")
 	}
 
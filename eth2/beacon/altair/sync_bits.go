@@ -146,6 +146,10 @@ func (li SyncCommitteeBits) SetBit(i uint64, v bool) {
 	bitfields.SetBit(li, i, v)
 }
 
+func (li SyncCommitteeBits) OnesCount() uint64 {
+	return bitfields.BitvectorOnesCount(li)
+}
+
 type SyncCommitteeBitsView struct {
 	*BitVectorView
 }
@@ -25,6 +25,26 @@ func ProcessBLSToExecutionChanges(ctx context.Context, spec *common.Spec, epc *c
 }
 
 func ProcessBLSToExecutionChange(ctx context.Context, spec *common.Spec, epc *common.EpochsContext, state common.BeaconState, op *common.SignedBLSToExecutionChange) error {
+	if err := ValidateBLSToExecutionChange(spec, epc, state, op); err != nil {
+		return err
+	}
+	validators, err := state.Validators()
+	if err != nil {
+		return err
+	}
+	validator, err := validators.Validator(op.BLSToExecutionChange.ValidatorIndex)
+	if err != nil {
+		return err
+	}
+	var newWithdrawalCredentials tree.Root
+	copy(newWithdrawalCredentials[0:1], []byte{common.ETH1_ADDRESS_WITHDRAWAL_PREFIX})
+	copy(newWithdrawalCredentials[12:], op.BLSToExecutionChange.ToExecutionAddress[:])
+	return validator.SetWithdrawalCredentials(newWithdrawalCredentials)
+}
+
+// ValidateBLSToExecutionChange checks all the conditions of process_bls_to_execution_change,
+// including the signature, without modifying the state.
+func ValidateBLSToExecutionChange(spec *common.Spec, epc *common.EpochsContext, state common.BeaconState, op *common.SignedBLSToExecutionChange) error {
 	validators, err := state.Validators()
 	if err != nil {
 		return err
@@ -75,8 +95,5 @@ func ProcessBLSToExecutionChange(ctx context.Context, spec *common.Spec, epc *co
 	if !blsu.Verify(pubKey, sigRoot[:], signature) {
 		return fmt.Errorf("invalid bls to execution change signature")
 	}
-	var newWithdrawalCredentials tree.Root
-	copy(newWithdrawalCredentials[0:1], []byte{common.ETH1_ADDRESS_WITHDRAWAL_PREFIX})
-	copy(newWithdrawalCredentials[12:], addressChange.ToExecutionAddress[:])
-	return validator.SetWithdrawalCredentials(newWithdrawalCredentials)
+	return nil
 }
@@ -273,3 +273,22 @@ func (lcou *LightClientOptimisticUpdate) HashTreeRoot(spec *common.Spec, hFn tre
 		&lcou.SignatureSlot,
 	)
 }
+
+func (lcfu *LightClientFinalityUpdate) Envelope(spec *common.Spec) *common.LightClientUpdateEnvelope {
+	return &common.LightClientUpdateEnvelope{
+		AttestedSlot:     lcfu.AttestedHeader.Beacon.Slot,
+		FinalizedSlot:    lcfu.FinalizedHeader.Beacon.Slot,
+		SignatureSlot:    lcfu.SignatureSlot,
+		SyncParticipants: lcfu.SyncAggregate.SyncCommitteeBits.OnesCount(),
+		UpdateRoot:       lcfu.HashTreeRoot(spec, tree.GetHashFn()),
+	}
+}
+
+func (lcou *LightClientOptimisticUpdate) Envelope(spec *common.Spec) *common.LightClientUpdateEnvelope {
+	return &common.LightClientUpdateEnvelope{
+		AttestedSlot:     lcou.AttestedHeader.Beacon.Slot,
+		SignatureSlot:    lcou.SignatureSlot,
+		SyncParticipants: lcou.SyncAggregate.SyncCommitteeBits.OnesCount(),
+		UpdateRoot:       lcou.HashTreeRoot(spec, tree.GetHashFn()),
+	}
+}
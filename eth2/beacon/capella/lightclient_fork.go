@@ -0,0 +1,171 @@
+package capella
+
+import (
+	"fmt"
+
+	"github.com/protolambda/ztyp/tree"
+
+	"github.com/protolambda/zrnt/eth2/beacon/altair"
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/protolambda/zrnt/eth2/util/merkle"
+)
+
+// https://github.com/ethereum/consensus-specs/blob/dev/specs/capella/light-client/sync-protocol.md
+// https://github.com/ethereum/consensus-specs/blob/dev/specs/capella/light-client/fork.md
+
+var _ altair.LightClientHeaderLike = (*LightClientHeader)(nil)
+
+func (l *LightClientHeader) BeaconHeader() *common.BeaconBlockHeader {
+	return &l.Beacon
+}
+
+// ExecutionRoot is the hash-tree-root of the execution payload header, or zero before Capella.
+func (l *LightClientHeader) ExecutionRoot(spec *common.Spec) common.Root {
+	if spec.SlotToEpoch(l.Beacon.Slot) >= spec.CAPELLA_FORK_EPOCH {
+		return l.Execution.HashTreeRoot(tree.GetHashFn())
+	}
+	return common.Root{}
+}
+
+// IsValid implements is_valid_light_client_header:
+// the execution branch must prove the execution payload header against the block body root.
+// Before Capella, the execution payload header and branch must be empty.
+func (l *LightClientHeader) IsValid(spec *common.Spec) bool {
+	if spec.SlotToEpoch(l.Beacon.Slot) < spec.CAPELLA_FORK_EPOCH {
+		var empty ExecutionPayloadHeader
+		hFn := tree.GetHashFn()
+		return l.Execution.HashTreeRoot(hFn) == empty.HashTreeRoot(hFn) && l.ExecutionBranch == ExecutionBranch{}
+	}
+	return merkle.VerifyMerkleBranch(l.ExecutionRoot(spec), l.ExecutionBranch[:], ExecutionBranchLength,
+		uint64(EXECUTION_PAYLOAD_INDEX)^(1<<ExecutionBranchLength), l.Beacon.BodyRoot)
+}
+
+func (l *LightClientHeader) IsEmpty() bool {
+	var empty LightClientHeader
+	hFn := tree.GetHashFn()
+	return l.HashTreeRoot(hFn) == empty.HashTreeRoot(hFn)
+}
+
+func (lcu *LightClientUpdate) Generic() *altair.GenericLightClientUpdate {
+	return &altair.GenericLightClientUpdate{
+		AttestedHeader:          &lcu.AttestedHeader,
+		NextSyncCommittee:       lcu.NextSyncCommittee,
+		NextSyncCommitteeBranch: lcu.NextSyncCommitteeBranch,
+		FinalizedHeader:         &lcu.FinalizedHeader,
+		FinalityBranch:          lcu.FinalityBranch,
+		SyncAggregate:           lcu.SyncAggregate,
+		SignatureSlot:           lcu.SignatureSlot,
+	}
+}
+
+func (lcfu *LightClientFinalityUpdate) Generic() *altair.GenericLightClientUpdate {
+	return &altair.GenericLightClientUpdate{
+		AttestedHeader:  &lcfu.AttestedHeader,
+		FinalizedHeader: &lcfu.FinalizedHeader,
+		FinalityBranch:  lcfu.FinalityBranch,
+		SyncAggregate:   lcfu.SyncAggregate,
+		SignatureSlot:   lcfu.SignatureSlot,
+	}
+}
+
+func (lcou *LightClientOptimisticUpdate) Generic() *altair.GenericLightClientUpdate {
+	return &altair.GenericLightClientUpdate{
+		AttestedHeader:  &lcou.AttestedHeader,
+		FinalizedHeader: &LightClientHeader{},
+		SyncAggregate:   lcou.SyncAggregate,
+		SignatureSlot:   lcou.SignatureSlot,
+	}
+}
+
+func (lcb *LightClientBootstrap) Generic() *altair.GenericLightClientBootstrap {
+	return &altair.GenericLightClientBootstrap{
+		Header:                     &lcb.Header,
+		CurrentSyncCommittee:       lcb.CurrentSyncCommittee,
+		CurrentSyncCommitteeBranch: lcb.CurrentSyncCommitteeBranch,
+	}
+}
+
+func UpgradeLightClientHeader(pre *altair.LightClientHeader) *LightClientHeader {
+	return &LightClientHeader{Beacon: pre.Beacon}
+}
+
+func UpgradeLightClientBootstrap(pre *altair.LightClientBootstrap) *LightClientBootstrap {
+	return &LightClientBootstrap{
+		Header:                     *UpgradeLightClientHeader(&pre.Header),
+		CurrentSyncCommittee:       pre.CurrentSyncCommittee,
+		CurrentSyncCommitteeBranch: pre.CurrentSyncCommitteeBranch,
+	}
+}
+
+func UpgradeLightClientUpdate(pre *altair.LightClientUpdate) *LightClientUpdate {
+	return &LightClientUpdate{
+		AttestedHeader:          *UpgradeLightClientHeader(&pre.AttestedHeader),
+		NextSyncCommittee:       pre.NextSyncCommittee,
+		NextSyncCommitteeBranch: pre.NextSyncCommitteeBranch,
+		FinalizedHeader:         *UpgradeLightClientHeader(&pre.FinalizedHeader),
+		FinalityBranch:          pre.FinalityBranch,
+		SyncAggregate:           pre.SyncAggregate,
+		SignatureSlot:           pre.SignatureSlot,
+	}
+}
+
+func UpgradeLightClientFinalityUpdate(pre *altair.LightClientFinalityUpdate) *LightClientFinalityUpdate {
+	return &LightClientFinalityUpdate{
+		AttestedHeader:  *UpgradeLightClientHeader(&pre.AttestedHeader),
+		FinalizedHeader: LightClientHeader{Beacon: pre.FinalizedHeader},
+		FinalityBranch:  pre.FinalityBranch,
+		SyncAggregate:   pre.SyncAggregate,
+		SignatureSlot:   pre.SignatureSlot,
+	}
+}
+
+func UpgradeLightClientOptimisticUpdate(pre *altair.LightClientOptimisticUpdate) *LightClientOptimisticUpdate {
+	return &LightClientOptimisticUpdate{
+		AttestedHeader: *UpgradeLightClientHeader(&pre.AttestedHeader),
+		SyncAggregate:  pre.SyncAggregate,
+		SignatureSlot:  pre.SignatureSlot,
+	}
+}
+
+// upgradeHeaderLike upgrades altair headers, and keeps capella headers as-is.
+func upgradeHeaderLike(h altair.LightClientHeaderLike) (altair.LightClientHeaderLike, error) {
+	switch x := h.(type) {
+	case *altair.LightClientHeader:
+		return UpgradeLightClientHeader(x), nil
+	case *LightClientHeader:
+		return x, nil
+	default:
+		return nil, fmt.Errorf("cannot upgrade light client header of type %T to capella", h)
+	}
+}
+
+// UpgradeGenericLightClientUpdate upgrades the headers of the update to capella.
+func UpgradeGenericLightClientUpdate(pre *altair.GenericLightClientUpdate) (*altair.GenericLightClientUpdate, error) {
+	out := *pre
+	var err error
+	if out.AttestedHeader, err = upgradeHeaderLike(pre.AttestedHeader); err != nil {
+		return nil, err
+	}
+	if out.FinalizedHeader, err = upgradeHeaderLike(pre.FinalizedHeader); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// UpgradeLightClientStore implements upgrade_lc_store_to_capella, by upgrading all headers in the store.
+func UpgradeLightClientStore(pre *altair.LightClientStore) (*altair.LightClientStore, error) {
+	out := *pre
+	var err error
+	if out.FinalizedHeader, err = upgradeHeaderLike(pre.FinalizedHeader); err != nil {
+		return nil, err
+	}
+	if out.OptimisticHeader, err = upgradeHeaderLike(pre.OptimisticHeader); err != nil {
+		return nil, err
+	}
+	if pre.BestValidUpdate != nil {
+		if out.BestValidUpdate, err = UpgradeGenericLightClientUpdate(pre.BestValidUpdate); err != nil {
+			return nil, err
+		}
+	}
+	return &out, nil
+}
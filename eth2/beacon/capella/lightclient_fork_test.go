@@ -0,0 +1,132 @@
+package capella
+
+import (
+	"testing"
+
+	"github.com/protolambda/ztyp/tree"
+
+	"github.com/protolambda/zrnt/eth2/beacon/altair"
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/protolambda/zrnt/eth2/configs"
+)
+
+func testLightClientSpec() *common.Spec {
+	spec := *configs.Mainnet
+	spec.ALTAIR_FORK_EPOCH = 0
+	spec.BELLATRIX_FORK_EPOCH = 0
+	spec.CAPELLA_FORK_EPOCH = 1
+	return &spec
+}
+
+func testBlock(spec *common.Spec, slot common.Slot) *BeaconBlock {
+	block := &BeaconBlock{
+		Slot:          slot,
+		ProposerIndex: 7,
+		ParentRoot:    common.Root{0x01},
+		StateRoot:     common.Root{0x02},
+	}
+	block.Body.Graffiti = common.Root{0x03}
+	block.Body.SyncAggregate.SyncCommitteeBits = make(altair.SyncCommitteeBits, spec.SYNC_COMMITTEE_SIZE/8)
+	block.Body.ExecutionPayload.BlockHash = common.Hash32{0x04}
+	block.Body.ExecutionPayload.BlockNumber = 123
+	block.Body.ExecutionPayload.Timestamp = 1000
+	return block
+}
+
+func TestLightClientHeaderIsValid(t *testing.T) {
+	spec := testLightClientSpec()
+	block := testBlock(spec, common.Slot(spec.SLOTS_PER_EPOCH)+1)
+	header, err := BlockToLightClientHeader(spec, block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header.Beacon.BodyRoot != block.Body.HashTreeRoot(spec, tree.GetHashFn()) {
+		t.Fatal("header does not commit to the block body")
+	}
+	if !header.IsValid(spec) {
+		t.Fatal("header of block is not valid")
+	}
+
+	testCases := []struct {
+		name   string
+		tamper func(h *LightClientHeader)
+	}{
+		{"execution branch", func(h *LightClientHeader) { h.ExecutionBranch[1][0] ^= 0xff }},
+		{"execution payload header", func(h *LightClientHeader) { h.Execution.BlockNumber++ }},
+		{"body root", func(h *LightClientHeader) { h.Beacon.BodyRoot[0] ^= 0xff }},
+		// a Capella header before the fork must not have execution data
+		{"slot before capella", func(h *LightClientHeader) { h.Beacon.Slot = 1 }},
+	}
+	for _, c := range testCases {
+		tampered := *header
+		c.tamper(&tampered)
+		if tampered.IsValid(spec) {
+			t.Errorf("%s: tampered header is valid", c.name)
+		}
+	}
+
+	// Before Capella the header is valid only without execution data and branch.
+	pre := UpgradeLightClientHeader(&altair.LightClientHeader{Beacon: common.BeaconBlockHeader{Slot: 1, BodyRoot: common.Root{0x05}}})
+	if !pre.IsValid(spec) {
+		t.Error("upgraded pre-capella header is not valid")
+	}
+	if pre.ExecutionRoot(spec) != (common.Root{}) {
+		t.Error("expected zero execution root before capella")
+	}
+	pre.ExecutionBranch[0] = common.Root{0x06}
+	if pre.IsValid(spec) {
+		t.Error("pre-capella header with execution branch is valid")
+	}
+}
+
+func TestUpgradeLightClientStore(t *testing.T) {
+	spec := testLightClientSpec()
+	hFn := tree.GetHashFn()
+	finalized := &altair.LightClientHeader{Beacon: common.BeaconBlockHeader{Slot: 8, BodyRoot: common.Root{0x07}}}
+	optimistic := &altair.LightClientHeader{Beacon: common.BeaconBlockHeader{Slot: 20, BodyRoot: common.Root{0x08}}}
+	capellaHeader, err := BlockToLightClientHeader(spec, testBlock(spec, common.Slot(spec.SLOTS_PER_EPOCH)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pre := &altair.LightClientStore{
+		FinalizedHeader:  finalized,
+		OptimisticHeader: optimistic,
+		BestValidUpdate: &altair.GenericLightClientUpdate{
+			AttestedHeader:  capellaHeader,
+			FinalizedHeader: finalized,
+		},
+		CurrentMaxActiveParticipants: 10,
+	}
+	out, err := UpgradeLightClientStore(pre)
+	if err != nil {
+		t.Fatal(err)
+	}
+	headers := []struct {
+		name string
+		pre  altair.LightClientHeaderLike
+		post altair.LightClientHeaderLike
+	}{
+		{"finalized", finalized, out.FinalizedHeader},
+		{"optimistic", optimistic, out.OptimisticHeader},
+		{"best attested", capellaHeader, out.BestValidUpdate.AttestedHeader},
+		{"best finalized", finalized, out.BestValidUpdate.FinalizedHeader},
+	}
+	for _, h := range headers {
+		if _, ok := h.post.(*LightClientHeader); !ok {
+			t.Errorf("%s: expected capella header, got %T", h.name, h.post)
+			continue
+		}
+		if h.post.BeaconHeader().HashTreeRoot(hFn) != h.pre.BeaconHeader().HashTreeRoot(hFn) {
+			t.Errorf("%s: beacon root changed", h.name)
+		}
+		if !h.post.IsValid(spec) {
+			t.Errorf("%s: upgraded header is not valid", h.name)
+		}
+	}
+	if out.CurrentMaxActiveParticipants != 10 {
+		t.Error("participants are not kept")
+	}
+	if _, ok := pre.FinalizedHeader.(*altair.LightClientHeader); !ok {
+		t.Error("pre store is modified")
+	}
+}
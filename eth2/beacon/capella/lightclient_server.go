@@ -0,0 +1,139 @@
+package capella
+
+import (
+	"fmt"
+
+	"github.com/protolambda/ztyp/tree"
+
+	"github.com/protolambda/zrnt/eth2/beacon/altair"
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/protolambda/zrnt/eth2/util/merkle"
+)
+
+// https://github.com/ethereum/consensus-specs/blob/dev/specs/capella/light-client/full-node.md
+
+// The BeaconBlockBody has 11 fields, padded to 16, a depth of 4 bits.
+// The execution payload is the 10th field.
+const EXECUTION_PAYLOAD_INDEX = tree.Gindex64((1 << ExecutionBranchLength) | 9)
+
+// ExecutionPayloadBranch computes the merkle branch of the execution payload in the block body.
+func (b *BeaconBlockBody) ExecutionPayloadBranch(spec *common.Spec, hFn tree.HashFn) (out ExecutionBranch, err error) {
+	fields := []common.Root{
+		b.RandaoReveal.HashTreeRoot(hFn),
+		b.Eth1Data.HashTreeRoot(hFn),
+		b.Graffiti,
+		spec.Wrap(&b.ProposerSlashings).HashTreeRoot(hFn),
+		spec.Wrap(&b.AttesterSlashings).HashTreeRoot(hFn),
+		spec.Wrap(&b.Attestations).HashTreeRoot(hFn),
+		spec.Wrap(&b.Deposits).HashTreeRoot(hFn),
+		spec.Wrap(&b.VoluntaryExits).HashTreeRoot(hFn),
+		spec.Wrap(&b.SyncAggregate).HashTreeRoot(hFn),
+		spec.Wrap(&b.ExecutionPayload).HashTreeRoot(hFn),
+		spec.Wrap(&b.BLSToExecutionChanges).HashTreeRoot(hFn),
+	}
+	branch, err := merkle.ComputeFieldsProof(fields, uint64(EXECUTION_PAYLOAD_INDEX)^(1<<ExecutionBranchLength), hFn)
+	if err != nil {
+		return out, err
+	}
+	copy(out[:], branch)
+	return out, nil
+}
+
+// BlockToLightClientHeader creates the light client header of a Capella block.
+// Light client headers of pre-Capella blocks only have the beacon header, and are created with
+// LightClientHeader{Beacon: header} instead.
+func BlockToLightClientHeader(spec *common.Spec, block *BeaconBlock) (*LightClientHeader, error) {
+	hFn := tree.GetHashFn()
+	branch, err := block.Body.ExecutionPayloadBranch(spec, hFn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compute execution branch: %w", err)
+	}
+	return &LightClientHeader{
+		Beacon:          *block.Header(spec),
+		Execution:       *block.Body.ExecutionPayload.Header(spec),
+		ExecutionBranch: branch,
+	}, nil
+}
+
+func checkHeader(header *LightClientHeader, data *altair.LightClientStateData) error {
+	hFn := tree.GetHashFn()
+	if root, expected := header.Beacon.HashTreeRoot(hFn), data.Header.HashTreeRoot(hFn); root != expected {
+		return fmt.Errorf("header root %s does not match state header root %s", root, expected)
+	}
+	return nil
+}
+
+// NewLightClientBootstrap creates a bootstrap for the block of the header, from the post-state of the block.
+func NewLightClientBootstrap(spec *common.Spec, header *LightClientHeader, state common.SyncCommitteeBeaconState) (*LightClientBootstrap, error) {
+	data, err := altair.NewLightClientStateData(spec, state)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkHeader(header, data); err != nil {
+		return nil, err
+	}
+	return &LightClientBootstrap{
+		Header:                     *header,
+		CurrentSyncCommittee:       data.CurrentSyncCommittee,
+		CurrentSyncCommitteeBranch: data.CurrentSyncCommitteeBranch,
+	}, nil
+}
+
+// NewLightClientUpdate creates an update for the attested header, with the post-state of the attested block,
+// signed with the sync aggregate of the block at the signature slot.
+// The finalized header is optional, if nil the update does not indicate finality.
+func NewLightClientUpdate(spec *common.Spec, attestedHeader *LightClientHeader, attestedState common.SyncCommitteeBeaconState,
+	syncAggregate *altair.SyncAggregate, signatureSlot common.Slot, finalizedHeader *LightClientHeader) (*LightClientUpdate, error) {
+	data, err := altair.NewLightClientStateData(spec, attestedState)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkHeader(attestedHeader, data); err != nil {
+		return nil, err
+	}
+	if err := data.CheckSignature(spec, syncAggregate, signatureSlot); err != nil {
+		return nil, err
+	}
+	update := &LightClientUpdate{
+		AttestedHeader: *attestedHeader,
+		NextSyncCommittee: common.SyncCommittee{
+			Pubkeys: make(common.SyncCommitteePubkeys, spec.SYNC_COMMITTEE_SIZE),
+		},
+		SyncAggregate: *syncAggregate,
+		SignatureSlot: signatureSlot,
+	}
+	// The next sync committee is only useful if the message is signed by the current sync committee
+	if altair.ComputeSyncCommitteePeriodAtSlot(spec, data.Header.Slot) == altair.ComputeSyncCommitteePeriodAtSlot(spec, signatureSlot) {
+		update.NextSyncCommittee = data.NextSyncCommittee
+		update.NextSyncCommitteeBranch = data.NextSyncCommitteeBranch
+	}
+	// Indicate finality whenever possible
+	if finalizedHeader != nil {
+		if err := data.CheckFinalizedHeader(&finalizedHeader.Beacon); err != nil {
+			return nil, err
+		}
+		if finalizedHeader.Beacon.Slot != common.GENESIS_SLOT {
+			update.FinalizedHeader = *finalizedHeader
+		}
+		update.FinalityBranch = data.FinalityBranch
+	}
+	return update, nil
+}
+
+func (lcu *LightClientUpdate) FinalityUpdate() *LightClientFinalityUpdate {
+	return &LightClientFinalityUpdate{
+		AttestedHeader:  lcu.AttestedHeader,
+		FinalizedHeader: lcu.FinalizedHeader,
+		FinalityBranch:  lcu.FinalityBranch,
+		SyncAggregate:   lcu.SyncAggregate,
+		SignatureSlot:   lcu.SignatureSlot,
+	}
+}
+
+func (lcu *LightClientUpdate) OptimisticUpdate() *LightClientOptimisticUpdate {
+	return &LightClientOptimisticUpdate{
+		AttestedHeader: lcu.AttestedHeader,
+		SyncAggregate:  lcu.SyncAggregate,
+		SignatureSlot:  lcu.SignatureSlot,
+	}
+}
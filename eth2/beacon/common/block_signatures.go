@@ -0,0 +1,37 @@
+package common
+
+import (
+	blsu "github.com/protolambda/bls12-381-util"
+)
+
+// SignatureCollector collects signatures to verify them later, e.g. all signatures of a batch of blocks at once.
+// blsu.SignatureSet implements it.
+type SignatureCollector interface {
+	Add(pubkey *blsu.Pubkey, message []byte, signature *blsu.Signature)
+}
+
+var _ SignatureCollector = (*blsu.SignatureSet)(nil)
+
+// VerifyBlockSignature verifies the signature, or adds it to BlockSignatures if signatures are collected.
+func (epc *EpochsContext) VerifyBlockSignature(pubkey *blsu.Pubkey, message []byte, signature *blsu.Signature) bool {
+	if epc.BlockSignatures == nil {
+		return blsu.Verify(pubkey, message, signature)
+	}
+	epc.BlockSignatures.Add(pubkey, message, signature)
+	return true
+}
+
+// VerifyBlockAggregateSignature verifies the aggregate signature like blsu.Eth2FastAggregateVerify,
+// or adds it with the aggregate pubkey to BlockSignatures if signatures are collected.
+// Signatures without any pubkeys are checked immediately, they have to be the point at infinity.
+func (epc *EpochsContext) VerifyBlockAggregateSignature(pubkeys []*blsu.Pubkey, message []byte, signature *blsu.Signature) bool {
+	if epc.BlockSignatures == nil || len(pubkeys) == 0 {
+		return blsu.Eth2FastAggregateVerify(pubkeys, message, signature)
+	}
+	aggPub, err := blsu.AggregatePubkeys(pubkeys)
+	if err != nil {
+		return false
+	}
+	epc.BlockSignatures.Add(aggPub, message, signature)
+	return true
+}
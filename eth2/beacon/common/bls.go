@@ -155,6 +155,26 @@ func (p *BLSSignature) Signature() (*blsu.Signature, error) {
 	return &sig, nil
 }
 
+// G2_POINT_AT_INFINITY is the signature of an empty aggregate.
+var G2_POINT_AT_INFINITY = BLSSignature{0: 0xc0}
+
+// AggregateSignatures aggregates the given signatures into a single signature.
+func AggregateSignatures(sigs []BLSSignature) (BLSSignature, error) {
+	blsSigs := make([]*blsu.Signature, 0, len(sigs))
+	for i := range sigs {
+		sig, err := sigs[i].Signature()
+		if err != nil {
+			return BLSSignature{}, fmt.Errorf("failed to deserialize signature %d: %w", i, err)
+		}
+		blsSigs = append(blsSigs, sig)
+	}
+	agg, err := blsu.Aggregate(blsSigs)
+	if err != nil {
+		return BLSSignature{}, err
+	}
+	return agg.Serialize(), nil
+}
+
 func ViewSignature(sig *BLSSignature) *BLSSignatureView {
 	v, _ := BLSSignatureType.Deserialize(codec.NewDecodingReader(bytes.NewReader(sig[:]), 48))
 	return &BLSSignatureView{v.(*BasicVectorView)}
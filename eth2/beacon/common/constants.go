@@ -12,3 +12,7 @@ const GENESIS_SLOT Slot = 0
 const GENESIS_EPOCH Epoch = 0
 
 const JUSTIFICATION_BITS_LENGTH = 4
+
+// INTERVALS_PER_SLOT is the number of intervals that a slot is split in:
+// the block is proposed in the first, attestations are made in the second, and aggregates in the third.
+const INTERVALS_PER_SLOT = 3
@@ -0,0 +1,261 @@
+package common
+
+import (
+	"fmt"
+
+	"github.com/protolambda/ztyp/tree"
+	. "github.com/protolambda/ztyp/view"
+)
+
+// AttesterDuty follows the Beacon API attester duty shape.
+type AttesterDuty struct {
+	Pubkey                  BLSPubkey      `json:"pubkey" yaml:"pubkey"`
+	ValidatorIndex          ValidatorIndex `json:"validator_index" yaml:"validator_index"`
+	CommitteeIndex          CommitteeIndex `json:"committee_index" yaml:"committee_index"`
+	CommitteeLength         Uint64View     `json:"committee_length" yaml:"committee_length"`
+	CommitteesAtSlot        Uint64View     `json:"committees_at_slot" yaml:"committees_at_slot"`
+	ValidatorCommitteeIndex Uint64View     `json:"validator_committee_index" yaml:"validator_committee_index"`
+	Slot                    Slot           `json:"slot" yaml:"slot"`
+}
+
+// AttesterDuties is the response of the Beacon API attester duties endpoint.
+type AttesterDuties struct {
+	// DependentRoot is the block root that the shuffling of the duties depends on.
+	// The duties only change when the chain reorgs past this root.
+	DependentRoot Root `json:"dependent_root" yaml:"dependent_root"`
+	// ExecutionOptimistic is not known from the state, and left to the caller to set.
+	ExecutionOptimistic bool           `json:"execution_optimistic" yaml:"execution_optimistic"`
+	Data                []AttesterDuty `json:"data" yaml:"data"`
+}
+
+// ProposerDuty follows the Beacon API proposer duty shape.
+type ProposerDuty struct {
+	Pubkey         BLSPubkey      `json:"pubkey" yaml:"pubkey"`
+	ValidatorIndex ValidatorIndex `json:"validator_index" yaml:"validator_index"`
+	Slot           Slot           `json:"slot" yaml:"slot"`
+}
+
+// ProposerDuties is the response of the Beacon API proposer duties endpoint.
+type ProposerDuties struct {
+	// DependentRoot is the block root that the proposer shuffling depends on.
+	DependentRoot Root `json:"dependent_root" yaml:"dependent_root"`
+	// ExecutionOptimistic is not known from the state, and left to the caller to set.
+	ExecutionOptimistic bool           `json:"execution_optimistic" yaml:"execution_optimistic"`
+	Data                []ProposerDuty `json:"data" yaml:"data"`
+}
+
+// SyncCommitteeDuty follows the Beacon API sync committee duty shape.
+type SyncCommitteeDuty struct {
+	Pubkey         BLSPubkey      `json:"pubkey" yaml:"pubkey"`
+	ValidatorIndex ValidatorIndex `json:"validator_index" yaml:"validator_index"`
+	// Positions of the validator in the sync committee, a validator may be included more than once.
+	ValidatorSyncCommitteeIndices []Uint64View `json:"validator_sync_committee_indices" yaml:"validator_sync_committee_indices"`
+}
+
+// SyncCommitteeDuties is the response of the Beacon API sync committee duties endpoint.
+// Sync committees are fixed for the period, and have no dependent root.
+type SyncCommitteeDuties struct {
+	// ExecutionOptimistic is not known from the state, and left to the caller to set.
+	ExecutionOptimistic bool                `json:"execution_optimistic" yaml:"execution_optimistic"`
+	Data                []SyncCommitteeDuty `json:"data" yaml:"data"`
+}
+
+// ValidatorIndicesOf resolves the indices of the validators with the given pubkeys.
+// Unknown pubkeys are skipped, like the Beacon API does.
+func ValidatorIndicesOf(epc *EpochsContext, pubkeys []BLSPubkey) []ValidatorIndex {
+	out := make([]ValidatorIndex, 0, len(pubkeys))
+	for _, pub := range pubkeys {
+		if index, ok := epc.ValidatorPubkeyCache.ValidatorIndex(pub); ok {
+			out = append(out, index)
+		}
+	}
+	return out
+}
+
+// dutyPubkeys looks up the pubkeys of the validators. An error is returned if any validator is not in the state.
+func dutyPubkeys(epc *EpochsContext, state BeaconState, indices []ValidatorIndex) (map[ValidatorIndex]BLSPubkey, error) {
+	vals, err := state.Validators()
+	if err != nil {
+		return nil, err
+	}
+	count, err := vals.ValidatorCount()
+	if err != nil {
+		return nil, err
+	}
+	out := make(map[ValidatorIndex]BLSPubkey, len(indices))
+	for _, index := range indices {
+		if uint64(index) >= count {
+			return nil, fmt.Errorf("unknown validator index %d", index)
+		}
+		pub, ok := epc.ValidatorPubkeyCache.Pubkey(index)
+		if !ok {
+			return nil, fmt.Errorf("missing pubkey of validator %d", index)
+		}
+		out[index] = pub.Compressed
+	}
+	return out, nil
+}
+
+// DependentRoot returns the block root at the last slot before the given epoch,
+// or the genesis block root for the genesis epoch.
+// The proposer shuffling of an epoch depends on the dependent root of the epoch itself,
+// the attester shuffling on the dependent root of the epoch before it.
+func DependentRoot(spec *Spec, state BeaconState, epoch Epoch) (Root, error) {
+	slot := GENESIS_SLOT
+	if epoch > GENESIS_EPOCH {
+		start, err := spec.EpochStartSlot(epoch)
+		if err != nil {
+			return Root{}, err
+		}
+		slot = start - 1
+	}
+	stateSlot, err := state.Slot()
+	if err != nil {
+		return Root{}, err
+	}
+	if slot < stateSlot {
+		if slot+spec.SLOTS_PER_HISTORICAL_ROOT < stateSlot {
+			return Root{}, fmt.Errorf("dependent slot %d is too old for state at slot %d", slot, stateSlot)
+		}
+		return GetBlockRootAtSlot(spec, state, slot)
+	}
+	if slot > stateSlot {
+		return Root{}, fmt.Errorf("dependent slot %d is after state slot %d", slot, stateSlot)
+	}
+	// The block root of the state slot itself is not in the history yet, it is the latest block.
+	header, err := state.LatestBlockHeader()
+	if err != nil {
+		return Root{}, err
+	}
+	if header.StateRoot == (Root{}) {
+		header.StateRoot = state.HashTreeRoot(tree.GetHashFn())
+	}
+	return header.HashTreeRoot(tree.GetHashFn()), nil
+}
+
+// GetAttesterDuties computes the attester duties of the validators for the given epoch,
+// which must be the current or next epoch of the epochs-context.
+// Inactive validators have no duty.
+func GetAttesterDuties(spec *Spec, epc *EpochsContext, state BeaconState, epoch Epoch, indices []ValidatorIndex) (*AttesterDuties, error) {
+	if epoch != epc.CurrentEpoch.Epoch && epoch != epc.NextEpoch.Epoch {
+		return nil, fmt.Errorf("attester duties epoch %d is not the current (%d) or next epoch", epoch, epc.CurrentEpoch.Epoch)
+	}
+	pubkeys, err := dutyPubkeys(epc, state, indices)
+	if err != nil {
+		return nil, err
+	}
+	dependentEpoch := GENESIS_EPOCH
+	if epoch > GENESIS_EPOCH {
+		dependentEpoch = epoch - 1
+	}
+	dependentRoot, err := DependentRoot(spec, state, dependentEpoch)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get attester dependent root: %w", err)
+	}
+	comms, err := epc.getEpochComms(epoch)
+	if err != nil {
+		return nil, err
+	}
+	startSlot, err := spec.EpochStartSlot(epoch)
+	if err != nil {
+		return nil, err
+	}
+	out := &AttesterDuties{DependentRoot: dependentRoot, Data: []AttesterDuty{}}
+	for i, slotComms := range comms {
+		slot := startSlot + Slot(i)
+		for committeeIndex, committee := range slotComms {
+			for position, valIndex := range committee {
+				pub, ok := pubkeys[valIndex]
+				if !ok {
+					continue
+				}
+				out.Data = append(out.Data, AttesterDuty{
+					Pubkey:                  pub,
+					ValidatorIndex:          valIndex,
+					CommitteeIndex:          CommitteeIndex(committeeIndex),
+					CommitteeLength:         Uint64View(len(committee)),
+					CommitteesAtSlot:        Uint64View(len(slotComms)),
+					ValidatorCommitteeIndex: Uint64View(position),
+					Slot:                    slot,
+				})
+			}
+		}
+	}
+	return out, nil
+}
+
+// GetProposerDuties computes the proposer duties of the current epoch of the epochs-context, for all validators.
+func GetProposerDuties(spec *Spec, epc *EpochsContext, state BeaconState) (*ProposerDuties, error) {
+	epoch := epc.Proposers.Epoch
+	dependentRoot, err := DependentRoot(spec, state, epoch)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get proposer dependent root: %w", err)
+	}
+	pubkeys, err := dutyPubkeys(epc, state, epc.Proposers.Proposers)
+	if err != nil {
+		return nil, err
+	}
+	startSlot, err := spec.EpochStartSlot(epoch)
+	if err != nil {
+		return nil, err
+	}
+	out := &ProposerDuties{DependentRoot: dependentRoot, Data: make([]ProposerDuty, 0, len(epc.Proposers.Proposers))}
+	for i, valIndex := range epc.Proposers.Proposers {
+		slot := startSlot + Slot(i)
+		// There is no proposer at the genesis slot.
+		if slot == GENESIS_SLOT {
+			continue
+		}
+		out.Data = append(out.Data, ProposerDuty{
+			Pubkey:         pubkeys[valIndex],
+			ValidatorIndex: valIndex,
+			Slot:           slot,
+		})
+	}
+	return out, nil
+}
+
+// GetSyncCommitteeDuties computes the sync committee duties of the validators for the sync committee period
+// of the given epoch, which must be the current or next period of the epochs-context.
+// Validators that are not in the sync committee have no duty.
+func GetSyncCommitteeDuties(spec *Spec, epc *EpochsContext, state BeaconState, epoch Epoch, indices []ValidatorIndex) (*SyncCommitteeDuties, error) {
+	if epc.CurrentSyncCommittee == nil || epc.NextSyncCommittee == nil {
+		return nil, fmt.Errorf("no sync committees before altair")
+	}
+	period := epoch / spec.EPOCHS_PER_SYNC_COMMITTEE_PERIOD
+	currentPeriod := epc.CurrentEpoch.Epoch / spec.EPOCHS_PER_SYNC_COMMITTEE_PERIOD
+	var committee *IndexedSyncCommittee
+	switch period {
+	case currentPeriod:
+		committee = epc.CurrentSyncCommittee
+	case currentPeriod + 1:
+		committee = epc.NextSyncCommittee
+	default:
+		return nil, fmt.Errorf("sync committee duties epoch %d is not in the current (%d) or next period", epoch, currentPeriod)
+	}
+	pubkeys, err := dutyPubkeys(epc, state, indices)
+	if err != nil {
+		return nil, err
+	}
+	positions := make(map[ValidatorIndex][]Uint64View)
+	for i, valIndex := range committee.Indices {
+		if _, ok := pubkeys[valIndex]; ok {
+			positions[valIndex] = append(positions[valIndex], Uint64View(i))
+		}
+	}
+	out := &SyncCommitteeDuties{Data: []SyncCommitteeDuty{}}
+	for _, valIndex := range indices {
+		pos, ok := positions[valIndex]
+		if !ok {
+			continue
+		}
+		// Only list each validator once, even if requested multiple times.
+		delete(positions, valIndex)
+		out.Data = append(out.Data, SyncCommitteeDuty{
+			Pubkey:                        pubkeys[valIndex],
+			ValidatorIndex:                valIndex,
+			ValidatorSyncCommitteeIndices: pos,
+		})
+	}
+	return out, nil
+}
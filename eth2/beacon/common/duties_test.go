@@ -0,0 +1,295 @@
+package common_test
+
+import (
+	"context"
+	"math/big"
+	"testing"
+
+	blsu "github.com/protolambda/bls12-381-util"
+	"github.com/protolambda/ztyp/tree"
+
+	"github.com/protolambda/zrnt/eth2/beacon"
+	"github.com/protolambda/zrnt/eth2/beacon/altair"
+	. "github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/protolambda/zrnt/eth2/beacon/phase0"
+	"github.com/protolambda/zrnt/eth2/configs"
+)
+
+const testValidatorCount = 64
+
+// testGenesis creates a genesis state on the minimal preset, in phase0 or altair.
+func testGenesis(t *testing.T, altairGenesis bool) (*Spec, BeaconState, *EpochsContext) {
+	spec := *configs.Minimal
+	spec.ALTAIR_FORK_EPOCH = FAR_FUTURE_EPOCH
+	if altairGenesis {
+		spec.ALTAIR_FORK_EPOCH = 0
+	}
+	spec.BELLATRIX_FORK_EPOCH = FAR_FUTURE_EPOCH
+	spec.CAPELLA_FORK_EPOCH = FAR_FUTURE_EPOCH
+	spec.DENEB_FORK_EPOCH = FAR_FUTURE_EPOCH
+	validators := make([]phase0.KickstartValidatorData, testValidatorCount)
+	for i := range validators {
+		var raw [32]byte
+		big.NewInt(int64(i + 1)).FillBytes(raw[:])
+		var sk blsu.SecretKey
+		if err := sk.Deserialize(&raw); err != nil {
+			t.Fatal(err)
+		}
+		pub, err := blsu.SkToPk(&sk)
+		if err != nil {
+			t.Fatal(err)
+		}
+		validators[i] = phase0.KickstartValidatorData{Pubkey: pub.Serialize(), Balance: spec.MAX_EFFECTIVE_BALANCE}
+	}
+	state, epc, err := phase0.KickStartState(&spec, Root{0x42}, 1000, validators)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !altairGenesis {
+		return &spec, state, epc
+	}
+	post, err := altair.UpgradeToAltair(&spec, epc, state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	epc, err = NewEpochsContext(&spec, post)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &spec, post, epc
+}
+
+// advance processes empty slots up to the given slot, and returns the updated epochs-context.
+func advance(t *testing.T, spec *Spec, epc *EpochsContext, state BeaconState, slot Slot) *EpochsContext {
+	epc = epc.Clone()
+	if err := ProcessSlots(context.Background(), spec, epc, &beacon.StandardUpgradeableBeaconState{BeaconState: state}, slot); err != nil {
+		t.Fatal(err)
+	}
+	return epc
+}
+
+func latestBlockRoot(t *testing.T, state BeaconState) Root {
+	header, err := state.LatestBlockHeader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header.StateRoot == (Root{}) {
+		header.StateRoot = state.HashTreeRoot(tree.GetHashFn())
+	}
+	return header.HashTreeRoot(tree.GetHashFn())
+}
+
+func allIndices() []ValidatorIndex {
+	out := make([]ValidatorIndex, testValidatorCount)
+	for i := range out {
+		out[i] = ValidatorIndex(i)
+	}
+	return out
+}
+
+func TestDependentRoot(t *testing.T) {
+	spec, state, epc := testGenesis(t, false)
+	genesisRoot := latestBlockRoot(t, state)
+
+	// At genesis the dependent slot of the genesis epoch is the state slot itself,
+	// and the latest header does not have its state root yet.
+	if root, err := DependentRoot(spec, state, 0); err != nil {
+		t.Fatal(err)
+	} else if root != genesisRoot {
+		t.Fatalf("expected genesis block root %s, got %s", genesisRoot, root)
+	}
+	if _, err := DependentRoot(spec, state, 1); err == nil {
+		t.Fatal("expected error for dependent slot after the state slot")
+	}
+
+	// A block at the last slot of epoch 0 is the latest header, while the state is still at that slot.
+	lastSlot := spec.SLOTS_PER_EPOCH - 1
+	epc = advance(t, spec, epc, state, lastSlot)
+	if err := state.SetLatestBlockHeader(&BeaconBlockHeader{Slot: lastSlot, ParentRoot: genesisRoot}); err != nil {
+		t.Fatal(err)
+	}
+	blockRoot := latestBlockRoot(t, state)
+	if root, err := DependentRoot(spec, state, 1); err != nil {
+		t.Fatal(err)
+	} else if root != blockRoot {
+		t.Fatalf("expected latest block root %s, got %s", blockRoot, root)
+	}
+
+	// Once the slot is processed, the same root is read from the block roots history.
+	advance(t, spec, epc, state, spec.SLOTS_PER_EPOCH)
+	if root, err := DependentRoot(spec, state, 1); err != nil {
+		t.Fatal(err)
+	} else if root != blockRoot {
+		t.Fatalf("expected block root %s from history, got %s", blockRoot, root)
+	}
+	if root, err := DependentRoot(spec, state, 0); err != nil {
+		t.Fatal(err)
+	} else if root != genesisRoot {
+		t.Fatalf("expected genesis block root %s, got %s", genesisRoot, root)
+	}
+	if _, err := DependentRoot(spec, state, 2); err == nil {
+		t.Fatal("expected error for dependent slot after the state slot")
+	}
+}
+
+func TestGetAttesterDuties(t *testing.T) {
+	spec, state, epc := testGenesis(t, false)
+	genesisRoot := latestBlockRoot(t, state)
+	for _, epoch := range []Epoch{0, 1} {
+		duties, err := GetAttesterDuties(spec, epc, state, epoch, allIndices())
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Both epochs depend on the genesis block: the dependent epoch of epoch 0 is epoch 0 itself.
+		if duties.DependentRoot != genesisRoot {
+			t.Fatalf("epoch %d: expected genesis dependent root, got %s", epoch, duties.DependentRoot)
+		}
+		if len(duties.Data) != testValidatorCount {
+			t.Fatalf("epoch %d: expected a duty per validator, got %d", epoch, len(duties.Data))
+		}
+		start, _ := spec.EpochStartSlot(epoch)
+		seen := make(map[ValidatorIndex]bool)
+		for _, d := range duties.Data {
+			if d.Slot < start || d.Slot >= start+spec.SLOTS_PER_EPOCH {
+				t.Fatalf("epoch %d: duty at slot %d", epoch, d.Slot)
+			}
+			committee, err := epc.GetBeaconCommittee(d.Slot, d.CommitteeIndex)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if uint64(d.CommitteeLength) != uint64(len(committee)) || committee[d.ValidatorCommitteeIndex] != d.ValidatorIndex {
+				t.Fatalf("epoch %d: duty does not match committee: %+v", epoch, d)
+			}
+			count, _ := epc.GetCommitteeCountPerSlot(epoch)
+			if uint64(d.CommitteesAtSlot) != count {
+				t.Fatalf("epoch %d: expected %d committees at slot, got %d", epoch, count, d.CommitteesAtSlot)
+			}
+			if pub, _ := epc.ValidatorPubkeyCache.Pubkey(d.ValidatorIndex); pub.Compressed != d.Pubkey {
+				t.Fatalf("epoch %d: wrong pubkey for validator %d", epoch, d.ValidatorIndex)
+			}
+			seen[d.ValidatorIndex] = true
+		}
+		if len(seen) != testValidatorCount {
+			t.Fatalf("epoch %d: expected each validator once, got %d", epoch, len(seen))
+		}
+	}
+	if _, err := GetAttesterDuties(spec, epc, state, 2, allIndices()); err == nil {
+		t.Fatal("expected error for epoch after next epoch")
+	}
+	if _, err := GetAttesterDuties(spec, epc, state, 0, []ValidatorIndex{testValidatorCount}); err == nil {
+		t.Fatal("expected error for unknown validator")
+	}
+	duties, err := GetAttesterDuties(spec, epc, state, 0, []ValidatorIndex{3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(duties.Data) != 1 || duties.Data[0].ValidatorIndex != 3 {
+		t.Fatalf("expected the duty of validator 3 only: %+v", duties.Data)
+	}
+}
+
+func TestGetProposerDuties(t *testing.T) {
+	spec, state, epc := testGenesis(t, false)
+	genesisRoot := latestBlockRoot(t, state)
+	duties, err := GetProposerDuties(spec, epc, state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if duties.DependentRoot != genesisRoot {
+		t.Fatalf("expected genesis dependent root, got %s", duties.DependentRoot)
+	}
+	// There is no proposer duty for the genesis slot.
+	if uint64(len(duties.Data)) != uint64(spec.SLOTS_PER_EPOCH)-1 {
+		t.Fatalf("expected %d duties, got %d", spec.SLOTS_PER_EPOCH-1, len(duties.Data))
+	}
+	for i, d := range duties.Data {
+		if d.Slot != Slot(i+1) {
+			t.Fatalf("expected duty at slot %d, got %d", i+1, d.Slot)
+		}
+		if proposer, _ := epc.GetBeaconProposer(d.Slot); proposer != d.ValidatorIndex {
+			t.Fatalf("slot %d: expected proposer %d, got %d", d.Slot, proposer, d.ValidatorIndex)
+		}
+	}
+
+	// In epoch 1 the proposers depend on the last block of epoch 0, the genesis block here.
+	state, err = state.CopyState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	epc = advance(t, spec, epc, state, spec.SLOTS_PER_EPOCH)
+	duties, err = GetProposerDuties(spec, epc, state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if duties.DependentRoot != genesisRoot {
+		t.Fatalf("expected genesis dependent root, got %s", duties.DependentRoot)
+	}
+	if uint64(len(duties.Data)) != uint64(spec.SLOTS_PER_EPOCH) || duties.Data[0].Slot != spec.SLOTS_PER_EPOCH {
+		t.Fatalf("expected a duty for every slot of epoch 1: %+v", duties.Data)
+	}
+}
+
+func TestGetSyncCommitteeDuties(t *testing.T) {
+	spec, state, epc := testGenesis(t, false)
+	if _, err := GetSyncCommitteeDuties(spec, epc, state, 0, allIndices()); err == nil {
+		t.Fatal("expected error before altair")
+	}
+
+	spec, state, epc = testGenesis(t, true)
+	period := spec.EPOCHS_PER_SYNC_COMMITTEE_PERIOD
+	check := func(epc *EpochsContext, epoch Epoch, expected *IndexedSyncCommittee) {
+		t.Helper()
+		duties, err := GetSyncCommitteeDuties(spec, epc, state, epoch, append(allIndices(), 0, 1))
+		if err != nil {
+			t.Fatalf("epoch %d: %v", epoch, err)
+		}
+		positions := 0
+		seen := make(map[ValidatorIndex]bool)
+		for _, d := range duties.Data {
+			if seen[d.ValidatorIndex] {
+				t.Fatalf("epoch %d: validator %d listed twice", epoch, d.ValidatorIndex)
+			}
+			seen[d.ValidatorIndex] = true
+			for _, pos := range d.ValidatorSyncCommitteeIndices {
+				if expected.Indices[pos] != d.ValidatorIndex {
+					t.Fatalf("epoch %d: validator %d is not at position %d", epoch, d.ValidatorIndex, pos)
+				}
+				positions += 1
+			}
+		}
+		if uint64(positions) != uint64(spec.SYNC_COMMITTEE_SIZE) {
+			t.Fatalf("epoch %d: expected %d positions, got %d", epoch, spec.SYNC_COMMITTEE_SIZE, positions)
+		}
+	}
+	for _, epoch := range []Epoch{0, period - 1} {
+		check(epc, epoch, epc.CurrentSyncCommittee)
+	}
+	for _, epoch := range []Epoch{period, 2*period - 1} {
+		check(epc, epoch, epc.NextSyncCommittee)
+	}
+	if _, err := GetSyncCommitteeDuties(spec, epc, state, 2*period, allIndices()); err == nil {
+		t.Fatal("expected error for epoch after the next period")
+	}
+
+	// At the start of the next period, the next sync committee becomes the current one.
+	prevNext := epc.NextSyncCommittee
+	start, _ := spec.EpochStartSlot(period)
+	advance(t, spec, epc, state, start)
+	epc, err := NewEpochsContext(spec, state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, index := range epc.CurrentSyncCommittee.Indices {
+		if prevNext.Indices[i] != index {
+			t.Fatal("expected the previous next sync committee to be current")
+		}
+	}
+	check(epc, period, epc.CurrentSyncCommittee)
+	check(epc, 3*period-1, epc.NextSyncCommittee)
+	if _, err := GetSyncCommitteeDuties(spec, epc, state, period-1, allIndices()); err == nil {
+		t.Fatal("expected error for epoch of the previous period")
+	}
+	if _, err := GetSyncCommitteeDuties(spec, epc, state, 3*period, allIndices()); err == nil {
+		t.Fatal("expected error for epoch after the next period")
+	}
+}
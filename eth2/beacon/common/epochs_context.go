@@ -30,6 +30,16 @@ func (isc *IndexedSyncCommittee) Subnets(spec *Spec, valIndex ValidatorIndex) (o
 	return out
 }
 
+// SyncnetBits returns the sync committee subnets that any of the given validators is part of.
+func (isc *IndexedSyncCommittee) SyncnetBits(spec *Spec, valIndices []ValidatorIndex) (out SyncnetBits) {
+	for _, valIndex := range valIndices {
+		for _, subnet := range isc.Subnets(spec, valIndex) {
+			out.SetBit(subnet, true)
+		}
+	}
+	return out
+}
+
 func (isc *IndexedSyncCommittee) InSubnet(spec *Spec, valIndex ValidatorIndex, subnet uint64) bool {
 	for i, commValIndex := range isc.Indices {
 		if commValIndex == valIndex {
@@ -65,6 +75,10 @@ type EpochsContext struct {
 	TotalActiveStake Gwei
 	// cached integer square root of TotalActiveStake
 	TotalActiveStakeSqRoot Gwei
+
+	// BlockSignatures, if not nil, collects the RANDAO, attestation and sync aggregate signatures of processed blocks,
+	// instead of verifying them during the state transition. The collector is responsible for verifying them.
+	BlockSignatures SignatureCollector
 }
 
 // NewEpochsContext constructs a new context for the processing of the current epoch.
@@ -330,6 +344,15 @@ func (epc *EpochsContext) GetCommitteeCountPerSlot(epoch Epoch) (uint64, error)
 	return uint64(len(epochComms[0])), err
 }
 
+// AttestationSubnet returns the subnet of the attestations of the given committee.
+func (epc *EpochsContext) AttestationSubnet(slot Slot, committeeIndex CommitteeIndex) (uint64, error) {
+	committeesPerSlot, err := epc.GetCommitteeCountPerSlot(epc.Spec.SlotToEpoch(slot))
+	if err != nil {
+		return 0, err
+	}
+	return ComputeSubnetForAttestation(epc.Spec, committeesPerSlot, slot, committeeIndex)
+}
+
 func (epc *EpochsContext) GetBeaconProposer(slot Slot) (ValidatorIndex, error) {
 	return epc.Proposers.GetBeaconProposer(slot)
 }
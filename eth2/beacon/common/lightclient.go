@@ -0,0 +1,21 @@
+package common
+
+// LightClientUpdateEnvelope is a fork-agnostic summary of a light-client finality or optimistic update,
+// with everything that is needed to validate the update on gossip.
+type LightClientUpdateEnvelope struct {
+	// Slot of the attested header, the block that is signed by the sync committee.
+	AttestedSlot Slot
+	// Slot of the finalized header. Zero for optimistic updates.
+	FinalizedSlot Slot
+	// Slot of the block with the sync aggregate.
+	SignatureSlot Slot
+	// Number of sync committee members that signed.
+	SyncParticipants uint64
+	// Cached hash-tree-root of the full update.
+	UpdateRoot Root
+}
+
+// HasSupermajority returns true if more than 2/3 of the sync committee signed.
+func (e *LightClientUpdateEnvelope) HasSupermajority(spec *Spec) bool {
+	return e.SyncParticipants*3 > uint64(spec.SYNC_COMMITTEE_SIZE)*2
+}
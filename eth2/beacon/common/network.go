@@ -1,15 +1,30 @@
 package common
 
 import (
+	"bytes"
+	"encoding/binary"
 	"encoding/hex"
 	"errors"
 	"fmt"
+	"math/big"
 
+	"github.com/protolambda/ztyp/bitfields"
 	"github.com/protolambda/ztyp/codec"
 	"github.com/protolambda/ztyp/tree"
 	. "github.com/protolambda/ztyp/view"
+
+	"github.com/protolambda/zrnt/eth2/util/hashing"
+)
+
+// ENR keys of the eth2 fields of a node record.
+const (
+	ENR_ETH2_KEY                 = "eth2"
+	ENR_ATTNETS_KEY              = "attnets"
+	ENR_SYNCNETS_KEY             = "syncnets"
+	ENR_CUSTODY_SUBNET_COUNT_KEY = "csc"
 )
 
+// Eth2Data is the ENRForkID, the SSZ-encoded value of the "eth2" ENR field.
 type Eth2Data struct {
 	ForkDigest      ForkDigest `json:"fork_digest" yaml:"fork_digest"`
 	NextForkVersion Version    `json:"next_fork_version" yaml:"next_fork_version"`
@@ -36,6 +51,42 @@ func (d *Eth2Data) HashTreeRoot(hFn tree.HashFn) Root {
 	return hFn.HashTreeRoot(&d.ForkDigest, &d.NextForkVersion, &d.NextForkEpoch)
 }
 
+// MarshalBinary encodes the data as ENR field value.
+func (d *Eth2Data) MarshalBinary() ([]byte, error) {
+	var buf bytes.Buffer
+	if err := d.Serialize(codec.NewEncodingWriter(&buf)); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// UnmarshalBinary decodes the data from an ENR field value.
+func (d *Eth2Data) UnmarshalBinary(data []byte) error {
+	if uint64(len(data)) != d.FixedLength() {
+		return fmt.Errorf("invalid eth2 ENR field length: %d", len(data))
+	}
+	return d.Deserialize(codec.NewDecodingReader(bytes.NewReader(data), uint64(len(data))))
+}
+
+func (d *Eth2Data) String() string {
+	return fmt.Sprintf("Eth2Data(fork_digest: %s, next_fork_version: %s, next_fork_epoch: %d)",
+		d.ForkDigest.String(), d.NextForkVersion.String(), d.NextForkEpoch)
+}
+
+// ENRForkID is the name of the "eth2" ENR field value in the p2p-interface spec.
+type ENRForkID = Eth2Data
+
+// ComputeENRForkID returns the "eth2" ENR field value to advertise at the given epoch:
+// the fork digest of the current fork, and the version and epoch of the next scheduled fork.
+func ComputeENRForkID(spec *Spec, genesisValidatorsRoot Root, epoch Epoch) Eth2Data {
+	nextVersion, nextEpoch := spec.NextFork(epoch)
+	return Eth2Data{
+		ForkDigest:      ComputeForkDigest(spec.ForkVersionAtEpoch(epoch), genesisValidatorsRoot),
+		NextForkVersion: nextVersion,
+		NextForkEpoch:   nextEpoch,
+	}
+}
+
 const ATTESTATION_SUBNET_COUNT = 64
 
 const attnetByteLen = (ATTESTATION_SUBNET_COUNT + 7) / 8
@@ -92,6 +143,90 @@ func (p *AttnetBits) UnmarshalText(text []byte) error {
 	return err
 }
 
+func (p *AttnetBits) GetBit(i uint64) bool {
+	return bitfields.GetBit(p[:], i)
+}
+
+func (p *AttnetBits) SetBit(i uint64, v bool) {
+	bitfields.SetBit(p[:], i, v)
+}
+
+// Subnets returns the indices of the subnets that are set.
+func (p *AttnetBits) Subnets() (out []uint64) {
+	for i := uint64(0); i < ATTESTATION_SUBNET_COUNT; i++ {
+		if p.GetBit(i) {
+			out = append(out, i)
+		}
+	}
+	return out
+}
+
+// AttnetBitsOf returns the bitfield with the given subnets set. Out-of-range subnets are ignored.
+func AttnetBitsOf(subnets []uint64) (out AttnetBits) {
+	for _, s := range subnets {
+		if s < ATTESTATION_SUBNET_COUNT {
+			out.SetBit(s, true)
+		}
+	}
+	return out
+}
+
+// ComputeAttnets returns the "attnets" bitfield that the node advertises for the given epoch,
+// i.e. the subnets of ComputeSubscribedSubnets.
+func ComputeAttnets(spec *Spec, nodeID NodeID, epoch Epoch) AttnetBits {
+	return AttnetBitsOf(ComputeSubscribedSubnets(spec, nodeID, epoch))
+}
+
+// ComputeSubnetForAttestation returns the attestation subnet of a committee,
+// given the number of committees per slot in the epoch of the attestation.
+func ComputeSubnetForAttestation(spec *Spec, committeesPerSlot uint64, slot Slot, committeeIndex CommitteeIndex) (uint64, error) {
+	if uint64(committeeIndex) >= committeesPerSlot {
+		return 0, fmt.Errorf("committee index %d out of range, only %d committees per slot", committeeIndex, committeesPerSlot)
+	}
+	slotsSinceEpochStart := uint64(slot % spec.SLOTS_PER_EPOCH)
+	committeesSinceEpochStart := committeesPerSlot * slotsSinceEpochStart
+	return (committeesSinceEpochStart + uint64(committeeIndex)) % uint64(spec.ATTESTATION_SUBNET_COUNT), nil
+}
+
+// NodeID is a discv5 node identity, interpreted as a big-endian uint256.
+type NodeID [32]byte
+
+const NODE_ID_BITS = 256
+
+// ComputeSubscribedSubnet returns the attestation subnet that the node subscribes to for the given epoch,
+// for the subscription with the given index, in range [0, SUBNETS_PER_NODE).
+func ComputeSubscribedSubnet(spec *Spec, nodeID NodeID, epoch Epoch, index uint64) uint64 {
+	id := new(big.Int).SetBytes(nodeID[:])
+	prefixBits := uint64(spec.ATTESTATION_SUBNET_PREFIX_BITS)
+	nodeIDPrefix := new(big.Int).Rsh(id, uint(NODE_ID_BITS-prefixBits)).Uint64()
+	nodeOffset := new(big.Int).Mod(id, new(big.Int).SetUint64(uint64(spec.EPOCHS_PER_SUBNET_SUBSCRIPTION))).Uint64()
+	var period [8]byte
+	binary.LittleEndian.PutUint64(period[:], (uint64(epoch)+nodeOffset)/uint64(spec.EPOCHS_PER_SUBNET_SUBSCRIPTION))
+	permutationSeed := hashing.Hash(period[:])
+	permutatedPrefix := PermuteIndex(uint8(spec.SHUFFLE_ROUND_COUNT), ValidatorIndex(nodeIDPrefix),
+		uint64(1)<<prefixBits, permutationSeed)
+	return (uint64(permutatedPrefix) + index) % uint64(spec.ATTESTATION_SUBNET_COUNT)
+}
+
+// ComputeSubscribedSubnets returns the SUBNETS_PER_NODE attestation subnets that the node subscribes to for the epoch.
+func ComputeSubscribedSubnets(spec *Spec, nodeID NodeID, epoch Epoch) []uint64 {
+	out := make([]uint64, spec.SUBNETS_PER_NODE)
+	for i := range out {
+		out[i] = ComputeSubscribedSubnet(spec, nodeID, epoch, uint64(i))
+	}
+	return out
+}
+
+// SubscribedSubnetsChangeEpoch returns the first epoch after the given epoch
+// in which the node subscribes to different subnets, to schedule the next subscription change.
+func SubscribedSubnetsChangeEpoch(spec *Spec, nodeID NodeID, epoch Epoch) Epoch {
+	period := uint64(spec.EPOCHS_PER_SUBNET_SUBSCRIPTION)
+	id := new(big.Int).SetBytes(nodeID[:])
+	nodeOffset := new(big.Int).Mod(id, new(big.Int).SetUint64(period)).Uint64()
+	next := ((uint64(epoch)+nodeOffset)/period+1)*period - nodeOffset
+	return Epoch(next)
+}
+
 const syncnetByteLen = (SYNC_COMMITTEE_SUBNET_COUNT + 7) / 8
 
 type SyncnetBits [syncnetByteLen]byte
@@ -147,6 +282,51 @@ func (p *SyncnetBits) UnmarshalText(text []byte) error {
 	return err
 }
 
+func (p *SyncnetBits) GetBit(i uint64) bool {
+	return bitfields.GetBit(p[:], i)
+}
+
+func (p *SyncnetBits) SetBit(i uint64, v bool) {
+	bitfields.SetBit(p[:], i, v)
+}
+
+// Subnets returns the indices of the subnets that are set.
+func (p *SyncnetBits) Subnets() (out []uint64) {
+	for i := uint64(0); i < SYNC_COMMITTEE_SUBNET_COUNT; i++ {
+		if p.GetBit(i) {
+			out = append(out, i)
+		}
+	}
+	return out
+}
+
+// SyncnetBitsOf returns the bitfield with the given subnets set. Out-of-range subnets are ignored.
+func SyncnetBitsOf(subnets []uint64) (out SyncnetBits) {
+	for _, s := range subnets {
+		if s < SYNC_COMMITTEE_SUBNET_COUNT {
+			out.SetBit(s, true)
+		}
+	}
+	return out
+}
+
+// ComputeSyncnets returns the "syncnets" bitfield that a node with the given validators advertises:
+// the subnets of the validators in the current sync committee, and in the next sync committee,
+// which the validators subscribe to ahead of the sync committee period.
+// The committees may be nil, e.g. before Altair.
+func ComputeSyncnets(spec *Spec, current *IndexedSyncCommittee, next *IndexedSyncCommittee, valIndices []ValidatorIndex) (out SyncnetBits) {
+	for _, isc := range []*IndexedSyncCommittee{current, next} {
+		if isc == nil {
+			continue
+		}
+		bits := isc.SyncnetBits(spec, valIndices)
+		for i := range out {
+			out[i] |= bits[i]
+		}
+	}
+	return out
+}
+
 type SeqNr Uint64View
 
 func (i *SeqNr) Deserialize(dr *codec.DecodingReader) error {
@@ -249,6 +429,46 @@ func (i Pong) String() string {
 	return Uint64View(i).String()
 }
 
+// MetaDataV1 is the phase0 MetaData, served by the v1 metadata protocol.
+type MetaDataV1 struct {
+	SeqNumber SeqNr      `json:"seq_number" yaml:"seq_number"`
+	Attnets   AttnetBits `json:"attnets" yaml:"attnets"`
+}
+
+func (m *MetaDataV1) Data() map[string]interface{} {
+	return map[string]interface{}{
+		"seq_number": m.SeqNumber,
+		"attnets":    hex.EncodeToString(m.Attnets[:]),
+	}
+}
+
+func (d *MetaDataV1) Deserialize(dr *codec.DecodingReader) error {
+	return dr.FixedLenContainer(&d.SeqNumber, &d.Attnets)
+}
+
+func (d *MetaDataV1) Serialize(w *codec.EncodingWriter) error {
+	return w.FixedLenContainer(&d.SeqNumber, &d.Attnets)
+}
+
+const MetadataV1ByteLen = 8 + attnetByteLen
+
+func (d MetaDataV1) ByteLength() uint64 {
+	return MetadataV1ByteLen
+}
+
+func (*MetaDataV1) FixedLength() uint64 {
+	return MetadataV1ByteLen
+}
+
+func (d *MetaDataV1) HashTreeRoot(hFn tree.HashFn) Root {
+	return hFn.HashTreeRoot(&d.SeqNumber, &d.Attnets)
+}
+
+func (m *MetaDataV1) String() string {
+	return fmt.Sprintf("MetaDataV1(seq: %d, attnet bits: %08b)", m.SeqNumber, m.Attnets)
+}
+
+// MetaData is the Altair MetaData (v2), with the sync committee subnets.
 type MetaData struct {
 	SeqNumber SeqNr       `json:"seq_number" yaml:"seq_number"`
 	Attnets   AttnetBits  `json:"attnets" yaml:"attnets"`
@@ -289,6 +509,60 @@ func (m *MetaData) String() string {
 	return fmt.Sprintf("MetaData(seq: %d, attnet bits: %08b, syncnet bits: %08b)", m.SeqNumber, m.Attnets, m.Syncnets)
 }
 
+// V1 returns the phase0 MetaData, without the syncnets.
+func (m *MetaData) V1() MetaDataV1 {
+	return MetaDataV1{SeqNumber: m.SeqNumber, Attnets: m.Attnets}
+}
+
+// MetaDataV3 is the PeerDAS MetaData, with the number of data column subnets the node custodies.
+type MetaDataV3 struct {
+	SeqNumber          SeqNr       `json:"seq_number" yaml:"seq_number"`
+	Attnets            AttnetBits  `json:"attnets" yaml:"attnets"`
+	Syncnets           SyncnetBits `json:"syncnets" yaml:"syncnets"`
+	CustodySubnetCount Uint64View  `json:"custody_subnet_count" yaml:"custody_subnet_count"`
+}
+
+func (m *MetaDataV3) Data() map[string]interface{} {
+	return map[string]interface{}{
+		"seq_number":           m.SeqNumber,
+		"attnets":              hex.EncodeToString(m.Attnets[:]),
+		"syncnets":             hex.EncodeToString(m.Syncnets[:]),
+		"custody_subnet_count": m.CustodySubnetCount,
+	}
+}
+
+func (d *MetaDataV3) Deserialize(dr *codec.DecodingReader) error {
+	return dr.FixedLenContainer(&d.SeqNumber, &d.Attnets, &d.Syncnets, &d.CustodySubnetCount)
+}
+
+func (d *MetaDataV3) Serialize(w *codec.EncodingWriter) error {
+	return w.FixedLenContainer(&d.SeqNumber, &d.Attnets, &d.Syncnets, &d.CustodySubnetCount)
+}
+
+const MetadataV3ByteLen = 8 + attnetByteLen + syncnetByteLen + 8
+
+func (d MetaDataV3) ByteLength() uint64 {
+	return MetadataV3ByteLen
+}
+
+func (*MetaDataV3) FixedLength() uint64 {
+	return MetadataV3ByteLen
+}
+
+func (d *MetaDataV3) HashTreeRoot(hFn tree.HashFn) Root {
+	return hFn.HashTreeRoot(&d.SeqNumber, &d.Attnets, &d.Syncnets, &d.CustodySubnetCount)
+}
+
+func (m *MetaDataV3) String() string {
+	return fmt.Sprintf("MetaDataV3(seq: %d, attnet bits: %08b, syncnet bits: %08b, custody subnets: %d)",
+		m.SeqNumber, m.Attnets, m.Syncnets, m.CustodySubnetCount)
+}
+
+// V2 returns the Altair MetaData, without the custody subnet count.
+func (m *MetaDataV3) V2() MetaData {
+	return MetaData{SeqNumber: m.SeqNumber, Attnets: m.Attnets, Syncnets: m.Syncnets}
+}
+
 type Status struct {
 	ForkDigest     ForkDigest `json:"fork_digest" yaml:"fork_digest"`
 	FinalizedRoot  Root       `json:"finalized_root" yaml:"finalized_root"`
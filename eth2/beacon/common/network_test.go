@@ -0,0 +1,238 @@
+package common_test
+
+import (
+	"encoding/hex"
+	"math/big"
+	"testing"
+
+	. "github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/protolambda/zrnt/eth2/configs"
+)
+
+func testNodeID(hex string) (out NodeID) {
+	v, ok := new(big.Int).SetString(hex, 16)
+	if !ok {
+		panic("invalid node id " + hex)
+	}
+	v.FillBytes(out[:])
+	return out
+}
+
+func TestComputeSubscribedSubnets(t *testing.T) {
+	// Expected subnets are computed with the pseudocode of the p2p spec:
+	// the 6 prefix bits of the node ID are shuffled with a seed of the subscription period,
+	// and the period is offset by node_id % EPOCHS_PER_SUBNET_SUBSCRIPTION.
+	prefixed := "a800000000000000000000000000000000000000000000000000000000000000"
+	testCases := []struct {
+		name    string
+		nodeID  NodeID
+		epoch   Epoch
+		mainnet []uint64
+		minimal []uint64
+	}{
+		{"zero", NodeID{}, 0, []uint64{49, 50}, []uint64{62, 63}},
+		{"zero, last epoch of period", NodeID{}, 255, []uint64{49, 50}, []uint64{62, 63}},
+		{"zero, next period", NodeID{}, 256, []uint64{16, 17}, []uint64{58, 59}},
+		{"zero, later period", NodeID{}, 1000, []uint64{28, 29}, []uint64{12, 13}},
+		{"max", testNodeID("ffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff"), 0, []uint64{57, 58}, []uint64{12, 13}},
+		// offset 255: the first period ends after epoch 0
+		{"max, second period", testNodeID("ffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff"), 1, []uint64{55, 56}, []uint64{10, 11}},
+		{"prefix 0x2a", testNodeID(prefixed), 0, []uint64{3, 4}, []uint64{57, 58}},
+		{"prefix 0x2a, next period", testNodeID(prefixed), 256, []uint64{0, 1}, []uint64{8, 9}},
+		// bits below the prefix only matter through the offset
+		{"prefix 0x2a, low bits", testNodeID("a800000000000000000000000000000000000000000000000000000000000100"), 0, []uint64{3, 4}, []uint64{57, 58}},
+		// offset 100: the subscription rotates after epoch 155 instead of 255
+		{"prefix 0x2a offset 100", testNodeID("a800000000000000000000000000000000000000000000000000000000000064"), 155, []uint64{3, 4}, []uint64{57, 58}},
+		{"prefix 0x2a offset 100, rotated", testNodeID("a800000000000000000000000000000000000000000000000000000000000064"), 156, []uint64{0, 1}, []uint64{8, 9}},
+		{"prefix 0x2a offset 100, later period", testNodeID("a800000000000000000000000000000000000000000000000000000000000064"), 1000, []uint64{61, 62}, []uint64{2, 3}},
+		{"mixed", testNodeID("0c5a5a5a5a5a5a5a5a5a5a5a5a5a5a5a5a5a5a5a5a5a5a5a5a5a5a5a5a5a5af3"), 0, []uint64{25, 26}, []uint64{18, 19}},
+		{"mixed, rotated", testNodeID("0c5a5a5a5a5a5a5a5a5a5a5a5a5a5a5a5a5a5a5a5a5a5a5a5a5a5a5a5a5a5af3"), 155, []uint64{33, 34}, []uint64{60, 61}},
+	}
+	for _, c := range testCases {
+		for _, s := range []struct {
+			spec     *Spec
+			expected []uint64
+		}{{configs.Mainnet, c.mainnet}, {configs.Minimal, c.minimal}} {
+			got := ComputeSubscribedSubnets(s.spec, c.nodeID, c.epoch)
+			if len(got) != len(s.expected) {
+				t.Errorf("%s (%s): expected %v, got %v", c.name, s.spec.CONFIG_NAME, s.expected, got)
+				continue
+			}
+			for i := range got {
+				if got[i] != s.expected[i] {
+					t.Errorf("%s (%s): expected %v, got %v", c.name, s.spec.CONFIG_NAME, s.expected, got)
+					break
+				}
+			}
+		}
+	}
+
+	// SUBNETS_PER_NODE subscriptions are consecutive subnets, wrapping around the subnet count.
+	spec := *configs.Mainnet
+	spec.SUBNETS_PER_NODE = 3
+	got := ComputeSubscribedSubnets(&spec, testNodeID("a800000000000000000000000000000000000000000000000000000000000064"), 1000)
+	if len(got) != 3 || got[0] != 61 || got[1] != 62 || got[2] != 63 {
+		t.Errorf("expected 3 subnets [61 62 63], got %v", got)
+	}
+	if s := ComputeSubscribedSubnet(&spec, testNodeID("a800000000000000000000000000000000000000000000000000000000000064"), 1000, 3); s != 0 {
+		t.Errorf("expected subnet to wrap around to 0, got %d", s)
+	}
+}
+
+func TestSubscribedSubnetsChangeEpoch(t *testing.T) {
+	testCases := []struct {
+		nodeID   NodeID
+		epoch    Epoch
+		expected Epoch
+	}{
+		{NodeID{}, 0, 256},
+		{NodeID{}, 255, 256},
+		{NodeID{}, 256, 512},
+		{testNodeID("a800000000000000000000000000000000000000000000000000000000000064"), 0, 156},
+		{testNodeID("a800000000000000000000000000000000000000000000000000000000000064"), 156, 412},
+		{testNodeID("ffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff"), 0, 1},
+	}
+	for _, c := range testCases {
+		got := SubscribedSubnetsChangeEpoch(configs.Mainnet, c.nodeID, c.epoch)
+		if got != c.expected {
+			t.Errorf("node %x epoch %d: expected change at epoch %d, got %d", c.nodeID[:], c.epoch, c.expected, got)
+		}
+		before := ComputeSubscribedSubnets(configs.Mainnet, c.nodeID, got-1)
+		after := ComputeSubscribedSubnets(configs.Mainnet, c.nodeID, got)
+		if before[0] == after[0] {
+			t.Errorf("node %x epoch %d: subnets do not change at epoch %d", c.nodeID[:], c.epoch, got)
+		}
+	}
+}
+
+func TestComputeSubnetForAttestation(t *testing.T) {
+	testCases := []struct {
+		name              string
+		committeesPerSlot uint64
+		slot              Slot
+		committeeIndex    CommitteeIndex
+		expected          uint64
+	}{
+		{"first committee", 1, 0, 0, 0},
+		{"single committee per slot", 1, 32 + 17, 0, 17},
+		{"last slot of epoch", 4, 31, 3, 63},
+		{"wraps around subnet count", 64, 33, 5, 5},
+		{"max committees, last slot", 64, 63, 63, 63},
+		{"mid epoch", 3, 64 + 10, 2, 32},
+	}
+	for _, c := range testCases {
+		got, err := ComputeSubnetForAttestation(configs.Mainnet, c.committeesPerSlot, c.slot, c.committeeIndex)
+		if err != nil {
+			t.Errorf("%s: %v", c.name, err)
+		} else if got != c.expected {
+			t.Errorf("%s: expected subnet %d, got %d", c.name, c.expected, got)
+		}
+	}
+	if _, err := ComputeSubnetForAttestation(configs.Mainnet, 4, 10, 4); err == nil {
+		t.Error("expected error for committee index out of range")
+	}
+}
+
+func TestComputeENRForkID(t *testing.T) {
+	var genesisValRoot Root
+	if err := genesisValRoot.UnmarshalText([]byte("0x4b363db94e286120d76eb905340fdd4e54bfe9f06bf33ff6cf5ad27f511bfe95")); err != nil {
+		t.Fatal(err)
+	}
+	spec := configs.Mainnet
+	testCases := []struct {
+		name     string
+		epoch    Epoch
+		expected string
+	}{
+		// fork digest, next fork version, next fork epoch (little-endian)
+		{"phase0", 0, "b5303f2a" + "01000000" + "0022010000000000"},
+		{"last phase0 epoch", spec.ALTAIR_FORK_EPOCH - 1, "b5303f2a" + "01000000" + "0022010000000000"},
+		{"altair", spec.ALTAIR_FORK_EPOCH, "afcaaba0" + "02000000" + "0036020000000000"},
+		{"bellatrix", spec.BELLATRIX_FORK_EPOCH, "4a26c58b" + "03000000" + "00f6020000000000"},
+		{"capella", spec.CAPELLA_FORK_EPOCH, "bba4da96" + "04000000" + "001d040000000000"},
+		// no next fork scheduled: the current fork version and FAR_FUTURE_EPOCH
+		{"deneb", spec.DENEB_FORK_EPOCH, "6a95a1a9" + "04000000" + "ffffffffffffffff"},
+	}
+	for _, c := range testCases {
+		forkID := ComputeENRForkID(spec, genesisValRoot, c.epoch)
+		data, err := forkID.MarshalBinary()
+		if err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		if got := hex.EncodeToString(data); got != c.expected {
+			t.Errorf("%s: expected %s, got %s", c.name, c.expected, got)
+		}
+	}
+}
+
+func TestEth2DataBinary(t *testing.T) {
+	data := Eth2Data{ForkDigest: ForkDigest{1, 2, 3, 4}, NextForkVersion: Version{5, 6, 7, 8}, NextForkEpoch: 0x0102}
+	enc, err := data.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := hex.EncodeToString(enc); got != "01020304"+"05060708"+"0201000000000000" {
+		t.Fatalf("unexpected encoding %s", got)
+	}
+	var dec Eth2Data
+	if err := dec.UnmarshalBinary(enc); err != nil {
+		t.Fatal(err)
+	}
+	if dec != data {
+		t.Fatalf("expected %s, got %s", &data, &dec)
+	}
+	for _, n := range []int{0, 15, 17} {
+		invalid := make([]byte, n)
+		copy(invalid, enc)
+		if err := dec.UnmarshalBinary(invalid); err == nil {
+			t.Errorf("expected error for %d bytes", n)
+		}
+	}
+}
+
+func TestComputeSyncnets(t *testing.T) {
+	spec := configs.Minimal
+	// The minimal sync committee has 32 members, 8 per subnet.
+	current := &IndexedSyncCommittee{Indices: make([]ValidatorIndex, spec.SYNC_COMMITTEE_SIZE)}
+	next := &IndexedSyncCommittee{Indices: make([]ValidatorIndex, spec.SYNC_COMMITTEE_SIZE)}
+	for i := range current.Indices {
+		current.Indices[i] = ValidatorIndex(i)
+		next.Indices[i] = ValidatorIndex(100 + i)
+	}
+	// validator 1 is in subnet 0 of the current committee, and in subnet 3 of the next
+	next.Indices[31] = 1
+	// validator 20 is in subnet 2 of the current committee, and twice in subnet 1 of the next
+	next.Indices[8] = 20
+	next.Indices[9] = 20
+
+	testCases := []struct {
+		name       string
+		current    *IndexedSyncCommittee
+		next       *IndexedSyncCommittee
+		validators []ValidatorIndex
+		expected   []uint64
+	}{
+		{"current and next", current, next, []ValidatorIndex{1}, []uint64{0, 3}},
+		{"multiple validators", current, next, []ValidatorIndex{1, 20}, []uint64{0, 1, 2, 3}},
+		{"only next", current, next, []ValidatorIndex{110}, []uint64{1}},
+		{"not in committees", current, next, []ValidatorIndex{50}, nil},
+		{"no validators", current, next, nil, nil},
+		{"before altair", nil, nil, []ValidatorIndex{1}, nil},
+		{"no next committee", current, nil, []ValidatorIndex{1, 20}, []uint64{0, 2}},
+		{"no current committee", nil, next, []ValidatorIndex{1, 20}, []uint64{1, 3}},
+	}
+	for _, c := range testCases {
+		bits := ComputeSyncnets(spec, c.current, c.next, c.validators)
+		got := bits.Subnets()
+		if len(got) != len(c.expected) {
+			t.Errorf("%s: expected subnets %v, got %v", c.name, c.expected, got)
+			continue
+		}
+		for i := range got {
+			if got[i] != c.expected[i] {
+				t.Errorf("%s: expected subnets %v, got %v", c.name, c.expected, got)
+				break
+			}
+		}
+	}
+}
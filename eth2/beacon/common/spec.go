@@ -308,14 +308,39 @@ func (spec *Spec) Wrap(des SpecObj) SSZObj {
 }
 
 func (spec *Spec) ForkVersion(slot Slot) Version {
-	epoch := spec.SlotToEpoch(slot)
+	return spec.ForkVersionAtEpoch(spec.SlotToEpoch(slot))
+}
+
+func (spec *Spec) ForkVersionAtEpoch(epoch Epoch) Version {
 	if epoch < spec.ALTAIR_FORK_EPOCH {
 		return spec.GENESIS_FORK_VERSION
 	} else if epoch < spec.BELLATRIX_FORK_EPOCH {
 		return spec.ALTAIR_FORK_VERSION
 	} else if epoch < spec.CAPELLA_FORK_EPOCH {
 		return spec.BELLATRIX_FORK_VERSION
-	} else {
+	} else if epoch < spec.DENEB_FORK_EPOCH {
 		return spec.CAPELLA_FORK_VERSION
+	} else {
+		return spec.DENEB_FORK_VERSION
+	}
+}
+
+// NextFork returns the version and epoch of the first fork that is scheduled after the given epoch.
+// If no next fork is scheduled, the current fork version and FAR_FUTURE_EPOCH are returned.
+func (spec *Spec) NextFork(epoch Epoch) (Version, Epoch) {
+	schedule := [...]struct {
+		version Version
+		epoch   Epoch
+	}{
+		{spec.ALTAIR_FORK_VERSION, spec.ALTAIR_FORK_EPOCH},
+		{spec.BELLATRIX_FORK_VERSION, spec.BELLATRIX_FORK_EPOCH},
+		{spec.CAPELLA_FORK_VERSION, spec.CAPELLA_FORK_EPOCH},
+		{spec.DENEB_FORK_VERSION, spec.DENEB_FORK_EPOCH},
+	}
+	for _, f := range schedule {
+		if f.epoch > epoch && f.epoch != FAR_FUTURE_EPOCH {
+			return f.version, f.epoch
+		}
 	}
+	return spec.ForkVersionAtEpoch(epoch), FAR_FUTURE_EPOCH
 }
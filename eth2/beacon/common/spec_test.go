@@ -0,0 +1,79 @@
+package common
+
+import "testing"
+
+func TestForkVersion(t *testing.T) {
+	spec := &Spec{
+		Phase0Preset: Phase0Preset{SLOTS_PER_EPOCH: 8},
+		Config: Config{
+			GENESIS_FORK_VERSION:   Version{0},
+			ALTAIR_FORK_VERSION:    Version{1},
+			ALTAIR_FORK_EPOCH:      1,
+			BELLATRIX_FORK_VERSION: Version{2},
+			BELLATRIX_FORK_EPOCH:   2,
+			CAPELLA_FORK_VERSION:   Version{3},
+			CAPELLA_FORK_EPOCH:     3,
+			DENEB_FORK_VERSION:     Version{4},
+			DENEB_FORK_EPOCH:       4,
+		},
+	}
+	for _, c := range []struct {
+		slot     Slot
+		expected Version
+	}{
+		{0, Version{0}},
+		{7, Version{0}},
+		{8, Version{1}},
+		{16, Version{2}},
+		{24, Version{3}},
+		{31, Version{3}},
+		{32, Version{4}},
+		{1000, Version{4}},
+	} {
+		if got := spec.ForkVersion(c.slot); got != c.expected {
+			t.Errorf("slot %d: expected fork version %s, got %s", c.slot, c.expected, got)
+		}
+	}
+}
+
+func TestNextFork(t *testing.T) {
+	spec := &Spec{
+		Config: Config{
+			GENESIS_FORK_VERSION:   Version{0},
+			ALTAIR_FORK_VERSION:    Version{1},
+			ALTAIR_FORK_EPOCH:      0,
+			BELLATRIX_FORK_VERSION: Version{2},
+			BELLATRIX_FORK_EPOCH:   2,
+			CAPELLA_FORK_VERSION:   Version{3},
+			CAPELLA_FORK_EPOCH:     5,
+			DENEB_FORK_VERSION:     Version{4},
+			DENEB_FORK_EPOCH:       FAR_FUTURE_EPOCH,
+		},
+	}
+	for _, c := range []struct {
+		epoch           Epoch
+		expectedVersion Version
+		expectedEpoch   Epoch
+	}{
+		// altair at genesis is not a next fork
+		{0, Version{2}, 2},
+		{1, Version{2}, 2},
+		{2, Version{3}, 5},
+		{4, Version{3}, 5},
+		// no next fork: the current version, the unscheduled deneb fork is skipped
+		{5, Version{3}, FAR_FUTURE_EPOCH},
+		{1000, Version{3}, FAR_FUTURE_EPOCH},
+	} {
+		version, epoch := spec.NextFork(c.epoch)
+		if version != c.expectedVersion || epoch != c.expectedEpoch {
+			t.Errorf("epoch %d: expected next fork %s at %d, got %s at %d", c.epoch, c.expectedVersion, c.expectedEpoch, version, epoch)
+		}
+	}
+
+	// A fork that is scheduled after an unscheduled fork is still the next fork.
+	spec.CAPELLA_FORK_EPOCH = FAR_FUTURE_EPOCH
+	spec.DENEB_FORK_EPOCH = 7
+	if version, epoch := spec.NextFork(3); version != (Version{4}) || epoch != 7 {
+		t.Errorf("expected next fork %s at 7, got %s at %d", Version{4}, version, epoch)
+	}
+}
@@ -130,6 +130,22 @@ func (p *SyncCommitteeView) AggregatePubkey() (BLSPubkey, error) {
 	return AsBLSPubkey(p.Get(1))
 }
 
+func (p *SyncCommitteeView) Raw() (*SyncCommittee, error) {
+	pubsView, err := p.Pubkeys()
+	if err != nil {
+		return nil, err
+	}
+	pubs, err := pubsView.Flatten()
+	if err != nil {
+		return nil, err
+	}
+	aggPub, err := p.AggregatePubkey()
+	if err != nil {
+		return nil, err
+	}
+	return &SyncCommittee{Pubkeys: pubs, AggregatePubkey: aggPub}, nil
+}
+
 func AsSyncCommittee(v View, err error) (*SyncCommitteeView, error) {
 	c, err := AsContainer(v, err)
 	return &SyncCommitteeView{c}, err
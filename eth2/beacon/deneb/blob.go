@@ -0,0 +1,245 @@
+package deneb
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"github.com/protolambda/ztyp/codec"
+	"github.com/protolambda/ztyp/conv"
+	"github.com/protolambda/ztyp/tree"
+	"github.com/protolambda/ztyp/view"
+
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/protolambda/zrnt/eth2/util/merkle"
+)
+
+const BYTES_PER_FIELD_ELEMENT = 32
+
+func BlobType(spec *common.Spec) *view.BasicVectorTypeDef {
+	return view.BasicVectorType(view.ByteType, uint64(spec.FIELD_ELEMENTS_PER_BLOB)*BYTES_PER_FIELD_ELEMENT)
+}
+
+type Blob []byte
+
+func (b *Blob) Deserialize(spec *common.Spec, dr *codec.DecodingReader) error {
+	size := uint64(spec.FIELD_ELEMENTS_PER_BLOB) * BYTES_PER_FIELD_ELEMENT
+	if uint64(cap(*b)) < size {
+		*b = make(Blob, size)
+	} else {
+		*b = (*b)[:size]
+	}
+	_, err := dr.Read(*b)
+	return err
+}
+
+func (b Blob) Serialize(spec *common.Spec, w *codec.EncodingWriter) error {
+	if expected := uint64(spec.FIELD_ELEMENTS_PER_BLOB) * BYTES_PER_FIELD_ELEMENT; uint64(len(b)) != expected {
+		return fmt.Errorf("blob has length %d, expected %d", len(b), expected)
+	}
+	return w.Write(b)
+}
+
+func (b Blob) ByteLength(spec *common.Spec) uint64 {
+	return uint64(spec.FIELD_ELEMENTS_PER_BLOB) * BYTES_PER_FIELD_ELEMENT
+}
+
+func (b *Blob) FixedLength(spec *common.Spec) uint64 {
+	return uint64(spec.FIELD_ELEMENTS_PER_BLOB) * BYTES_PER_FIELD_ELEMENT
+}
+
+func (b Blob) HashTreeRoot(spec *common.Spec, hFn tree.HashFn) common.Root {
+	return hFn.ByteVectorHTR(b)
+}
+
+func (b Blob) MarshalText() ([]byte, error) {
+	return conv.BytesMarshalText(b[:])
+}
+
+func (b Blob) String() string {
+	return "0x" + hex.EncodeToString(b[:])
+}
+
+func (b *Blob) UnmarshalText(text []byte) error {
+	if b == nil {
+		return errors.New("cannot decode into nil blob")
+	}
+	return conv.DynamicBytesUnmarshalText((*[]byte)(b), text[:])
+}
+
+func KZGCommitmentInclusionProofType(spec *common.Spec) view.VectorTypeDef {
+	return view.VectorType(common.Bytes32Type, uint64(spec.KZG_COMMITMENT_INCLUSION_PROOF_DEPTH))
+}
+
+// KZGCommitmentInclusionProof is the merkle branch of a KZG commitment, to the body root of the block that includes it.
+type KZGCommitmentInclusionProof []common.Bytes32
+
+func (p *KZGCommitmentInclusionProof) Deserialize(spec *common.Spec, dr *codec.DecodingReader) error {
+	return tree.ReadRoots(dr, (*[]common.Root)(p), uint64(spec.KZG_COMMITMENT_INCLUSION_PROOF_DEPTH))
+}
+
+func (p KZGCommitmentInclusionProof) Serialize(spec *common.Spec, w *codec.EncodingWriter) error {
+	if depth := uint64(spec.KZG_COMMITMENT_INCLUSION_PROOF_DEPTH); uint64(len(p)) != depth {
+		return fmt.Errorf("inclusion proof has length %d, expected %d", len(p), depth)
+	}
+	return tree.WriteRoots(w, p)
+}
+
+func (p KZGCommitmentInclusionProof) ByteLength(spec *common.Spec) uint64 {
+	return uint64(spec.KZG_COMMITMENT_INCLUSION_PROOF_DEPTH) * 32
+}
+
+func (p *KZGCommitmentInclusionProof) FixedLength(spec *common.Spec) uint64 {
+	return uint64(spec.KZG_COMMITMENT_INCLUSION_PROOF_DEPTH) * 32
+}
+
+func (p KZGCommitmentInclusionProof) HashTreeRoot(spec *common.Spec, hFn tree.HashFn) common.Root {
+	length := uint64(len(p))
+	return hFn.ComplexVectorHTR(func(i uint64) tree.HTR {
+		if i < length {
+			return &p[i]
+		}
+		return nil
+	}, uint64(spec.KZG_COMMITMENT_INCLUSION_PROOF_DEPTH))
+}
+
+type BlobIndex = view.Uint64View
+
+const BlobIndexType = view.Uint64Type
+
+func BlobSidecarType(spec *common.Spec) *view.ContainerTypeDef {
+	return view.ContainerType("BlobSidecar", []view.FieldDef{
+		{Name: "index", Type: BlobIndexType},
+		{Name: "blob", Type: BlobType(spec)},
+		{Name: "kzg_commitment", Type: common.KZGCommitmentType},
+		{Name: "kzg_proof", Type: common.KZGProofType},
+		{Name: "signed_block_header", Type: common.SignedBeaconBlockHeaderType},
+		{Name: "kzg_commitment_inclusion_proof", Type: KZGCommitmentInclusionProofType(spec)},
+	})
+}
+
+type BlobSidecar struct {
+	Index                       BlobIndex                      `json:"index" yaml:"index"`
+	Blob                        Blob                           `json:"blob" yaml:"blob"`
+	KZGCommitment               common.KZGCommitment           `json:"kzg_commitment" yaml:"kzg_commitment"`
+	KZGProof                    common.KZGProof                `json:"kzg_proof" yaml:"kzg_proof"`
+	SignedBlockHeader           common.SignedBeaconBlockHeader `json:"signed_block_header" yaml:"signed_block_header"`
+	KZGCommitmentInclusionProof KZGCommitmentInclusionProof    `json:"kzg_commitment_inclusion_proof" yaml:"kzg_commitment_inclusion_proof"`
+}
+
+func (b *BlobSidecar) Deserialize(spec *common.Spec, dr *codec.DecodingReader) error {
+	return dr.FixedLenContainer(&b.Index, spec.Wrap(&b.Blob), &b.KZGCommitment, &b.KZGProof,
+		&b.SignedBlockHeader, spec.Wrap(&b.KZGCommitmentInclusionProof))
+}
+
+func (b *BlobSidecar) Serialize(spec *common.Spec, w *codec.EncodingWriter) error {
+	return w.FixedLenContainer(&b.Index, spec.Wrap(&b.Blob), &b.KZGCommitment, &b.KZGProof,
+		&b.SignedBlockHeader, spec.Wrap(&b.KZGCommitmentInclusionProof))
+}
+
+func (b *BlobSidecar) ByteLength(spec *common.Spec) uint64 {
+	return b.FixedLength(spec)
+}
+
+func (b *BlobSidecar) FixedLength(spec *common.Spec) uint64 {
+	return codec.ContainerLength(&b.Index, spec.Wrap(&b.Blob), &b.KZGCommitment, &b.KZGProof,
+		&b.SignedBlockHeader, spec.Wrap(&b.KZGCommitmentInclusionProof))
+}
+
+func (b *BlobSidecar) HashTreeRoot(spec *common.Spec, hFn tree.HashFn) common.Root {
+	return hFn.HashTreeRoot(b.Index, spec.Wrap(&b.Blob), &b.KZGCommitment, &b.KZGProof,
+		&b.SignedBlockHeader, spec.Wrap(&b.KZGCommitmentInclusionProof))
+}
+
+// BlockRoot returns the root of the block that the blob sidecar belongs to.
+func (b *BlobSidecar) BlockRoot() common.Root {
+	return b.SignedBlockHeader.Message.HashTreeRoot(tree.GetHashFn())
+}
+
+// Depth of the BeaconBlockBody container, and the field index of blob_kzg_commitments in it.
+const (
+	beaconBlockBodyDepth         = 4
+	blobKZGCommitmentsFieldIndex = 11
+)
+
+// VerifyInclusionProof checks the merkle proof of the KZG commitment against the body root in the block header.
+// This implements verify_blob_sidecar_inclusion_proof.
+func (b *BlobSidecar) VerifyInclusionProof(spec *common.Spec) error {
+	depth := uint64(spec.KZG_COMMITMENT_INCLUSION_PROOF_DEPTH)
+	if uint64(len(b.KZGCommitmentInclusionProof)) != depth {
+		return fmt.Errorf("inclusion proof has length %d, expected %d", len(b.KZGCommitmentInclusionProof), depth)
+	}
+	if uint64(b.Index) >= uint64(spec.MAX_BLOB_COMMITMENTS_PER_BLOCK) {
+		return fmt.Errorf("blob index %d out of range", b.Index)
+	}
+	// The proof goes through the list items, the list length mix-in, and the body fields.
+	listDepth := uint64(tree.CoverDepth(uint64(spec.MAX_BLOB_COMMITMENTS_PER_BLOCK)))
+	if listDepth+1+beaconBlockBodyDepth != depth {
+		return fmt.Errorf("inclusion proof depth %d does not match block body, expected %d", depth, listDepth+1+beaconBlockBodyDepth)
+	}
+	index := (uint64(blobKZGCommitmentsFieldIndex) << (listDepth + 1)) | uint64(b.Index)
+	leaf := b.KZGCommitment.HashTreeRoot(tree.GetHashFn())
+	if !merkle.VerifyMerkleBranch(leaf, b.KZGCommitmentInclusionProof, depth, index, b.SignedBlockHeader.Message.BodyRoot) {
+		return errors.New("invalid KZG commitment inclusion proof")
+	}
+	return nil
+}
+
+// KZGCommitmentInclusionProof computes the merkle proof of the KZG commitment with the given index,
+// to the root of the block body, as verified by BlobSidecar.VerifyInclusionProof.
+func (b *BeaconBlockBody) KZGCommitmentInclusionProof(spec *common.Spec, hFn tree.HashFn, index uint64) (KZGCommitmentInclusionProof, error) {
+	if index >= uint64(len(b.BlobKZGCommitments)) {
+		return nil, fmt.Errorf("blob index %d out of range, block has %d commitments", index, len(b.BlobKZGCommitments))
+	}
+	// The list items, padded to the list limit.
+	items := make([]common.Root, spec.MAX_BLOB_COMMITMENTS_PER_BLOCK)
+	for i := range b.BlobKZGCommitments {
+		items[i] = b.BlobKZGCommitments[i].HashTreeRoot(hFn)
+	}
+	itemsBranch, err := merkle.ComputeFieldsProof(items, index, hFn)
+	if err != nil {
+		return nil, err
+	}
+	var length common.Root
+	binary.LittleEndian.PutUint64(length[:], uint64(len(b.BlobKZGCommitments)))
+	bodyBranch, err := merkle.ComputeFieldsProof(b.fieldRoots(spec, hFn), blobKZGCommitmentsFieldIndex, hFn)
+	if err != nil {
+		return nil, err
+	}
+	out := make(KZGCommitmentInclusionProof, 0, len(itemsBranch)+1+len(bodyBranch))
+	out = append(out, itemsBranch...)
+	out = append(out, length)
+	out = append(out, bodyBranch...)
+	if depth := uint64(spec.KZG_COMMITMENT_INCLUSION_PROOF_DEPTH); uint64(len(out)) != depth {
+		return nil, fmt.Errorf("inclusion proof has length %d, expected %d", len(out), depth)
+	}
+	return out, nil
+}
+
+// BlobSidecars creates the sidecars of the blobs of a block, given the blobs and KZG proofs for the commitments in the block.
+// This implements get_blob_sidecars.
+func BlobSidecars(spec *common.Spec, block *SignedBeaconBlock, blobs []Blob, blobKZGProofs []common.KZGProof) ([]*BlobSidecar, error) {
+	commitments := block.Message.Body.BlobKZGCommitments
+	if len(blobs) != len(commitments) || len(blobKZGProofs) != len(commitments) {
+		return nil, fmt.Errorf("got %d blobs and %d proofs for %d commitments", len(blobs), len(blobKZGProofs), len(commitments))
+	}
+	hFn := tree.GetHashFn()
+	header := block.SignedHeader(spec)
+	out := make([]*BlobSidecar, len(blobs))
+	for i := range blobs {
+		proof, err := block.Message.Body.KZGCommitmentInclusionProof(spec, hFn, uint64(i))
+		if err != nil {
+			return nil, fmt.Errorf("failed to compute inclusion proof of blob %d: %w", i, err)
+		}
+		out[i] = &BlobSidecar{
+			Index:                       BlobIndex(i),
+			Blob:                        blobs[i],
+			KZGCommitment:               commitments[i],
+			KZGProof:                    blobKZGProofs[i],
+			SignedBlockHeader:           *header,
+			KZGCommitmentInclusionProof: proof,
+		}
+	}
+	return out, nil
+}
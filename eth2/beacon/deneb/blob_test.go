@@ -0,0 +1,92 @@
+package deneb
+
+import (
+	"testing"
+
+	"github.com/protolambda/ztyp/tree"
+
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/protolambda/zrnt/eth2/configs"
+)
+
+func testBlobBlock(spec *common.Spec, commitments int) *SignedBeaconBlock {
+	block := &SignedBeaconBlock{Message: BeaconBlock{Slot: 10, ProposerIndex: 3, ParentRoot: common.Root{0x01}}}
+	block.Message.Body.Graffiti = common.Root{0x02}
+	block.Message.Body.SyncAggregate.SyncCommitteeBits = make([]byte, spec.SYNC_COMMITTEE_SIZE/8)
+	block.Message.Body.ExecutionPayload.BlockNumber = 123
+	for i := 0; i < commitments; i++ {
+		block.Message.Body.BlobKZGCommitments = append(block.Message.Body.BlobKZGCommitments, common.KZGCommitment{0xc0, byte(i)})
+	}
+	return block
+}
+
+func TestBlobSidecarInclusionProof(t *testing.T) {
+	for _, spec := range []*common.Spec{configs.Mainnet, configs.Minimal} {
+		t.Run(spec.CONFIG_NAME, func(t *testing.T) {
+			block := testBlobBlock(spec, 3)
+			blobs := make([]Blob, 3)
+			proofs := make([]common.KZGProof, 3)
+			for i := range blobs {
+				blobs[i] = make(Blob, uint64(spec.FIELD_ELEMENTS_PER_BLOB)*BYTES_PER_FIELD_ELEMENT)
+				proofs[i] = common.KZGProof{0xd0, byte(i)}
+			}
+			sidecars, err := BlobSidecars(spec, block, blobs, proofs)
+			if err != nil {
+				t.Fatal(err)
+			}
+			bodyRoot := block.Message.Body.HashTreeRoot(spec, tree.GetHashFn())
+			for i, sidecar := range sidecars {
+				if uint64(sidecar.Index) != uint64(i) || sidecar.KZGCommitment != block.Message.Body.BlobKZGCommitments[i] {
+					t.Fatalf("sidecar %d has index %d and commitment %s", i, sidecar.Index, sidecar.KZGCommitment)
+				}
+				if sidecar.SignedBlockHeader.Message.BodyRoot != bodyRoot {
+					t.Fatalf("sidecar %d does not commit to the block body", i)
+				}
+				if sidecar.BlockRoot() != block.Message.HashTreeRoot(spec, tree.GetHashFn()) {
+					t.Fatalf("sidecar %d does not have the root of the block", i)
+				}
+				if err := sidecar.VerifyInclusionProof(spec); err != nil {
+					t.Fatalf("sidecar %d: %v", i, err)
+				}
+			}
+
+			testCases := []struct {
+				name   string
+				tamper func(s *BlobSidecar)
+			}{
+				{"commitment", func(s *BlobSidecar) { s.KZGCommitment[1] ^= 0xff }},
+				{"list item branch", func(s *BlobSidecar) { s.KZGCommitmentInclusionProof[0][0] ^= 0xff }},
+				{"list length", func(s *BlobSidecar) {
+					s.KZGCommitmentInclusionProof[len(s.KZGCommitmentInclusionProof)-5][0]++
+				}},
+				{"body branch", func(s *BlobSidecar) {
+					s.KZGCommitmentInclusionProof[len(s.KZGCommitmentInclusionProof)-1][0] ^= 0xff
+				}},
+				{"index", func(s *BlobSidecar) { s.Index = 2 }},
+				{"body root", func(s *BlobSidecar) { s.SignedBlockHeader.Message.BodyRoot[0] ^= 0xff }},
+				{"proof too short", func(s *BlobSidecar) {
+					s.KZGCommitmentInclusionProof = s.KZGCommitmentInclusionProof[:len(s.KZGCommitmentInclusionProof)-1]
+				}},
+				{"index out of range", func(s *BlobSidecar) { s.Index = BlobIndex(spec.MAX_BLOB_COMMITMENTS_PER_BLOCK) }},
+			}
+			for _, c := range testCases {
+				tampered := *sidecars[1]
+				tampered.KZGCommitmentInclusionProof = append(KZGCommitmentInclusionProof{}, sidecars[1].KZGCommitmentInclusionProof...)
+				c.tamper(&tampered)
+				if err := tampered.VerifyInclusionProof(spec); err == nil {
+					t.Errorf("%s: tampered sidecar verifies", c.name)
+				}
+			}
+			if err := sidecars[1].VerifyInclusionProof(spec); err != nil {
+				t.Errorf("original sidecar is modified: %v", err)
+			}
+
+			if _, err := BlobSidecars(spec, block, blobs[:2], proofs[:2]); err == nil {
+				t.Error("expected error for missing blob")
+			}
+			if _, err := block.Message.Body.KZGCommitmentInclusionProof(spec, tree.GetHashFn(), 3); err == nil {
+				t.Error("expected error for index without commitment")
+			}
+		})
+	}
+}
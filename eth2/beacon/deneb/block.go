@@ -184,6 +184,24 @@ func (b *BeaconBlockBody) HashTreeRoot(spec *common.Spec, hFn tree.HashFn) commo
 	)
 }
 
+// fieldRoots returns the roots of the body fields, to compute merkle branches into the body with.
+func (b *BeaconBlockBody) fieldRoots(spec *common.Spec, hFn tree.HashFn) []common.Root {
+	return []common.Root{
+		b.RandaoReveal.HashTreeRoot(hFn),
+		b.Eth1Data.HashTreeRoot(hFn),
+		b.Graffiti,
+		spec.Wrap(&b.ProposerSlashings).HashTreeRoot(hFn),
+		spec.Wrap(&b.AttesterSlashings).HashTreeRoot(hFn),
+		spec.Wrap(&b.Attestations).HashTreeRoot(hFn),
+		spec.Wrap(&b.Deposits).HashTreeRoot(hFn),
+		spec.Wrap(&b.VoluntaryExits).HashTreeRoot(hFn),
+		spec.Wrap(&b.SyncAggregate).HashTreeRoot(hFn),
+		spec.Wrap(&b.ExecutionPayload).HashTreeRoot(hFn),
+		spec.Wrap(&b.BLSToExecutionChanges).HashTreeRoot(hFn),
+		spec.Wrap(&b.BlobKZGCommitments).HashTreeRoot(hFn),
+	}
+}
+
 func (b *BeaconBlockBody) CheckLimits(spec *common.Spec) error {
 	if x := uint64(len(b.ProposerSlashings)); x > uint64(spec.MAX_PROPOSER_SLASHINGS) {
 		return fmt.Errorf("too many proposer slashings: %d", x)
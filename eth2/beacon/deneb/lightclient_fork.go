@@ -0,0 +1,227 @@
+package deneb
+
+import (
+	"fmt"
+
+	"github.com/protolambda/ztyp/tree"
+
+	"github.com/protolambda/zrnt/eth2/beacon/altair"
+	"github.com/protolambda/zrnt/eth2/beacon/capella"
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/protolambda/zrnt/eth2/util/merkle"
+)
+
+// https://github.com/ethereum/consensus-specs/blob/dev/specs/deneb/light-client/sync-protocol.md
+// https://github.com/ethereum/consensus-specs/blob/dev/specs/deneb/light-client/fork.md
+
+var _ altair.LightClientHeaderLike = (*LightClientHeader)(nil)
+
+func (l *LightClientHeader) BeaconHeader() *common.BeaconBlockHeader {
+	return &l.Beacon
+}
+
+// ExecutionRoot is the hash-tree-root of the execution payload header, in the format of the fork of the header.
+// Before Deneb it is the root of the Capella execution payload header, and before Capella it is zero.
+func (l *LightClientHeader) ExecutionRoot(spec *common.Spec) common.Root {
+	epoch := spec.SlotToEpoch(l.Beacon.Slot)
+	hFn := tree.GetHashFn()
+	if epoch >= spec.DENEB_FORK_EPOCH {
+		return l.Execution.HashTreeRoot(hFn)
+	}
+	if epoch >= spec.CAPELLA_FORK_EPOCH {
+		return capellaExecutionPayloadHeader(&l.Execution).HashTreeRoot(hFn)
+	}
+	return common.Root{}
+}
+
+// IsValid implements is_valid_light_client_header:
+// the execution branch must prove the execution payload header against the block body root.
+// Before Deneb the blob gas fields must be zero, and before Capella the execution data must be empty.
+func (l *LightClientHeader) IsValid(spec *common.Spec) bool {
+	epoch := spec.SlotToEpoch(l.Beacon.Slot)
+	if epoch < spec.DENEB_FORK_EPOCH {
+		if l.Execution.BlobGasUsed != 0 || l.Execution.ExcessBlobGas != 0 {
+			return false
+		}
+	}
+	if epoch < spec.CAPELLA_FORK_EPOCH {
+		var empty ExecutionPayloadHeader
+		hFn := tree.GetHashFn()
+		return l.Execution.HashTreeRoot(hFn) == empty.HashTreeRoot(hFn) && l.ExecutionBranch == capella.ExecutionBranch{}
+	}
+	return merkle.VerifyMerkleBranch(l.ExecutionRoot(spec), l.ExecutionBranch[:], capella.ExecutionBranchLength,
+		uint64(capella.EXECUTION_PAYLOAD_INDEX)^(1<<capella.ExecutionBranchLength), l.Beacon.BodyRoot)
+}
+
+func (l *LightClientHeader) IsEmpty() bool {
+	var empty LightClientHeader
+	hFn := tree.GetHashFn()
+	return l.HashTreeRoot(hFn) == empty.HashTreeRoot(hFn)
+}
+
+func capellaExecutionPayloadHeader(h *ExecutionPayloadHeader) *capella.ExecutionPayloadHeader {
+	return &capella.ExecutionPayloadHeader{
+		ParentHash:       h.ParentHash,
+		FeeRecipient:     h.FeeRecipient,
+		StateRoot:        h.StateRoot,
+		ReceiptsRoot:     h.ReceiptsRoot,
+		LogsBloom:        h.LogsBloom,
+		PrevRandao:       h.PrevRandao,
+		BlockNumber:      h.BlockNumber,
+		GasLimit:         h.GasLimit,
+		GasUsed:          h.GasUsed,
+		Timestamp:        h.Timestamp,
+		ExtraData:        h.ExtraData,
+		BaseFeePerGas:    h.BaseFeePerGas,
+		BlockHash:        h.BlockHash,
+		TransactionsRoot: h.TransactionsRoot,
+		WithdrawalsRoot:  h.WithdrawalsRoot,
+	}
+}
+
+func (lcu *LightClientUpdate) Generic() *altair.GenericLightClientUpdate {
+	return &altair.GenericLightClientUpdate{
+		AttestedHeader:          &lcu.AttestedHeader,
+		NextSyncCommittee:       lcu.NextSyncCommittee,
+		NextSyncCommitteeBranch: lcu.NextSyncCommitteeBranch,
+		FinalizedHeader:         &lcu.FinalizedHeader,
+		FinalityBranch:          lcu.FinalityBranch,
+		SyncAggregate:           lcu.SyncAggregate,
+		SignatureSlot:           lcu.SignatureSlot,
+	}
+}
+
+func (lcfu *LightClientFinalityUpdate) Generic() *altair.GenericLightClientUpdate {
+	return &altair.GenericLightClientUpdate{
+		AttestedHeader:  &lcfu.AttestedHeader,
+		FinalizedHeader: &lcfu.FinalizedHeader,
+		FinalityBranch:  lcfu.FinalityBranch,
+		SyncAggregate:   lcfu.SyncAggregate,
+		SignatureSlot:   lcfu.SignatureSlot,
+	}
+}
+
+func (lcou *LightClientOptimisticUpdate) Generic() *altair.GenericLightClientUpdate {
+	return &altair.GenericLightClientUpdate{
+		AttestedHeader:  &lcou.AttestedHeader,
+		FinalizedHeader: &LightClientHeader{},
+		SyncAggregate:   lcou.SyncAggregate,
+		SignatureSlot:   lcou.SignatureSlot,
+	}
+}
+
+func (lcb *LightClientBootstrap) Generic() *altair.GenericLightClientBootstrap {
+	return &altair.GenericLightClientBootstrap{
+		Header:                     &lcb.Header,
+		CurrentSyncCommittee:       lcb.CurrentSyncCommittee,
+		CurrentSyncCommitteeBranch: lcb.CurrentSyncCommitteeBranch,
+	}
+}
+
+// UpgradeLightClientHeader upgrades a Capella header, the blob gas fields are left zero.
+func UpgradeLightClientHeader(pre *capella.LightClientHeader) *LightClientHeader {
+	return &LightClientHeader{
+		Beacon: pre.Beacon,
+		Execution: ExecutionPayloadHeader{
+			ParentHash:       pre.Execution.ParentHash,
+			FeeRecipient:     pre.Execution.FeeRecipient,
+			StateRoot:        pre.Execution.StateRoot,
+			ReceiptsRoot:     pre.Execution.ReceiptsRoot,
+			LogsBloom:        pre.Execution.LogsBloom,
+			PrevRandao:       pre.Execution.PrevRandao,
+			BlockNumber:      pre.Execution.BlockNumber,
+			GasLimit:         pre.Execution.GasLimit,
+			GasUsed:          pre.Execution.GasUsed,
+			Timestamp:        pre.Execution.Timestamp,
+			ExtraData:        pre.Execution.ExtraData,
+			BaseFeePerGas:    pre.Execution.BaseFeePerGas,
+			BlockHash:        pre.Execution.BlockHash,
+			TransactionsRoot: pre.Execution.TransactionsRoot,
+			WithdrawalsRoot:  pre.Execution.WithdrawalsRoot,
+		},
+		ExecutionBranch: pre.ExecutionBranch,
+	}
+}
+
+func UpgradeLightClientBootstrap(pre *capella.LightClientBootstrap) *LightClientBootstrap {
+	return &LightClientBootstrap{
+		Header:                     *UpgradeLightClientHeader(&pre.Header),
+		CurrentSyncCommittee:       pre.CurrentSyncCommittee,
+		CurrentSyncCommitteeBranch: pre.CurrentSyncCommitteeBranch,
+	}
+}
+
+func UpgradeLightClientUpdate(pre *capella.LightClientUpdate) *LightClientUpdate {
+	return &LightClientUpdate{
+		AttestedHeader:          *UpgradeLightClientHeader(&pre.AttestedHeader),
+		NextSyncCommittee:       pre.NextSyncCommittee,
+		NextSyncCommitteeBranch: pre.NextSyncCommitteeBranch,
+		FinalizedHeader:         *UpgradeLightClientHeader(&pre.FinalizedHeader),
+		FinalityBranch:          pre.FinalityBranch,
+		SyncAggregate:           pre.SyncAggregate,
+		SignatureSlot:           pre.SignatureSlot,
+	}
+}
+
+func UpgradeLightClientFinalityUpdate(pre *capella.LightClientFinalityUpdate) *LightClientFinalityUpdate {
+	return &LightClientFinalityUpdate{
+		AttestedHeader:  *UpgradeLightClientHeader(&pre.AttestedHeader),
+		FinalizedHeader: *UpgradeLightClientHeader(&pre.FinalizedHeader),
+		FinalityBranch:  pre.FinalityBranch,
+		SyncAggregate:   pre.SyncAggregate,
+		SignatureSlot:   pre.SignatureSlot,
+	}
+}
+
+func UpgradeLightClientOptimisticUpdate(pre *capella.LightClientOptimisticUpdate) *LightClientOptimisticUpdate {
+	return &LightClientOptimisticUpdate{
+		AttestedHeader: *UpgradeLightClientHeader(&pre.AttestedHeader),
+		SyncAggregate:  pre.SyncAggregate,
+		SignatureSlot:  pre.SignatureSlot,
+	}
+}
+
+// upgradeHeaderLike upgrades altair and capella headers, and keeps deneb headers as-is.
+func upgradeHeaderLike(h altair.LightClientHeaderLike) (altair.LightClientHeaderLike, error) {
+	switch x := h.(type) {
+	case *altair.LightClientHeader:
+		return UpgradeLightClientHeader(capella.UpgradeLightClientHeader(x)), nil
+	case *capella.LightClientHeader:
+		return UpgradeLightClientHeader(x), nil
+	case *LightClientHeader:
+		return x, nil
+	default:
+		return nil, fmt.Errorf("cannot upgrade light client header of type %T to deneb", h)
+	}
+}
+
+// UpgradeGenericLightClientUpdate upgrades the headers of the update to deneb.
+func UpgradeGenericLightClientUpdate(pre *altair.GenericLightClientUpdate) (*altair.GenericLightClientUpdate, error) {
+	out := *pre
+	var err error
+	if out.AttestedHeader, err = upgradeHeaderLike(pre.AttestedHeader); err != nil {
+		return nil, err
+	}
+	if out.FinalizedHeader, err = upgradeHeaderLike(pre.FinalizedHeader); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// UpgradeLightClientStore implements upgrade_lc_store_to_deneb, by upgrading all headers in the store.
+func UpgradeLightClientStore(pre *altair.LightClientStore) (*altair.LightClientStore, error) {
+	out := *pre
+	var err error
+	if out.FinalizedHeader, err = upgradeHeaderLike(pre.FinalizedHeader); err != nil {
+		return nil, err
+	}
+	if out.OptimisticHeader, err = upgradeHeaderLike(pre.OptimisticHeader); err != nil {
+		return nil, err
+	}
+	if pre.BestValidUpdate != nil {
+		if out.BestValidUpdate, err = UpgradeGenericLightClientUpdate(pre.BestValidUpdate); err != nil {
+			return nil, err
+		}
+	}
+	return &out, nil
+}
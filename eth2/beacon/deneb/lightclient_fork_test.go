@@ -0,0 +1,114 @@
+package deneb
+
+import (
+	"testing"
+
+	"github.com/protolambda/ztyp/tree"
+
+	"github.com/protolambda/zrnt/eth2/beacon/altair"
+	"github.com/protolambda/zrnt/eth2/beacon/capella"
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/protolambda/zrnt/eth2/configs"
+)
+
+func testLightClientSpec() *common.Spec {
+	spec := *configs.Mainnet
+	spec.ALTAIR_FORK_EPOCH = 0
+	spec.BELLATRIX_FORK_EPOCH = 0
+	spec.CAPELLA_FORK_EPOCH = 1
+	spec.DENEB_FORK_EPOCH = 2
+	return &spec
+}
+
+func TestLightClientHeaderIsValid(t *testing.T) {
+	spec := testLightClientSpec()
+	block := &BeaconBlock{
+		Slot:          common.Slot(spec.SLOTS_PER_EPOCH)*2 + 1,
+		ProposerIndex: 7,
+		ParentRoot:    common.Root{0x01},
+		StateRoot:     common.Root{0x02},
+	}
+	block.Body.SyncAggregate.SyncCommitteeBits = make(altair.SyncCommitteeBits, spec.SYNC_COMMITTEE_SIZE/8)
+	block.Body.ExecutionPayload.BlockHash = common.Hash32{0x04}
+	block.Body.ExecutionPayload.BlockNumber = 123
+	block.Body.ExecutionPayload.BlobGasUsed = 131072
+	block.Body.ExecutionPayload.ExcessBlobGas = 262144
+	header, err := BlockToLightClientHeader(spec, block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header.Beacon.BodyRoot != block.Body.HashTreeRoot(spec, tree.GetHashFn()) {
+		t.Fatal("header does not commit to the block body")
+	}
+	if !header.IsValid(spec) {
+		t.Fatal("header of block is not valid")
+	}
+
+	testCases := []struct {
+		name   string
+		tamper func(h *LightClientHeader)
+	}{
+		{"execution branch", func(h *LightClientHeader) { h.ExecutionBranch[0][0] ^= 0xff }},
+		{"blob gas used", func(h *LightClientHeader) { h.Execution.BlobGasUsed++ }},
+		{"body root", func(h *LightClientHeader) { h.Beacon.BodyRoot[0] ^= 0xff }},
+		// a header with blob gas must not be before deneb
+		{"slot before deneb", func(h *LightClientHeader) { h.Beacon.Slot = common.Slot(spec.SLOTS_PER_EPOCH) + 1 }},
+	}
+	for _, c := range testCases {
+		tampered := *header
+		c.tamper(&tampered)
+		if tampered.IsValid(spec) {
+			t.Errorf("%s: tampered header is valid", c.name)
+		}
+	}
+}
+
+func TestUpgradeLightClientHeader(t *testing.T) {
+	spec := testLightClientSpec()
+	hFn := tree.GetHashFn()
+
+	// A Capella header stays valid after the upgrade, its execution branch proves the Capella payload header.
+	capellaBlock := &capella.BeaconBlock{Slot: common.Slot(spec.SLOTS_PER_EPOCH) + 1, ProposerIndex: 3}
+	capellaBlock.Body.SyncAggregate.SyncCommitteeBits = make(altair.SyncCommitteeBits, spec.SYNC_COMMITTEE_SIZE/8)
+	capellaBlock.Body.ExecutionPayload.BlockNumber = 99
+	capellaHeader, err := capella.BlockToLightClientHeader(spec, capellaBlock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	upgraded := UpgradeLightClientHeader(capellaHeader)
+	if upgraded.Beacon.HashTreeRoot(hFn) != capellaHeader.Beacon.HashTreeRoot(hFn) {
+		t.Error("capella upgrade changed the beacon root")
+	}
+	if upgraded.ExecutionRoot(spec) != capellaHeader.ExecutionRoot(spec) {
+		t.Error("capella upgrade changed the execution root")
+	}
+	if !upgraded.IsValid(spec) {
+		t.Error("upgraded capella header is not valid")
+	}
+
+	// An Altair header is upgraded through Capella.
+	altairHeader := &altair.LightClientHeader{Beacon: common.BeaconBlockHeader{Slot: 5, BodyRoot: common.Root{0x05}}}
+	store, err := UpgradeLightClientStore(&altair.LightClientStore{
+		FinalizedHeader:  altairHeader,
+		OptimisticHeader: capellaHeader,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, h := range map[string]altair.LightClientHeaderLike{"finalized": store.FinalizedHeader, "optimistic": store.OptimisticHeader} {
+		if _, ok := h.(*LightClientHeader); !ok {
+			t.Errorf("%s: expected deneb header, got %T", name, h)
+		} else if !h.IsValid(spec) {
+			t.Errorf("%s: upgraded header is not valid", name)
+		}
+	}
+	if store.FinalizedHeader.BeaconHeader().HashTreeRoot(hFn) != altairHeader.Beacon.HashTreeRoot(hFn) {
+		t.Error("altair upgrade changed the beacon root")
+	}
+	if store.BestValidUpdate != nil {
+		t.Error("expected no best valid update")
+	}
+	if _, err := UpgradeLightClientStore(&altair.LightClientStore{FinalizedHeader: altairHeader}); err == nil {
+		t.Error("expected error for missing optimistic header")
+	}
+}
@@ -55,6 +55,63 @@ func (d *ForkDecoder) BlockAllocator(digest common.ForkDigest) (func() OpaqueBlo
 	}
 }
 
+type OpaqueLightClientUpdate interface {
+	common.SpecObj
+	Envelope(spec *common.Spec) *common.LightClientUpdateEnvelope
+}
+
+func (d *ForkDecoder) LightClientFinalityUpdateAllocator(digest common.ForkDigest) (func() OpaqueLightClientUpdate, error) {
+	switch digest {
+	case d.Altair, d.Bellatrix:
+		return func() OpaqueLightClientUpdate { return new(altair.LightClientFinalityUpdate) }, nil
+	case d.Capella:
+		return func() OpaqueLightClientUpdate { return new(capella.LightClientFinalityUpdate) }, nil
+	case d.Deneb:
+		return func() OpaqueLightClientUpdate { return new(deneb.LightClientFinalityUpdate) }, nil
+	default:
+		return nil, fmt.Errorf("unrecognized fork digest for light client finality update: %s", digest)
+	}
+}
+
+func (d *ForkDecoder) LightClientOptimisticUpdateAllocator(digest common.ForkDigest) (func() OpaqueLightClientUpdate, error) {
+	switch digest {
+	case d.Altair, d.Bellatrix:
+		return func() OpaqueLightClientUpdate { return new(altair.LightClientOptimisticUpdate) }, nil
+	case d.Capella:
+		return func() OpaqueLightClientUpdate { return new(capella.LightClientOptimisticUpdate) }, nil
+	case d.Deneb:
+		return func() OpaqueLightClientUpdate { return new(deneb.LightClientOptimisticUpdate) }, nil
+	default:
+		return nil, fmt.Errorf("unrecognized fork digest for light client optimistic update: %s", digest)
+	}
+}
+
+func (d *ForkDecoder) LightClientBootstrapAllocator(digest common.ForkDigest) (func() common.SpecObj, error) {
+	switch digest {
+	case d.Altair, d.Bellatrix:
+		return func() common.SpecObj { return new(altair.LightClientBootstrap) }, nil
+	case d.Capella:
+		return func() common.SpecObj { return new(capella.LightClientBootstrap) }, nil
+	case d.Deneb:
+		return func() common.SpecObj { return new(deneb.LightClientBootstrap) }, nil
+	default:
+		return nil, fmt.Errorf("unrecognized fork digest for light client bootstrap: %s", digest)
+	}
+}
+
+func (d *ForkDecoder) LightClientUpdateAllocator(digest common.ForkDigest) (func() common.SpecObj, error) {
+	switch digest {
+	case d.Altair, d.Bellatrix:
+		return func() common.SpecObj { return new(altair.LightClientUpdate) }, nil
+	case d.Capella:
+		return func() common.SpecObj { return new(capella.LightClientUpdate) }, nil
+	case d.Deneb:
+		return func() common.SpecObj { return new(deneb.LightClientUpdate) }, nil
+	default:
+		return nil, fmt.Errorf("unrecognized fork digest for light client update: %s", digest)
+	}
+}
+
 func (d *ForkDecoder) ForkDigest(epoch common.Epoch) common.ForkDigest {
 	if epoch < d.Spec.ALTAIR_FORK_EPOCH {
 		return d.Genesis
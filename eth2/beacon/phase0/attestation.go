@@ -232,13 +232,7 @@ func (attestation *Attestation) ConvertToIndexed(spec *common.Spec, committee []
 	}, nil
 }
 
+// ComputeSubnetForAttestation is the same as common.ComputeSubnetForAttestation
 func ComputeSubnetForAttestation(spec *common.Spec, committeesPerSlot uint64, slot common.Slot, committeeIndex common.CommitteeIndex) (uint64, error) {
-	maxCommitteeIndex := common.CommitteeIndex(committeesPerSlot * uint64(spec.SLOTS_PER_EPOCH))
-	if committeeIndex >= maxCommitteeIndex {
-		return 0, fmt.Errorf("committee index %d >= maximum %d", committeeIndex, maxCommitteeIndex)
-	}
-	slotsSinceEpochStart := uint64(slot % spec.SLOTS_PER_EPOCH)
-	committeesSinceEpochStart := committeesPerSlot * slotsSinceEpochStart
-
-	return (committeesSinceEpochStart + uint64(committeeIndex)) % common.ATTESTATION_SUBNET_COUNT, nil
+	return common.ComputeSubnetForAttestation(spec, committeesPerSlot, slot, committeeIndex)
 }
@@ -15,6 +15,13 @@ import (
 // AttestationBits is formatted as a serialized SSZ bitlist, including the delimit bit
 type AttestationBits []byte
 
+// NewAttestationBits creates a bitlist of the given length, with all bits unset.
+func NewAttestationBits(bitLen uint64) AttestationBits {
+	out := make(AttestationBits, (bitLen>>3)+1)
+	out[bitLen>>3] = 1 << (bitLen & 7)
+	return out
+}
+
 func (li AttestationBits) View(spec *common.Spec) *AttestationBitsView {
 	v, _ := AttestationBitsType(spec).Deserialize(codec.NewDecodingReader(bytes.NewReader(li), uint64(len(li))))
 	return &AttestationBitsView{v.(*BitListView)}
@@ -97,32 +97,40 @@ func ValidateIndexedAttestationNoSignature(spec *common.Spec, state common.Beaco
 }
 
 func ValidateIndexedAttestationSignature(spec *common.Spec, dom common.BLSDomain, pubCache *common.PubkeyCache, indexedAttestation *IndexedAttestation) error {
+	pubkeys, signingRoot, sig, err := indexedAttestationSignature(dom, pubCache, indexedAttestation)
+	if err != nil {
+		return err
+	}
+	if !blsu.Eth2FastAggregateVerify(pubkeys, signingRoot[:], sig) {
+		return errors.New("could not verify BLS signature for indexed attestation")
+	}
+	return nil
+}
+
+func indexedAttestationSignature(dom common.BLSDomain, pubCache *common.PubkeyCache, indexedAttestation *IndexedAttestation) ([]*blsu.Pubkey, common.Root, *blsu.Signature, error) {
 	pubkeys := make([]*blsu.Pubkey, 0, len(indexedAttestation.AttestingIndices))
 	for _, i := range indexedAttestation.AttestingIndices {
 		pub, ok := pubCache.Pubkey(i)
 		if !ok {
-			return fmt.Errorf("could not find pubkey for index %d", i)
+			return nil, common.Root{}, nil, fmt.Errorf("could not find pubkey for index %d", i)
 		}
 		blsPub, err := pub.Pubkey()
 		if err != nil {
-			return fmt.Errorf("failed to deserialize pubkey in cache: %v", err)
+			return nil, common.Root{}, nil, fmt.Errorf("failed to deserialize pubkey in cache: %v", err)
 		}
 		pubkeys = append(pubkeys, blsPub)
 	}
 	// empty attestation. (Double check, since this function is public, the user might not have validated if it's empty or not)
 	if len(pubkeys) <= 0 {
-		return errors.New("in phase 0 no empty attestation signatures are allowed")
+		return nil, common.Root{}, nil, errors.New("in phase 0 no empty attestation signatures are allowed")
 	}
 
 	signingRoot := common.ComputeSigningRoot(indexedAttestation.Data.HashTreeRoot(tree.GetHashFn()), dom)
 	sig, err := indexedAttestation.Signature.Signature()
 	if err != nil {
-		return fmt.Errorf("failed to deserialize and sub-group check indexed attestation signature: %v", err)
+		return nil, common.Root{}, nil, fmt.Errorf("failed to deserialize and sub-group check indexed attestation signature: %v", err)
 	}
-	if !blsu.Eth2FastAggregateVerify(pubkeys, signingRoot[:], sig) {
-		return errors.New("could not verify BLS signature for indexed attestation")
-	}
-	return nil
+	return pubkeys, signingRoot, sig, nil
 }
 
 // Verify validity of slashable_attestation fields.
@@ -134,5 +142,13 @@ func ValidateIndexedAttestation(spec *common.Spec, epc *common.EpochsContext, st
 	if err != nil {
 		return err
 	}
-	return ValidateIndexedAttestationSignature(spec, dom, epc.ValidatorPubkeyCache, indexedAttestation)
+	// The signature is verified now, or collected with the other block signatures.
+	pubkeys, signingRoot, sig, err := indexedAttestationSignature(dom, epc.ValidatorPubkeyCache, indexedAttestation)
+	if err != nil {
+		return err
+	}
+	if !epc.VerifyBlockAggregateSignature(pubkeys, signingRoot[:], sig) {
+		return errors.New("could not verify BLS signature for indexed attestation")
+	}
+	return nil
 }
@@ -0,0 +1,190 @@
+package forkchoice
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// DebugNode is a snapshot of a fork-choice graph node, for debugging purposes.
+// Relations to other nodes are expressed as node indices, nil if there is no such relation.
+type DebugNode struct {
+	Index            NodeIndex       `json:"index"`
+	Slot             Slot            `json:"slot"`
+	Root             Root            `json:"root"`
+	ParentRoot       Root            `json:"parent_root"`
+	TransitionParent *NodeIndex      `json:"transition_parent"`
+	ForkchoiceParent *NodeIndex      `json:"forkchoice_parent"`
+	Weight           SignedGwei      `json:"weight"`
+	BestChild        *NodeIndex      `json:"best_child"`
+	BestDescendant   *NodeIndex      `json:"best_descendant"`
+	JustifiedEpoch   Epoch           `json:"justified_epoch"`
+	FinalizedEpoch   Epoch           `json:"finalized_epoch"`
+	ExecutionStatus  ExecutionStatus `json:"execution_status"`
+	// Viable is true if the node itself is viable for the head.
+	Viable bool `json:"viable"`
+	// LeadsToViableHead is true if the node or its best descendant is viable for the head.
+	LeadsToViableHead bool `json:"leads_to_viable_head"`
+}
+
+// IsEmptySlot returns true if the node represents a slot without block.
+func (n *DebugNode) IsEmptySlot() bool {
+	return n.Root == n.ParentRoot
+}
+
+func (n *DebugNode) Ref() NodeRef {
+	return NodeRef{Root: n.Root, Slot: n.Slot}
+}
+
+func (s ExecutionStatus) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+func (s *ExecutionStatus) UnmarshalText(text []byte) error {
+	for _, v := range []ExecutionStatus{ExecutionValid, ExecutionOptimistic, ExecutionInvalid} {
+		if string(text) == v.String() {
+			*s = v
+			return nil
+		}
+	}
+	return fmt.Errorf("unrecognized execution status: %q", text)
+}
+
+// HeadCandidate is a child that competed to be the best child of a node, while walking to the head.
+type HeadCandidate struct {
+	Ref               NodeRef    `json:"ref"`
+	Weight            SignedGwei `json:"weight"`
+	LeadsToViableHead bool       `json:"leads_to_viable_head"`
+	Chosen            bool       `json:"chosen"`
+}
+
+// HeadStep is a step of the walk from the anchor to the head, following the best child of each node.
+type HeadStep struct {
+	Node       DebugNode       `json:"node"`
+	Candidates []HeadCandidate `json:"candidates"`
+}
+
+// HeadExplanation explains why a head was chosen.
+type HeadExplanation struct {
+	Anchor         NodeRef    `json:"anchor"`
+	Head           NodeRef    `json:"head"`
+	JustifiedEpoch Epoch      `json:"justified_epoch"`
+	FinalizedEpoch Epoch      `json:"finalized_epoch"`
+	Steps          []HeadStep `json:"steps"`
+}
+
+func (e *HeadExplanation) String() string {
+	var b strings.Builder
+	_, _ = fmt.Fprintf(&b, "head %s from anchor %s (justified epoch %d, finalized epoch %d)\n",
+		e.Head, e.Anchor, e.JustifiedEpoch, e.FinalizedEpoch)
+	for _, step := range e.Steps {
+		_, _ = fmt.Fprintf(&b, "%s weight %d\n", step.Node.Ref(), step.Node.Weight)
+		for _, c := range step.Candidates {
+			marker := " "
+			if c.Chosen {
+				marker = "*"
+			}
+			_, _ = fmt.Fprintf(&b, "  %s %s weight %d viable %v\n", marker, c.Ref, c.Weight, c.LeadsToViableHead)
+		}
+	}
+	return b.String()
+}
+
+// ForkchoiceDebugView is implemented by graphs that support debugging.
+type ForkchoiceDebugView interface {
+	// DebugNodes returns a snapshot of all the nodes in the graph.
+	DebugNodes() []DebugNode
+	// ExplainHead walks from the anchor to the head, and explains every best-child choice.
+	ExplainHead(anchorRoot Root, anchorSlot Slot) (*HeadExplanation, error)
+}
+
+// WriteNodesJSON writes the nodes as JSON array.
+func WriteNodesJSON(w io.Writer, nodes []DebugNode) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(nodes)
+}
+
+// WriteNodesDOT writes the nodes as graph in the DOT format, to render with graphviz.
+// Block nodes are drawn as boxes, empty slots as ellipses. Non-viable nodes are grey.
+// Fork-choice parent relations are drawn as solid edges, the best child is highlighted,
+// and transition parent relations that differ from the fork-choice parent are dashed.
+func WriteNodesDOT(w io.Writer, nodes []DebugNode) error {
+	var b strings.Builder
+	b.WriteString("digraph forkchoice {\n  rankdir=BT;\n  node [fontname=\"monospace\"];\n")
+	for i := range nodes {
+		n := &nodes[i]
+		shape := "box"
+		if n.IsEmptySlot() {
+			shape = "ellipse"
+		}
+		color := "black"
+		if !n.Viable {
+			color = "grey"
+		}
+		rootStr := n.Root.String()
+		if len(rootStr) > 10 {
+			rootStr = rootStr[:10]
+		}
+		_, _ = fmt.Fprintf(&b, "  n%d [shape=%s, color=%s, label=\"slot %d\\n%s\\nweight %d\\nj %d f %d\\n%s\"];\n",
+			n.Index, shape, color, n.Slot, rootStr, n.Weight, n.JustifiedEpoch, n.FinalizedEpoch, n.ExecutionStatus)
+	}
+	byIndex := make(map[NodeIndex]*DebugNode, len(nodes))
+	for i := range nodes {
+		byIndex[nodes[i].Index] = &nodes[i]
+	}
+	for i := range nodes {
+		n := &nodes[i]
+		if n.ForkchoiceParent != nil {
+			style := ""
+			if p, ok := byIndex[*n.ForkchoiceParent]; ok && p.BestChild != nil && *p.BestChild == n.Index {
+				style = " [color=blue, penwidth=2]"
+			}
+			_, _ = fmt.Fprintf(&b, "  n%d -> n%d%s;\n", n.Index, *n.ForkchoiceParent, style)
+		}
+		if n.TransitionParent != nil && (n.ForkchoiceParent == nil || *n.TransitionParent != *n.ForkchoiceParent) {
+			_, _ = fmt.Fprintf(&b, "  n%d -> n%d [style=dashed];\n", n.Index, *n.TransitionParent)
+		}
+	}
+	b.WriteString("}\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func (fc *ProtoForkChoice) debugView() (ForkchoiceDebugView, error) {
+	view, ok := fc.protoArray.(ForkchoiceDebugView)
+	if !ok {
+		return nil, fmt.Errorf("graph of type %T does not support debugging", fc.protoArray)
+	}
+	return view, nil
+}
+
+// DebugNodes returns a snapshot of all the nodes of the graph, with all pending votes applied.
+func (fc *ProtoForkChoice) DebugNodes() ([]DebugNode, error) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	view, err := fc.debugView()
+	if err != nil {
+		return nil, err
+	}
+	if err := fc.updateVotesMaybe(); err != nil {
+		return nil, err
+	}
+	return view.DebugNodes(), nil
+}
+
+// ExplainHead explains the choice of the current head, see Head.
+func (fc *ProtoForkChoice) ExplainHead() (*HeadExplanation, error) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	view, err := fc.debugView()
+	if err != nil {
+		return nil, err
+	}
+	if err := fc.updateVotesMaybe(); err != nil {
+		return nil, err
+	}
+	root, slot := fc.headAnchor()
+	return view.ExplainHead(root, slot)
+}
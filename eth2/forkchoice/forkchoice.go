@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"sync"
 
+	"github.com/protolambda/ztyp/codec"
+
 	"github.com/protolambda/zrnt/eth2/beacon/common"
 )
 
@@ -83,7 +85,7 @@ func (fc *ProtoForkChoice) UpdateJustified(ctx context.Context, trigger Root, ju
 	}
 	if fc.pin != nil && trigger != fc.pin.Root {
 		// check trigger against pin, to ensure no justification/finalization of data that conflicts with the pin.
-		if unknown, inSubtree := fc.InSubtree(fc.pin.Root, trigger); unknown {
+		if unknown, inSubtree := fc.protoArray.InSubtree(fc.pin.Root, trigger); unknown {
 			return fmt.Errorf("cannot justify/finalize with unknown trigger when forkchoice is pinned")
 		} else if !inSubtree {
 			return fmt.Errorf("cannot justify/finalize outside of pinned forkchoice tree")
@@ -92,7 +94,7 @@ func (fc *ProtoForkChoice) UpdateJustified(ctx context.Context, trigger Root, ju
 
 	prevFinalized := fc.finalized
 
-	if err := fc.updateJustified(justified, finalized, justifiedStateBalances); err != nil {
+	if err := fc.updateJustified(finalized, justified, justifiedStateBalances); err != nil {
 		return err
 	}
 
@@ -115,7 +117,7 @@ func (fc *ProtoForkChoice) updateJustified(finalized Checkpoint, justified Check
 
 	// check if new finalized checkpoint is valid
 	if fc.finalized != finalized {
-		if unknown, inSubtree := fc.InSubtree(fc.finalized.Root, finalized.Root); unknown {
+		if unknown, inSubtree := fc.protoArray.InSubtree(fc.finalized.Root, finalized.Root); unknown {
 			return fmt.Errorf("unknown finalized checkpoint: %s", finalized)
 		} else if !inSubtree || fc.finalized.Epoch > finalized.Epoch {
 			return fmt.Errorf("new finalized checkpoint %s is outside of finalized subtree: %s",
@@ -123,7 +125,7 @@ func (fc *ProtoForkChoice) updateJustified(finalized Checkpoint, justified Check
 		}
 	}
 	if fc.justified != justified {
-		if unknown, inSubtree := fc.InSubtree(fc.finalized.Root, justified.Root); unknown {
+		if unknown, inSubtree := fc.protoArray.InSubtree(fc.finalized.Root, justified.Root); unknown {
 			return fmt.Errorf("unknown justified checkpoint: %s", justified)
 		} else if !inSubtree || fc.finalized.Epoch > justified.Epoch {
 			return fmt.Errorf("new justified checkpoint %s is outside of finalized subtree: %s",
@@ -180,7 +182,7 @@ func (fc *ProtoForkChoice) ProcessAttestation(index ValidatorIndex, blockRoot Ro
 	defer fc.mu.Unlock()
 	// only add the vote if we can. Don't add if it's not within view.
 	blockSlot, ok := fc.protoArray.GetSlot(blockRoot)
-	if !ok || blockSlot < headSlot {
+	if !ok || blockSlot > headSlot {
 		return false
 	}
 	return fc.voteStore.ProcessAttestation(index, blockRoot, headSlot)
@@ -204,6 +206,31 @@ func (fc *ProtoForkChoice) ProcessBlock(parentRoot Root, blockRoot Root, blockSl
 	return fc.protoArray.ProcessBlock(parentRoot, blockRoot, blockSlot, justifiedEpoch, finalizedEpoch)
 }
 
+func (fc *ProtoForkChoice) ProcessPayloadBlock(parentRoot Root, blockRoot Root, blockSlot Slot, justifiedEpoch Epoch, finalizedEpoch Epoch,
+	blockHash Root, status ExecutionStatus) (ok bool) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	return fc.protoArray.ProcessPayloadBlock(parentRoot, blockRoot, blockSlot, justifiedEpoch, finalizedEpoch, blockHash, status)
+}
+
+func (fc *ProtoForkChoice) SetPayloadValid(blockRoot Root) error {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	return fc.protoArray.SetPayloadValid(blockRoot)
+}
+
+func (fc *ProtoForkChoice) SetPayloadInvalid(blockRoot Root, latestValidHash *Root) error {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	return fc.protoArray.SetPayloadInvalid(blockRoot, latestValidHash)
+}
+
+func (fc *ProtoForkChoice) PayloadStatus(blockRoot Root) (status ExecutionStatus, ok bool) {
+	fc.mu.RLock()
+	defer fc.mu.RUnlock()
+	return fc.protoArray.PayloadStatus(blockRoot)
+}
+
 func (fc *ProtoForkChoice) InSubtree(anchor Root, root Root) (unknown bool, inSubtree bool) {
 	fc.mu.Lock()
 	defer fc.mu.Unlock()
@@ -246,14 +273,132 @@ func (fc *ProtoForkChoice) FindHead(anchorRoot Root, anchorSlot Slot) (NodeRef,
 func (fc *ProtoForkChoice) Head() (NodeRef, error) {
 	fc.mu.Lock()
 	defer fc.mu.Unlock()
+	return fc.head()
+}
+
+func (fc *ProtoForkChoice) HeadIsOptimistic() (bool, error) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	head, err := fc.head()
+	if err != nil {
+		return false, err
+	}
+	status, ok := fc.protoArray.PayloadStatus(head.Root)
+	if !ok {
+		return false, fmt.Errorf("head %s is unknown", head)
+	}
+	return status == ExecutionOptimistic, nil
+}
+
+func (fc *ProtoForkChoice) head() (NodeRef, error) {
 	if err := fc.updateVotesMaybe(); err != nil {
 		return NodeRef{}, err
 	}
-	root := fc.justified.Root
+	root, slot := fc.headAnchor()
+	return fc.protoArray.FindHead(root, slot)
+}
+
+// The head is searched for in the pinned subtree, or the justified subtree if not pinned.
+func (fc *ProtoForkChoice) headAnchor() (Root, Slot) {
+	if fc.pin != nil {
+		return fc.pin.Root, fc.pin.Slot
+	}
 	slot, _ := fc.spec.EpochStartSlot(fc.justified.Epoch)
+	return fc.justified.Root, slot
+}
+
+// Serialize writes the fork-choice state: the justified and finalized checkpoints, the pin (if any),
+// the justified balances, followed by the graph and the votes.
+// The graph and vote-store must both implement codec.Serializable.
+// Use LoadForkChoice to restore the fork-choice.
+func (fc *ProtoForkChoice) Serialize(w *codec.EncodingWriter) error {
+	fc.mu.RLock()
+	defer fc.mu.RUnlock()
+	graph, ok := fc.protoArray.(codec.Serializable)
+	if !ok {
+		return fmt.Errorf("graph of type %T cannot be serialized", fc.protoArray)
+	}
+	votes, ok := fc.voteStore.(codec.Serializable)
+	if !ok {
+		return fmt.Errorf("vote store of type %T cannot be serialized", fc.voteStore)
+	}
+	if err := w.FixedLenContainer(&fc.justified, &fc.finalized); err != nil {
+		return err
+	}
+	if fc.pin == nil {
+		if err := w.WriteByte(0); err != nil {
+			return err
+		}
+	} else {
+		if err := w.WriteByte(1); err != nil {
+			return err
+		}
+		if err := w.FixedLenContainer(&fc.pin.Root, fc.pin.Slot); err != nil {
+			return err
+		}
+	}
+	if err := w.WriteUint64(uint64(len(fc.balances))); err != nil {
+		return err
+	}
+	for _, bal := range fc.balances {
+		if err := w.WriteUint64(uint64(bal)); err != nil {
+			return err
+		}
+	}
+	if err := graph.Serialize(w); err != nil {
+		return err
+	}
+	return votes.Serialize(w)
+}
+
+// LoadForkChoice restores a fork-choice that was written with ProtoForkChoice.Serialize.
+// The graph and votes are restored with the given loader functions, in that order.
+func LoadForkChoice(spec *common.Spec, dr *codec.DecodingReader,
+	loadGraph func(dr *codec.DecodingReader) (ForkchoiceGraph, error),
+	loadVotes func(dr *codec.DecodingReader) (VoteStore, error)) (*ProtoForkChoice, error) {
+	fc := &ProtoForkChoice{spec: spec}
+	if err := dr.FixedLenContainer(&fc.justified, &fc.finalized); err != nil {
+		return nil, err
+	}
+	if fc.justified.Epoch < fc.finalized.Epoch {
+		return nil, fmt.Errorf("justified epoch %d lower than finalized epoch %d", fc.justified.Epoch, fc.finalized.Epoch)
+	}
+	hasPin, err := dr.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	if hasPin != 0 {
+		var pin NodeRef
+		if err := dr.FixedLenContainer(&pin.Root, &pin.Slot); err != nil {
+			return nil, err
+		}
+		fc.pin = &pin
+	}
+	count, err := dr.ReadUint64()
+	if err != nil {
+		return nil, err
+	}
+	if count > dr.Scope()/8 {
+		return nil, fmt.Errorf("balances count %d too large for input", count)
+	}
+	fc.balances = make([]Gwei, count)
+	for i := range fc.balances {
+		bal, err := dr.ReadUint64()
+		if err != nil {
+			return nil, err
+		}
+		fc.balances[i] = Gwei(bal)
+	}
+	if fc.protoArray, err = loadGraph(dr); err != nil {
+		return nil, fmt.Errorf("failed to load graph: %w", err)
+	}
+	if fc.voteStore, err = loadVotes(dr); err != nil {
+		return nil, fmt.Errorf("failed to load votes: %w", err)
+	}
 	if fc.pin != nil {
-		root = fc.pin.Root
-		slot = fc.pin.Slot
+		if _, err := fc.protoArray.ClosestToSlot(fc.pin.Root, fc.pin.Slot); err != nil {
+			return nil, fmt.Errorf("cannot find pin: %v", err)
+		}
 	}
-	return fc.protoArray.FindHead(root, slot)
+	return fc, nil
 }
@@ -2,6 +2,7 @@ package forkchoice
 
 import (
 	"context"
+	"fmt"
 
 	"github.com/protolambda/zrnt/eth2/beacon/common"
 )
@@ -17,6 +18,33 @@ type ExtendedNodeRef = common.ExtendedNodeRef
 type SignedGwei int64
 type NodeIndex uint64
 
+// ExecutionStatus is the validation status of the execution payload of a node.
+type ExecutionStatus uint8
+
+const (
+	// ExecutionValid is the status of nodes with a payload that was validated by the execution engine,
+	// and of nodes without execution payload (pre-merge).
+	ExecutionValid ExecutionStatus = iota
+	// ExecutionOptimistic is the status of nodes that were imported before the execution engine validated the payload.
+	ExecutionOptimistic
+	// ExecutionInvalid is the status of nodes with an invalid payload, or with an invalid ancestor.
+	// Invalid nodes are never viable for the head.
+	ExecutionInvalid
+)
+
+func (s ExecutionStatus) String() string {
+	switch s {
+	case ExecutionValid:
+		return "valid"
+	case ExecutionOptimistic:
+		return "optimistic"
+	case ExecutionInvalid:
+		return "invalid"
+	default:
+		return fmt.Sprintf("unknown(%d)", uint8(s))
+	}
+}
+
 type ForkchoiceView interface {
 	CanonicalChain(anchorRoot Root, anchorSlot Slot) ([]ExtendedNodeRef, error)
 	ClosestToSlot(anchor Root, slot Slot) (closest NodeRef, err error)
@@ -25,17 +53,35 @@ type ForkchoiceView interface {
 	FindHead(anchorRoot Root, anchorSlot Slot) (NodeRef, error)
 	InSubtree(anchor Root, root Root) (unknown bool, inSubtree bool)
 	Search(anchor NodeRef, parentRoot *Root, slot *Slot) (nonCanon []NodeRef, canon []NodeRef, err error)
+	// PayloadStatus returns the execution status of the given block, ok=false if the block is unknown.
+	PayloadStatus(blockRoot Root) (status ExecutionStatus, ok bool)
 }
 
 type ForkchoiceNodeInput interface {
 	ProcessSlot(parent Root, slot Slot, justifiedEpoch Epoch, finalizedEpoch Epoch)
 	ProcessBlock(parent Root, blockRoot Root, blockSlot Slot, justifiedEpoch Epoch, finalizedEpoch Epoch) (ok bool)
+	// ProcessPayloadBlock is like ProcessBlock, but for a block with an execution payload,
+	// identified by the payload block hash, which may not have been validated yet (optimistic sync).
+	ProcessPayloadBlock(parent Root, blockRoot Root, blockSlot Slot, justifiedEpoch Epoch, finalizedEpoch Epoch,
+		blockHash Root, status ExecutionStatus) (ok bool)
+}
+
+type ExecutionStatusInput interface {
+	// SetPayloadValid marks the payload of the block, and the payloads of all its ancestors, as valid.
+	SetPayloadValid(blockRoot Root) error
+	// SetPayloadInvalid marks the payload of the block as invalid, and all its descendants.
+	// If latestValidHash is not nil, and matches the payload of an ancestor,
+	// then all optimistic blocks after this ancestor are invalidated as well, and the ancestor is marked as valid.
+	SetPayloadInvalid(blockRoot Root, latestValidHash *Root) error
 }
 
 type ForkchoiceGraph interface {
 	ForkchoiceView
 	ForkchoiceNodeInput
+	ExecutionStatusInput
 	Indices() map[NodeRef]NodeIndex
+	// Weight returns the weight of the node, including the weight of all its descendants.
+	Weight(ref NodeRef) (weight SignedGwei, ok bool)
 	ApplyScoreChanges(deltas []SignedGwei, justifiedEpoch Epoch, finalizedEpoch Epoch) error
 	OnPrune(ctx context.Context, anchorRoot Root, anchorSlot Slot) error
 }
@@ -56,6 +102,7 @@ type VoteStore interface {
 type Forkchoice interface {
 	ForkchoiceView
 	ForkchoiceNodeInput
+	ExecutionStatusInput
 	VoteInput
 	UpdateJustified(ctx context.Context, trigger Root, justified Checkpoint, finalized Checkpoint,
 		justifiedStateBalances func() ([]Gwei, error)) error
@@ -64,4 +111,9 @@ type Forkchoice interface {
 	Justified() Checkpoint
 	Finalized() Checkpoint
 	Head() (NodeRef, error)
+	// SafeBlock returns the latest block that is considered safe, following the given rule.
+	// The current slot is used to determine the committee weight of the elapsed slots.
+	SafeBlock(rule SafeBlockRule, currentSlot Slot) (NodeRef, error)
+	// HeadIsOptimistic returns true if the payload of the current head is not validated yet.
+	HeadIsOptimistic() (bool, error)
 }
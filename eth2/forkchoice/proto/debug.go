@@ -0,0 +1,106 @@
+package proto
+
+import (
+	"io"
+
+	. "github.com/protolambda/zrnt/eth2/forkchoice"
+)
+
+var _ ForkchoiceDebugView = (*ProtoArray)(nil)
+
+func (pr *ProtoArray) debugNode(index NodeIndex, node *ProtoNode) DebugNode {
+	rel := func(i NodeIndex) *NodeIndex {
+		if i == NONE {
+			return nil
+		}
+		return &i
+	}
+	leadsToViableHead, _ := pr.nodeLeadsToViableHead(node)
+	return DebugNode{
+		Index:             index,
+		Slot:              node.Ref.Slot,
+		Root:              node.Ref.Root,
+		ParentRoot:        node.ParentRoot,
+		TransitionParent:  rel(node.TransitionParent),
+		ForkchoiceParent:  rel(node.ForkchoiceParent),
+		Weight:            node.Weight,
+		BestChild:         rel(node.BestChild),
+		BestDescendant:    rel(node.BestDescendant),
+		JustifiedEpoch:    node.JustifiedEpoch,
+		FinalizedEpoch:    node.FinalizedEpoch,
+		ExecutionStatus:   node.ExecutionStatus,
+		Viable:            pr.isNodeViableForHead(node),
+		LeadsToViableHead: leadsToViableHead,
+	}
+}
+
+// DebugNodes returns a snapshot of all nodes, ordered by index.
+// The best child and descendant links are updated first.
+func (pr *ProtoArray) DebugNodes() []DebugNode {
+	if !pr.updatedConnections {
+		_ = pr.updateConnections()
+	}
+	out := make([]DebugNode, 0, len(pr.nodes))
+	for i := range pr.nodes {
+		out = append(out, pr.debugNode(pr.indexOffset+NodeIndex(i), &pr.nodes[i]))
+	}
+	return out
+}
+
+// ExplainHead walks the best-child links from the anchor to the head, like FindHead,
+// and lists the competing children, with their weights, for every node along the way.
+func (pr *ProtoArray) ExplainHead(anchorRoot Root, anchorSlot Slot) (*HeadExplanation, error) {
+	head, err := pr.FindHead(anchorRoot, anchorSlot)
+	if err != nil {
+		return nil, err
+	}
+	anchorIndex := pr.indices[NodeRef{Root: anchorRoot, Slot: anchorSlot}]
+	children := make(map[NodeIndex][]NodeIndex)
+	for i := range pr.nodes {
+		if p := pr.nodes[i].ForkchoiceParent; p != NONE {
+			children[p] = append(children[p], pr.indexOffset+NodeIndex(i))
+		}
+	}
+	out := &HeadExplanation{
+		Anchor:         NodeRef{Root: anchorRoot, Slot: anchorSlot},
+		Head:           head,
+		JustifiedEpoch: pr.justifiedEpoch,
+		FinalizedEpoch: pr.finalizedEpoch,
+	}
+	for index := anchorIndex; index != NONE; {
+		node, err := pr.getNode(index)
+		if err != nil {
+			return nil, err
+		}
+		step := HeadStep{Node: pr.debugNode(index, node)}
+		for _, childIndex := range children[index] {
+			child, err := pr.getNode(childIndex)
+			if err != nil {
+				return nil, err
+			}
+			leadsToViableHead, err := pr.nodeLeadsToViableHead(child)
+			if err != nil {
+				return nil, err
+			}
+			step.Candidates = append(step.Candidates, HeadCandidate{
+				Ref:               child.Ref,
+				Weight:            child.Weight,
+				LeadsToViableHead: leadsToViableHead,
+				Chosen:            childIndex == node.BestChild,
+			})
+		}
+		out.Steps = append(out.Steps, step)
+		index = node.BestChild
+	}
+	return out, nil
+}
+
+// WriteDOT writes the graph in the DOT format, see WriteNodesDOT.
+func (pr *ProtoArray) WriteDOT(w io.Writer) error {
+	return WriteNodesDOT(w, pr.DebugNodes())
+}
+
+// WriteJSON writes the graph nodes as JSON array, see WriteNodesJSON.
+func (pr *ProtoArray) WriteJSON(w io.Writer) error {
+	return WriteNodesJSON(w, pr.DebugNodes())
+}
@@ -0,0 +1,67 @@
+package proto
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	. "github.com/protolambda/zrnt/eth2/forkchoice"
+)
+
+func TestDebugDump(t *testing.T) {
+	root := func(i byte) (out Root) {
+		out[0] = i
+		return
+	}
+	pr := NewProtoArray(Root{}, root(0), 0, 0, 0, nil)
+	pr.ProcessBlock(root(0), root(1), 1, 0, 0)
+	pr.ProcessBlock(root(0), root(2), 2, 0, 0)
+	deltas := make([]SignedGwei, len(pr.Indices()))
+	deltas[pr.Indices()[NodeRef{Root: root(1), Slot: 1}]] = 10
+	if err := pr.ApplyScoreChanges(deltas, 0, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	var jsonBuf bytes.Buffer
+	if err := pr.WriteJSON(&jsonBuf); err != nil {
+		t.Fatal(err)
+	}
+	var nodes []DebugNode
+	if err := json.Unmarshal(jsonBuf.Bytes(), &nodes); err != nil {
+		t.Fatal(err)
+	}
+	if len(nodes) != len(pr.Indices()) {
+		t.Fatalf("expected %d nodes, got %d", len(pr.Indices()), len(nodes))
+	}
+
+	var dotBuf bytes.Buffer
+	if err := pr.WriteDOT(&dotBuf); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(dotBuf.String(), "digraph forkchoice {") {
+		t.Fatalf("unexpected DOT output:\n%s", dotBuf.String())
+	}
+
+	expl, err := pr.ExplainHead(root(0), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := (NodeRef{Root: root(1), Slot: 1}); expl.Head != expected {
+		t.Fatalf("expected head %s, got %s", expected, expl.Head)
+	}
+	last := expl.Steps[len(expl.Steps)-1]
+	if last.Node.Ref() != expl.Head {
+		t.Fatalf("walk did not end at head, but at %s", last.Node.Ref())
+	}
+	first := expl.Steps[0]
+	// block 1, block 2, and the empty slot 1
+	if len(first.Candidates) != 3 {
+		t.Fatalf("expected 3 candidates for the anchor, got %d", len(first.Candidates))
+	}
+	for _, c := range first.Candidates {
+		if c.Chosen != (c.Ref == expl.Head) {
+			t.Fatalf("unexpected choice of candidate %s:\n%s", c.Ref, expl)
+		}
+	}
+}
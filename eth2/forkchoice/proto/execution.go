@@ -0,0 +1,138 @@
+package proto
+
+import (
+	"fmt"
+
+	. "github.com/protolambda/zrnt/eth2/forkchoice"
+)
+
+// Returns the payload block hash and execution status of the node at the given index.
+// Unknown and pruned nodes are considered to be valid.
+func (pr *ProtoArray) payloadOf(index NodeIndex) (blockHash Root, status ExecutionStatus) {
+	if index == NONE || index < pr.indexOffset || index-pr.indexOffset >= NodeIndex(len(pr.nodes)) {
+		return Root{}, ExecutionValid
+	}
+	node := &pr.nodes[index-pr.indexOffset]
+	return node.BlockHash, node.ExecutionStatus
+}
+
+func (pr *ProtoArray) blockIndex(blockRoot Root) (NodeIndex, bool) {
+	slot, ok := pr.blockSlots[blockRoot]
+	if !ok {
+		return NONE, false
+	}
+	index, ok := pr.indices[NodeRef{Root: blockRoot, Slot: slot}]
+	return index, ok
+}
+
+func (pr *ProtoArray) PayloadStatus(blockRoot Root) (status ExecutionStatus, ok bool) {
+	index, ok := pr.blockIndex(blockRoot)
+	if !ok {
+		return ExecutionValid, false
+	}
+	_, status = pr.payloadOf(index)
+	return status, true
+}
+
+// Marks the optimistic node at the given index, and any optimistic ancestors, as valid.
+// All nodes that share the block root of a validated node (i.e. empty slots after the block) are updated too.
+func (pr *ProtoArray) markValid(index NodeIndex) {
+	roots := make(map[Root]struct{})
+	start := index
+	for i := index; i != NONE && i >= pr.indexOffset; {
+		node := &pr.nodes[i-pr.indexOffset]
+		// ancestors of a valid node are valid, no need to look any further.
+		if node.ExecutionStatus == ExecutionValid {
+			break
+		}
+		roots[node.Ref.Root] = struct{}{}
+		start = i
+		i = node.ForkchoiceParent
+	}
+	if len(roots) == 0 {
+		return
+	}
+	for i := start - pr.indexOffset; i < NodeIndex(len(pr.nodes)); i++ {
+		node := &pr.nodes[i]
+		if _, ok := roots[node.Ref.Root]; ok && node.ExecutionStatus == ExecutionOptimistic {
+			node.ExecutionStatus = ExecutionValid
+		}
+	}
+}
+
+// SetPayloadValid marks the payload of the given block, and those of all its ancestors, as valid.
+func (pr *ProtoArray) SetPayloadValid(blockRoot Root) error {
+	index, ok := pr.blockIndex(blockRoot)
+	if !ok {
+		return fmt.Errorf("cannot validate payload of unknown block %s", blockRoot)
+	}
+	if _, status := pr.payloadOf(index); status == ExecutionInvalid {
+		return fmt.Errorf("cannot validate payload of invalid block %s", blockRoot)
+	}
+	pr.markValid(index)
+	return nil
+}
+
+// SetPayloadInvalid marks the payload of the given block as invalid, and invalidates all its descendants.
+//
+// The latest valid hash, if not nil, is the block hash of the latest valid ancestor payload,
+// as reported by the execution engine. If this ancestor is known,
+// the optimistic blocks in-between the ancestor and the given block are invalidated as well,
+// and the ancestor (and by extension its ancestors) are marked as valid.
+// If the latest valid hash is not known, only the given block and its descendants are invalidated.
+func (pr *ProtoArray) SetPayloadInvalid(blockRoot Root, latestValidHash *Root) error {
+	index, ok := pr.blockIndex(blockRoot)
+	if !ok {
+		return fmt.Errorf("cannot invalidate payload of unknown block %s", blockRoot)
+	}
+	if _, status := pr.payloadOf(index); status == ExecutionValid {
+		return fmt.Errorf("cannot invalidate payload of valid block %s", blockRoot)
+	}
+	invalidRoots := map[Root]struct{}{blockRoot: {}}
+	start := index
+	if latestValidHash != nil {
+		ancestors := make(map[Root]struct{})
+		ancestorsStart := start
+		validIndex := NONE
+		node := &pr.nodes[index-pr.indexOffset]
+		for i := node.ForkchoiceParent; i != NONE && i >= pr.indexOffset; {
+			node := &pr.nodes[i-pr.indexOffset]
+			if node.BlockHash == *latestValidHash {
+				validIndex = i
+				break
+			}
+			// cannot invalidate payloads of nodes that are already known to be valid.
+			if node.ExecutionStatus == ExecutionValid {
+				break
+			}
+			if node.Ref.Root != blockRoot {
+				ancestors[node.Ref.Root] = struct{}{}
+			}
+			ancestorsStart = i
+			i = node.ForkchoiceParent
+		}
+		if validIndex != NONE {
+			for root := range ancestors {
+				invalidRoots[root] = struct{}{}
+			}
+			start = ancestorsStart
+			pr.markValid(validIndex)
+		}
+	}
+	// The array is ordered: parents always come before their children,
+	// so a single pass is enough to spread the invalidation to all descendants.
+	isInvalid := func(i NodeIndex) bool {
+		_, status := pr.payloadOf(i)
+		return status == ExecutionInvalid
+	}
+	for i := start - pr.indexOffset; i < NodeIndex(len(pr.nodes)); i++ {
+		node := &pr.nodes[i]
+		if _, ok := invalidRoots[node.Ref.Root]; ok ||
+			isInvalid(node.ForkchoiceParent) || isInvalid(node.TransitionParent) {
+			node.ExecutionStatus = ExecutionInvalid
+		}
+	}
+	// Connections are out of sync, invalid nodes have to be dropped as best child/descendant.
+	pr.updatedConnections = false
+	return nil
+}
@@ -0,0 +1,118 @@
+package proto
+
+import (
+	"testing"
+
+	. "github.com/protolambda/zrnt/eth2/forkchoice"
+)
+
+func TestExecutionStatus(t *testing.T) {
+	root := func(i byte) (out Root) {
+		out[0] = i
+		return
+	}
+	hash := func(i byte) (out Root) {
+		out[0] = 0xee
+		out[1] = i
+		return
+	}
+	pr := NewProtoArray(Root{}, root(0), 0, 0, 0, nil)
+	//   0
+	//   |
+	//   1
+	//  / \
+	// 2   4
+	// |
+	// 3
+	for _, b := range []struct {
+		parent, block byte
+		slot          Slot
+	}{{0, 1, 1}, {1, 2, 2}, {2, 3, 3}, {1, 4, 3}} {
+		if !pr.ProcessPayloadBlock(root(b.parent), root(b.block), b.slot, 0, 0, hash(b.block), ExecutionOptimistic) {
+			t.Fatalf("failed to add block %d", b.block)
+		}
+	}
+	expectStatus := func(block byte, expected ExecutionStatus) {
+		t.Helper()
+		status, ok := pr.PayloadStatus(root(block))
+		if !ok {
+			t.Fatalf("block %d unknown", block)
+		}
+		if status != expected {
+			t.Fatalf("block %d: expected status %s, got %s", block, expected, status)
+		}
+	}
+	expectHead := func(block byte, slot Slot) {
+		t.Helper()
+		head, err := pr.FindHead(root(0), 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected := (NodeRef{Root: root(block), Slot: slot}); head != expected {
+			t.Fatalf("expected head %s, got %s", expected, head)
+		}
+	}
+	expectHead(4, 3)
+
+	latestValid := hash(1)
+	if err := pr.SetPayloadInvalid(root(4), &latestValid); err != nil {
+		t.Fatal(err)
+	}
+	expectStatus(0, ExecutionValid)
+	expectStatus(1, ExecutionValid)
+	expectStatus(2, ExecutionOptimistic)
+	expectStatus(3, ExecutionOptimistic)
+	expectStatus(4, ExecutionInvalid)
+	expectHead(3, 3)
+
+	// gap slots and children of invalid blocks are invalid too.
+	pr.ProcessSlot(root(4), 5, 0, 0)
+	if !pr.ProcessPayloadBlock(root(4), root(5), 6, 0, 0, hash(5), ExecutionOptimistic) {
+		t.Fatal("failed to add block 5")
+	}
+	expectStatus(5, ExecutionInvalid)
+	expectHead(3, 3)
+
+	if err := pr.SetPayloadValid(root(3)); err != nil {
+		t.Fatal(err)
+	}
+	expectStatus(2, ExecutionValid)
+	expectStatus(3, ExecutionValid)
+	if err := pr.SetPayloadInvalid(root(3), nil); err == nil {
+		t.Fatal("expected error when invalidating a valid payload")
+	}
+	if err := pr.SetPayloadValid(root(5)); err == nil {
+		t.Fatal("expected error when validating an invalid payload")
+	}
+	expectHead(3, 3)
+}
+
+func TestValidChildValidatesAncestors(t *testing.T) {
+	root := func(i byte) (out Root) {
+		out[0] = i
+		return
+	}
+	pr := NewProtoArray(Root{}, root(0), 0, 0, 0, nil)
+	if !pr.ProcessPayloadBlock(root(0), root(1), 1, 0, 0, Root{0xee, 1}, ExecutionOptimistic) {
+		t.Fatal("failed to add block 1")
+	}
+	// gap slot between the optimistic parent and the valid child.
+	pr.ProcessSlot(root(1), 2, 0, 0)
+	if !pr.ProcessPayloadBlock(root(1), root(2), 3, 0, 0, Root{0xee, 2}, ExecutionValid) {
+		t.Fatal("failed to add block 2")
+	}
+	for _, block := range []byte{0, 1, 2} {
+		status, ok := pr.PayloadStatus(root(block))
+		if !ok {
+			t.Fatalf("block %d unknown", block)
+		}
+		if status != ExecutionValid {
+			t.Fatalf("block %d: expected status %s, got %s", block, ExecutionValid, status)
+		}
+	}
+	for i := range pr.nodes {
+		if node := &pr.nodes[i]; node.ExecutionStatus != ExecutionValid {
+			t.Fatalf("node %s: expected status %s, got %s", node.Ref, ExecutionValid, node.ExecutionStatus)
+		}
+	}
+}
@@ -1,6 +1,8 @@
 package proto
 
 import (
+	"github.com/protolambda/ztyp/codec"
+
 	"github.com/protolambda/zrnt/eth2/beacon/common"
 	. "github.com/protolambda/zrnt/eth2/forkchoice"
 )
@@ -12,3 +14,15 @@ func NewProtoForkChoice(spec *common.Spec, finalized Checkpoint, justified Check
 		NewProtoArray(anchorParent, anchorRoot, anchorSlot, justified.Epoch, finalized.Epoch, sink),
 		NewProtoVoteStore(spec), initialBalances)
 }
+
+// LoadProtoForkChoice restores a fork-choice with a proto-array graph and vote-store,
+// written with ProtoForkChoice.Serialize, to resume without replaying all unfinalized blocks.
+func LoadProtoForkChoice(spec *common.Spec, dr *codec.DecodingReader, sink NodeSink) (Forkchoice, error) {
+	return LoadForkChoice(spec, dr,
+		func(dr *codec.DecodingReader) (ForkchoiceGraph, error) {
+			return LoadProtoArray(dr, sink)
+		},
+		func(dr *codec.DecodingReader) (VoteStore, error) {
+			return LoadProtoVoteStore(spec, dr)
+		})
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"testing"
 
+	"github.com/protolambda/zrnt/eth2/configs"
 	"github.com/protolambda/zrnt/eth2/forkchoice"
 	"github.com/protolambda/zrnt/eth2/forkchoice/internal/fctest"
 )
@@ -30,3 +31,61 @@ func TestProtoArray(t *testing.T) {
 		t.Error(err)
 	}
 }
+
+func TestUpdateJustified(t *testing.T) {
+	spec := configs.Mainnet
+	root := func(i byte) (out forkchoice.Root) {
+		out[0] = i
+		return
+	}
+	bal := spec.MAX_EFFECTIVE_BALANCE
+	genesis := forkchoice.Checkpoint{Root: root(0), Epoch: 0}
+	fc, err := NewProtoForkChoice(spec, genesis, genesis, root(0), 0, forkchoice.Root{}, []forkchoice.Gwei{bal, bal}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	epochSlot := spec.SLOTS_PER_EPOCH
+	if !fc.ProcessBlock(root(0), root(1), epochSlot, 0, 0) {
+		t.Fatal("failed to add block 1")
+	}
+	// The justified checkpoint may be ahead of the finalized checkpoint.
+	justified := forkchoice.Checkpoint{Root: root(1), Epoch: 1}
+	err = fc.UpdateJustified(context.Background(), root(1), justified, genesis, func() ([]forkchoice.Gwei, error) {
+		return []forkchoice.Gwei{bal, bal}, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := fc.Justified(); got != justified {
+		t.Fatalf("expected justified %s, got %s", justified, got)
+	}
+	if got := fc.Finalized(); got != genesis {
+		t.Fatalf("expected finalized %s, got %s", genesis, got)
+	}
+}
+
+func TestProcessAttestationView(t *testing.T) {
+	spec := configs.Mainnet
+	root := func(i byte) (out forkchoice.Root) {
+		out[0] = i
+		return
+	}
+	genesis := forkchoice.Checkpoint{Root: root(0), Epoch: 0}
+	fc, err := NewProtoForkChoice(spec, genesis, genesis, root(0), 0, forkchoice.Root{}, []forkchoice.Gwei{spec.MAX_EFFECTIVE_BALANCE}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fc.ProcessBlock(root(0), root(1), 1, 0, 0)
+	fc.ProcessBlock(root(1), root(2), 3, 0, 0)
+	// A vote at a later slot may still be for an older block.
+	if !fc.ProcessAttestation(0, root(1), 2) {
+		t.Fatal("expected vote for block before the head slot to be accepted")
+	}
+	// A vote cannot be for a block after the slot of the vote.
+	if fc.ProcessAttestation(0, root(2), 2) {
+		t.Fatal("expected vote for block after the head slot to be rejected")
+	}
+	if fc.ProcessAttestation(0, root(9), 4) {
+		t.Fatal("expected vote for unknown block to be rejected")
+	}
+}
@@ -0,0 +1,260 @@
+package proto
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/protolambda/ztyp/codec"
+
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+	. "github.com/protolambda/zrnt/eth2/forkchoice"
+)
+
+const protoNodeSize = 40 + 8 + 8 + 32 + 8 + 8 + 8 + 8 + 8 + 32 + 1
+
+func (n *ProtoNode) Serialize(w *codec.EncodingWriter) error {
+	if err := w.FixedLenContainer(&n.Ref.Root, n.Ref.Slot); err != nil {
+		return err
+	}
+	for _, v := range []uint64{uint64(n.TransitionParent), uint64(n.ForkchoiceParent)} {
+		if err := w.WriteUint64(v); err != nil {
+			return err
+		}
+	}
+	if err := w.FixedLenContainer(&n.ParentRoot, n.JustifiedEpoch, n.FinalizedEpoch); err != nil {
+		return err
+	}
+	for _, v := range []uint64{uint64(n.Weight), uint64(n.BestChild), uint64(n.BestDescendant)} {
+		if err := w.WriteUint64(v); err != nil {
+			return err
+		}
+	}
+	if err := n.BlockHash.Serialize(w); err != nil {
+		return err
+	}
+	return w.WriteByte(byte(n.ExecutionStatus))
+}
+
+func (n *ProtoNode) Deserialize(dr *codec.DecodingReader) error {
+	if err := dr.FixedLenContainer(&n.Ref.Root, &n.Ref.Slot); err != nil {
+		return err
+	}
+	for _, dst := range []*NodeIndex{&n.TransitionParent, &n.ForkchoiceParent} {
+		v, err := dr.ReadUint64()
+		if err != nil {
+			return err
+		}
+		*dst = NodeIndex(v)
+	}
+	if err := dr.FixedLenContainer(&n.ParentRoot, &n.JustifiedEpoch, &n.FinalizedEpoch); err != nil {
+		return err
+	}
+	weight, err := dr.ReadUint64()
+	if err != nil {
+		return err
+	}
+	n.Weight = SignedGwei(weight)
+	for _, dst := range []*NodeIndex{&n.BestChild, &n.BestDescendant} {
+		v, err := dr.ReadUint64()
+		if err != nil {
+			return err
+		}
+		*dst = NodeIndex(v)
+	}
+	if err := n.BlockHash.Deserialize(dr); err != nil {
+		return err
+	}
+	status, err := dr.ReadByte()
+	if err != nil {
+		return err
+	}
+	if ExecutionStatus(status) > ExecutionInvalid {
+		return fmt.Errorf("unrecognized execution status: %d", status)
+	}
+	n.ExecutionStatus = ExecutionStatus(status)
+	return nil
+}
+
+func (n *ProtoNode) ByteLength() uint64 {
+	return protoNodeSize
+}
+
+func (n *ProtoNode) FixedLength() uint64 {
+	return protoNodeSize
+}
+
+// Serialize writes the proto-array: the index offset, the justified and finalized epochs,
+// the nodes, and the first known slot of each block root.
+// The node indices are not written, these are restored from the nodes.
+func (pr *ProtoArray) Serialize(w *codec.EncodingWriter) error {
+	for _, v := range []uint64{uint64(pr.indexOffset), uint64(pr.justifiedEpoch), uint64(pr.finalizedEpoch),
+		uint64(len(pr.nodes))} {
+		if err := w.WriteUint64(v); err != nil {
+			return err
+		}
+	}
+	for i := range pr.nodes {
+		if err := pr.nodes[i].Serialize(w); err != nil {
+			return err
+		}
+	}
+	// sort the block roots, to make the output deterministic
+	roots := make([]Root, 0, len(pr.blockSlots))
+	for root := range pr.blockSlots {
+		roots = append(roots, root)
+	}
+	sort.Slice(roots, func(i, j int) bool {
+		return string(roots[i][:]) < string(roots[j][:])
+	})
+	if err := w.WriteUint64(uint64(len(roots))); err != nil {
+		return err
+	}
+	for i := range roots {
+		if err := w.FixedLenContainer(&roots[i], pr.blockSlots[roots[i]]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (pr *ProtoArray) ByteLength() uint64 {
+	return 8*4 + uint64(len(pr.nodes))*protoNodeSize + 8 + uint64(len(pr.blockSlots))*(32+8)
+}
+
+func (pr *ProtoArray) FixedLength() uint64 {
+	return 0
+}
+
+// LoadProtoArray restores a proto-array that was written with ProtoArray.Serialize.
+func LoadProtoArray(dr *codec.DecodingReader, sink NodeSink) (*ProtoArray, error) {
+	var header [4]uint64
+	for i := range header {
+		v, err := dr.ReadUint64()
+		if err != nil {
+			return nil, err
+		}
+		header[i] = v
+	}
+	nodeCount := header[3]
+	if nodeCount == 0 {
+		return nil, fmt.Errorf("proto-array must have at least one node")
+	}
+	if nodeCount > dr.Scope()/protoNodeSize {
+		return nil, fmt.Errorf("node count %d too large for input", nodeCount)
+	}
+	pr := &ProtoArray{
+		sink:           sink,
+		indexOffset:    NodeIndex(header[0]),
+		justifiedEpoch: Epoch(header[1]),
+		finalizedEpoch: Epoch(header[2]),
+		nodes:          make([]ProtoNode, nodeCount),
+		indices:        make(map[NodeRef]NodeIndex, nodeCount),
+		// the best child and descendant links are persisted, but recompute them to be safe.
+		updatedConnections: false,
+	}
+	for i := range pr.nodes {
+		node := &pr.nodes[i]
+		if err := node.Deserialize(dr); err != nil {
+			return nil, fmt.Errorf("failed to decode node %d: %w", i, err)
+		}
+		index := pr.indexOffset + NodeIndex(i)
+		for _, rel := range []NodeIndex{node.TransitionParent, node.ForkchoiceParent} {
+			if rel != NONE && rel >= index {
+				return nil, fmt.Errorf("node %d refers to later node %d as parent", index, rel)
+			}
+		}
+		for _, rel := range []NodeIndex{node.BestChild, node.BestDescendant} {
+			if rel != NONE && (rel < pr.indexOffset || rel-pr.indexOffset >= NodeIndex(nodeCount)) {
+				return nil, fmt.Errorf("node %d refers to unknown node %d", index, rel)
+			}
+		}
+		pr.indices[node.Ref] = index
+	}
+	blockCount, err := dr.ReadUint64()
+	if err != nil {
+		return nil, err
+	}
+	if blockCount > dr.Scope()/(32+8) {
+		return nil, fmt.Errorf("block count %d too large for input", blockCount)
+	}
+	pr.blockSlots = make(map[Root]Slot, blockCount)
+	for i := uint64(0); i < blockCount; i++ {
+		var root Root
+		var slot Slot
+		if err := dr.FixedLenContainer(&root, &slot); err != nil {
+			return nil, err
+		}
+		pr.blockSlots[root] = slot
+	}
+	return pr, nil
+}
+
+const voteTrackerSize = 40 + 40 + 8 + 8
+
+func (v *VoteTracker) Serialize(w *codec.EncodingWriter) error {
+	return w.FixedLenContainer(&v.Current.Root, v.Current.Slot, &v.Next.Root, v.Next.Slot,
+		v.CurrentTargetEpoch, v.NextTargetEpoch)
+}
+
+func (v *VoteTracker) Deserialize(dr *codec.DecodingReader) error {
+	return dr.FixedLenContainer(&v.Current.Root, &v.Current.Slot, &v.Next.Root, &v.Next.Slot,
+		&v.CurrentTargetEpoch, &v.NextTargetEpoch)
+}
+
+func (v *VoteTracker) ByteLength() uint64 {
+	return voteTrackerSize
+}
+
+func (v *VoteTracker) FixedLength() uint64 {
+	return voteTrackerSize
+}
+
+// Serialize writes the votes, and if there are any vote changes that have not been applied yet.
+func (st *ProtoVoteStore) Serialize(w *codec.EncodingWriter) error {
+	changed := byte(0)
+	if st.changed {
+		changed = 1
+	}
+	if err := w.WriteByte(changed); err != nil {
+		return err
+	}
+	if err := w.WriteUint64(uint64(len(st.votes))); err != nil {
+		return err
+	}
+	for i := range st.votes {
+		if err := st.votes[i].Serialize(w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (st *ProtoVoteStore) ByteLength() uint64 {
+	return 1 + 8 + uint64(len(st.votes))*voteTrackerSize
+}
+
+func (st *ProtoVoteStore) FixedLength() uint64 {
+	return 0
+}
+
+// LoadProtoVoteStore restores a vote-store that was written with ProtoVoteStore.Serialize.
+func LoadProtoVoteStore(spec *common.Spec, dr *codec.DecodingReader) (*ProtoVoteStore, error) {
+	changed, err := dr.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	count, err := dr.ReadUint64()
+	if err != nil {
+		return nil, err
+	}
+	if count > dr.Scope()/voteTrackerSize {
+		return nil, fmt.Errorf("vote count %d too large for input", count)
+	}
+	st := &ProtoVoteStore{spec: spec, votes: make([]VoteTracker, count), changed: changed != 0}
+	for i := range st.votes {
+		if err := st.votes[i].Deserialize(dr); err != nil {
+			return nil, fmt.Errorf("failed to decode vote %d: %w", i, err)
+		}
+	}
+	return st, nil
+}
@@ -0,0 +1,68 @@
+package proto
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/protolambda/ztyp/codec"
+
+	"github.com/protolambda/zrnt/eth2/configs"
+	. "github.com/protolambda/zrnt/eth2/forkchoice"
+)
+
+func TestPersistForkChoice(t *testing.T) {
+	spec := configs.Mainnet
+	root := func(i byte) (out Root) {
+		out[0] = i
+		return
+	}
+	genesis := Checkpoint{Root: root(0), Epoch: 0}
+	fc, err := NewProtoForkChoice(spec, genesis, genesis, root(0), 0, Root{},
+		[]Gwei{spec.MAX_EFFECTIVE_BALANCE, spec.MAX_EFFECTIVE_BALANCE, spec.MAX_EFFECTIVE_BALANCE}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fc.ProcessBlock(root(0), root(1), 1, 0, 0)
+	fc.ProcessBlock(root(0), root(2), 2, 0, 0)
+	fc.ProcessPayloadBlock(root(1), root(3), 3, 0, 0, root(0xee), ExecutionOptimistic)
+	fc.ProcessAttestation(0, root(1), 1)
+	fc.ProcessAttestation(1, root(3), 3)
+	head, err := fc.Head()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// vote after computing the head, so there are pending vote changes to persist.
+	fc.ProcessAttestation(2, root(2), 2)
+
+	var buf bytes.Buffer
+	if err := fc.(*ProtoForkChoice).Serialize(codec.NewEncodingWriter(&buf)); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	loaded, err := LoadProtoForkChoice(spec, codec.NewDecodingReader(bytes.NewReader(data), uint64(len(data))), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var reBuf bytes.Buffer
+	if err := loaded.(*ProtoForkChoice).Serialize(codec.NewEncodingWriter(&reBuf)); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, reBuf.Bytes()) {
+		t.Fatal("serialized fork-choice changed after loading")
+	}
+	if status, ok := loaded.PayloadStatus(root(3)); !ok || status != ExecutionOptimistic {
+		t.Fatalf("expected optimistic block 3, got %s (known: %v)", status, ok)
+	}
+	for _, f := range []Forkchoice{fc, loaded} {
+		got, err := f.Head()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != head {
+			t.Fatalf("expected head %s, got %s", head, got)
+		}
+	}
+	if _, err := LoadProtoForkChoice(spec, codec.NewDecodingReader(bytes.NewReader(data[:len(data)-1]), uint64(len(data)-1)), nil); err == nil {
+		t.Fatal("expected error when loading truncated data")
+	}
+}
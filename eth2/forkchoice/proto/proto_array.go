@@ -28,6 +28,11 @@ type ProtoNode struct {
 	BestChild NodeIndex
 	// Relative to ForkchoiceParent relations
 	BestDescendant NodeIndex
+	// Block hash of the execution payload, zeroed if the block has no payload.
+	// Empty slot nodes copy the block hash of their block.
+	BlockHash Root
+	// Validation status of the execution payload. Empty slot nodes copy the status of their block.
+	ExecutionStatus ExecutionStatus
 }
 
 type NodeSinkFn func(ctx context.Context, ref NodeRef, canonical bool) error
@@ -364,6 +369,7 @@ func (pr *ProtoArray) ProcessSlot(parent Root, slot Slot, justifiedEpoch Epoch,
 			// No node to represent space between parent slot and new slot yet, so we add it.
 			nodeIndex = pr.indexOffset + NodeIndex(len(pr.nodes))
 			pr.indices[nodeRef] = nodeIndex
+			blockHash, status := pr.payloadOf(parentIndex)
 			pr.nodes = append(pr.nodes, ProtoNode{
 				Ref:              nodeRef,
 				TransitionParent: parentIndex,
@@ -374,6 +380,8 @@ func (pr *ProtoArray) ProcessSlot(parent Root, slot Slot, justifiedEpoch Epoch,
 				Weight:           0,
 				BestChild:        NONE,
 				BestDescendant:   NONE,
+				BlockHash:        blockHash,
+				ExecutionStatus:  status,
 			})
 			// remember the node as parent for the next
 			parentIndex = nodeIndex
@@ -382,6 +390,7 @@ func (pr *ProtoArray) ProcessSlot(parent Root, slot Slot, justifiedEpoch Epoch,
 	// Add the node for the slot
 	nodeIndex := pr.indexOffset + NodeIndex(len(pr.nodes))
 	pr.indices[nodeRef] = nodeIndex
+	blockHash, status := pr.payloadOf(parentIndex)
 	pr.nodes = append(pr.nodes, ProtoNode{
 		Ref:              nodeRef,
 		TransitionParent: parentIndex,
@@ -392,6 +401,8 @@ func (pr *ProtoArray) ProcessSlot(parent Root, slot Slot, justifiedEpoch Epoch,
 		Weight:           0,
 		BestChild:        NONE,
 		BestDescendant:   NONE,
+		BlockHash:        blockHash,
+		ExecutionStatus:  status,
 	})
 	// Connections are out of sync, i.e. array needs work before next find-head can return the proper head.
 	pr.updatedConnections = false
@@ -402,6 +413,14 @@ func (pr *ProtoArray) ProcessSlot(parent Root, slot Slot, justifiedEpoch Epoch,
 //
 // The parent root of the genesis block should be zeroed.
 func (pr *ProtoArray) ProcessBlock(parent Root, blockRoot Root, blockSlot Slot, justifiedEpoch Epoch, finalizedEpoch Epoch) (ok bool) {
+	return pr.ProcessPayloadBlock(parent, blockRoot, blockSlot, justifiedEpoch, finalizedEpoch, Root{}, ExecutionValid)
+}
+
+// Register a block with an execution payload, identified by its block hash, with the given execution status.
+// Blocks that build on an invalid parent are invalid, regardless of the given status.
+// A block with a valid payload marks all its ancestors as valid.
+func (pr *ProtoArray) ProcessPayloadBlock(parent Root, blockRoot Root, blockSlot Slot, justifiedEpoch Epoch, finalizedEpoch Epoch,
+	blockHash Root, status ExecutionStatus) (ok bool) {
 	blockRef := NodeRef{Root: blockRoot, Slot: blockSlot}
 	// If the block is already known, simply ignore it.
 	if _, ok := pr.indices[blockRef]; ok {
@@ -429,6 +448,14 @@ func (pr *ProtoArray) ProcessBlock(parent Root, blockRoot Root, blockSlot Slot,
 	if !ok {
 		panic("OnSlot failed to add node for block slot (transition parent)")
 	}
+	if _, parentStatus := pr.payloadOf(forkchoiceParentIndex); parentStatus == ExecutionInvalid {
+		status = ExecutionInvalid
+	}
+	// A valid node is added as optimistic first, for markValid to validate its optimistic ancestors too.
+	valid := status == ExecutionValid
+	if valid {
+		status = ExecutionOptimistic
+	}
 	nodeIndex := pr.indexOffset + NodeIndex(len(pr.nodes))
 	pr.blockSlots[blockRoot] = blockSlot
 	pr.indices[blockRef] = nodeIndex
@@ -442,7 +469,12 @@ func (pr *ProtoArray) ProcessBlock(parent Root, blockRoot Root, blockSlot Slot,
 		Weight:           0,
 		BestChild:        NONE,
 		BestDescendant:   NONE,
+		BlockHash:        blockHash,
+		ExecutionStatus:  status,
 	})
+	if valid {
+		pr.markValid(nodeIndex)
+	}
 	// Connections are out of sync, i.e. array needs work before next find-head can return the proper head.
 	pr.updatedConnections = false
 	return true
@@ -734,7 +766,23 @@ func (pr *ProtoArray) nodeLeadsToViableHead(node *ProtoNode) (bool, error) {
 // https://github.com/ethereum/eth2.0-specs/blob/v0.11.1/specs/phase0/fork-choice.md#filter_block_tree
 //
 // Any node that has a different finalized or justified epoch should not be viable for the head.
+// Nodes with an invalid execution payload are never viable for the head.
 func (pr *ProtoArray) isNodeViableForHead(node *ProtoNode) bool {
+	if node.ExecutionStatus == ExecutionInvalid {
+		return false
+	}
 	return (node.JustifiedEpoch == pr.justifiedEpoch || pr.justifiedEpoch == common.GENESIS_EPOCH) &&
 		(node.FinalizedEpoch == pr.finalizedEpoch || pr.finalizedEpoch == common.GENESIS_EPOCH)
 }
+
+func (pr *ProtoArray) Weight(ref NodeRef) (weight SignedGwei, ok bool) {
+	index, ok := pr.indices[ref]
+	if !ok {
+		return 0, false
+	}
+	node, err := pr.getNode(index)
+	if err != nil {
+		return 0, false
+	}
+	return node.Weight, true
+}
@@ -0,0 +1,47 @@
+package proto
+
+import (
+	"testing"
+
+	"github.com/protolambda/zrnt/eth2/configs"
+	. "github.com/protolambda/zrnt/eth2/forkchoice"
+)
+
+func TestSafeBlock(t *testing.T) {
+	spec := configs.Mainnet
+	root := func(i byte) (out Root) {
+		out[0] = i
+		return
+	}
+	bal := spec.MAX_EFFECTIVE_BALANCE
+	genesis := Checkpoint{Root: root(0), Epoch: 0}
+	fc, err := NewProtoForkChoice(spec, genesis, genesis, root(0), 0, Root{}, []Gwei{bal, bal, bal, bal}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fc.ProcessBlock(root(0), root(1), 1, 0, 0)
+	fc.ProcessBlock(root(1), root(2), 2, 0, 0)
+	fc.ProcessBlock(root(2), root(3), 3, 0, 0)
+	for i := ValidatorIndex(0); i < 3; i++ {
+		fc.ProcessAttestation(i, root(2), 2)
+	}
+	expectSafe := func(rule SafeBlockRule, currentSlot Slot, expected NodeRef) {
+		t.Helper()
+		safe, err := fc.SafeBlock(rule, currentSlot)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if safe != expected {
+			t.Fatalf("expected safe block %s, got %s", expected, safe)
+		}
+	}
+	expectSafe(SafeJustified, 4, NodeRef{Root: root(0), Slot: 0})
+	// No committee could have voted on block 1 yet during its own slot, even though the block has votes.
+	expectSafe(SafeConfirmed, 1, NodeRef{Root: root(0), Slot: 0})
+	// Only a few slots elapsed, 3 out of 4 validators is more than enough to confirm block 2, but not block 3.
+	expectSafe(SafeConfirmed, 4, NodeRef{Root: root(2), Slot: 2})
+	// After a full epoch, 3 out of 4 validators is not enough to confirm anything.
+	expectSafe(SafeConfirmed, spec.SLOTS_PER_EPOCH+2, NodeRef{Root: root(0), Slot: 0})
+	fc.ProcessAttestation(3, root(3), 3)
+	expectSafe(SafeConfirmed, spec.SLOTS_PER_EPOCH+2, NodeRef{Root: root(2), Slot: 2})
+}
@@ -0,0 +1,97 @@
+package forkchoice
+
+import "fmt"
+
+// SafeBlockRule determines which block is considered to be safe,
+// e.g. to report as safe block to the execution engine.
+type SafeBlockRule uint8
+
+const (
+	// SafeJustified considers the block of the justified checkpoint to be safe.
+	SafeJustified SafeBlockRule = iota
+	// SafeConfirmed considers the latest canonical block that satisfies the LMD confirmation rule to be safe,
+	// falling back to the justified checkpoint block.
+	SafeConfirmed
+)
+
+// CONFIRMATION_BYZANTINE_THRESHOLD is the assumed percentage of byzantine stake, used by the confirmation rule.
+const CONFIRMATION_BYZANTINE_THRESHOLD = 33
+
+func (fc *ProtoForkChoice) SafeBlock(rule SafeBlockRule, currentSlot Slot) (NodeRef, error) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	justifiedSlot, ok := fc.protoArray.GetSlot(fc.justified.Root)
+	if !ok {
+		return NodeRef{}, fmt.Errorf("justified block %s is unknown", fc.justified.Root)
+	}
+	safe := NodeRef{Root: fc.justified.Root, Slot: justifiedSlot}
+	switch rule {
+	case SafeJustified:
+		return safe, nil
+	case SafeConfirmed:
+	default:
+		return NodeRef{}, fmt.Errorf("unknown safe block rule: %d", rule)
+	}
+	if err := fc.updateVotesMaybe(); err != nil {
+		return NodeRef{}, err
+	}
+	// from head back to the start of the graph
+	chain, err := fc.protoArray.CanonicalChain(safe.Root, safe.Slot)
+	if err != nil {
+		return NodeRef{}, err
+	}
+	var totalBalance Gwei
+	for _, bal := range fc.balances {
+		totalBalance += bal
+	}
+	// Every block from the justified block up to the safe block must be confirmed.
+	for i := len(chain) - 1; i >= 0; i-- {
+		node := &chain[i]
+		// skip empty slots, and anything up to and including the justified block
+		if node.Root == node.ParentRoot || node.Slot <= justifiedSlot {
+			continue
+		}
+		support, ok := fc.protoArray.Weight(node.NodeRef)
+		if !ok {
+			return NodeRef{}, fmt.Errorf("canonical node %s is unknown", node.NodeRef)
+		}
+		parentSlot, ok := fc.protoArray.GetSlot(node.ParentRoot)
+		if !ok {
+			return NodeRef{}, fmt.Errorf("parent %s of canonical node %s is unknown", node.ParentRoot, node.NodeRef)
+		}
+		var maxSupport Gwei
+		if currentSlot > 0 {
+			maxSupport = fc.committeeWeightBetweenSlots(totalBalance, parentSlot+1, currentSlot-1)
+		}
+		if !isOneConfirmed(support, maxSupport) {
+			break
+		}
+		safe = node.NodeRef
+	}
+	return safe, nil
+}
+
+// Approximates the weight of the committees of the slots from start to end (inclusive),
+// assuming the total active balance is evenly spread over the slots of an epoch.
+func (fc *ProtoForkChoice) committeeWeightBetweenSlots(totalBalance Gwei, start Slot, end Slot) Gwei {
+	if end < start {
+		return 0
+	}
+	slots := uint64(end-start) + 1
+	if slots >= uint64(fc.spec.SLOTS_PER_EPOCH) {
+		return totalBalance
+	}
+	return totalBalance * Gwei(slots) / Gwei(fc.spec.SLOTS_PER_EPOCH)
+}
+
+// The LMD confirmation rule, without proposer boost:
+//
+//	support / maxSupport > 0.5 + CONFIRMATION_BYZANTINE_THRESHOLD / 100
+//
+// Without any committee weight since the block, e.g. for a block of the current slot, nothing is confirmed.
+func isOneConfirmed(support SignedGwei, maxSupport Gwei) bool {
+	if support <= 0 || maxSupport == 0 {
+		return false
+	}
+	return 100*uint64(support) > (50+CONFIRMATION_BYZANTINE_THRESHOLD)*uint64(maxSupport)
+}
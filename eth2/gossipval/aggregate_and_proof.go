@@ -18,10 +18,11 @@ type AggregatesValBackend interface {
 	SlotAfter
 	BadBlockValidator
 
-	// Checks if the aggregate attestation defined by aggRoot = hash_tree_root(aggregate) has been seen
+	// Checks if an aggregate attestation with the same data, dataRoot = hash_tree_root(aggregate.data),
+	// and a non-strict superset of the given aggregation bits has been seen
 	// (via aggregate gossip, within a verified block, or through the creation of an equivalent aggregate locally).
-	SeenAggregate(aggRoot common.Root) bool
-	MarkAggregate(aggRoot common.Root)
+	SeenAggregate(dataRoot common.Root, bits phase0.AttestationBits) bool
+	MarkAggregate(dataRoot common.Root, bits phase0.AttestationBits)
 
 	// Checks if an aggregate by the given aggregator for the given epoch has been seen before.
 	SeenAggregator(targetEpoch common.Epoch, aggregator common.ValidatorIndex) bool
@@ -31,12 +32,9 @@ type AggregatesValBackend interface {
 func ValidateAggregateAndProof(ctx context.Context, signedAgg *phase0.SignedAggregateAndProof,
 	aggVal AggregatesValBackend) ([]common.ValidatorIndex, GossipValidatorResult) {
 	spec := aggVal.Spec()
-	// [IGNORE] aggregate.data.slot is within the last ATTESTATION_PROPAGATION_SLOT_RANGE
-	// slots (with a MAXIMUM_GOSSIP_CLOCK_DISPARITY allowance) --
-	// i.e. aggregate.data.slot + ATTESTATION_PROPAGATION_SLOT_RANGE >= current_slot >= aggregate.data.slot
-	// overflow check
+	// [IGNORE] aggregate.data.slot is within the propagation window, see CheckAttestationSlotRange.
 	att := &signedAgg.Message.Aggregate
-	if err := CheckSlotSpan(aggVal.SlotAfter, att.Data.Slot, ATTESTATION_PROPAGATION_SLOT_RANGE); err != nil {
+	if err := CheckAttestationSlotRange(spec, aggVal.SlotAfter, att.Data.Slot); err != nil {
 		return nil, GossipValidatorResult{IGNORE, fmt.Errorf("aggregate attestation not within slot range: %v", err)}
 	}
 
@@ -53,11 +51,12 @@ func ValidateAggregateAndProof(ctx context.Context, signedAgg *phase0.SignedAggr
 		return nil, GossipValidatorResult{IGNORE, fmt.Errorf("already seen aggregate by %d for epoch %d", index, epoch)}
 	}
 
-	// [IGNORE] The valid aggregate attestation defined by hash_tree_root(aggregate) has not already been seen
+	// [IGNORE] A valid aggregate attestation defined by hash_tree_root(aggregate.data) whose aggregation_bits
+	// is a non-strict superset has not already been seen
 	// (via aggregate gossip, within a verified block, or through the creation of an equivalent aggregate locally).
-	aggRoot := att.HashTreeRoot(spec, tree.GetHashFn())
-	if aggVal.SeenAggregate(aggRoot) {
-		return nil, GossipValidatorResult{IGNORE, fmt.Errorf("attestation aggregate %s has already been seen", aggRoot)}
+	dataRoot := att.Data.HashTreeRoot(tree.GetHashFn())
+	if aggVal.SeenAggregate(dataRoot, att.AggregationBits) {
+		return nil, GossipValidatorResult{IGNORE, fmt.Errorf("attestation aggregate with data %s and bits %s is already covered", dataRoot, att.AggregationBits)}
 	}
 
 	// [REJECT] The attestation has participants --
@@ -66,10 +65,6 @@ func ValidateAggregateAndProof(ctx context.Context, signedAgg *phase0.SignedAggr
 		return nil, GossipValidatorResult{REJECT, fmt.Errorf("attestation has no participants")}
 	}
 
-	// [IGNORE] The block being voted for (aggregate.data.beacon_block_root) has been seen (via both gossip and non-gossip sources)
-	// (a client MAY queue aggregates for processing once block is retrieved).
-	// TODO
-
 	// [REJECT] The block being voted for (aggregate.data.beacon_block_root) passes validation.
 	if aggVal.IsBadBlock(att.Data.BeaconBlockRoot) {
 		return nil, GossipValidatorResult{REJECT, errors.New("aggregate voted for invalid block")}
@@ -77,6 +72,25 @@ func ValidateAggregateAndProof(ctx context.Context, signedAgg *phase0.SignedAggr
 
 	ch := aggVal.Chain()
 
+	// [IGNORE] The block being voted for (aggregate.data.beacon_block_root) has been seen (via both gossip and non-gossip sources)
+	// (a client MAY queue aggregates for processing once block is retrieved).
+	blockRef, ok := ch.ByBlock(att.Data.BeaconBlockRoot)
+	if !ok {
+		return nil, GossipValidatorResult{IGNORE, errors.New("aggregate voted for unknown block")}
+	}
+	if refSlot := blockRef.Step().Slot(); refSlot > att.Data.Slot {
+		return nil, GossipValidatorResult{REJECT, errors.New("aggregate voted for block in the future")}
+	}
+
+	// [REJECT] The aggregate attestation's target block is an ancestor of the block named in the LMD vote --
+	// i.e. get_ancestor(store, aggregate.data.beacon_block_root, compute_start_slot_at_epoch(aggregate.data.target.epoch))
+	//        == aggregate.data.target.root
+	if unknown, inSubtree := ch.InSubtree(att.Data.Target.Root, att.Data.BeaconBlockRoot); unknown {
+		return nil, GossipValidatorResult{IGNORE, errors.New("unknown block and/or target, cannot check if in subtree")}
+	} else if !inSubtree {
+		return nil, GossipValidatorResult{REJECT, errors.New("block not in subtree of target")}
+	}
+
 	// [REJECT] The current finalized_checkpoint is an ancestor of the block defined
 	// by aggregate.data.beacon_block_root --
 	// i.e. get_ancestor(store, attestation.data.beacon_block_root, compute_start_slot_at_epoch(store.finalized_checkpoint.epoch))
@@ -142,7 +156,7 @@ func ValidateAggregateAndProof(ctx context.Context, signedAgg *phase0.SignedAggr
 	if err != nil {
 		return nil, GossipValidatorResult{REJECT, fmt.Errorf("failed to deserialize aggregate signature: %v", err)}
 	}
-	if !blsu.Verify(blsPub, sigRoot[:2], sig) {
+	if !blsu.Verify(blsPub, sigRoot[:], sig) {
 		return nil, GossipValidatorResult{REJECT, errors.New("invalid aggregate signature")}
 	}
 
@@ -160,7 +174,7 @@ func ValidateAggregateAndProof(ctx context.Context, signedAgg *phase0.SignedAggr
 		return nil, GossipValidatorResult{REJECT, err}
 	}
 
-	aggVal.MarkAggregate(aggRoot)
+	aggVal.MarkAggregate(dataRoot, att.AggregationBits)
 	aggVal.MarkAggregator(att.Data.Target.Epoch, signedAgg.Message.AggregatorIndex)
 
 	return committee, GossipValidatorResult{ACCEPT, nil}
@@ -31,6 +31,32 @@ type AttestationValBackend interface {
 
 const catchupTimeout = time.Second * 2
 
+// CheckAttestationSlotRange checks if the attestation slot is within the propagation window,
+// with a MAXIMUM_GOSSIP_CLOCK_DISPARITY allowance. The rule is fork-aware, the current slot determines the fork.
+//
+// Before Deneb: the slot is within the last ATTESTATION_PROPAGATION_SLOT_RANGE slots --
+// i.e. attestation.data.slot + ATTESTATION_PROPAGATION_SLOT_RANGE >= current_slot >= attestation.data.slot
+//
+// From Deneb (EIP-7045): the slot is not in the future, and the epoch of the slot is the current or previous epoch --
+// i.e. attestation.data.slot <= current_slot and
+// compute_epoch_at_slot(attestation.data.slot) in (get_previous_epoch(state), get_current_epoch(state))
+func CheckAttestationSlotRange(spec *common.Spec, slotAfter func(delta time.Duration) common.Slot, slot common.Slot) error {
+	if currentEpoch := spec.SlotToEpoch(slotAfter(0)); currentEpoch < spec.DENEB_FORK_EPOCH {
+		return CheckSlotSpan(slotAfter, slot, ATTESTATION_PROPAGATION_SLOT_RANGE)
+	}
+	if maxSlot := slotAfter(MAXIMUM_GOSSIP_CLOCK_DISPARITY); slot > maxSlot {
+		return fmt.Errorf("slot %d is too new, maximum slot is %d", slot, maxSlot)
+	}
+	minEpoch := spec.SlotToEpoch(slotAfter(-MAXIMUM_GOSSIP_CLOCK_DISPARITY))
+	if minEpoch > 0 {
+		minEpoch -= 1
+	}
+	if epoch := spec.SlotToEpoch(slot); epoch < minEpoch {
+		return fmt.Errorf("slot %d of epoch %d is too old, minimum epoch is %d", slot, epoch, minEpoch)
+	}
+	return nil
+}
+
 func ValidateAttestation(ctx context.Context, subnet uint64, att *phase0.Attestation,
 	attVal AttestationValBackend) (comm []common.ValidatorIndex, res GossipValidatorResult) {
 	spec := attVal.Spec()
@@ -40,11 +66,8 @@ func ValidateAttestation(ctx context.Context, subnet uint64, att *phase0.Attesta
 		return nil, GossipValidatorResult{REJECT, fmt.Errorf("cannot get start slot of attestation target epoch %d: %w", att.Data.Target.Epoch, err)}
 	}
 
-	// [IGNORE] attestation.data.slot is within the last ATTESTATION_PROPAGATION_SLOT_RANGE slots
-	// (within a MAXIMUM_GOSSIP_CLOCK_DISPARITY allowance) --
-	// i.e. attestation.data.slot + ATTESTATION_PROPAGATION_SLOT_RANGE >= current_slot >= attestation.data.slot
-
-	if err := CheckSlotSpan(attVal.SlotAfter, att.Data.Slot, ATTESTATION_PROPAGATION_SLOT_RANGE); err != nil {
+	// [IGNORE] attestation.data.slot is within the propagation window, see CheckAttestationSlotRange.
+	if err := CheckAttestationSlotRange(spec, attVal.SlotAfter, att.Data.Slot); err != nil {
 		return nil, GossipValidatorResult{IGNORE, fmt.Errorf("individual attestation not within slot range: %v", err)}
 	}
 
@@ -101,7 +124,8 @@ func ValidateAttestation(ctx context.Context, subnet uint64, att *phase0.Attesta
 		return nil, GossipValidatorResult{REJECT, errors.New("cannot vote for finalized root as target")}
 	}
 
-	// TODO: additional validation of data.source?
+	// Note: data.source is not validated on gossip. The source is checked against the justified checkpoint
+	// when the attestation is processed, and is not needed to validate the LMD vote or the signature.
 
 	towardsCtx, cancel := context.WithTimeout(ctx, catchupTimeout)
 	defer cancel()
@@ -0,0 +1,77 @@
+package gossipval
+
+import (
+	"testing"
+	"time"
+
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/protolambda/zrnt/eth2/configs"
+)
+
+// testClock returns a SlotAfter function for the given time since genesis.
+func testClock(spec *common.Spec, sinceGenesis time.Duration) func(delta time.Duration) common.Slot {
+	return func(delta time.Duration) common.Slot {
+		since := sinceGenesis + delta
+		if since < 0 {
+			return 0
+		}
+		return common.Slot(since / (time.Duration(spec.SECONDS_PER_SLOT) * time.Second))
+	}
+}
+
+func TestCheckAttestationSlotRange(t *testing.T) {
+	spec := *configs.Mainnet
+	spec.DENEB_FORK_EPOCH = 5
+	// at returns the time since genesis at an offset into the slot.
+	at := func(slot common.Slot, offset time.Duration) time.Duration {
+		return time.Duration(slot)*time.Duration(spec.SECONDS_PER_SLOT)*time.Second + offset
+	}
+	testCases := []struct {
+		name  string
+		now   time.Duration
+		slot  common.Slot
+		valid bool
+	}{
+		// Before Deneb: within ATTESTATION_PROPAGATION_SLOT_RANGE slots
+		{"current slot", at(100, time.Second), 100, true},
+		{"next slot within disparity", at(100, 11600*time.Millisecond), 101, true},
+		{"next slot beyond disparity", at(100, 11400*time.Millisecond), 101, false},
+		{"oldest slot in range", at(100, time.Second), 68, true},
+		{"slot out of range", at(100, time.Second), 67, false},
+		{"slot out of range within disparity", at(100, 400*time.Millisecond), 67, true},
+		{"last slot before deneb, oldest slot", at(159, 11*time.Second), 127, true},
+		{"last slot before deneb, previous epoch out of range", at(159, 11*time.Second), 96, false},
+		{"genesis", at(0, 0), 0, true},
+		{"genesis, next slot", at(0, 0), 1, false},
+
+		// From Deneb: the current or previous epoch, and not in the future
+		{"deneb, first slot", at(160, time.Second), 160, true},
+		{"deneb, next slot within disparity", at(170, 11600*time.Millisecond), 171, true},
+		{"deneb, next slot beyond disparity", at(170, 11400*time.Millisecond), 171, false},
+		// EIP-7045: older than ATTESTATION_PROPAGATION_SLOT_RANGE slots, but in the previous epoch
+		{"deneb, start of previous epoch", at(191, time.Second), 128, true},
+		{"deneb, before previous epoch", at(191, time.Second), 127, false},
+		{"deneb, previous epoch moves at epoch boundary", at(192, time.Second), 128, false},
+		{"deneb, previous epoch within disparity", at(192, 400*time.Millisecond), 128, true},
+		// The clock is in Deneb, and with the disparity the epoch before the fork is still the current epoch.
+		{"deneb fork boundary within disparity", at(160, 200*time.Millisecond), 96, true},
+		{"deneb fork boundary beyond disparity", at(160, time.Second), 96, false},
+	}
+	for _, c := range testCases {
+		err := CheckAttestationSlotRange(&spec, testClock(&spec, c.now), c.slot)
+		if c.valid && err != nil {
+			t.Errorf("%s: expected slot %d to be valid: %v", c.name, c.slot, err)
+		} else if !c.valid && err == nil {
+			t.Errorf("%s: expected slot %d to be invalid", c.name, c.slot)
+		}
+	}
+
+	// With Deneb at genesis, the previous epoch of the genesis epoch is the genesis epoch.
+	spec.DENEB_FORK_EPOCH = 0
+	if err := CheckAttestationSlotRange(&spec, testClock(&spec, at(5, 0)), 0); err != nil {
+		t.Errorf("expected genesis slot to be valid in deneb genesis epoch: %v", err)
+	}
+	if err := CheckAttestationSlotRange(&spec, testClock(&spec, at(5, 0)), 6); err == nil {
+		t.Error("expected future slot to be invalid in deneb genesis epoch")
+	}
+}
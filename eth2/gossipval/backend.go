@@ -0,0 +1,124 @@
+package gossipval
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/protolambda/zrnt/eth2/beacon"
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/protolambda/zrnt/eth2/beacon/deneb"
+)
+
+// ChainValBackend combines a chain with a SeenCache, and implements all gossip validation backends.
+type ChainValBackend struct {
+	*SeenCache
+	spec  *common.Spec
+	chain beacon.Chain
+
+	// Now returns the current time. Defaults to time.Now, can be replaced for testing.
+	Now func() time.Time
+
+	// BlobVerifier implements verify_blob_kzg_proof. Blob sidecars are rejected if it is not set.
+	BlobVerifier func(blob deneb.Blob, commitment common.KZGCommitment, proof common.KZGProof) error
+
+	localUpdatesLock      sync.RWMutex
+	localFinalityUpdate   *common.LightClientUpdateEnvelope
+	localOptimisticUpdate *common.LightClientUpdateEnvelope
+}
+
+var (
+	_ BeaconBlockValBackend                 = (*ChainValBackend)(nil)
+	_ BlobSidecarValBackend                 = (*ChainValBackend)(nil)
+	_ AttestationValBackend                 = (*ChainValBackend)(nil)
+	_ AggregatesValBackend                  = (*ChainValBackend)(nil)
+	_ VoluntaryExitValBackend               = (*ChainValBackend)(nil)
+	_ ProposerSlashingValBackend            = (*ChainValBackend)(nil)
+	_ AttesterSlashingValBackend            = (*ChainValBackend)(nil)
+	_ SyncCommitteeSubnetValBackend         = (*ChainValBackend)(nil)
+	_ SyncContribAndProofValBackend         = (*ChainValBackend)(nil)
+	_ BLSToExecutionChangeValBackend        = (*ChainValBackend)(nil)
+	_ LightClientFinalityUpdateValBackend   = (*ChainValBackend)(nil)
+	_ LightClientOptimisticUpdateValBackend = (*ChainValBackend)(nil)
+)
+
+func NewChainValBackend(spec *common.Spec, chain beacon.Chain) *ChainValBackend {
+	return &ChainValBackend{
+		SeenCache: NewSeenCache(spec),
+		spec:      spec,
+		chain:     chain,
+		Now:       time.Now,
+	}
+}
+
+func (b *ChainValBackend) Spec() *common.Spec {
+	return b.spec
+}
+
+func (b *ChainValBackend) Chain() beacon.Chain {
+	return b.chain
+}
+
+func (b *ChainValBackend) SlotAfter(delta time.Duration) common.Slot {
+	genesis := time.Unix(int64(b.chain.Genesis().Time), 0)
+	since := b.Now().Add(delta).Sub(genesis)
+	if since < 0 {
+		return 0
+	}
+	return common.Slot(since / (time.Duration(b.spec.SECONDS_PER_SLOT) * time.Second))
+}
+
+func (b *ChainValBackend) GenesisValidatorsRoot() common.Root {
+	return b.chain.Genesis().ValidatorsRoot
+}
+
+func (b *ChainValBackend) GetDomain(typ common.BLSDomainType, epoch common.Epoch) (common.BLSDomain, error) {
+	slot, err := b.spec.EpochStartSlot(epoch)
+	if err != nil {
+		return common.BLSDomain{}, err
+	}
+	return common.ComputeDomain(typ, b.spec.ForkVersion(slot), b.GenesisValidatorsRoot()), nil
+}
+
+func (b *ChainValBackend) HeadInfo(ctx context.Context) (beacon.ChainEntry, *common.EpochsContext, common.BeaconState, error) {
+	return RetrieveHeadInfo(ctx, b.chain)
+}
+
+func (b *ChainValBackend) VerifyBlobKZGProof(blob deneb.Blob, commitment common.KZGCommitment, proof common.KZGProof) error {
+	if b.BlobVerifier == nil {
+		return errors.New("no blob verifier available")
+	}
+	return b.BlobVerifier(blob, commitment, proof)
+}
+
+// SetLocalFinalityUpdate sets the locally computed finality update, to compare gossip against.
+func (b *ChainValBackend) SetLocalFinalityUpdate(update *common.LightClientUpdateEnvelope) {
+	b.localUpdatesLock.Lock()
+	defer b.localUpdatesLock.Unlock()
+	b.localFinalityUpdate = update
+}
+
+func (b *ChainValBackend) LocalFinalityUpdate() *common.LightClientUpdateEnvelope {
+	b.localUpdatesLock.RLock()
+	defer b.localUpdatesLock.RUnlock()
+	return b.localFinalityUpdate
+}
+
+// SetLocalOptimisticUpdate sets the locally computed optimistic update, to compare gossip against.
+func (b *ChainValBackend) SetLocalOptimisticUpdate(update *common.LightClientUpdateEnvelope) {
+	b.localUpdatesLock.Lock()
+	defer b.localUpdatesLock.Unlock()
+	b.localOptimisticUpdate = update
+}
+
+func (b *ChainValBackend) LocalOptimisticUpdate() *common.LightClientUpdateEnvelope {
+	b.localUpdatesLock.RLock()
+	defer b.localUpdatesLock.RUnlock()
+	return b.localOptimisticUpdate
+}
+
+// PruneSeen prunes the seen-cache, based on the current slot and the finalized checkpoint of the chain.
+func (b *ChainValBackend) PruneSeen() {
+	b.SeenCache.Prune(b.spec.SlotToEpoch(b.SlotAfter(0)), b.chain.FinalizedCheckpoint().Epoch)
+}
@@ -0,0 +1,120 @@
+package gossipval
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/protolambda/zrnt/eth2/beacon"
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/protolambda/zrnt/eth2/configs"
+)
+
+type testChainEntry struct {
+	beacon.ChainEntry
+	slot common.Slot
+	epc  *common.EpochsContext
+}
+
+func (e *testChainEntry) Step() common.Step {
+	return common.AsStep(e.slot, true)
+}
+
+func (e *testChainEntry) EpochsContext(ctx context.Context) (*common.EpochsContext, error) {
+	return e.epc, nil
+}
+
+// testChain implements the parts of the chain that the validators use, other methods panic.
+type testChain struct {
+	beacon.Chain
+	genesis   beacon.GenesisInfo
+	finalized common.Checkpoint
+	blocks    map[common.Root]*testChainEntry
+	// Blocks that are in the subtree of the finalized block. Blocks that are not in here are unknown.
+	finalizedSubtree map[common.Root]bool
+}
+
+func (c *testChain) ByBlock(root common.Root) (beacon.ChainEntry, bool) {
+	e, ok := c.blocks[root]
+	if !ok {
+		return nil, false
+	}
+	return e, true
+}
+
+func (c *testChain) InSubtree(anchor common.Root, root common.Root) (unknown bool, inSubtree bool) {
+	if anchor != c.finalized.Root {
+		return true, false
+	}
+	inSubtree, ok := c.finalizedSubtree[root]
+	return !ok, inSubtree
+}
+
+func (c *testChain) Genesis() beacon.GenesisInfo {
+	return c.genesis
+}
+
+func (c *testChain) FinalizedCheckpoint() common.Checkpoint {
+	return c.finalized
+}
+
+func TestChainValBackendSlotAfter(t *testing.T) {
+	spec := configs.Mainnet
+	genesis := time.Unix(1606824023, 0)
+	chain := &testChain{genesis: beacon.GenesisInfo{Time: common.Timestamp(genesis.Unix())}}
+	b := NewChainValBackend(spec, chain)
+	slotDuration := time.Duration(spec.SECONDS_PER_SLOT) * time.Second
+
+	testCases := []struct {
+		name     string
+		now      time.Time
+		delta    time.Duration
+		expected common.Slot
+	}{
+		{"before genesis", genesis.Add(-time.Hour), 0, 0},
+		{"just before genesis", genesis.Add(-time.Millisecond), 0, 0},
+		{"genesis", genesis, 0, 0},
+		{"end of slot", genesis.Add(slotDuration - time.Nanosecond), 0, 0},
+		{"next slot", genesis.Add(slotDuration), 0, 1},
+		{"disparity into next slot", genesis.Add(slotDuration - 100*time.Millisecond), 500 * time.Millisecond, 1},
+		{"disparity into previous slot", genesis.Add(slotDuration + 100*time.Millisecond), -500 * time.Millisecond, 0},
+		{"later", genesis.Add(100*slotDuration + time.Second), 0, 100},
+	}
+	for _, c := range testCases {
+		now := c.now
+		b.Now = func() time.Time { return now }
+		if got := b.SlotAfter(c.delta); got != c.expected {
+			t.Errorf("%s: expected slot %d, got %d", c.name, c.expected, got)
+		}
+	}
+}
+
+func TestChainValBackendPruneSeen(t *testing.T) {
+	spec := configs.Mainnet
+	genesis := time.Unix(1606824023, 0)
+	chain := &testChain{genesis: beacon.GenesisInfo{Time: common.Timestamp(genesis.Unix())}}
+	b := NewChainValBackend(spec, chain)
+	epochDuration := time.Duration(uint64(spec.SLOTS_PER_EPOCH)*uint64(spec.SECONDS_PER_SLOT)) * time.Second
+
+	b.Now = func() time.Time { return genesis.Add(3 * epochDuration) }
+	b.PruneSeen()
+	b.MarkBadBlock(common.Root{1})
+	b.MarkAttestation(2, 1)
+	b.MarkAttestation(3, 1)
+
+	// The current epoch is taken from the clock, the finalized epoch from the chain.
+	b.Now = func() time.Time { return genesis.Add(4 * epochDuration) }
+	chain.finalized = common.Checkpoint{Epoch: 3}
+	b.PruneSeen()
+	if b.SeenAttestation(2, 1) || !b.SeenAttestation(3, 1) {
+		t.Error("expected only attestations before epoch 3 to be pruned")
+	}
+	if !b.IsBadBlock(common.Root{1}) {
+		t.Error("bad block is pruned when the epoch it was marked in is finalized")
+	}
+	chain.finalized = common.Checkpoint{Epoch: 4}
+	b.PruneSeen()
+	if b.IsBadBlock(common.Root{1}) {
+		t.Error("bad block is not pruned after finalization")
+	}
+}
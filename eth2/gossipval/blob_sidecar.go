@@ -0,0 +1,150 @@
+package gossipval
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	blsu "github.com/protolambda/bls12-381-util"
+	"github.com/protolambda/ztyp/tree"
+
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/protolambda/zrnt/eth2/beacon/deneb"
+)
+
+type BlobSidecarValBackend interface {
+	Spec
+	SlotAfter
+	Chain
+	GenesisValidatorsRoot
+
+	// Checks if the (block root, blob index) pair was seen, does not do any tracking.
+	SeenBlobSidecar(blockRoot common.Root, index uint64) bool
+
+	// When the blob sidecar is fully validated, the combination can be marked as seen
+	// to avoid future duplicate sidecars from being propagated.
+	MarkBlobSidecar(blockRoot common.Root, index uint64)
+
+	// VerifyBlobKZGProof checks that the blob matches the commitment, with the given proof.
+	// I.e. verify_blob_kzg_proof(blob, commitment, proof).
+	VerifyBlobKZGProof(blob deneb.Blob, commitment common.KZGCommitment, proof common.KZGProof) error
+}
+
+// ComputeSubnetForBlobSidecar returns the subnet a blob sidecar with the given index is propagated on.
+func ComputeSubnetForBlobSidecar(spec *common.Spec, index uint64) uint64 {
+	return index % uint64(spec.BLOB_SIDECAR_SUBNET_COUNT)
+}
+
+func ValidateBlobSidecar(ctx context.Context, subnet uint64, sidecar *deneb.BlobSidecar,
+	blobVal BlobSidecarValBackend) GossipValidatorResult {
+	spec := blobVal.Spec()
+	header := &sidecar.SignedBlockHeader.Message
+	index := uint64(sidecar.Index)
+
+	// [REJECT] The sidecar's index is consistent with MAX_BLOBS_PER_BLOCK -- i.e. blob_sidecar.index < MAX_BLOBS_PER_BLOCK.
+	if index >= uint64(spec.MAX_BLOBS_PER_BLOCK) {
+		return GossipValidatorResult{REJECT, fmt.Errorf("blob index %d is too large", index)}
+	}
+	// [REJECT] The sidecar is for the correct subnet -- i.e. compute_subnet_for_blob_sidecar(blob_sidecar.index) == subnet_id.
+	if expected := ComputeSubnetForBlobSidecar(spec, index); expected != subnet {
+		return GossipValidatorResult{REJECT, fmt.Errorf("blob index %d belongs to subnet %d, not %d", index, expected, subnet)}
+	}
+	// [IGNORE] The sidecar is not from a future slot (with a MAXIMUM_GOSSIP_CLOCK_DISPARITY allowance) --
+	// i.e. validate that block_header.slot <= current_slot
+	if maxSlot := blobVal.SlotAfter(MAXIMUM_GOSSIP_CLOCK_DISPARITY); maxSlot < header.Slot {
+		return GossipValidatorResult{IGNORE, fmt.Errorf("blob sidecar slot %d is later than max slot %d", header.Slot, maxSlot)}
+	}
+	ch := blobVal.Chain()
+	// [IGNORE] The sidecar is from a slot greater than the latest finalized slot --
+	// i.e. validate that block_header.slot > compute_start_slot_at_epoch(state.finalized_checkpoint.epoch)
+	fin := ch.FinalizedCheckpoint()
+	if finSlot, _ := spec.EpochStartSlot(fin.Epoch); header.Slot <= finSlot {
+		return GossipValidatorResult{IGNORE, fmt.Errorf("blob sidecar slot %d is not after finalized slot %d", header.Slot, finSlot)}
+	}
+
+	blockRoot := sidecar.BlockRoot()
+	// [IGNORE] The sidecar is the first sidecar for the tuple (block_header.slot, block_header.proposer_index, blob_sidecar.index)
+	// with valid header signature, sidecar inclusion proof, and kzg proof.
+	// The block root commits to both the slot and the proposer index.
+	if blobVal.SeenBlobSidecar(blockRoot, index) {
+		return GossipValidatorResult{IGNORE, fmt.Errorf("already seen blob sidecar %d of block %s", index, blockRoot)}
+	}
+
+	// [IGNORE] The sidecar's block's parent (defined by block_header.parent_root) has been seen
+	// (via both gossip and non-gossip sources)
+	parentRef, ok := ch.ByBlock(header.ParentRoot)
+	if !ok {
+		return GossipValidatorResult{IGNORE, fmt.Errorf("blob sidecar has unavailable parent block %s", header.ParentRoot)}
+	}
+	// [REJECT] The sidecar's block's parent (defined by block_header.parent_root) passes validation.
+	// *implicit*: parent was already processed and put into forkchoice view, so it passes validation.
+
+	// [REJECT] The sidecar is from a higher slot than the sidecar's block's parent (defined by block_header.parent_root).
+	if refSlot := parentRef.Step().Slot(); refSlot >= header.Slot {
+		return GossipValidatorResult{REJECT, fmt.Errorf("blob sidecar slot %d not after parent %d (%s)", header.Slot, refSlot, header.ParentRoot)}
+	}
+	// [REJECT] The current finalized_checkpoint is an ancestor of the sidecar's block --
+	// i.e. get_checkpoint_block(store, block_header.parent_root, store.finalized_checkpoint.epoch) == store.finalized_checkpoint.root
+	if unknown, inSubtree := ch.InSubtree(fin.Root, header.ParentRoot); unknown {
+		return GossipValidatorResult{IGNORE, fmt.Errorf("failed to determine if parent block %s is in subtree of finalized block %s", header.ParentRoot, fin.Root)}
+	} else if !inSubtree {
+		return GossipValidatorResult{REJECT, fmt.Errorf("parent block %s is not in subtree of finalized root %s", header.ParentRoot, fin.Root)}
+	}
+
+	// [REJECT] The sidecar's inclusion proof is valid as verified by verify_blob_sidecar_inclusion_proof(blob_sidecar).
+	if err := sidecar.VerifyInclusionProof(spec); err != nil {
+		return GossipValidatorResult{REJECT, err}
+	}
+	// [REJECT] The sidecar's blob is valid as verified by
+	// verify_blob_kzg_proof(blob_sidecar.blob, blob_sidecar.kzg_commitment, blob_sidecar.kzg_proof).
+	if err := blobVal.VerifyBlobKZGProof(sidecar.Blob, sidecar.KZGCommitment, sidecar.KZGProof); err != nil {
+		return GossipValidatorResult{REJECT, fmt.Errorf("invalid blob KZG proof: %w", err)}
+	}
+
+	parentEpc, err := parentRef.EpochsContext(ctx)
+	if err != nil {
+		return GossipValidatorResult{IGNORE, fmt.Errorf("cannot find context for parent block %s", header.ParentRoot)}
+	}
+	// [REJECT] The proposer signature of blob_sidecar.signed_block_header, is valid with respect to the block_header.proposer_index pubkey.
+	pub, ok := parentEpc.ValidatorPubkeyCache.Pubkey(header.ProposerIndex)
+	if !ok {
+		return GossipValidatorResult{IGNORE, fmt.Errorf("cannot find pubkey for proposer index %d", header.ProposerIndex)}
+	}
+	// Use untrusted proposer index, we validate this later, after signature check.
+	if err := verifyBlockHeaderSignature(spec, blobVal.GenesisValidatorsRoot(), &sidecar.SignedBlockHeader, pub); err != nil {
+		return GossipValidatorResult{REJECT, err}
+	}
+
+	blobVal.MarkBlobSidecar(blockRoot, index)
+
+	// [REJECT] The sidecar is proposed by the expected proposer_index for the block's slot in the context of
+	// the current shuffling (defined by block_header.parent_root/block_header.slot).
+	proposer, res := expectedProposer(ctx, spec, ch, header.ParentRoot, parentRef, parentEpc, header.Slot)
+	if res.Err != nil {
+		return res
+	}
+	if proposer != header.ProposerIndex {
+		return GossipValidatorResult{REJECT, fmt.Errorf("expected proposer %d, but blob sidecar was proposed by %d", proposer, header.ProposerIndex)}
+	}
+
+	return GossipValidatorResult{ACCEPT, nil}
+}
+
+func verifyBlockHeaderSignature(spec *common.Spec, genesisValidatorsRoot common.Root,
+	signed *common.SignedBeaconBlockHeader, cachedPub *common.CachedPubkey) error {
+	pub, err := cachedPub.Pubkey()
+	if err != nil {
+		return fmt.Errorf("invalid proposer pubkey: %v", err)
+	}
+	sig, err := signed.Signature.Signature()
+	if err != nil {
+		return fmt.Errorf("failed to deserialize block header signature: %v", err)
+	}
+	version := spec.ForkVersion(signed.Message.Slot)
+	dom := common.ComputeDomain(common.DOMAIN_BEACON_PROPOSER, version, genesisValidatorsRoot)
+	signingRoot := common.ComputeSigningRoot(signed.Message.HashTreeRoot(tree.GetHashFn()), dom)
+	if !blsu.Verify(pub, signingRoot[:], sig) {
+		return errors.New("invalid block header signature")
+	}
+	return nil
+}
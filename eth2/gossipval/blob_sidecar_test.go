@@ -0,0 +1,188 @@
+package gossipval
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"testing"
+
+	blsu "github.com/protolambda/bls12-381-util"
+	"github.com/protolambda/ztyp/tree"
+
+	"github.com/protolambda/zrnt/eth2/beacon"
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/protolambda/zrnt/eth2/beacon/deneb"
+	"github.com/protolambda/zrnt/eth2/beacon/phase0"
+	"github.com/protolambda/zrnt/eth2/configs"
+)
+
+type blobTestBackend struct {
+	*testBackend
+	chain          *testChain
+	genesisValRoot common.Root
+	kzgErr         error
+}
+
+func (b *blobTestBackend) Chain() beacon.Chain {
+	return b.chain
+}
+
+func (b *blobTestBackend) GenesisValidatorsRoot() common.Root {
+	return b.genesisValRoot
+}
+
+func (b *blobTestBackend) VerifyBlobKZGProof(blob deneb.Blob, commitment common.KZGCommitment, proof common.KZGProof) error {
+	return b.kzgErr
+}
+
+func TestComputeSubnetForBlobSidecar(t *testing.T) {
+	spec := *configs.Mainnet
+	for index, expected := range []uint64{0, 1, 2, 3, 4, 5} {
+		if got := ComputeSubnetForBlobSidecar(&spec, uint64(index)); got != expected {
+			t.Errorf("index %d: expected subnet %d, got %d", index, expected, got)
+		}
+	}
+	spec.BLOB_SIDECAR_SUBNET_COUNT = 4
+	for index, expected := range []uint64{0, 1, 2, 3, 0, 1} {
+		if got := ComputeSubnetForBlobSidecar(&spec, uint64(index)); got != expected {
+			t.Errorf("index %d of 4 subnets: expected subnet %d, got %d", index, expected, got)
+		}
+	}
+}
+
+func TestValidateBlobSidecar(t *testing.T) {
+	spec := *configs.Minimal
+	spec.ALTAIR_FORK_EPOCH = 0
+	spec.BELLATRIX_FORK_EPOCH = 0
+	spec.CAPELLA_FORK_EPOCH = 0
+	spec.DENEB_FORK_EPOCH = 0
+	keys := make([]blsu.SecretKey, 64)
+	validators := make([]phase0.KickstartValidatorData, len(keys))
+	for i := range keys {
+		var raw [32]byte
+		big.NewInt(int64(i + 1)).FillBytes(raw[:])
+		if err := keys[i].Deserialize(&raw); err != nil {
+			t.Fatal(err)
+		}
+		pub, err := blsu.SkToPk(&keys[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		validators[i] = phase0.KickstartValidatorData{Pubkey: pub.Serialize(), Balance: spec.MAX_EFFECTIVE_BALANCE}
+	}
+	state, epc, err := phase0.KickStartState(&spec, common.Root{0x42}, 1000, validators)
+	if err != nil {
+		t.Fatal(err)
+	}
+	genesisValRoot, err := state.GenesisValidatorsRoot()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const slot = common.Slot(3)
+	parentRoot := common.Root{0xaa}
+	proposer, err := epc.GetBeaconProposer(slot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherProposer := (proposer + 1) % common.ValidatorIndex(len(keys))
+
+	// sidecar creates the sidecar of the blob with the given index, of a block signed by the given proposer.
+	sidecar := func(index uint64, proposer common.ValidatorIndex, signer *blsu.SecretKey) *deneb.BlobSidecar {
+		block := &deneb.SignedBeaconBlock{Message: deneb.BeaconBlock{Slot: slot, ProposerIndex: proposer, ParentRoot: parentRoot}}
+		block.Message.Body.SyncAggregate.SyncCommitteeBits = make([]byte, spec.SYNC_COMMITTEE_SIZE/8)
+		blobs := make([]deneb.Blob, index+1)
+		proofs := make([]common.KZGProof, index+1)
+		for i := range blobs {
+			block.Message.Body.BlobKZGCommitments = append(block.Message.Body.BlobKZGCommitments, common.KZGCommitment{0xc0, byte(i)})
+			blobs[i] = make(deneb.Blob, uint64(spec.FIELD_ELEMENTS_PER_BLOB)*deneb.BYTES_PER_FIELD_ELEMENT)
+		}
+		dom := common.ComputeDomain(common.DOMAIN_BEACON_PROPOSER, spec.ForkVersion(slot), genesisValRoot)
+		root := common.ComputeSigningRoot(block.Message.Header(&spec).HashTreeRoot(tree.GetHashFn()), dom)
+		block.Signature = blsu.Sign(signer, root[:]).Serialize()
+		sidecars, err := deneb.BlobSidecars(&spec, block, blobs, proofs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return sidecars[index]
+	}
+	valid := func() *deneb.BlobSidecar {
+		return sidecar(1, proposer, &keys[proposer])
+	}
+
+	testCases := []struct {
+		name    string
+		subnet  uint64
+		sidecar *deneb.BlobSidecar
+		// modifies the default backend: clock at the sidecar slot, parent in the previous slot,
+		// and finalized genesis.
+		setup  func(b *blobTestBackend)
+		expect GossipValidatorCode
+	}{
+		{name: "valid", subnet: 1, sidecar: valid(), expect: ACCEPT},
+		{name: "last index", subnet: 5, sidecar: sidecar(5, proposer, &keys[proposer]), expect: ACCEPT},
+		{name: "index too large", subnet: 0, sidecar: sidecar(uint64(spec.MAX_BLOBS_PER_BLOCK), proposer, &keys[proposer]), expect: REJECT},
+		{name: "wrong subnet", subnet: 2, sidecar: valid(), expect: REJECT},
+		{name: "future slot", subnet: 1, sidecar: valid(), setup: func(b *blobTestBackend) { b.slot = slot - 1 }, expect: IGNORE},
+		{name: "finalized slot", subnet: 1, sidecar: valid(), setup: func(b *blobTestBackend) {
+			b.chain.finalized.Epoch = 1
+		}, expect: IGNORE},
+		{name: "already seen", subnet: 1, sidecar: valid(), setup: func(b *blobTestBackend) {
+			b.MarkBlobSidecar(valid().BlockRoot(), 1)
+		}, expect: IGNORE},
+		{name: "other index seen", subnet: 1, sidecar: valid(), setup: func(b *blobTestBackend) {
+			b.MarkBlobSidecar(valid().BlockRoot(), 0)
+		}, expect: ACCEPT},
+		{name: "unknown parent", subnet: 1, sidecar: valid(), setup: func(b *blobTestBackend) {
+			delete(b.chain.blocks, parentRoot)
+		}, expect: IGNORE},
+		{name: "parent not before sidecar", subnet: 1, sidecar: valid(), setup: func(b *blobTestBackend) {
+			b.chain.blocks[parentRoot].slot = slot
+		}, expect: REJECT},
+		{name: "parent not descending from finalized", subnet: 1, sidecar: valid(), setup: func(b *blobTestBackend) {
+			b.chain.finalizedSubtree[parentRoot] = false
+		}, expect: REJECT},
+		{name: "parent subtree unknown", subnet: 1, sidecar: valid(), setup: func(b *blobTestBackend) {
+			delete(b.chain.finalizedSubtree, parentRoot)
+		}, expect: IGNORE},
+		{name: "invalid inclusion proof", subnet: 1, sidecar: func() *deneb.BlobSidecar {
+			s := valid()
+			s.KZGCommitment[1] ^= 0xff
+			return s
+		}(), expect: REJECT},
+		{name: "invalid KZG proof", subnet: 1, sidecar: valid(), setup: func(b *blobTestBackend) {
+			b.kzgErr = errors.New("bad proof")
+		}, expect: REJECT},
+		{name: "invalid signature", subnet: 1, sidecar: sidecar(1, proposer, &keys[otherProposer]), expect: REJECT},
+		{name: "unknown proposer", subnet: 1, sidecar: sidecar(1, 1000, &keys[proposer]), expect: IGNORE},
+		{name: "wrong proposer", subnet: 1, sidecar: sidecar(1, otherProposer, &keys[otherProposer]), expect: REJECT},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			backend := &blobTestBackend{
+				testBackend: newTestBackend(&spec, slot, epc, state),
+				chain: &testChain{
+					blocks:           map[common.Root]*testChainEntry{parentRoot: {slot: slot - 1, epc: epc}},
+					finalizedSubtree: map[common.Root]bool{parentRoot: true},
+				},
+				genesisValRoot: genesisValRoot,
+			}
+			if testCase.setup != nil {
+				testCase.setup(backend)
+			}
+			res := ValidateBlobSidecar(context.Background(), testCase.subnet, testCase.sidecar, backend)
+			if res.Result != testCase.expect {
+				t.Fatalf("expected %s, got %s: %v", testCase.expect, res.Result, res.Err)
+			}
+			blockRoot, index := testCase.sidecar.BlockRoot(), uint64(testCase.sidecar.Index)
+			if res.Result == ACCEPT {
+				if !backend.SeenBlobSidecar(blockRoot, index) {
+					t.Error("accepted sidecar is not marked as seen")
+				}
+				if again := ValidateBlobSidecar(context.Background(), testCase.subnet, testCase.sidecar, backend); again.Result != IGNORE {
+					t.Errorf("expected duplicate to be ignored, got %s", again.Result)
+				}
+			}
+		})
+	}
+}
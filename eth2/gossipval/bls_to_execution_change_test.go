@@ -0,0 +1,98 @@
+package gossipval
+
+import (
+	"context"
+	"math/big"
+	"testing"
+
+	blsu "github.com/protolambda/bls12-381-util"
+	"github.com/protolambda/ztyp/tree"
+
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/protolambda/zrnt/eth2/beacon/phase0"
+	"github.com/protolambda/zrnt/eth2/configs"
+	"github.com/protolambda/zrnt/eth2/util/hashing"
+)
+
+func TestValidateBLSToExecutionChange(t *testing.T) {
+	spec := *configs.Minimal
+	spec.CAPELLA_FORK_EPOCH = 1
+	keys := make([]blsu.SecretKey, 64)
+	validators := make([]phase0.KickstartValidatorData, len(keys))
+	for i := range keys {
+		var raw [32]byte
+		big.NewInt(int64(i + 1)).FillBytes(raw[:])
+		if err := keys[i].Deserialize(&raw); err != nil {
+			t.Fatal(err)
+		}
+		pub, err := blsu.SkToPk(&keys[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		pubkey := common.BLSPubkey(pub.Serialize())
+		creds := hashing.Hash(pubkey[:])
+		creds[0] = common.BLS_WITHDRAWAL_PREFIX
+		validators[i] = phase0.KickstartValidatorData{Pubkey: pubkey, WithdrawalCredentials: creds, Balance: spec.MAX_EFFECTIVE_BALANCE}
+	}
+	state, epc, err := phase0.KickStartState(&spec, common.Root{0x42}, 1000, validators)
+	if err != nil {
+		t.Fatal(err)
+	}
+	genesisValRoot, err := state.GenesisValidatorsRoot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	change := func(index common.ValidatorIndex, signer *blsu.SecretKey) *common.SignedBLSToExecutionChange {
+		ch := common.BLSToExecutionChange{
+			ValidatorIndex:     index,
+			FromBLSPubKey:      validators[index].Pubkey,
+			ToExecutionAddress: common.Eth1Address{0xee},
+		}
+		// Signed with the genesis fork version, regardless of the current fork.
+		domain := common.ComputeDomain(common.DOMAIN_BLS_TO_EXECUTION_CHANGE, spec.GENESIS_FORK_VERSION, genesisValRoot)
+		root := common.ComputeSigningRoot(ch.HashTreeRoot(tree.GetHashFn()), domain)
+		return &common.SignedBLSToExecutionChange{BLSToExecutionChange: ch, Signature: blsu.Sign(signer, root[:]).Serialize()}
+	}
+	capellaSlot, _ := spec.EpochStartSlot(spec.CAPELLA_FORK_EPOCH)
+
+	testCases := []struct {
+		name   string
+		slot   common.Slot
+		seen   []common.ValidatorIndex
+		change *common.SignedBLSToExecutionChange
+		expect GossipValidatorCode
+	}{
+		{name: "valid", slot: capellaSlot, change: change(1, &keys[1]), expect: ACCEPT},
+		{name: "before capella", slot: capellaSlot - 1, change: change(1, &keys[1]), expect: IGNORE},
+		{name: "already seen", slot: capellaSlot, seen: []common.ValidatorIndex{1}, change: change(1, &keys[1]), expect: IGNORE},
+		{name: "other validator seen", slot: capellaSlot, seen: []common.ValidatorIndex{2}, change: change(1, &keys[1]), expect: ACCEPT},
+		{name: "bad signature", slot: capellaSlot, change: change(1, &keys[2]), expect: REJECT},
+		{name: "unknown validator", slot: capellaSlot, change: change(1, &keys[1]), expect: REJECT},
+	}
+	// The change of an unknown validator is otherwise valid.
+	testCases[len(testCases)-1].change.BLSToExecutionChange.ValidatorIndex = 100
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			backend := newTestBackend(&spec, testCase.slot, epc, state)
+			for _, index := range testCase.seen {
+				backend.MarkBLSToExecutionChange(index)
+			}
+			res := ValidateBLSToExecutionChange(context.Background(), testCase.change, backend)
+			if res.Result != testCase.expect {
+				t.Fatalf("expected %s, got %s: %v", testCase.expect, res.Result, res.Err)
+			}
+			index := testCase.change.BLSToExecutionChange.ValidatorIndex
+			if res.Result == ACCEPT && !backend.SeenBLSToExecutionChange(index) {
+				t.Error("accepted change is not marked as seen")
+			}
+			if res.Result == REJECT && backend.SeenBLSToExecutionChange(index) {
+				t.Error("rejected change is marked as seen")
+			}
+			if res.Result == ACCEPT {
+				if again := ValidateBLSToExecutionChange(context.Background(), testCase.change, backend); again.Result != IGNORE {
+					t.Errorf("expected duplicate to be ignored, got %s", again.Result)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,215 @@
+package gossipval
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/golang/snappy"
+	"github.com/protolambda/ztyp/codec"
+
+	"github.com/protolambda/zrnt/eth2/beacon"
+	"github.com/protolambda/zrnt/eth2/beacon/altair"
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/protolambda/zrnt/eth2/beacon/deneb"
+	"github.com/protolambda/zrnt/eth2/beacon/phase0"
+)
+
+const MessageIDLength = 20
+
+type MessageID [MessageIDLength]byte
+
+// MaxCompressedLen is the maximum snappy-compressed length of a payload of n bytes, i.e. max_compressed_len(n).
+func MaxCompressedLen(n uint64) uint64 {
+	return 32 + n + n/6
+}
+
+// decompressPayload decompresses the raw snappy block of a gossip message, within the GOSSIP_MAX_SIZE limit.
+func decompressPayload(spec *common.Spec, data []byte) ([]byte, error) {
+	maxSize := uint64(spec.GOSSIP_MAX_SIZE)
+	if uint64(len(data)) > MaxCompressedLen(maxSize) {
+		return nil, fmt.Errorf("compressed payload of %d bytes is too large", len(data))
+	}
+	size, err := snappy.DecodedLen(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid snappy payload: %w", err)
+	}
+	if uint64(size) > maxSize {
+		return nil, fmt.Errorf("decompressed payload of %d bytes exceeds limit of %d", size, maxSize)
+	}
+	out, err := snappy.Decode(nil, data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid snappy payload: %w", err)
+	}
+	return out, nil
+}
+
+// ComputeMessageID computes the gossipsub message-id of a message on the given topic.
+// Messages on phase0 topics do not commit to the topic, messages of Altair and later do.
+func ComputeMessageID(dec *beacon.ForkDecoder, topic string, data []byte) MessageID {
+	spec := dec.Spec
+	h := sha256.New()
+	payload, err := decompressPayload(spec, data)
+	if err != nil {
+		h.Write(spec.MESSAGE_DOMAIN_INVALID_SNAPPY[:])
+		payload = data
+	} else {
+		h.Write(spec.MESSAGE_DOMAIN_VALID_SNAPPY[:])
+	}
+	// The topic is always included, unless it is a genesis-fork topic.
+	if t, err := ParseTopic(topic); err != nil || t.ForkDigest != dec.Genesis {
+		var length [8]byte
+		binary.LittleEndian.PutUint64(length[:], uint64(len(topic)))
+		h.Write(length[:])
+		h.Write([]byte(topic))
+	}
+	h.Write(payload)
+	var out MessageID
+	copy(out[:], h.Sum(nil))
+	return out
+}
+
+// GossipMessage is a decoded gossip message.
+type GossipMessage struct {
+	Topic *GossipTopic
+	// The decoded message, e.g. *phase0.Attestation.
+	// Blocks and light-client updates are decoded into the type of the fork of the topic.
+	Object interface{}
+	// The input for the matching gossipval validation function:
+	// a *common.BeaconBlockEnvelope for blocks, a *common.LightClientUpdateEnvelope for light-client updates,
+	// and the same as Object for all other topics.
+	Input interface{}
+}
+
+// DecodeGossipMessage decompresses and decodes the payload of a gossip message.
+// The fork digest of the topic must be known, and the topic must be active in the fork.
+func DecodeGossipMessage(dec *beacon.ForkDecoder, topic string, data []byte) (*GossipMessage, error) {
+	spec := dec.Spec
+	t, err := ParseTopic(topic)
+	if err != nil {
+		return nil, err
+	}
+	if err := t.CheckSubnet(spec); err != nil {
+		return nil, err
+	}
+	forkIndex, err := forkIndexOf(dec, t.ForkDigest)
+	if err != nil {
+		return nil, err
+	}
+	if minFork := topicMinFork(t.Name); forkIndex < minFork {
+		return nil, fmt.Errorf("topic %s is not active in fork of digest %s", t.Name, t.ForkDigest)
+	}
+	payload, err := decompressPayload(spec, data)
+	if err != nil {
+		return nil, err
+	}
+	out := &GossipMessage{Topic: t}
+	switch t.Name {
+	case BeaconBlockTopic:
+		alloc, err := dec.BlockAllocator(t.ForkDigest)
+		if err != nil {
+			return nil, err
+		}
+		block := alloc()
+		if err := decodeSSZ(spec, payload, block); err != nil {
+			return nil, err
+		}
+		out.Object = block
+		out.Input = block.Envelope(spec, t.ForkDigest)
+		return out, nil
+	case LightClientFinalityUpdateTopic, LightClientOptimisticUpdateTopic:
+		var alloc func() beacon.OpaqueLightClientUpdate
+		if t.Name == LightClientFinalityUpdateTopic {
+			alloc, err = dec.LightClientFinalityUpdateAllocator(t.ForkDigest)
+		} else {
+			alloc, err = dec.LightClientOptimisticUpdateAllocator(t.ForkDigest)
+		}
+		if err != nil {
+			return nil, err
+		}
+		update := alloc()
+		if err := decodeSSZ(spec, payload, update); err != nil {
+			return nil, err
+		}
+		out.Object = update
+		out.Input = update.Envelope(spec)
+		return out, nil
+	case BeaconAggregateAndProofTopic:
+		out.Object = new(phase0.SignedAggregateAndProof)
+	case BeaconAttestationTopicPrefix:
+		out.Object = new(phase0.Attestation)
+	case VoluntaryExitTopic:
+		out.Object = new(phase0.SignedVoluntaryExit)
+	case ProposerSlashingTopic:
+		out.Object = new(phase0.ProposerSlashing)
+	case AttesterSlashingTopic:
+		out.Object = new(phase0.AttesterSlashing)
+	case SyncCommitteeContributionTopic:
+		out.Object = new(altair.SignedContributionAndProof)
+	case SyncCommitteeTopicPrefix:
+		out.Object = new(altair.SyncCommitteeMessage)
+	case BLSToExecutionChangeTopic:
+		out.Object = new(common.SignedBLSToExecutionChange)
+	case BlobSidecarTopicPrefix:
+		out.Object = new(deneb.BlobSidecar)
+	default:
+		return nil, fmt.Errorf("unsupported topic %s", t.Name)
+	}
+	if err := decodeSSZ(spec, payload, out.Object); err != nil {
+		return nil, err
+	}
+	out.Input = out.Object
+	return out, nil
+}
+
+func decodeSSZ(spec *common.Spec, data []byte, dst interface{}) error {
+	dr := codec.NewDecodingReader(bytes.NewReader(data), uint64(len(data)))
+	var err error
+	// Fixed-size types would not consume any trailing bytes, the length must match exactly.
+	var fixed uint64
+	switch x := dst.(type) {
+	case common.SpecObj:
+		fixed = x.FixedLength(spec)
+		err = x.Deserialize(spec, dr)
+	case codec.Deserializable:
+		if fl, ok := x.(codec.FixedLength); ok {
+			fixed = fl.FixedLength()
+		}
+		err = x.Deserialize(dr)
+	default:
+		return fmt.Errorf("cannot decode %T", dst)
+	}
+	if err == nil && fixed != 0 && uint64(len(data)) != fixed {
+		err = fmt.Errorf("payload of %d bytes does not match length %d", len(data), fixed)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to decode %T: %w", dst, err)
+	}
+	return nil
+}
+
+// forkIndexOf returns the index of the fork of the digest: 0 for phase0, 1 for altair, etc.
+func forkIndexOf(dec *beacon.ForkDecoder, digest common.ForkDigest) (int, error) {
+	for i, d := range []common.ForkDigest{dec.Genesis, dec.Altair, dec.Bellatrix, dec.Capella, dec.Deneb} {
+		if d == digest {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("unrecognized fork digest: %s", digest)
+}
+
+// topicMinFork returns the index of the first fork that has the topic.
+func topicMinFork(name string) int {
+	switch name {
+	case SyncCommitteeContributionTopic, SyncCommitteeTopicPrefix,
+		LightClientFinalityUpdateTopic, LightClientOptimisticUpdateTopic:
+		return 1
+	case BLSToExecutionChangeTopic:
+		return 3
+	case BlobSidecarTopicPrefix:
+		return 4
+	default:
+		return 0
+	}
+}
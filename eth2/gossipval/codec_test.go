@@ -0,0 +1,159 @@
+package gossipval
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"strings"
+	"testing"
+
+	"github.com/golang/snappy"
+	"github.com/protolambda/ztyp/codec"
+
+	"github.com/protolambda/zrnt/eth2/beacon"
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/protolambda/zrnt/eth2/beacon/phase0"
+	"github.com/protolambda/zrnt/eth2/configs"
+)
+
+// testMessageID computes the message-id as specified: the phase0 variant without topic,
+// or the altair variant with the length-prefixed topic.
+func testMessageID(domain common.NetworkMessageDomain, topic string, payload []byte) MessageID {
+	var input []byte
+	input = append(input, domain[:]...)
+	if topic != "" {
+		var length [8]byte
+		binary.LittleEndian.PutUint64(length[:], uint64(len(topic)))
+		input = append(input, length[:]...)
+		input = append(input, topic...)
+	}
+	input = append(input, payload...)
+	h := sha256.Sum256(input)
+	var out MessageID
+	copy(out[:], h[:MessageIDLength])
+	return out
+}
+
+func TestComputeMessageID(t *testing.T) {
+	spec := configs.Mainnet
+	dec := beacon.NewForkDecoder(spec, common.Root{0x42})
+	payload := []byte("hello gossip")
+	compressed := snappy.Encode(nil, payload)
+	invalid := []byte{0xff, 0xff, 0xff, 0xff, 0x0f, 0x00}
+	genesisTopic := FormatTopic(dec.Genesis, BeaconBlockTopic)
+	altairTopic := FormatTopic(dec.Altair, BeaconBlockTopic)
+
+	cases := []struct {
+		name     string
+		topic    string
+		data     []byte
+		expected MessageID
+	}{
+		{"phase0 valid snappy", genesisTopic, compressed, testMessageID(spec.MESSAGE_DOMAIN_VALID_SNAPPY, "", payload)},
+		{"phase0 invalid snappy", genesisTopic, invalid, testMessageID(spec.MESSAGE_DOMAIN_INVALID_SNAPPY, "", invalid)},
+		{"altair valid snappy", altairTopic, compressed, testMessageID(spec.MESSAGE_DOMAIN_VALID_SNAPPY, altairTopic, payload)},
+		{"altair invalid snappy", altairTopic, invalid, testMessageID(spec.MESSAGE_DOMAIN_INVALID_SNAPPY, altairTopic, invalid)},
+		{"unknown topic", "/foo/bar", compressed, testMessageID(spec.MESSAGE_DOMAIN_VALID_SNAPPY, "/foo/bar", payload)},
+	}
+	seen := make(map[MessageID]string)
+	for _, c := range cases {
+		id := ComputeMessageID(dec, c.topic, c.data)
+		if id != c.expected {
+			t.Errorf("%s: expected message-id %x, got %x", c.name, c.expected, id)
+		}
+		if other, ok := seen[id]; ok {
+			t.Errorf("%s: same message-id as %s", c.name, other)
+		}
+		seen[id] = c.name
+	}
+
+	// Payloads that decompress beyond GOSSIP_MAX_SIZE are hashed as invalid snappy.
+	small := *spec
+	small.GOSSIP_MAX_SIZE = 8
+	smallDec := beacon.NewForkDecoder(&small, common.Root{0x42})
+	if id, expected := ComputeMessageID(smallDec, genesisTopic, compressed),
+		testMessageID(spec.MESSAGE_DOMAIN_INVALID_SNAPPY, "", compressed); id != expected {
+		t.Errorf("oversize: expected message-id %x, got %x", expected, id)
+	}
+}
+
+func encodeGossip(t *testing.T, spec *common.Spec, obj interface{}) []byte {
+	var buf bytes.Buffer
+	w := codec.NewEncodingWriter(&buf)
+	var err error
+	switch x := obj.(type) {
+	case common.SpecObj:
+		err = x.Serialize(spec, w)
+	case codec.Serializable:
+		err = x.Serialize(w)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return snappy.Encode(nil, buf.Bytes())
+}
+
+func TestDecodeGossipMessage(t *testing.T) {
+	spec := configs.Mainnet
+	dec := beacon.NewForkDecoder(spec, common.Root{0x42})
+	exit := &phase0.SignedVoluntaryExit{Message: phase0.VoluntaryExit{Epoch: 3, ValidatorIndex: 7}, Signature: common.BLSSignature{0xc0}}
+	exitData := encodeGossip(t, spec, exit)
+	block := &phase0.SignedBeaconBlock{Message: phase0.BeaconBlock{Slot: 5, ProposerIndex: 2}}
+	blockData := encodeGossip(t, spec, block)
+
+	msg, err := DecodeGossipMessage(dec, FormatTopic(dec.Genesis, VoluntaryExitTopic), exitData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, ok := msg.Object.(*phase0.SignedVoluntaryExit); !ok || *got != *exit || msg.Input != msg.Object {
+		t.Errorf("unexpected voluntary exit %v", msg.Object)
+	}
+
+	msg, err = DecodeGossipMessage(dec, FormatTopic(dec.Genesis, BeaconBlockTopic), blockData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, ok := msg.Object.(*phase0.SignedBeaconBlock); !ok || got.Message.Slot != 5 {
+		t.Errorf("unexpected block %v", msg.Object)
+	}
+	if benv, ok := msg.Input.(*common.BeaconBlockEnvelope); !ok || benv.ForkDigest != dec.Genesis || benv.ProposerIndex != 2 {
+		t.Errorf("unexpected block envelope %v", msg.Input)
+	}
+
+	small := *spec
+	small.GOSSIP_MAX_SIZE = 100
+	smallDec := beacon.NewForkDecoder(&small, common.Root{0x42})
+	cases := []struct {
+		name  string
+		dec   *beacon.ForkDecoder
+		topic string
+		data  []byte
+		err   string
+	}{
+		{"invalid topic", dec, "/eth2/beacon_block", exitData, "not an eth2 topic"},
+		{"subnet out of range", dec, FormatTopic(dec.Genesis, BeaconAttestationTopicPrefix+"64"), exitData, "out of range"},
+		{"unknown fork digest", dec, FormatTopic(common.ForkDigest{1, 2, 3, 4}, VoluntaryExitTopic), exitData, "unrecognized fork digest"},
+		{"topic before its fork", dec, FormatTopic(dec.Genesis, SyncCommitteeContributionTopic), exitData, "not active"},
+		{"bls change before capella", dec, FormatTopic(dec.Bellatrix, BLSToExecutionChangeTopic), exitData, "not active"},
+		{"invalid snappy", dec, FormatTopic(dec.Genesis, VoluntaryExitTopic), []byte{0x05, 0xff}, "invalid snappy"},
+		{"wrong type", dec, FormatTopic(dec.Genesis, ProposerSlashingTopic), exitData, "failed to decode"},
+		{"trailing bytes", dec, FormatTopic(dec.Genesis, VoluntaryExitTopic), snappy.Encode(nil, append(snappyDecode(t, exitData), 0)), "failed to decode"},
+		{"compressed too large", smallDec, FormatTopic(dec.Genesis, VoluntaryExitTopic),
+			make([]byte, MaxCompressedLen(100)+1), "too large"},
+		{"decompressed too large", smallDec, FormatTopic(dec.Genesis, VoluntaryExitTopic),
+			snappy.Encode(nil, make([]byte, 101)), "exceeds limit"},
+	}
+	for _, c := range cases {
+		if _, err := DecodeGossipMessage(c.dec, c.topic, c.data); err == nil || !strings.Contains(err.Error(), c.err) {
+			t.Errorf("%s: expected error containing %q, got: %v", c.name, c.err, err)
+		}
+	}
+}
+
+func snappyDecode(t *testing.T, data []byte) []byte {
+	out, err := snappy.Decode(nil, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
@@ -0,0 +1,110 @@
+package gossipval
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+)
+
+type LightClientFinalityUpdateValBackend interface {
+	Spec
+	SlotAfter
+
+	// The previously forwarded finality update with the highest finalized slot, nil if none was forwarded yet.
+	LastForwardedFinalityUpdate() *common.LightClientUpdateEnvelope
+	// Marks the finality update as forwarded.
+	MarkFinalityUpdate(update *common.LightClientUpdateEnvelope)
+	// The locally computed finality update, as defined in create_light_client_finality_update. Nil if not available.
+	LocalFinalityUpdate() *common.LightClientUpdateEnvelope
+}
+
+func ValidateLightClientFinalityUpdate(update *common.LightClientUpdateEnvelope,
+	updateVal LightClientFinalityUpdateValBackend) GossipValidatorResult {
+	spec := updateVal.Spec()
+	// [IGNORE] The finalized_header.beacon.slot is greater than that of all previously forwarded finality_updates,
+	// or it matches the highest previously forwarded slot and also has a sync_aggregate indicating supermajority
+	// (> 2/3) sync committee participation while the previously forwarded finality_update for that slot
+	// did not indicate supermajority.
+	if prev := updateVal.LastForwardedFinalityUpdate(); prev != nil {
+		if update.FinalizedSlot < prev.FinalizedSlot {
+			return GossipValidatorResult{IGNORE, fmt.Errorf("finalized slot %d is older than previously forwarded %d",
+				update.FinalizedSlot, prev.FinalizedSlot)}
+		}
+		if update.FinalizedSlot == prev.FinalizedSlot && (prev.HasSupermajority(spec) || !update.HasSupermajority(spec)) {
+			return GossipValidatorResult{IGNORE, fmt.Errorf("already forwarded finality update for finalized slot %d", update.FinalizedSlot)}
+		}
+	}
+	// [IGNORE] The finality_update is received after the block at signature_slot was given enough time
+	// to propagate through the network.
+	if err := checkLightClientUpdateTiming(spec, updateVal.SlotAfter, update.SignatureSlot); err != nil {
+		return GossipValidatorResult{IGNORE, err}
+	}
+	// [IGNORE] The received finality_update matches the locally computed one exactly
+	// (as defined in create_light_client_finality_update).
+	local := updateVal.LocalFinalityUpdate()
+	if local == nil {
+		return GossipValidatorResult{IGNORE, fmt.Errorf("no local finality update available")}
+	}
+	if local.UpdateRoot != update.UpdateRoot {
+		return GossipValidatorResult{IGNORE, fmt.Errorf("finality update %s does not match local update %s",
+			update.UpdateRoot, local.UpdateRoot)}
+	}
+
+	updateVal.MarkFinalityUpdate(update)
+
+	return GossipValidatorResult{ACCEPT, nil}
+}
+
+type LightClientOptimisticUpdateValBackend interface {
+	Spec
+	SlotAfter
+
+	// The previously forwarded optimistic update with the highest attested slot, nil if none was forwarded yet.
+	LastForwardedOptimisticUpdate() *common.LightClientUpdateEnvelope
+	// Marks the optimistic update as forwarded.
+	MarkOptimisticUpdate(update *common.LightClientUpdateEnvelope)
+	// The locally computed optimistic update, as defined in create_light_client_optimistic_update. Nil if not available.
+	LocalOptimisticUpdate() *common.LightClientUpdateEnvelope
+}
+
+func ValidateLightClientOptimisticUpdate(update *common.LightClientUpdateEnvelope,
+	updateVal LightClientOptimisticUpdateValBackend) GossipValidatorResult {
+	spec := updateVal.Spec()
+	// [IGNORE] The attested_header.beacon.slot is greater than that of all previously forwarded optimistic_updates
+	if prev := updateVal.LastForwardedOptimisticUpdate(); prev != nil && update.AttestedSlot <= prev.AttestedSlot {
+		return GossipValidatorResult{IGNORE, fmt.Errorf("attested slot %d is not newer than previously forwarded %d",
+			update.AttestedSlot, prev.AttestedSlot)}
+	}
+	// [IGNORE] The optimistic_update is received after the block at signature_slot was given enough time
+	// to propagate through the network.
+	if err := checkLightClientUpdateTiming(spec, updateVal.SlotAfter, update.SignatureSlot); err != nil {
+		return GossipValidatorResult{IGNORE, err}
+	}
+	// [IGNORE] The received optimistic_update matches the locally computed one exactly
+	// (as defined in create_light_client_optimistic_update).
+	local := updateVal.LocalOptimisticUpdate()
+	if local == nil {
+		return GossipValidatorResult{IGNORE, fmt.Errorf("no local optimistic update available")}
+	}
+	if local.UpdateRoot != update.UpdateRoot {
+		return GossipValidatorResult{IGNORE, fmt.Errorf("optimistic update %s does not match local update %s",
+			update.UpdateRoot, local.UpdateRoot)}
+	}
+
+	updateVal.MarkOptimisticUpdate(update)
+
+	return GossipValidatorResult{ACCEPT, nil}
+}
+
+// checkLightClientUpdateTiming checks that one-third of the signature slot has transpired
+// (SECONDS_PER_SLOT / INTERVALS_PER_SLOT seconds after the start of the slot),
+// with a MAXIMUM_GOSSIP_CLOCK_DISPARITY allowance.
+func checkLightClientUpdateTiming(spec *common.Spec, slotAfter func(delta time.Duration) common.Slot, signatureSlot common.Slot) error {
+	interval := time.Duration(spec.SECONDS_PER_SLOT) * time.Second / common.INTERVALS_PER_SLOT
+	// The slot of the time one interval ago, must be at least the signature slot.
+	if slot := slotAfter(MAXIMUM_GOSSIP_CLOCK_DISPARITY - interval); slot < signatureSlot {
+		return fmt.Errorf("received update too early, signature slot %d has not progressed one interval yet", signatureSlot)
+	}
+	return nil
+}
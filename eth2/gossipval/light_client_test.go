@@ -0,0 +1,154 @@
+package gossipval
+
+import (
+	"testing"
+	"time"
+
+	"github.com/protolambda/zrnt/eth2/beacon"
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/protolambda/zrnt/eth2/configs"
+)
+
+func TestValidateLightClientFinalityUpdate(t *testing.T) {
+	spec := configs.Mainnet
+	genesis := time.Unix(1606824023, 0)
+	slotDuration := time.Duration(spec.SECONDS_PER_SLOT) * time.Second
+	const signatureSlot = common.Slot(101)
+	// One third of the signature slot has passed.
+	onTime := genesis.Add(time.Duration(signatureSlot)*slotDuration + slotDuration/3)
+
+	// supermajority is more than 2/3 of the 512 sync committee members
+	update := func(finalizedSlot common.Slot, participants uint64) *common.LightClientUpdateEnvelope {
+		return &common.LightClientUpdateEnvelope{
+			AttestedSlot:     100,
+			FinalizedSlot:    finalizedSlot,
+			SignatureSlot:    signatureSlot,
+			SyncParticipants: participants,
+			UpdateRoot:       common.Root{byte(finalizedSlot), byte(participants >> 8), byte(participants)},
+		}
+	}
+	testCases := []struct {
+		name   string
+		now    time.Time
+		prev   *common.LightClientUpdateEnvelope
+		update *common.LightClientUpdateEnvelope
+		// defaults to the update itself
+		local   *common.LightClientUpdateEnvelope
+		noLocal bool
+		expect  GossipValidatorCode
+	}{
+		{name: "first update", now: onTime, update: update(64, 400), expect: ACCEPT},
+		{name: "newer finalized slot", now: onTime, prev: update(32, 512), update: update(64, 300), expect: ACCEPT},
+		{name: "older finalized slot", now: onTime, prev: update(96, 300), update: update(64, 512), expect: IGNORE},
+		{name: "same finalized slot, gains supermajority", now: onTime, prev: update(64, 341), update: update(64, 342), expect: ACCEPT},
+		{name: "same finalized slot, both supermajority", now: onTime, prev: update(64, 342), update: update(64, 512), expect: IGNORE},
+		{name: "same finalized slot, neither supermajority", now: onTime, prev: update(64, 300), update: update(64, 341), expect: IGNORE},
+		{name: "same finalized slot, loses supermajority", now: onTime, prev: update(64, 400), update: update(64, 300), expect: IGNORE},
+		{name: "one third of slot within disparity", now: onTime.Add(-MAXIMUM_GOSSIP_CLOCK_DISPARITY), update: update(64, 400), expect: ACCEPT},
+		{name: "too early in slot", now: onTime.Add(-MAXIMUM_GOSSIP_CLOCK_DISPARITY - time.Millisecond), update: update(64, 400), expect: IGNORE},
+		{name: "start of signature slot", now: onTime.Add(-slotDuration / 3), update: update(64, 400), expect: IGNORE},
+		{name: "later slot", now: onTime.Add(10 * slotDuration), update: update(64, 400), expect: ACCEPT},
+		{name: "no local update", now: onTime, update: update(64, 400), noLocal: true, expect: IGNORE},
+		{name: "different local update", now: onTime, update: update(64, 400), local: update(64, 401), expect: IGNORE},
+	}
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			b := NewChainValBackend(spec, &testChain{genesis: beacon.GenesisInfo{Time: common.Timestamp(genesis.Unix())}})
+			now := c.now
+			b.Now = func() time.Time { return now }
+			if c.prev != nil {
+				b.MarkFinalityUpdate(c.prev)
+			}
+			local := c.update
+			if c.local != nil {
+				local = c.local
+			}
+			if !c.noLocal {
+				b.SetLocalFinalityUpdate(local)
+			}
+			res := ValidateLightClientFinalityUpdate(c.update, b)
+			if res.Result != c.expect {
+				t.Fatalf("expected %s, got %s: %v", c.expect, res.Result, res.Err)
+			}
+			forwarded := b.LastForwardedFinalityUpdate()
+			if c.expect == ACCEPT {
+				if forwarded != c.update {
+					t.Fatal("accepted update is not marked as forwarded")
+				}
+				if again := ValidateLightClientFinalityUpdate(c.update, b); again.Result != IGNORE {
+					t.Errorf("expected duplicate to be ignored, got %s", again.Result)
+				}
+			} else if forwarded != c.prev {
+				t.Error("ignored update is marked as forwarded")
+			}
+		})
+	}
+}
+
+func TestValidateLightClientOptimisticUpdate(t *testing.T) {
+	spec := configs.Mainnet
+	genesis := time.Unix(1606824023, 0)
+	slotDuration := time.Duration(spec.SECONDS_PER_SLOT) * time.Second
+	const signatureSlot = common.Slot(101)
+	onTime := genesis.Add(time.Duration(signatureSlot)*slotDuration + slotDuration/3)
+
+	update := func(attestedSlot common.Slot) *common.LightClientUpdateEnvelope {
+		return &common.LightClientUpdateEnvelope{
+			AttestedSlot:     attestedSlot,
+			SignatureSlot:    signatureSlot,
+			SyncParticipants: 400,
+			UpdateRoot:       common.Root{byte(attestedSlot)},
+		}
+	}
+	testCases := []struct {
+		name    string
+		now     time.Time
+		prev    *common.LightClientUpdateEnvelope
+		update  *common.LightClientUpdateEnvelope
+		local   *common.LightClientUpdateEnvelope
+		noLocal bool
+		expect  GossipValidatorCode
+	}{
+		{name: "first update", now: onTime, update: update(100), expect: ACCEPT},
+		{name: "newer attested slot", now: onTime, prev: update(99), update: update(100), expect: ACCEPT},
+		{name: "same attested slot", now: onTime, prev: update(100), update: update(100), expect: IGNORE},
+		{name: "older attested slot", now: onTime, prev: update(100), update: update(99), expect: IGNORE},
+		{name: "one third of slot within disparity", now: onTime.Add(-MAXIMUM_GOSSIP_CLOCK_DISPARITY), update: update(100), expect: ACCEPT},
+		{name: "too early in slot", now: onTime.Add(-MAXIMUM_GOSSIP_CLOCK_DISPARITY - time.Millisecond), update: update(100), expect: IGNORE},
+		{name: "before signature slot", now: onTime.Add(-slotDuration), update: update(100), expect: IGNORE},
+		{name: "no local update", now: onTime, update: update(100), noLocal: true, expect: IGNORE},
+		{name: "different local update", now: onTime, update: update(100), local: update(98), expect: IGNORE},
+	}
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			b := NewChainValBackend(spec, &testChain{genesis: beacon.GenesisInfo{Time: common.Timestamp(genesis.Unix())}})
+			now := c.now
+			b.Now = func() time.Time { return now }
+			if c.prev != nil {
+				b.MarkOptimisticUpdate(c.prev)
+			}
+			local := c.update
+			if c.local != nil {
+				local = c.local
+			}
+			if !c.noLocal {
+				b.SetLocalOptimisticUpdate(local)
+			}
+			res := ValidateLightClientOptimisticUpdate(c.update, b)
+			if res.Result != c.expect {
+				t.Fatalf("expected %s, got %s: %v", c.expect, res.Result, res.Err)
+			}
+			forwarded := b.LastForwardedOptimisticUpdate()
+			if c.expect == ACCEPT {
+				if forwarded != c.update {
+					t.Fatal("accepted update is not marked as forwarded")
+				}
+				if again := ValidateLightClientOptimisticUpdate(c.update, b); again.Result != IGNORE {
+					t.Errorf("expected duplicate to be ignored, got %s", again.Result)
+				}
+			} else if forwarded != c.prev {
+				t.Error("ignored update is marked as forwarded")
+			}
+		})
+	}
+}
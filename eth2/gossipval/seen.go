@@ -0,0 +1,345 @@
+package gossipval
+
+import (
+	"sync"
+
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/protolambda/zrnt/eth2/beacon/phase0"
+)
+
+type slotProposer struct {
+	Slot     common.Slot
+	Proposer common.ValidatorIndex
+}
+
+type blobKey struct {
+	BlockRoot common.Root
+	Index     uint64
+}
+
+type epochValidator struct {
+	Epoch     common.Epoch
+	Validator common.ValidatorIndex
+}
+
+type syncCommKey struct {
+	Validator common.ValidatorIndex
+	Slot      common.Slot
+	Subnet    uint64
+}
+
+type seenAggregates struct {
+	// The epoch of the cache when the first aggregate for the data was marked, used for pruning.
+	Epoch common.Epoch
+	Bits  []phase0.AttestationBits
+}
+
+// SeenCache tracks everything the gossip validators need to deduplicate messages with,
+// and implements the Seen/Mark methods of all validation backends. It is safe for concurrent use.
+//
+// Entries that are only relevant to recent slots are removed with Prune.
+// Entries that do not have a slot or epoch of their own, like blob sidecars and aggregates,
+// are pruned based on the epoch of the cache at the time they were marked.
+// Exits, slashings and BLS-to-execution changes are a one-time event per validator, these are never pruned.
+type SeenCache struct {
+	sync.RWMutex
+	spec *common.Spec
+	// The epoch of the last Prune call
+	epoch common.Epoch
+
+	blocks       map[slotProposer]struct{}
+	blobSidecars map[blobKey]common.Epoch
+	attestations map[epochValidator]struct{}
+	aggregators  map[epochValidator]struct{}
+	aggregates   map[common.Root]*seenAggregates
+	syncCommMsgs map[syncCommKey]struct{}
+	contribs     map[syncCommKey]struct{}
+
+	exits              map[common.ValidatorIndex]struct{}
+	proposerSlashings  map[common.ValidatorIndex]struct{}
+	attesterSlashings  map[common.ValidatorIndex]struct{}
+	blsToExecChanges   map[common.ValidatorIndex]struct{}
+	badBlocks          map[common.Root]common.Epoch
+	lastFinalityUpdate *common.LightClientUpdateEnvelope
+	lastOptimisticUpd  *common.LightClientUpdateEnvelope
+}
+
+func NewSeenCache(spec *common.Spec) *SeenCache {
+	return &SeenCache{
+		spec:              spec,
+		blocks:            make(map[slotProposer]struct{}),
+		blobSidecars:      make(map[blobKey]common.Epoch),
+		attestations:      make(map[epochValidator]struct{}),
+		aggregators:       make(map[epochValidator]struct{}),
+		aggregates:        make(map[common.Root]*seenAggregates),
+		syncCommMsgs:      make(map[syncCommKey]struct{}),
+		contribs:          make(map[syncCommKey]struct{}),
+		exits:             make(map[common.ValidatorIndex]struct{}),
+		proposerSlashings: make(map[common.ValidatorIndex]struct{}),
+		attesterSlashings: make(map[common.ValidatorIndex]struct{}),
+		blsToExecChanges:  make(map[common.ValidatorIndex]struct{}),
+		badBlocks:         make(map[common.Root]common.Epoch),
+	}
+}
+
+// Prune removes all entries older than the epoch before the given epoch.
+// Gossip messages of older epochs are ignored by the validators regardless of the cache,
+// so it is safe to call this with the current epoch, at the start of every epoch.
+// Bad blocks are pruned when the epoch they were marked in is finalized.
+func (sc *SeenCache) Prune(epoch common.Epoch, finalizedEpoch common.Epoch) {
+	sc.Lock()
+	defer sc.Unlock()
+	sc.epoch = epoch
+	var minEpoch common.Epoch
+	if epoch > 0 {
+		minEpoch = epoch - 1
+	}
+	for k := range sc.blocks {
+		if sc.spec.SlotToEpoch(k.Slot) < minEpoch {
+			delete(sc.blocks, k)
+		}
+	}
+	for k, e := range sc.blobSidecars {
+		if e < minEpoch {
+			delete(sc.blobSidecars, k)
+		}
+	}
+	for k := range sc.attestations {
+		if k.Epoch < minEpoch {
+			delete(sc.attestations, k)
+		}
+	}
+	for k := range sc.aggregators {
+		if k.Epoch < minEpoch {
+			delete(sc.aggregators, k)
+		}
+	}
+	for k, v := range sc.aggregates {
+		if v.Epoch < minEpoch {
+			delete(sc.aggregates, k)
+		}
+	}
+	for k := range sc.syncCommMsgs {
+		if sc.spec.SlotToEpoch(k.Slot) < minEpoch {
+			delete(sc.syncCommMsgs, k)
+		}
+	}
+	for k := range sc.contribs {
+		if sc.spec.SlotToEpoch(k.Slot) < minEpoch {
+			delete(sc.contribs, k)
+		}
+	}
+	// A bad block cannot become finalized, and anything that is not after finality is ignored anyway.
+	for k, e := range sc.badBlocks {
+		if e < finalizedEpoch {
+			delete(sc.badBlocks, k)
+		}
+	}
+}
+
+func (sc *SeenCache) SeenBlock(slot common.Slot, proposer common.ValidatorIndex) bool {
+	sc.RLock()
+	defer sc.RUnlock()
+	_, ok := sc.blocks[slotProposer{slot, proposer}]
+	return ok
+}
+
+func (sc *SeenCache) MarkBlock(slot common.Slot, proposer common.ValidatorIndex) {
+	sc.Lock()
+	defer sc.Unlock()
+	sc.blocks[slotProposer{slot, proposer}] = struct{}{}
+}
+
+func (sc *SeenCache) SeenBlobSidecar(blockRoot common.Root, index uint64) bool {
+	sc.RLock()
+	defer sc.RUnlock()
+	_, ok := sc.blobSidecars[blobKey{blockRoot, index}]
+	return ok
+}
+
+func (sc *SeenCache) MarkBlobSidecar(blockRoot common.Root, index uint64) {
+	sc.Lock()
+	defer sc.Unlock()
+	sc.blobSidecars[blobKey{blockRoot, index}] = sc.epoch
+}
+
+func (sc *SeenCache) SeenAttestation(targetEpoch common.Epoch, voter common.ValidatorIndex) bool {
+	sc.RLock()
+	defer sc.RUnlock()
+	_, ok := sc.attestations[epochValidator{targetEpoch, voter}]
+	return ok
+}
+
+func (sc *SeenCache) MarkAttestation(targetEpoch common.Epoch, voter common.ValidatorIndex) {
+	sc.Lock()
+	defer sc.Unlock()
+	sc.attestations[epochValidator{targetEpoch, voter}] = struct{}{}
+}
+
+func (sc *SeenCache) SeenAggregator(targetEpoch common.Epoch, aggregator common.ValidatorIndex) bool {
+	sc.RLock()
+	defer sc.RUnlock()
+	_, ok := sc.aggregators[epochValidator{targetEpoch, aggregator}]
+	return ok
+}
+
+func (sc *SeenCache) MarkAggregator(targetEpoch common.Epoch, aggregator common.ValidatorIndex) {
+	sc.Lock()
+	defer sc.Unlock()
+	sc.aggregators[epochValidator{targetEpoch, aggregator}] = struct{}{}
+}
+
+func (sc *SeenCache) SeenAggregate(dataRoot common.Root, bits phase0.AttestationBits) bool {
+	sc.RLock()
+	defer sc.RUnlock()
+	seen, ok := sc.aggregates[dataRoot]
+	if !ok {
+		return false
+	}
+	for _, b := range seen.Bits {
+		if covers, err := b.Covers(bits); err == nil && covers {
+			return true
+		}
+	}
+	return false
+}
+
+func (sc *SeenCache) MarkAggregate(dataRoot common.Root, bits phase0.AttestationBits) {
+	sc.Lock()
+	defer sc.Unlock()
+	seen, ok := sc.aggregates[dataRoot]
+	if !ok {
+		seen = &seenAggregates{Epoch: sc.epoch}
+		sc.aggregates[dataRoot] = seen
+	}
+	// Drop the aggregates that are covered by the new one, they are redundant.
+	kept := seen.Bits[:0]
+	for _, b := range seen.Bits {
+		if covered, err := bits.Covers(b); err != nil || !covered {
+			kept = append(kept, b)
+		}
+	}
+	seen.Bits = append(kept, bits.Copy())
+}
+
+func (sc *SeenCache) SeenSyncCommMsg(validator common.ValidatorIndex, slot common.Slot, subnet uint64) bool {
+	sc.RLock()
+	defer sc.RUnlock()
+	_, ok := sc.syncCommMsgs[syncCommKey{validator, slot, subnet}]
+	return ok
+}
+
+func (sc *SeenCache) MarkSyncCommMsg(validator common.ValidatorIndex, slot common.Slot, subnet uint64) {
+	sc.Lock()
+	defer sc.Unlock()
+	sc.syncCommMsgs[syncCommKey{validator, slot, subnet}] = struct{}{}
+}
+
+func (sc *SeenCache) SeenContribution(aggregator common.ValidatorIndex, slot common.Slot, subnet uint64) bool {
+	sc.RLock()
+	defer sc.RUnlock()
+	_, ok := sc.contribs[syncCommKey{aggregator, slot, subnet}]
+	return ok
+}
+
+func (sc *SeenCache) MarkContribution(aggregator common.ValidatorIndex, slot common.Slot, subnet uint64) {
+	sc.Lock()
+	defer sc.Unlock()
+	sc.contribs[syncCommKey{aggregator, slot, subnet}] = struct{}{}
+}
+
+func (sc *SeenCache) SeenExit(index common.ValidatorIndex) bool {
+	sc.RLock()
+	defer sc.RUnlock()
+	_, ok := sc.exits[index]
+	return ok
+}
+
+func (sc *SeenCache) MarkExit(index common.ValidatorIndex) {
+	sc.Lock()
+	defer sc.Unlock()
+	sc.exits[index] = struct{}{}
+}
+
+func (sc *SeenCache) SeenProposerSlashing(proposer common.ValidatorIndex) bool {
+	sc.RLock()
+	defer sc.RUnlock()
+	_, ok := sc.proposerSlashings[proposer]
+	return ok
+}
+
+func (sc *SeenCache) MarkProposerSlashing(index common.ValidatorIndex) {
+	sc.Lock()
+	defer sc.Unlock()
+	sc.proposerSlashings[index] = struct{}{}
+}
+
+func (sc *SeenCache) AttesterSlashableAllSeen(indices []common.ValidatorIndex) bool {
+	sc.RLock()
+	defer sc.RUnlock()
+	for _, i := range indices {
+		if _, ok := sc.attesterSlashings[i]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func (sc *SeenCache) MarkAttesterSlashings(indices []common.ValidatorIndex) {
+	sc.Lock()
+	defer sc.Unlock()
+	for _, i := range indices {
+		sc.attesterSlashings[i] = struct{}{}
+	}
+}
+
+func (sc *SeenCache) SeenBLSToExecutionChange(index common.ValidatorIndex) bool {
+	sc.RLock()
+	defer sc.RUnlock()
+	_, ok := sc.blsToExecChanges[index]
+	return ok
+}
+
+func (sc *SeenCache) MarkBLSToExecutionChange(index common.ValidatorIndex) {
+	sc.Lock()
+	defer sc.Unlock()
+	sc.blsToExecChanges[index] = struct{}{}
+}
+
+func (sc *SeenCache) LastForwardedFinalityUpdate() *common.LightClientUpdateEnvelope {
+	sc.RLock()
+	defer sc.RUnlock()
+	return sc.lastFinalityUpdate
+}
+
+func (sc *SeenCache) MarkFinalityUpdate(update *common.LightClientUpdateEnvelope) {
+	sc.Lock()
+	defer sc.Unlock()
+	sc.lastFinalityUpdate = update
+}
+
+func (sc *SeenCache) LastForwardedOptimisticUpdate() *common.LightClientUpdateEnvelope {
+	sc.RLock()
+	defer sc.RUnlock()
+	return sc.lastOptimisticUpd
+}
+
+func (sc *SeenCache) MarkOptimisticUpdate(update *common.LightClientUpdateEnvelope) {
+	sc.Lock()
+	defer sc.Unlock()
+	sc.lastOptimisticUpd = update
+}
+
+// MarkBadBlock marks a block as invalid, votes for it are rejected.
+func (sc *SeenCache) MarkBadBlock(root common.Root) {
+	sc.Lock()
+	defer sc.Unlock()
+	sc.badBlocks[root] = sc.epoch
+}
+
+func (sc *SeenCache) IsBadBlock(root common.Root) bool {
+	sc.RLock()
+	defer sc.RUnlock()
+	_, ok := sc.badBlocks[root]
+	return ok
+}
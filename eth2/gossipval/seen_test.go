@@ -0,0 +1,196 @@
+package gossipval
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/protolambda/zrnt/eth2/beacon/phase0"
+	"github.com/protolambda/zrnt/eth2/configs"
+)
+
+func testBits(bitLen uint64, set ...uint64) phase0.AttestationBits {
+	bits := phase0.NewAttestationBits(bitLen)
+	for _, i := range set {
+		bits.SetBit(i, true)
+	}
+	return bits
+}
+
+func TestSeenCachePrune(t *testing.T) {
+	spec := configs.Mainnet
+	sc := NewSeenCache(spec)
+	epochStart := func(epoch common.Epoch) common.Slot {
+		return common.Slot(epoch) * spec.SLOTS_PER_EPOCH
+	}
+
+	// Marked at cache epoch 3
+	sc.Prune(3, 0)
+	sc.MarkBlobSidecar(common.Root{3}, 0)
+	sc.MarkAggregate(common.Root{3}, testBits(8, 1))
+	sc.MarkBadBlock(common.Root{3})
+	// Marked at cache epoch 4
+	sc.Prune(4, 0)
+	sc.MarkBlobSidecar(common.Root{4}, 0)
+	sc.MarkAggregate(common.Root{4}, testBits(8, 1))
+
+	// The last slot of epoch 3, and the first slot of epoch 4
+	sc.MarkBlock(epochStart(4)-1, 1)
+	sc.MarkBlock(epochStart(4), 1)
+	sc.MarkSyncCommMsg(1, epochStart(4)-1, 0)
+	sc.MarkSyncCommMsg(1, epochStart(4), 0)
+	sc.MarkContribution(1, epochStart(4)-1, 0)
+	sc.MarkContribution(1, epochStart(4), 0)
+	sc.MarkAttestation(3, 1)
+	sc.MarkAttestation(4, 1)
+	sc.MarkAggregator(3, 1)
+	sc.MarkAggregator(4, 1)
+	sc.MarkExit(1)
+	sc.MarkProposerSlashing(1)
+	sc.MarkAttesterSlashings([]common.ValidatorIndex{1})
+	sc.MarkBLSToExecutionChange(1)
+
+	// Everything before epoch 4 is pruned, epoch 4 is the minimum epoch that is kept.
+	sc.Prune(5, 0)
+	checks := []struct {
+		name     string
+		seen     bool
+		expected bool
+	}{
+		{"block epoch 3", sc.SeenBlock(epochStart(4)-1, 1), false},
+		{"block epoch 4", sc.SeenBlock(epochStart(4), 1), true},
+		{"sync committee message epoch 3", sc.SeenSyncCommMsg(1, epochStart(4)-1, 0), false},
+		{"sync committee message epoch 4", sc.SeenSyncCommMsg(1, epochStart(4), 0), true},
+		{"contribution epoch 3", sc.SeenContribution(1, epochStart(4)-1, 0), false},
+		{"contribution epoch 4", sc.SeenContribution(1, epochStart(4), 0), true},
+		{"attestation epoch 3", sc.SeenAttestation(3, 1), false},
+		{"attestation epoch 4", sc.SeenAttestation(4, 1), true},
+		{"aggregator epoch 3", sc.SeenAggregator(3, 1), false},
+		{"aggregator epoch 4", sc.SeenAggregator(4, 1), true},
+		{"blob sidecar marked in epoch 3", sc.SeenBlobSidecar(common.Root{3}, 0), false},
+		{"blob sidecar marked in epoch 4", sc.SeenBlobSidecar(common.Root{4}, 0), true},
+		{"aggregate marked in epoch 3", sc.SeenAggregate(common.Root{3}, testBits(8, 1)), false},
+		{"aggregate marked in epoch 4", sc.SeenAggregate(common.Root{4}, testBits(8, 1)), true},
+		// not finalized yet
+		{"bad block", sc.IsBadBlock(common.Root{3}), true},
+		{"exit", sc.SeenExit(1), true},
+		{"proposer slashing", sc.SeenProposerSlashing(1), true},
+		{"attester slashing", sc.AttesterSlashableAllSeen([]common.ValidatorIndex{1}), true},
+		{"bls to execution change", sc.SeenBLSToExecutionChange(1), true},
+	}
+	for _, c := range checks {
+		if c.seen != c.expected {
+			t.Errorf("%s: expected seen %v, got %v", c.name, c.expected, c.seen)
+		}
+	}
+
+	// At genesis the minimum epoch is 0, nothing is pruned.
+	sc = NewSeenCache(spec)
+	sc.MarkBlock(0, 1)
+	sc.MarkAttestation(0, 1)
+	sc.Prune(0, 0)
+	sc.Prune(1, 0)
+	if !sc.SeenBlock(0, 1) || !sc.SeenAttestation(0, 1) {
+		t.Error("genesis epoch entries are pruned before epoch 2")
+	}
+	sc.Prune(2, 0)
+	if sc.SeenBlock(0, 1) || sc.SeenAttestation(0, 1) {
+		t.Error("genesis epoch entries are not pruned at epoch 2")
+	}
+}
+
+func TestSeenCacheBadBlockFinalization(t *testing.T) {
+	sc := NewSeenCache(configs.Mainnet)
+	sc.Prune(2, 0)
+	sc.MarkBadBlock(common.Root{1})
+	sc.Prune(10, 2)
+	if !sc.IsBadBlock(common.Root{1}) {
+		t.Fatal("bad block is pruned when the epoch it was marked in is finalized")
+	}
+	sc.Prune(10, 3)
+	if sc.IsBadBlock(common.Root{1}) {
+		t.Fatal("bad block is not pruned after the epoch it was marked in is finalized")
+	}
+}
+
+func TestSeenAggregate(t *testing.T) {
+	sc := NewSeenCache(configs.Mainnet)
+	root := common.Root{1}
+	sc.MarkAggregate(root, testBits(10, 1, 2, 3))
+	sc.MarkAggregate(root, testBits(10, 7, 8))
+	testCases := []struct {
+		name     string
+		root     common.Root
+		bits     phase0.AttestationBits
+		expected bool
+	}{
+		{"equal", root, testBits(10, 1, 2, 3), true},
+		{"subset", root, testBits(10, 2), true},
+		{"subset of other aggregate", root, testBits(10, 8), true},
+		{"empty", root, testBits(10), true},
+		{"superset", root, testBits(10, 1, 2, 3, 4), false},
+		{"union of aggregates", root, testBits(10, 1, 7), false},
+		{"disjoint", root, testBits(10, 9), false},
+		{"different length", root, testBits(11, 1), false},
+		{"different data", common.Root{2}, testBits(10, 1), false},
+	}
+	for _, c := range testCases {
+		if got := sc.SeenAggregate(c.root, c.bits); got != c.expected {
+			t.Errorf("%s: expected seen %v, got %v", c.name, c.expected, got)
+		}
+	}
+
+	// A superset replaces the aggregates that it covers.
+	sc.MarkAggregate(root, testBits(10, 1, 2, 3, 4, 7, 8))
+	if n := len(sc.aggregates[root].Bits); n != 1 {
+		t.Errorf("expected covered aggregates to be dropped, got %d aggregates", n)
+	}
+	if !sc.SeenAggregate(root, testBits(10, 1, 7)) {
+		t.Error("expected subset of superset to be seen")
+	}
+	// The cache keeps a copy, modifying the marked bits does not affect it.
+	bits := testBits(10, 5)
+	sc.MarkAggregate(root, bits)
+	bits.SetBit(6, true)
+	if sc.SeenAggregate(root, testBits(10, 5, 6)) {
+		t.Error("marked bits are not copied")
+	}
+}
+
+func TestSeenCacheConcurrent(t *testing.T) {
+	spec := configs.Mainnet
+	sc := NewSeenCache(spec)
+	var wg sync.WaitGroup
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				index := common.ValidatorIndex(w*1000 + i)
+				slot := common.Slot(i)
+				epoch := spec.SlotToEpoch(slot)
+				sc.MarkBlock(slot, index)
+				sc.MarkAttestation(epoch, index)
+				sc.MarkAggregate(common.Root{byte(i)}, testBits(16, uint64(w)))
+				sc.MarkExit(index)
+				if !sc.SeenExit(index) {
+					t.Errorf("exit %d not seen", index)
+				}
+				sc.SeenBlock(slot, index)
+				sc.SeenAttestation(epoch, index)
+				sc.SeenAggregate(common.Root{byte(i)}, testBits(16, uint64(w)))
+				if i%50 == 0 {
+					sc.Prune(epoch, 0)
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	for w := 0; w < 8; w++ {
+		for i := 0; i < 200; i++ {
+			if !sc.SeenExit(common.ValidatorIndex(w*1000 + i)) {
+				t.Fatalf("exit of worker %d, %d is lost", w, i)
+			}
+		}
+	}
+}
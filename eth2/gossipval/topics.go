@@ -0,0 +1,126 @@
+package gossipval
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+)
+
+// Gossip topic names, see the p2p-interface spec.
+// Topics with a subnet are formatted with the subnet index appended, e.g. beacon_attestation_3.
+const (
+	BeaconBlockTopic                 = "beacon_block"
+	BeaconAggregateAndProofTopic     = "beacon_aggregate_and_proof"
+	BeaconAttestationTopicPrefix     = "beacon_attestation_"
+	VoluntaryExitTopic               = "voluntary_exit"
+	ProposerSlashingTopic            = "proposer_slashing"
+	AttesterSlashingTopic            = "attester_slashing"
+	SyncCommitteeContributionTopic   = "sync_committee_contribution_and_proof"
+	SyncCommitteeTopicPrefix         = "sync_committee_"
+	BLSToExecutionChangeTopic        = "bls_to_execution_change"
+	BlobSidecarTopicPrefix           = "blob_sidecar_"
+	LightClientFinalityUpdateTopic   = "light_client_finality_update"
+	LightClientOptimisticUpdateTopic = "light_client_optimistic_update"
+	GossipEncodingPostfix            = "ssz_snappy"
+)
+
+// GossipTopic is a parsed gossip topic: /eth2/{fork_digest}/{name}/ssz_snappy
+type GossipTopic struct {
+	ForkDigest common.ForkDigest
+	// Name of the topic, without subnet index. For subnet topics this is the prefix, e.g. "beacon_attestation_".
+	Name string
+	// Subnet index, only used for subnet topics.
+	Subnet uint64
+}
+
+// IsSubnetTopic returns true if the topic name is the prefix of a topic with subnets.
+func IsSubnetTopic(name string) bool {
+	switch name {
+	case BeaconAttestationTopicPrefix, SyncCommitteeTopicPrefix, BlobSidecarTopicPrefix:
+		return true
+	default:
+		return false
+	}
+}
+
+// FullName returns the name of the topic, including the subnet index if it is a subnet topic.
+func (t *GossipTopic) FullName() string {
+	if IsSubnetTopic(t.Name) {
+		return t.Name + strconv.FormatUint(t.Subnet, 10)
+	}
+	return t.Name
+}
+
+// String returns the full topic string: /eth2/{fork_digest}/{name}/ssz_snappy
+func (t *GossipTopic) String() string {
+	return FormatTopic(t.ForkDigest, t.FullName())
+}
+
+// FormatTopic formats the full topic string: /eth2/{fork_digest}/{name}/ssz_snappy
+// The fork digest is formatted as lowercase hex, without 0x prefix.
+func FormatTopic(digest common.ForkDigest, name string) string {
+	return fmt.Sprintf("/eth2/%x/%s/%s", digest[:], name, GossipEncodingPostfix)
+}
+
+// ParseTopic parses a full topic string, and checks that the topic name is known.
+func ParseTopic(topic string) (*GossipTopic, error) {
+	parts := strings.Split(topic, "/")
+	if len(parts) != 5 || parts[0] != "" || parts[1] != "eth2" {
+		return nil, fmt.Errorf("topic %q is not an eth2 topic", topic)
+	}
+	if parts[4] != GossipEncodingPostfix {
+		return nil, fmt.Errorf("topic %q has unsupported encoding %q", topic, parts[4])
+	}
+	var out GossipTopic
+	if err := out.ForkDigest.UnmarshalText([]byte(parts[2])); err != nil {
+		return nil, fmt.Errorf("topic %q has invalid fork digest: %w", topic, err)
+	}
+	// lowercase hex without 0x prefix, the formatting must be canonical.
+	if fmt.Sprintf("%x", out.ForkDigest[:]) != parts[2] {
+		return nil, fmt.Errorf("topic %q has non-canonical fork digest %q", topic, parts[2])
+	}
+	name := parts[3]
+	switch name {
+	case BeaconBlockTopic, BeaconAggregateAndProofTopic, VoluntaryExitTopic, ProposerSlashingTopic,
+		AttesterSlashingTopic, SyncCommitteeContributionTopic, BLSToExecutionChangeTopic,
+		LightClientFinalityUpdateTopic, LightClientOptimisticUpdateTopic:
+		out.Name = name
+		return &out, nil
+	}
+	for _, prefix := range []string{BeaconAttestationTopicPrefix, SyncCommitteeTopicPrefix, BlobSidecarTopicPrefix} {
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		subnetStr := name[len(prefix):]
+		// no leading zeroes or signs, the formatting must be canonical.
+		subnet, err := strconv.ParseUint(subnetStr, 10, 64)
+		if err != nil || strconv.FormatUint(subnet, 10) != subnetStr {
+			return nil, fmt.Errorf("topic %q has invalid subnet index %q", topic, subnetStr)
+		}
+		out.Name = prefix
+		out.Subnet = subnet
+		return &out, nil
+	}
+	return nil, fmt.Errorf("topic %q has unknown name %q", topic, name)
+}
+
+// CheckSubnet checks if the subnet index is within range for the topic.
+func (t *GossipTopic) CheckSubnet(spec *common.Spec) error {
+	var count uint64
+	switch t.Name {
+	case BeaconAttestationTopicPrefix:
+		count = uint64(spec.ATTESTATION_SUBNET_COUNT)
+	case SyncCommitteeTopicPrefix:
+		count = common.SYNC_COMMITTEE_SUBNET_COUNT
+	case BlobSidecarTopicPrefix:
+		count = uint64(spec.BLOB_SIDECAR_SUBNET_COUNT)
+	default:
+		return nil
+	}
+	if t.Subnet >= count {
+		return fmt.Errorf("subnet %d out of range, topic %s only has %d subnets", t.Subnet, t.Name, count)
+	}
+	return nil
+}
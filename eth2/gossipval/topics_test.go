@@ -0,0 +1,90 @@
+package gossipval
+
+import (
+	"testing"
+
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/protolambda/zrnt/eth2/configs"
+)
+
+func TestParseTopic(t *testing.T) {
+	digest := common.ForkDigest{0xb5, 0x30, 0x3f, 0x2a}
+	cases := []struct {
+		topic  string
+		name   string
+		subnet uint64
+	}{
+		{"/eth2/b5303f2a/beacon_block/ssz_snappy", BeaconBlockTopic, 0},
+		{"/eth2/b5303f2a/beacon_aggregate_and_proof/ssz_snappy", BeaconAggregateAndProofTopic, 0},
+		{"/eth2/b5303f2a/beacon_attestation_0/ssz_snappy", BeaconAttestationTopicPrefix, 0},
+		{"/eth2/b5303f2a/beacon_attestation_63/ssz_snappy", BeaconAttestationTopicPrefix, 63},
+		{"/eth2/b5303f2a/sync_committee_3/ssz_snappy", SyncCommitteeTopicPrefix, 3},
+		{"/eth2/b5303f2a/sync_committee_contribution_and_proof/ssz_snappy", SyncCommitteeContributionTopic, 0},
+		{"/eth2/b5303f2a/blob_sidecar_5/ssz_snappy", BlobSidecarTopicPrefix, 5},
+		{"/eth2/b5303f2a/voluntary_exit/ssz_snappy", VoluntaryExitTopic, 0},
+		{"/eth2/b5303f2a/proposer_slashing/ssz_snappy", ProposerSlashingTopic, 0},
+		{"/eth2/b5303f2a/attester_slashing/ssz_snappy", AttesterSlashingTopic, 0},
+		{"/eth2/b5303f2a/bls_to_execution_change/ssz_snappy", BLSToExecutionChangeTopic, 0},
+		{"/eth2/b5303f2a/light_client_finality_update/ssz_snappy", LightClientFinalityUpdateTopic, 0},
+		{"/eth2/b5303f2a/light_client_optimistic_update/ssz_snappy", LightClientOptimisticUpdateTopic, 0},
+	}
+	for _, c := range cases {
+		topic, err := ParseTopic(c.topic)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", c.topic, err)
+			continue
+		}
+		if topic.ForkDigest != digest || topic.Name != c.name || topic.Subnet != c.subnet {
+			t.Errorf("%s: unexpected topic %+v", c.topic, topic)
+		}
+		if s := topic.String(); s != c.topic {
+			t.Errorf("%s: formatted as %s", c.topic, s)
+		}
+	}
+
+	invalid := []string{
+		"",
+		"/eth2/b5303f2a/beacon_block",
+		"eth2/b5303f2a/beacon_block/ssz_snappy/",
+		"/eth1/b5303f2a/beacon_block/ssz_snappy",
+		"/eth2/b5303f2a/beacon_block/ssz",
+		"/eth2/b5303f2a/beacon_block/ssz_snappy/extra",
+		"/eth2/b5303f/beacon_block/ssz_snappy",
+		"/eth2/0xb5303f2a/beacon_block/ssz_snappy",
+		"/eth2/B5303F2A/beacon_block/ssz_snappy",
+		"/eth2/b5303f2z/beacon_block/ssz_snappy",
+		"/eth2/b5303f2a/beacon_blocks/ssz_snappy",
+		"/eth2/b5303f2a/beacon_attestation_/ssz_snappy",
+		"/eth2/b5303f2a/beacon_attestation_01/ssz_snappy",
+		"/eth2/b5303f2a/beacon_attestation_+1/ssz_snappy",
+		"/eth2/b5303f2a/beacon_attestation_-1/ssz_snappy",
+		"/eth2/b5303f2a/sync_committee_x/ssz_snappy",
+		"/eth2/b5303f2a/blob_sidecar_18446744073709551616/ssz_snappy",
+	}
+	for _, topic := range invalid {
+		if _, err := ParseTopic(topic); err == nil {
+			t.Errorf("%q: expected error", topic)
+		}
+	}
+}
+
+func TestCheckSubnet(t *testing.T) {
+	spec := configs.Mainnet
+	cases := []struct {
+		topic GossipTopic
+		valid bool
+	}{
+		{GossipTopic{Name: BeaconAttestationTopicPrefix, Subnet: uint64(spec.ATTESTATION_SUBNET_COUNT) - 1}, true},
+		{GossipTopic{Name: BeaconAttestationTopicPrefix, Subnet: uint64(spec.ATTESTATION_SUBNET_COUNT)}, false},
+		{GossipTopic{Name: SyncCommitteeTopicPrefix, Subnet: common.SYNC_COMMITTEE_SUBNET_COUNT - 1}, true},
+		{GossipTopic{Name: SyncCommitteeTopicPrefix, Subnet: common.SYNC_COMMITTEE_SUBNET_COUNT}, false},
+		{GossipTopic{Name: BlobSidecarTopicPrefix, Subnet: uint64(spec.BLOB_SIDECAR_SUBNET_COUNT) - 1}, true},
+		{GossipTopic{Name: BlobSidecarTopicPrefix, Subnet: uint64(spec.BLOB_SIDECAR_SUBNET_COUNT)}, false},
+		{GossipTopic{Name: BeaconBlockTopic}, true},
+	}
+	for _, c := range cases {
+		if err := c.topic.CheckSubnet(spec); (err == nil) != c.valid {
+			t.Errorf("%s: expected valid %v, got: %v", c.topic.FullName(), c.valid, err)
+		}
+	}
+}
@@ -0,0 +1,35 @@
+package gossipval
+
+import (
+	"context"
+	"time"
+
+	"github.com/protolambda/zrnt/eth2/beacon"
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+)
+
+// testBackend implements the common parts of the validation backends, with a fixed clock and head.
+type testBackend struct {
+	*SeenCache
+	spec  *common.Spec
+	slot  common.Slot
+	epc   *common.EpochsContext
+	state common.BeaconState
+}
+
+func newTestBackend(spec *common.Spec, slot common.Slot, epc *common.EpochsContext, state common.BeaconState) *testBackend {
+	return &testBackend{SeenCache: NewSeenCache(spec), spec: spec, slot: slot, epc: epc, state: state}
+}
+
+func (b *testBackend) Spec() *common.Spec {
+	return b.spec
+}
+
+// SlotAfter ignores the clock disparity: the tests are at the start of the slot.
+func (b *testBackend) SlotAfter(delta time.Duration) common.Slot {
+	return b.slot
+}
+
+func (b *testBackend) HeadInfo(ctx context.Context) (beacon.ChainEntry, *common.EpochsContext, common.BeaconState, error) {
+	return nil, b.epc, b.state, nil
+}
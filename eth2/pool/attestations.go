@@ -1,13 +1,17 @@
 package pool
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
+	"sort"
 	"sync"
 	"time"
 
+	"github.com/protolambda/zrnt/eth2/beacon/altair"
 	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/protolambda/zrnt/eth2/beacon/deneb"
 	"github.com/protolambda/zrnt/eth2/beacon/phase0"
 	"github.com/protolambda/ztyp/tree"
 )
@@ -64,6 +68,7 @@ func NewAttestationPool(spec *common.Spec) *AttestationPool {
 		datas:              make(map[common.Root]*IndexedAttData),
 		individual:         make(map[Assignment]*AttRef),
 		aggregate:          make(map[common.Root]*MinAggregates),
+		aggPerValidator:    make(map[Assignment]common.Root),
 		maxExtraAggregates: 10, // TODO: worth tuning
 	}
 }
@@ -224,16 +229,194 @@ func (ap *AttestationPool) Prune(epoch common.Epoch) {
 	}
 }
 
-// Approximation of the optimal attestation packing.
-// Attestations must match source, get prioritized if the target is correct, and more if the head is correct.
-// Attestations may not be included if they already are (checked via included func).
-// Maximum attestation output and packing-time constraints apply.
-func (ap *AttestationPool) Packing(ctx context.Context,
-	source common.Checkpoint, target common.Checkpoint,
-	headRoot common.Root, headSlot common.Slot,
+type packCandidate struct {
+	dataRoot     common.Root
+	data         *phase0.AttestationData
+	flags        altair.ParticipationFlags
+	agg          Aggregate
+	participants []common.ValidatorIndex
+}
+
+// flagsReward is the reward weight of the given flags, per effective-balance increment.
+func flagsReward(flags altair.ParticipationFlags) common.Gwei {
+	var out common.Gwei
+	if flags&altair.TIMELY_SOURCE_FLAG != 0 {
+		out += altair.TIMELY_SOURCE_WEIGHT
+	}
+	if flags&altair.TIMELY_TARGET_FLAG != 0 {
+		out += altair.TIMELY_TARGET_WEIGHT
+	}
+	if flags&altair.TIMELY_HEAD_FLAG != 0 {
+		out += altair.TIMELY_HEAD_WEIGHT
+	}
+	return out
+}
+
+// applicableFlags checks if the attestation data can be included in a block on top of the given state,
+// and returns the participation flags it would earn.
+func (ap *AttestationPool) applicableFlags(state common.BeaconState, slot common.Slot, data *phase0.AttestationData) (altair.ParticipationFlags, error) {
+	spec := ap.spec
+	currentEpoch := spec.SlotToEpoch(slot)
+	if data.Target.Epoch < currentEpoch.Previous() || data.Target.Epoch > currentEpoch {
+		return 0, errors.New("target epoch out of range")
+	}
+	if data.Slot+spec.MIN_ATTESTATION_INCLUSION_DELAY > slot {
+		return 0, errors.New("attestation is too new")
+	}
+	if currentEpoch >= spec.DENEB_FORK_EPOCH {
+		return deneb.GetApplicableAttestationParticipationFlags(spec, state, data, slot-data.Slot)
+	}
+	if slot > data.Slot+spec.SLOTS_PER_EPOCH {
+		return 0, errors.New("attestation slot is too old")
+	}
+	return altair.GetApplicableAttestationParticipationFlags(spec, state, data, slot-data.Slot)
+}
+
+// candidates collects all attestations that can be included on top of the state:
+// all aggregates, and per attestation data all individual attestations aggregated together.
+// The candidates are ordered by attestation data root, for the packing to be deterministic.
+func (ap *AttestationPool) candidates(state common.BeaconState, slot common.Slot) ([]*packCandidate, error) {
+	individuals := make(map[common.Root][]Assignment)
+	for k, v := range ap.individual {
+		individuals[v.DataRoot] = append(individuals[v.DataRoot], k)
+	}
+	var out []*packCandidate
+	for dataRoot, d := range ap.datas {
+		flags, err := ap.applicableFlags(state, slot, &d.Data)
+		if err != nil || flags == 0 {
+			continue
+		}
+		add := func(agg Aggregate) {
+			// FilterParticipants filters in-place, the committee of the pool must not be modified.
+			committee := append(common.CommitteeIndices(nil), d.Committee...)
+			out = append(out, &packCandidate{
+				dataRoot:     dataRoot,
+				data:         &d.Data,
+				flags:        flags,
+				agg:          agg,
+				participants: agg.Participants.FilterParticipants(committee),
+			})
+		}
+		if agg, ok := ap.aggregate[dataRoot]; ok {
+			for _, a := range agg.Aggregates {
+				add(a)
+			}
+			for _, a := range agg.Extra {
+				add(a)
+			}
+		}
+		if assignments, ok := individuals[dataRoot]; ok {
+			bits := phase0.NewAttestationBits(uint64(len(d.Committee)))
+			sigs := make([]common.BLSSignature, 0, len(assignments))
+			for _, a := range assignments {
+				for i, vi := range d.Committee {
+					if vi == a.Index {
+						bits.SetBit(uint64(i), true)
+						sigs = append(sigs, ap.individual[a].Sig)
+						break
+					}
+				}
+			}
+			if len(sigs) == 0 {
+				continue
+			}
+			sig, err := common.AggregateSignatures(sigs)
+			if err != nil {
+				return nil, fmt.Errorf("failed to aggregate individual attestations of %s: %w", dataRoot, err)
+			}
+			add(Aggregate{Participants: bits, Sig: sig})
+		}
+	}
+	sort.SliceStable(out, func(i, j int) bool {
+		return bytes.Compare(out[i].dataRoot[:], out[j].dataRoot[:]) < 0
+	})
+	return out, nil
+}
+
+// Packing approximates the optimal attestation packing, for a block at the slot of the given state.
+// The state must be processed up to the slot of the block, the EpochsContext must match it.
+//
+// This is a greedy weighted maximum-coverage: the attestation with the most unrealized reward is picked,
+// until maxCount attestations are picked or no remaining attestation adds reward.
+// The reward of an attestation is the sum of the participation flag weights,
+// of the flags that are new to each participant, times the effective balance increments of the participant.
+// The included func returns the flags that the validator already earned for the epoch, e.g. from the state.
+// Ties are broken by attestation data root, and then by the order of the aggregates in the pool.
+// If maxTime passes before the packing is complete, the attestations picked so far are returned.
+func (ap *AttestationPool) Packing(ctx context.Context, epc *common.EpochsContext, state common.BeaconState,
 	maxCount uint64, maxTime time.Duration,
-	included func(epoch common.Epoch, index common.ValidatorIndex)) ([]phase0.Attestation, error) {
+	included func(epoch common.Epoch, index common.ValidatorIndex) altair.ParticipationFlags) ([]phase0.Attestation, error) {
+	ap.RLock()
+	defer ap.RUnlock()
 
-	// TODO find best attestations to pack for profit.
-	return nil, nil
+	deadline := time.Now().Add(maxTime)
+	spec := ap.spec
+	slot, err := state.Slot()
+	if err != nil {
+		return nil, err
+	}
+	if max := uint64(spec.MAX_ATTESTATIONS); maxCount > max {
+		maxCount = max
+	}
+	candidates, err := ap.candidates(state, slot)
+	if err != nil {
+		return nil, err
+	}
+
+	// Flags that are already earned, including the flags of the attestations that were packed so far.
+	earned := make(map[Assignment]altair.ParticipationFlags)
+	earnedFlags := func(key Assignment) altair.ParticipationFlags {
+		flags, ok := earned[key]
+		if !ok {
+			flags = included(key.Epoch, key.Index)
+			earned[key] = flags
+		}
+		return flags
+	}
+	score := func(c *packCandidate) (out common.Gwei) {
+		key := Assignment{Epoch: c.data.Target.Epoch}
+		for _, vi := range c.participants {
+			key.Index = vi
+			if uint64(vi) >= uint64(len(epc.EffectiveBalances)) {
+				continue
+			}
+			increments := epc.EffectiveBalances[vi] / spec.EFFECTIVE_BALANCE_INCREMENT
+			out += increments * flagsReward(c.flags&^earnedFlags(key))
+		}
+		return out
+	}
+
+	out := make([]phase0.Attestation, 0, maxCount)
+	for uint64(len(out)) < maxCount && len(candidates) > 0 {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if time.Now().After(deadline) {
+			break
+		}
+		bestIndex := -1
+		var bestScore common.Gwei
+		for i, c := range candidates {
+			if s := score(c); s > bestScore {
+				bestIndex, bestScore = i, s
+			}
+		}
+		if bestIndex < 0 {
+			break
+		}
+		best := candidates[bestIndex]
+		out = append(out, phase0.Attestation{
+			AggregationBits: best.agg.Participants,
+			Data:            *best.data,
+			Signature:       best.agg.Sig,
+		})
+		key := Assignment{Epoch: best.data.Target.Epoch}
+		for _, vi := range best.participants {
+			key.Index = vi
+			earned[key] = earnedFlags(key) | best.flags
+		}
+		// Keep the order of the remaining candidates, the first candidate wins a tie.
+		candidates = append(candidates[:bestIndex], candidates[bestIndex+1:]...)
+	}
+	return out, nil
 }
@@ -0,0 +1,229 @@
+package pool
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/protolambda/zrnt/eth2/beacon"
+	"github.com/protolambda/zrnt/eth2/beacon/altair"
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/protolambda/zrnt/eth2/beacon/phase0"
+	"github.com/protolambda/zrnt/eth2/configs"
+	"github.com/protolambda/zrnt/tests/benches"
+	"github.com/protolambda/ztyp/tree"
+)
+
+type testAtt struct {
+	data      *phase0.AttestationData
+	committee common.CommitteeIndices
+	// indices of the committee positions that participate
+	positions []uint64
+}
+
+func (a *testAtt) attestation() *phase0.Attestation {
+	bits := phase0.NewAttestationBits(uint64(len(a.committee)))
+	for _, p := range a.positions {
+		bits.SetBit(p, true)
+	}
+	// aggregates are not verified or aggregated by the pool, any signature will do.
+	return &phase0.Attestation{AggregationBits: bits, Data: *a.data, Signature: common.BLSSignature{0xc0}}
+}
+
+func TestPacking(t *testing.T) {
+	ctx := context.Background()
+	state, epc := benches.CreateTestState(64, configs.Mainnet.MAX_EFFECTIVE_BALANCE)
+	if err := common.ProcessSlots(ctx, configs.Mainnet, epc,
+		&beacon.StandardUpgradeableBeaconState{BeaconState: state}, 3); err != nil {
+		t.Fatal(err)
+	}
+	// Validators 8 and up have half the effective balance.
+	for i := 8; i < len(epc.EffectiveBalances); i++ {
+		epc.EffectiveBalances[i] = configs.Mainnet.MAX_EFFECTIVE_BALANCE / 2
+	}
+	genesisRoot, err := common.GetBlockRootAtSlot(configs.Mainnet, state, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// All attestations are for slot 2, included at slot 3: timely source, target and head.
+	data := func(index common.CommitteeIndex) *phase0.AttestationData {
+		return &phase0.AttestationData{
+			Slot:            2,
+			Index:           index,
+			BeaconBlockRoot: genesisRoot,
+			Target:          common.Checkpoint{Epoch: 0, Root: genesisRoot},
+		}
+	}
+	// The head vote is wrong: timely source and target only.
+	wrongHead := func(index common.CommitteeIndex) *phase0.AttestationData {
+		d := data(index)
+		d.BeaconBlockRoot = common.Root{0xff}
+		return d
+	}
+	// The source vote is wrong: the attestation can not be included.
+	wrongSource := data(9)
+	wrongSource.Source.Epoch = 1
+
+	committee := func(indices ...common.ValidatorIndex) common.CommitteeIndices {
+		return indices
+	}
+	nothingIncluded := func(epoch common.Epoch, index common.ValidatorIndex) altair.ParticipationFlags {
+		return 0
+	}
+	// sort the data roots, to predict the tie-break
+	tieA, tieB := data(5), data(6)
+	if rootA, rootB := tieA.HashTreeRoot(tree.GetHashFn()), tieB.HashTreeRoot(tree.GetHashFn()); string(rootA[:]) > string(rootB[:]) {
+		tieA, tieB = tieB, tieA
+	}
+
+	testCases := []struct {
+		name     string
+		atts     []testAtt
+		maxCount uint64
+		maxTime  time.Duration
+		spec     func(spec *common.Spec)
+		included func(epoch common.Epoch, index common.ValidatorIndex) altair.ParticipationFlags
+		// indices into atts, in the order they are expected to be packed
+		expect []int
+	}{
+		{
+			name: "source, target and head weights",
+			atts: []testAtt{
+				// 2 full-balance participants, source+target: 2 * 32 * (14+26) = 2560
+				{data: wrongHead(1), committee: committee(0, 1, 2), positions: []uint64{0, 1}},
+				// 2 full-balance participants, source+target+head: 2 * 32 * (14+26+14) = 3456
+				{data: data(2), committee: committee(3, 4), positions: []uint64{0, 1}},
+				// 3 half-balance participants, source+target+head: 3 * 16 * 54 = 2592
+				{data: data(3), committee: committee(8, 9, 10), positions: []uint64{0, 1, 2}},
+			},
+			maxCount: 10,
+			maxTime:  time.Minute,
+			included: nothingIncluded,
+			expect:   []int{1, 2, 0},
+		},
+		{
+			name: "overlapping aggregates only count new participants",
+			atts: []testAtt{
+				{data: data(1), committee: committee(0, 1, 2, 3), positions: []uint64{0, 1, 2}},
+				// adds only validator 3 after the first aggregate
+				{data: data(1), committee: committee(0, 1, 2, 3), positions: []uint64{1, 2, 3}},
+				// 2 new participants, source+target: 2 * 32 * 40 = 2560, more than validator 3 alone: 32 * 54
+				{data: wrongHead(2), committee: committee(4, 5), positions: []uint64{0, 1}},
+			},
+			maxCount: 10,
+			maxTime:  time.Minute,
+			included: nothingIncluded,
+			expect:   []int{0, 2, 1},
+		},
+		{
+			name: "earned flags are skipped",
+			atts: []testAtt{
+				{data: data(1), committee: committee(0, 1), positions: []uint64{0, 1}},
+				{data: data(2), committee: committee(2, 3), positions: []uint64{0, 1}},
+				// validator 4 earned the target flag, validator 5 earned all flags: 32 * (14+14) = 896
+				{data: data(3), committee: committee(4, 5), positions: []uint64{0, 1}},
+			},
+			maxCount: 10,
+			maxTime:  time.Minute,
+			included: func(epoch common.Epoch, index common.ValidatorIndex) altair.ParticipationFlags {
+				switch index {
+				case 0, 1, 5:
+					return altair.TIMELY_SOURCE_FLAG | altair.TIMELY_TARGET_FLAG | altair.TIMELY_HEAD_FLAG
+				case 4:
+					return altair.TIMELY_TARGET_FLAG
+				}
+				return 0
+			},
+			expect: []int{1, 2},
+		},
+		{
+			name: "inapplicable attestations are skipped",
+			atts: []testAtt{
+				{data: wrongSource, committee: committee(0, 1), positions: []uint64{0, 1}},
+				{data: data(2), committee: committee(2, 3), positions: []uint64{0, 1}},
+			},
+			maxCount: 10,
+			maxTime:  time.Minute,
+			included: nothingIncluded,
+			expect:   []int{1},
+		},
+		{
+			name: "max count",
+			atts: []testAtt{
+				{data: wrongHead(1), committee: committee(0, 1), positions: []uint64{0, 1}},
+				{data: data(2), committee: committee(2, 3), positions: []uint64{0, 1}},
+				{data: data(3), committee: committee(4, 5, 6), positions: []uint64{0, 1, 2}},
+			},
+			maxCount: 2,
+			maxTime:  time.Minute,
+			included: nothingIncluded,
+			expect:   []int{2, 1},
+		},
+		{
+			name: "max count capped by MAX_ATTESTATIONS",
+			atts: []testAtt{
+				{data: wrongHead(1), committee: committee(0, 1), positions: []uint64{0, 1}},
+				{data: data(2), committee: committee(2, 3), positions: []uint64{0, 1}},
+				{data: data(3), committee: committee(4, 5, 6), positions: []uint64{0, 1, 2}},
+			},
+			maxCount: 10,
+			maxTime:  time.Minute,
+			spec: func(spec *common.Spec) {
+				spec.MAX_ATTESTATIONS = 1
+			},
+			included: nothingIncluded,
+			expect:   []int{2},
+		},
+		{
+			name: "max time passed",
+			atts: []testAtt{
+				{data: data(1), committee: committee(0, 1), positions: []uint64{0, 1}},
+			},
+			maxCount: 10,
+			maxTime:  -time.Second,
+			included: nothingIncluded,
+			expect:   []int{},
+		},
+		{
+			name: "ties are broken by data root",
+			atts: []testAtt{
+				{data: tieB, committee: committee(0, 1), positions: []uint64{0, 1}},
+				{data: tieA, committee: committee(2, 3), positions: []uint64{0, 1}},
+			},
+			maxCount: 10,
+			maxTime:  time.Minute,
+			included: nothingIncluded,
+			expect:   []int{1, 0},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			spec := *configs.Mainnet
+			if testCase.spec != nil {
+				testCase.spec(&spec)
+			}
+			ap := NewAttestationPool(&spec)
+			atts := make([]*phase0.Attestation, len(testCase.atts))
+			for i := range testCase.atts {
+				atts[i] = testCase.atts[i].attestation()
+				if err := ap.AddAttestation(ctx, atts[i], testCase.atts[i].committee); err != nil {
+					t.Fatalf("failed to add attestation %d: %v", i, err)
+				}
+			}
+			out, err := ap.Packing(ctx, epc, state, testCase.maxCount, testCase.maxTime, testCase.included)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(out) != len(testCase.expect) {
+				t.Fatalf("expected %d attestations, got %d", len(testCase.expect), len(out))
+			}
+			for i, j := range testCase.expect {
+				expected := atts[j]
+				if out[i].Data != expected.Data || string(out[i].AggregationBits) != string(expected.AggregationBits) {
+					t.Errorf("attestation %d: expected attestation %d (index %d, bits %s), got index %d, bits %s",
+						i, j, expected.Data.Index, expected.AggregationBits, out[i].Data.Index, out[i].AggregationBits)
+				}
+			}
+		})
+	}
+}
@@ -1,8 +1,10 @@
 package pool
 
 import (
+	"bytes"
 	"context"
 	"fmt"
+	"sort"
 	"sync"
 
 	"github.com/protolambda/zrnt/eth2/beacon/common"
@@ -16,6 +18,9 @@ type AttesterSlashingPool struct {
 	slashings map[common.Root]*phase0.AttesterSlashing
 }
 
+// NewAttesterSlashingPool creates an empty pool. The pool is not pruned by itself:
+// the chain must call PruneFinalized with the pool whenever its finalized checkpoint changes,
+// to drop the slashings that were included by other proposers.
 func NewAttesterSlashingPool(spec *common.Spec) *AttesterSlashingPool {
 	return &AttesterSlashingPool{
 		spec:      spec,
@@ -46,9 +51,139 @@ func (asp *AttesterSlashingPool) All() []*phase0.AttesterSlashing {
 	return out
 }
 
-// Pack n slashings, removes the slashings from the pool. A reward estimator is used to pick the best slashings.
-// Slashings with negative rewards will not be packed.
-func (asp *AttesterSlashingPool) Pack(estReward func(sl *phase0.AttesterSlashing) int, n uint) []*phase0.AttesterSlashing {
-	// TODO
+// slashable returns the indices that would be slashed by the attester slashing in the given state, with their rewards.
+// If none are slashable, done tells if the slashing can never become effective anymore.
+func (asp *AttesterSlashingPool) slashable(epoch common.Epoch, validators common.ValidatorRegistry,
+	sl *phase0.AttesterSlashing) (indices []common.ValidatorIndex, rewards []common.Gwei, done bool, err error) {
+	done = true
+	common.ValidatorSet(sl.Attestation1.AttestingIndices).ZigZagJoin(common.ValidatorSet(sl.Attestation2.AttestingIndices), func(i common.ValidatorIndex) {
+		if err != nil {
+			return
+		}
+		var valid bool
+		if valid, err = validators.IsValidIndex(i); err != nil || !valid {
+			return
+		}
+		var v common.Validator
+		if v, err = validators.Validator(i); err != nil {
+			return
+		}
+		slashable, indexDone, e := slashability(v, epoch)
+		if e != nil {
+			err = e
+			return
+		}
+		if !indexDone {
+			done = false
+		}
+		if !slashable {
+			return
+		}
+		reward, e := whistleblowerReward(asp.spec, v)
+		if e != nil {
+			err = e
+			return
+		}
+		indices = append(indices, i)
+		rewards = append(rewards, reward)
+	}, nil)
+	return
+}
+
+// Pack up to n slashings that are valid in the given state, the state the block is built on.
+// Slashings are picked greedily by whistleblower reward, only counting the validators
+// that are not slashed by previously picked slashings, or excluded by the exclude func (may be nil).
+// Ties are broken by the root of the slashing.
+// Packed slashings are removed from the pool, as well as slashings that can never become effective anymore.
+func (asp *AttesterSlashingPool) Pack(epc *common.EpochsContext, state common.BeaconState, n uint,
+	exclude func(index common.ValidatorIndex) bool) ([]*phase0.AttesterSlashing, error) {
+	asp.Lock()
+	defer asp.Unlock()
+	if max := uint(asp.spec.MAX_ATTESTER_SLASHINGS); n > max {
+		n = max
+	}
+	validators, err := state.Validators()
+	if err != nil {
+		return nil, err
+	}
+	type candidate struct {
+		root    common.Root
+		sl      *phase0.AttesterSlashing
+		indices []common.ValidatorIndex
+		rewards []common.Gwei
+	}
+	var candidates []*candidate
+	for root, sl := range asp.slashings {
+		indices, rewards, done, err := asp.slashable(epc.CurrentEpoch.Epoch, validators, sl)
+		if err != nil {
+			return nil, err
+		}
+		if len(indices) == 0 {
+			if done {
+				delete(asp.slashings, root)
+			}
+			continue
+		}
+		candidates = append(candidates, &candidate{root, sl, indices, rewards})
+	}
+	// Order by root, for the first candidate to win a tie, regardless of map iteration order.
+	sort.Slice(candidates, func(i, j int) bool {
+		return bytes.Compare(candidates[i].root[:], candidates[j].root[:]) < 0
+	})
+	picked := make(map[common.ValidatorIndex]struct{})
+	score := func(c *candidate) (out common.Gwei) {
+		for i, index := range c.indices {
+			if _, ok := picked[index]; ok {
+				continue
+			}
+			if exclude != nil && exclude(index) {
+				continue
+			}
+			out += c.rewards[i]
+		}
+		return out
+	}
+	var out []*phase0.AttesterSlashing
+	for uint(len(out)) < n && len(candidates) > 0 {
+		bestIndex := -1
+		var bestScore common.Gwei
+		for i, c := range candidates {
+			if s := score(c); s > bestScore {
+				bestIndex, bestScore = i, s
+			}
+		}
+		if bestIndex < 0 {
+			break
+		}
+		best := candidates[bestIndex]
+		out = append(out, best.sl)
+		delete(asp.slashings, best.root)
+		for _, index := range best.indices {
+			picked[index] = struct{}{}
+		}
+		// Keep the order of the remaining candidates.
+		candidates = append(candidates[:bestIndex], candidates[bestIndex+1:]...)
+	}
+	return out, nil
+}
+
+// OnFinalized removes the slashings that can never become effective anymore, given the finalized state:
+// all slashable indices are already slashed or withdrawable.
+func (asp *AttesterSlashingPool) OnFinalized(epc *common.EpochsContext, state common.BeaconState) error {
+	asp.Lock()
+	defer asp.Unlock()
+	validators, err := state.Validators()
+	if err != nil {
+		return err
+	}
+	for root, sl := range asp.slashings {
+		indices, _, done, err := asp.slashable(epc.CurrentEpoch.Epoch, validators, sl)
+		if err != nil {
+			return err
+		}
+		if len(indices) == 0 && done {
+			delete(asp.slashings, root)
+		}
+	}
 	return nil
 }
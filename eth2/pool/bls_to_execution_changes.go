@@ -0,0 +1,97 @@
+package pool
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/protolambda/zrnt/eth2/beacon/capella"
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+)
+
+type BLSToExecutionChangePool struct {
+	sync.RWMutex
+	spec    *common.Spec
+	changes map[common.ValidatorIndex]*common.SignedBLSToExecutionChange
+}
+
+func NewBLSToExecutionChangePool(spec *common.Spec) *BLSToExecutionChangePool {
+	return &BLSToExecutionChangePool{
+		spec:    spec,
+		changes: make(map[common.ValidatorIndex]*common.SignedBLSToExecutionChange),
+	}
+}
+
+func (bcp *BLSToExecutionChangePool) AddBLSToExecutionChange(ctx context.Context, change *common.SignedBLSToExecutionChange) error {
+	bcp.Lock()
+	defer bcp.Unlock()
+	key := change.BLSToExecutionChange.ValidatorIndex
+	if _, ok := bcp.changes[key]; ok {
+		return fmt.Errorf("already have bls to execution change for validator %d", key)
+	}
+	bcp.changes[key] = change
+	return nil
+}
+
+func (bcp *BLSToExecutionChangePool) All() []*common.SignedBLSToExecutionChange {
+	bcp.RLock()
+	defer bcp.RUnlock()
+	out := make([]*common.SignedBLSToExecutionChange, 0, len(bcp.changes))
+	for _, a := range bcp.changes {
+		out = append(out, a)
+	}
+	return out
+}
+
+// Pack up to MAX_BLS_TO_EXECUTION_CHANGES changes that are valid in the given state, the state the block is built on.
+// The changes are packed in order of validator index, packed changes are removed from the pool.
+// Changes that can never become valid are removed from the pool as well: the validator already has
+// execution withdrawal credentials, or the change does not match the BLS withdrawal credentials or signature.
+// The BLS credentials and the signature domain never change, so these changes cannot be valid in any other state.
+// Changes for validators that are not in the state are kept.
+func (bcp *BLSToExecutionChangePool) Pack(epc *common.EpochsContext, state common.BeaconState) ([]*common.SignedBLSToExecutionChange, error) {
+	bcp.Lock()
+	defer bcp.Unlock()
+	validators, err := state.Validators()
+	if err != nil {
+		return nil, err
+	}
+	indices := make([]common.ValidatorIndex, 0, len(bcp.changes))
+	for index := range bcp.changes {
+		indices = append(indices, index)
+	}
+	sort.Slice(indices, func(i, j int) bool {
+		return indices[i] < indices[j]
+	})
+	max := int(bcp.spec.MAX_BLS_TO_EXECUTION_CHANGES)
+	out := make([]*common.SignedBLSToExecutionChange, 0, max)
+	for _, index := range indices {
+		if len(out) >= max {
+			break
+		}
+		if valid, err := validators.IsValidIndex(index); err != nil {
+			return nil, err
+		} else if !valid {
+			continue
+		}
+		v, err := validators.Validator(index)
+		if err != nil {
+			return nil, err
+		}
+		creds, err := v.WithdrawalCredentials()
+		if err != nil {
+			return nil, err
+		}
+		ch := bcp.changes[index]
+		delete(bcp.changes, index)
+		if creds[0] != common.BLS_WITHDRAWAL_PREFIX {
+			continue
+		}
+		if err := capella.ValidateBLSToExecutionChange(bcp.spec, epc, state, ch); err != nil {
+			continue
+		}
+		out = append(out, ch)
+	}
+	return out, nil
+}
@@ -0,0 +1,126 @@
+package pool
+
+import (
+	"context"
+	"math/big"
+	"testing"
+
+	blsu "github.com/protolambda/bls12-381-util"
+	"github.com/protolambda/ztyp/tree"
+
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/protolambda/zrnt/eth2/beacon/phase0"
+	"github.com/protolambda/zrnt/eth2/configs"
+	"github.com/protolambda/zrnt/eth2/util/hashing"
+)
+
+// blsChangeState creates a state where each validator has BLS withdrawal credentials of its own key,
+// except for the validators that are listed as eth1, which have execution withdrawal credentials.
+func blsChangeState(t *testing.T, spec *common.Spec, count int, eth1 ...common.ValidatorIndex) ([]blsu.SecretKey, common.BeaconState, *common.EpochsContext) {
+	keys := make([]blsu.SecretKey, count)
+	validators := make([]phase0.KickstartValidatorData, count)
+	for i := range keys {
+		var raw [32]byte
+		big.NewInt(int64(i + 1)).FillBytes(raw[:])
+		if err := keys[i].Deserialize(&raw); err != nil {
+			t.Fatal(err)
+		}
+		pub, err := blsu.SkToPk(&keys[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		pubkey := common.BLSPubkey(pub.Serialize())
+		creds := hashing.Hash(pubkey[:])
+		creds[0] = common.BLS_WITHDRAWAL_PREFIX
+		validators[i] = phase0.KickstartValidatorData{
+			Pubkey:                pubkey,
+			WithdrawalCredentials: creds,
+			Balance:               spec.MAX_EFFECTIVE_BALANCE,
+		}
+	}
+	for _, i := range eth1 {
+		validators[i].WithdrawalCredentials = common.Root{0: common.ETH1_ADDRESS_WITHDRAWAL_PREFIX, 31: 0xaa}
+	}
+	state, epc, err := phase0.KickStartState(spec, common.Root{0x42}, 1000, validators)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keys, state, epc
+}
+
+// blsChange creates a change of the validator, from the BLS pubkey of the given key, signed by the signer key.
+func blsChange(t *testing.T, spec *common.Spec, state common.BeaconState, index common.ValidatorIndex,
+	key *blsu.SecretKey, signer *blsu.SecretKey) *common.SignedBLSToExecutionChange {
+	pub, err := blsu.SkToPk(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	genesisValRoot, err := state.GenesisValidatorsRoot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	change := common.BLSToExecutionChange{
+		ValidatorIndex:     index,
+		FromBLSPubKey:      pub.Serialize(),
+		ToExecutionAddress: common.Eth1Address{0xee},
+	}
+	domain := common.ComputeDomain(common.DOMAIN_BLS_TO_EXECUTION_CHANGE, spec.GENESIS_FORK_VERSION, genesisValRoot)
+	root := common.ComputeSigningRoot(change.HashTreeRoot(tree.GetHashFn()), domain)
+	return &common.SignedBLSToExecutionChange{
+		BLSToExecutionChange: change,
+		Signature:            blsu.Sign(signer, root[:]).Serialize(),
+	}
+}
+
+func TestBLSToExecutionChangePack(t *testing.T) {
+	spec := *configs.Minimal
+	spec.MAX_BLS_TO_EXECUTION_CHANGES = 2
+	keys, state, epc := blsChangeState(t, &spec, 8, 6)
+	bcp := NewBLSToExecutionChangePool(&spec)
+	for _, ch := range []*common.SignedBLSToExecutionChange{
+		blsChange(t, &spec, state, 5, &keys[5], &keys[5]),
+		blsChange(t, &spec, state, 1, &keys[1], &keys[1]),
+		blsChange(t, &spec, state, 2, &keys[2], &keys[2]),
+		// signed by the wrong key
+		blsChange(t, &spec, state, 3, &keys[3], &keys[0]),
+		// pubkey does not match the withdrawal credentials
+		blsChange(t, &spec, state, 4, &keys[0], &keys[0]),
+		// already has execution withdrawal credentials
+		blsChange(t, &spec, state, 6, &keys[6], &keys[6]),
+		// not in the state yet
+		blsChange(t, &spec, state, 100, &keys[7], &keys[7]),
+	} {
+		if err := bcp.AddBLSToExecutionChange(context.Background(), ch); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expectPack := func(expected ...common.ValidatorIndex) {
+		t.Helper()
+		packed, err := bcp.Pack(epc, state)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(packed) != len(expected) {
+			t.Fatalf("expected %d changes, got %d", len(expected), len(packed))
+		}
+		for i, index := range expected {
+			if got := packed[i].BLSToExecutionChange.ValidatorIndex; got != index {
+				t.Errorf("change %d: expected validator %d, got %d", i, index, got)
+			}
+		}
+	}
+	expectRemaining := func(expected int) {
+		t.Helper()
+		if got := len(bcp.All()); got != expected {
+			t.Errorf("expected %d changes in the pool, got %d", expected, got)
+		}
+	}
+	// Limited by MAX_BLS_TO_EXECUTION_CHANGES, the changes that are not visited stay in the pool.
+	expectPack(1, 2)
+	expectRemaining(5)
+	// The invalid changes are dropped, the change of the unknown validator is kept.
+	expectPack(5)
+	expectRemaining(1)
+	expectPack()
+	expectRemaining(1)
+}
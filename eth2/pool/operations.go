@@ -0,0 +1,77 @@
+package pool
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/protolambda/zrnt/eth2/beacon"
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+)
+
+// FinalizedPruner is a pool that drops the operations that can never be included anymore,
+// given the finalized state. Packing only drops the operations it packs, or that it checks against the block state,
+// operations that are included by other proposers stay in the pool until the pool is pruned.
+type FinalizedPruner interface {
+	OnFinalized(epc *common.EpochsContext, state common.BeaconState) error
+}
+
+var (
+	_ FinalizedPruner = (*AttesterSlashingPool)(nil)
+	_ FinalizedPruner = (*ProposerSlashingPool)(nil)
+	_ FinalizedPruner = (*VoluntaryExitPool)(nil)
+)
+
+// PruneFinalized prunes the pools with the finalized state of the chain.
+// beacon.Chain has no finalization hook: the user of the chain must call this
+// whenever the finalized checkpoint changes, the pools do not prune themselves.
+func PruneFinalized(ctx context.Context, chain beacon.Chain, pools ...FinalizedPruner) error {
+	entry, err := chain.Finalized()
+	if err != nil {
+		return fmt.Errorf("failed to get finalized entry: %w", err)
+	}
+	epc, err := entry.EpochsContext(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get finalized epochs context: %w", err)
+	}
+	state, err := entry.State(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get finalized state: %w", err)
+	}
+	for _, p := range pools {
+		if err := p.OnFinalized(epc, state); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// slashability checks if the validator is slashable at the given epoch.
+// If not, done tells if the validator can never be slashed anymore, i.e. it is already slashed or withdrawable.
+func slashability(v common.Validator, epoch common.Epoch) (slashable bool, done bool, err error) {
+	if slashed, err := v.Slashed(); err != nil {
+		return false, false, err
+	} else if slashed {
+		return false, true, nil
+	}
+	if withdrawableEpoch, err := v.WithdrawableEpoch(); err != nil {
+		return false, false, err
+	} else if withdrawableEpoch <= epoch {
+		return false, true, nil
+	}
+	if activationEpoch, err := v.ActivationEpoch(); err != nil {
+		return false, false, err
+	} else if activationEpoch > epoch {
+		return false, false, nil
+	}
+	return true, false, nil
+}
+
+// whistleblowerReward is the reward of the whistleblower for slashing the validator.
+// The proposer is the whistleblower, so this is what the proposer earns by including the slashing.
+func whistleblowerReward(spec *common.Spec, v common.Validator) (common.Gwei, error) {
+	effBal, err := v.EffectiveBalance()
+	if err != nil {
+		return 0, err
+	}
+	return effBal / common.Gwei(spec.WHISTLEBLOWER_REWARD_QUOTIENT), nil
+}
@@ -0,0 +1,199 @@
+package pool
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/protolambda/zrnt/eth2/beacon"
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/protolambda/zrnt/eth2/beacon/phase0"
+	"github.com/protolambda/zrnt/eth2/configs"
+	"github.com/protolambda/zrnt/tests/benches"
+	"github.com/protolambda/ztyp/tree"
+)
+
+type testEntry struct {
+	beacon.ChainEntry
+	epc   *common.EpochsContext
+	state common.BeaconState
+}
+
+func (e *testEntry) EpochsContext(ctx context.Context) (*common.EpochsContext, error) {
+	return e.epc, nil
+}
+
+func (e *testEntry) State(ctx context.Context) (common.BeaconState, error) {
+	return e.state, nil
+}
+
+type testChain struct {
+	beacon.Chain
+	finalized *testEntry
+}
+
+func (c *testChain) Finalized() (beacon.ChainEntry, error) {
+	return c.finalized, nil
+}
+
+func proposerSlashing(index common.ValidatorIndex) *phase0.ProposerSlashing {
+	sl := &phase0.ProposerSlashing{}
+	sl.SignedHeader1.Message.ProposerIndex = index
+	sl.SignedHeader2.Message.ProposerIndex = index
+	sl.SignedHeader2.Message.StateRoot = common.Root{1}
+	return sl
+}
+
+func attesterSlashing(indices ...common.ValidatorIndex) *phase0.AttesterSlashing {
+	sl := &phase0.AttesterSlashing{}
+	sl.Attestation1.AttestingIndices = indices
+	sl.Attestation2.AttestingIndices = indices
+	sl.Attestation2.Data.BeaconBlockRoot = common.Root{1}
+	return sl
+}
+
+func voluntaryExit(index common.ValidatorIndex) *phase0.SignedVoluntaryExit {
+	return &phase0.SignedVoluntaryExit{Message: phase0.VoluntaryExit{ValidatorIndex: index}}
+}
+
+func TestPruneFinalized(t *testing.T) {
+	spec := configs.Mainnet
+	ctx := context.Background()
+	state, epc := benches.CreateTestState(64, spec.MAX_EFFECTIVE_BALANCE)
+
+	attesterSlashings := NewAttesterSlashingPool(spec)
+	proposerSlashings := NewProposerSlashingPool(spec)
+	exits := NewVoluntaryExitPool(spec)
+	for _, sl := range []*phase0.AttesterSlashing{attesterSlashing(1, 2), attesterSlashing(3), attesterSlashing(5)} {
+		if err := attesterSlashings.AddAttesterSlashing(ctx, sl); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, index := range []common.ValidatorIndex{1, 3, 5} {
+		if err := proposerSlashings.AddProposerSlashing(ctx, proposerSlashing(index)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, index := range []common.ValidatorIndex{4, 6} {
+		if err := exits.AddVoluntaryExit(ctx, voluntaryExit(index)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	chain := &testChain{finalized: &testEntry{epc: epc, state: state}}
+	pools := []FinalizedPruner{attesterSlashings, proposerSlashings, exits}
+	expectCounts := func(attester int, proposer int, exit int) {
+		t.Helper()
+		if got := len(attesterSlashings.All()); got != attester {
+			t.Errorf("expected %d attester slashings, got %d", attester, got)
+		}
+		if got := len(proposerSlashings.All()); got != proposer {
+			t.Errorf("expected %d proposer slashings, got %d", proposer, got)
+		}
+		if got := len(exits.All()); got != exit {
+			t.Errorf("expected %d voluntary exits, got %d", exit, got)
+		}
+	}
+
+	// Nothing is final yet.
+	if err := PruneFinalized(ctx, chain, pools...); err != nil {
+		t.Fatal(err)
+	}
+	expectCounts(3, 3, 2)
+
+	// Validators 1 and 3 are slashed and validator 4 exited in the finalized state,
+	// e.g. by operations that other proposers included.
+	vals, err := state.Validators()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, index := range []common.ValidatorIndex{1, 3} {
+		v, err := vals.Validator(index)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := v.MakeSlashed(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	v, err := vals.Validator(4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := v.SetExitEpoch(10); err != nil {
+		t.Fatal(err)
+	}
+	if err := PruneFinalized(ctx, chain, pools...); err != nil {
+		t.Fatal(err)
+	}
+	// The attester slashing of 1 and 2 can still slash validator 2.
+	expectCounts(2, 1, 1)
+	for _, sl := range proposerSlashings.All() {
+		if index := sl.SignedHeader1.Message.ProposerIndex; index != 5 {
+			t.Errorf("unexpected proposer slashing of validator %d", index)
+		}
+	}
+	if exit := exits.All()[0]; exit.Message.ValidatorIndex != 6 {
+		t.Errorf("unexpected exit of validator %d", exit.Message.ValidatorIndex)
+	}
+
+	// Packed operations are dropped as well.
+	packedAttester, err := attesterSlashings.Pack(epc, state, 1, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	packedProposer, err := proposerSlashings.Pack(epc, state, 1, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(packedAttester) != 1 || len(packedProposer) != 1 {
+		t.Fatalf("expected one packed slashing of each kind, got %d and %d", len(packedAttester), len(packedProposer))
+	}
+	expectCounts(1, 0, 1)
+}
+
+func TestPackTieBreak(t *testing.T) {
+	spec := configs.Mainnet
+	ctx := context.Background()
+	state, epc := benches.CreateTestState(64, spec.MAX_EFFECTIVE_BALANCE)
+
+	// All validators have the same balance, and thus the same whistleblower reward.
+	for i := 0; i < 10; i++ {
+		proposerSlashings := NewProposerSlashingPool(spec)
+		for _, index := range []common.ValidatorIndex{7, 3, 5, 9} {
+			if err := proposerSlashings.AddProposerSlashing(ctx, proposerSlashing(index)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		packed, err := proposerSlashings.Pack(epc, state, 2, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(packed) != 2 || packed[0].SignedHeader1.Message.ProposerIndex != 3 || packed[1].SignedHeader1.Message.ProposerIndex != 5 {
+			t.Fatalf("expected proposer slashings of validators 3 and 5")
+		}
+	}
+
+	slashings := []*phase0.AttesterSlashing{attesterSlashing(1), attesterSlashing(2), attesterSlashing(3), attesterSlashing(4)}
+	first := slashings[0]
+	for _, sl := range slashings[1:] {
+		root, firstRoot := sl.HashTreeRoot(spec, tree.GetHashFn()), first.HashTreeRoot(spec, tree.GetHashFn())
+		if bytes.Compare(root[:], firstRoot[:]) < 0 {
+			first = sl
+		}
+	}
+	for i := 0; i < 10; i++ {
+		attesterSlashings := NewAttesterSlashingPool(spec)
+		for _, sl := range slashings {
+			if err := attesterSlashings.AddAttesterSlashing(ctx, sl); err != nil {
+				t.Fatal(err)
+			}
+		}
+		packed, err := attesterSlashings.Pack(epc, state, 1, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(packed) != 1 || packed[0] != first {
+			t.Fatalf("expected the attester slashing with the lowest root")
+		}
+	}
+}
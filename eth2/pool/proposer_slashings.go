@@ -3,6 +3,7 @@ package pool
 import (
 	"context"
 	"fmt"
+	"sort"
 	"sync"
 
 	"github.com/protolambda/zrnt/eth2/beacon/common"
@@ -15,6 +16,9 @@ type ProposerSlashingPool struct {
 	slashings map[common.ValidatorIndex]*phase0.ProposerSlashing
 }
 
+// NewProposerSlashingPool creates an empty pool. The pool is not pruned by itself:
+// the chain must call PruneFinalized with the pool whenever its finalized checkpoint changes,
+// to drop the slashings that were included by other proposers.
 func NewProposerSlashingPool(spec *common.Spec) *ProposerSlashingPool {
 	return &ProposerSlashingPool{
 		spec:      spec,
@@ -44,9 +48,97 @@ func (psp *ProposerSlashingPool) All() []*phase0.ProposerSlashing {
 	return out
 }
 
-// Pack n slashings, removes the slashings from the pool. A reward estimator is used to pick the best slashings.
-// Slashings with negative rewards will not be packed.
-func (psp *ProposerSlashingPool) Pack(estReward func(sl *phase0.ProposerSlashing) int, n uint) []*phase0.ProposerSlashing {
-	// TODO
+// Pack up to n slashings that are valid in the given state, the state the block is built on.
+// Slashings are ranked by whistleblower reward, ties are broken by proposer index.
+// Packed slashings are removed from the pool.
+// Slashings of proposers that cannot be slashed anymore are removed from the pool as well.
+// Proposers for which exclude returns true are not packed, exclude may be nil.
+func (psp *ProposerSlashingPool) Pack(epc *common.EpochsContext, state common.BeaconState, n uint,
+	exclude func(index common.ValidatorIndex) bool) ([]*phase0.ProposerSlashing, error) {
+	psp.Lock()
+	defer psp.Unlock()
+	if max := uint(psp.spec.MAX_PROPOSER_SLASHINGS); n > max {
+		n = max
+	}
+	validators, err := state.Validators()
+	if err != nil {
+		return nil, err
+	}
+	type candidate struct {
+		index  common.ValidatorIndex
+		sl     *phase0.ProposerSlashing
+		reward common.Gwei
+	}
+	var candidates []candidate
+	for index, sl := range psp.slashings {
+		if exclude != nil && exclude(index) {
+			continue
+		}
+		if valid, err := validators.IsValidIndex(index); err != nil {
+			return nil, err
+		} else if !valid {
+			continue
+		}
+		v, err := validators.Validator(index)
+		if err != nil {
+			return nil, err
+		}
+		slashable, done, err := slashability(v, epc.CurrentEpoch.Epoch)
+		if err != nil {
+			return nil, err
+		}
+		if done {
+			delete(psp.slashings, index)
+		}
+		if !slashable {
+			continue
+		}
+		reward, err := whistleblowerReward(psp.spec, v)
+		if err != nil {
+			return nil, err
+		}
+		candidates = append(candidates, candidate{index, sl, reward})
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].reward != candidates[j].reward {
+			return candidates[i].reward > candidates[j].reward
+		}
+		return candidates[i].index < candidates[j].index
+	})
+	if uint(len(candidates)) > n {
+		candidates = candidates[:n]
+	}
+	out := make([]*phase0.ProposerSlashing, 0, len(candidates))
+	for _, c := range candidates {
+		out = append(out, c.sl)
+		delete(psp.slashings, c.index)
+	}
+	return out, nil
+}
+
+// OnFinalized removes the slashings of proposers that are already slashed or withdrawable in the finalized state.
+func (psp *ProposerSlashingPool) OnFinalized(epc *common.EpochsContext, state common.BeaconState) error {
+	psp.Lock()
+	defer psp.Unlock()
+	validators, err := state.Validators()
+	if err != nil {
+		return err
+	}
+	for index := range psp.slashings {
+		if valid, err := validators.IsValidIndex(index); err != nil {
+			return err
+		} else if !valid {
+			continue
+		}
+		v, err := validators.Validator(index)
+		if err != nil {
+			return err
+		}
+		if _, done, err := slashability(v, epc.CurrentEpoch.Epoch); err != nil {
+			return err
+		} else if done {
+			delete(psp.slashings, index)
+		}
+	}
 	return nil
 }
@@ -3,10 +3,12 @@ package pool
 import (
 	"context"
 	"fmt"
+	"sort"
 	"sync"
 
 	"github.com/protolambda/zrnt/eth2/beacon/altair"
 	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/protolambda/ztyp/view"
 )
 
 // beacon root -> subnet -> contributions
@@ -17,8 +19,8 @@ type SyncCommitteeMessages map[common.ValidatorIndex]*altair.SyncCommitteeMessag
 func (msgs SyncCommitteeMessages) Select(root common.Root, members []common.ValidatorIndex) []*altair.SyncCommitteeMessage {
 	out := make([]*altair.SyncCommitteeMessage, 0, len(members))
 	for _, vi := range members {
-		msg := msgs[vi]
-		if msg.BeaconBlockRoot == root {
+		msg, ok := msgs[vi]
+		if ok && msg.BeaconBlockRoot == root {
 			out = append(out, msg)
 		}
 	}
@@ -102,18 +104,140 @@ func (sp *SyncCommitteePool) AddSyncCommitteeMessage(ctx context.Context, msg *a
 	return nil
 }
 
+func (sp *SyncCommitteePool) slotMsgs(slot common.Slot) (SyncCommitteeMessages, error) {
+	if sp.currentSlot == slot+1 {
+		return sp.prevMsgs, nil
+	} else if sp.currentSlot == slot {
+		return sp.currentMsgs, nil
+	} else if sp.currentSlot+1 == slot {
+		return sp.nextMsgs, nil
+	}
+	return nil, fmt.Errorf("current sync committee pool is at slot %d, no messages for slot %d", sp.currentSlot, slot)
+}
+
+func (sp *SyncCommitteePool) slotContribs(slot common.Slot) (SyncCommitteeContributions, error) {
+	if sp.currentSlot == slot+1 {
+		return sp.prevContribs, nil
+	} else if sp.currentSlot == slot {
+		return sp.currentContribs, nil
+	} else if sp.currentSlot+1 == slot {
+		return sp.nextContribs, nil
+	}
+	return nil, fmt.Errorf("current sync committee pool is at slot %d, no contributions for slot %d", sp.currentSlot, slot)
+}
+
+// PackContribution aggregates the sync committee messages of the subcommittee members into a contribution.
+// The subcommittee lists the validator of each position, validators may occupy multiple positions.
+// An error is returned if there are no messages to aggregate.
 func (sp *SyncCommitteePool) PackContribution(ctx context.Context, slot common.Slot, beaconBlockRoot common.Root, subnet uint64, subComm []common.ValidatorIndex) (*altair.SyncCommitteeContribution, error) {
 	sp.Lock()
 	defer sp.Unlock()
-	// TODO: select messages & aggregate a contribution
-	return nil, nil
+	subSize := uint64(sp.spec.SYNC_COMMITTEE_SIZE) / common.SYNC_COMMITTEE_SUBNET_COUNT
+	if uint64(len(subComm)) != subSize {
+		return nil, fmt.Errorf("subcommittee has %d members, expected %d", len(subComm), subSize)
+	}
+	if subnet >= common.SYNC_COMMITTEE_SUBNET_COUNT {
+		return nil, fmt.Errorf("invalid subnet %d", subnet)
+	}
+	msgs, err := sp.slotMsgs(slot)
+	if err != nil {
+		return nil, err
+	}
+	bits := make(altair.SyncCommitteeSubnetBits, (subSize+7)/8)
+	// One signature per set bit: the pubkey of a validator is aggregated once per position in verification.
+	sigs := make([]common.BLSSignature, 0, subSize)
+	for i, vi := range subComm {
+		msg, ok := msgs[vi]
+		if ok && msg.Slot == slot && msg.BeaconBlockRoot == beaconBlockRoot {
+			bits.SetBit(uint64(i), true)
+			sigs = append(sigs, msg.Signature)
+		}
+	}
+	if len(sigs) == 0 {
+		return nil, fmt.Errorf("no sync committee messages for subnet %d, slot %d, root %s", subnet, slot, beaconBlockRoot)
+	}
+	sig, err := common.AggregateSignatures(sigs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to aggregate sync committee messages: %w", err)
+	}
+	return &altair.SyncCommitteeContribution{
+		Slot:              slot,
+		BeaconBlockRoot:   beaconBlockRoot,
+		SubcommitteeIndex: view.Uint64View(subnet),
+		AggregationBits:   bits,
+		Signature:         sig,
+	}, nil
 }
 
+// PackAggregate builds the sync aggregate for the given slot and block root, to include in a block of the next slot.
+// Per subnet, the largest contribution is combined with the other contributions that do not overlap with it,
+// and any remaining gaps are filled with individual messages.
+// If there is no participation at all, the aggregate has the G2_POINT_AT_INFINITY signature.
 func (sp *SyncCommitteePool) PackAggregate(ctx context.Context, slot common.Slot, beaconBlockRoot common.Root, syncCommittee []common.ValidatorIndex) (*altair.SyncAggregate, error) {
 	sp.Lock()
 	defer sp.Unlock()
-	// TODO: select complimentary subnet contributions, fill gaps with any individual messages, and return it
-	return nil, nil
+	spec := sp.spec
+	size := uint64(spec.SYNC_COMMITTEE_SIZE)
+	if uint64(len(syncCommittee)) != size {
+		return nil, fmt.Errorf("sync committee has %d members, expected %d", len(syncCommittee), size)
+	}
+	msgs, err := sp.slotMsgs(slot)
+	if err != nil {
+		return nil, err
+	}
+	contribs, err := sp.slotContribs(slot)
+	if err != nil {
+		return nil, err
+	}
+	subSize := size / common.SYNC_COMMITTEE_SUBNET_COUNT
+	bits := make(altair.SyncCommitteeBits, (size+7)/8)
+	var sigs []common.BLSSignature
+	for subnet := uint64(0); subnet < common.SYNC_COMMITTEE_SUBNET_COUNT; subnet++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		offset := subnet * subSize
+		// largest contributions first
+		candidates := append([]*SubnetContrib(nil), contribs[beaconBlockRoot][subnet]...)
+		sort.SliceStable(candidates, func(i, j int) bool {
+			return candidates[i].AggregationBits.OnesCount() > candidates[j].AggregationBits.OnesCount()
+		})
+	contribLoop:
+		for _, c := range candidates {
+			if uint64(len(c.AggregationBits))*8 < subSize {
+				continue
+			}
+			for i := uint64(0); i < subSize; i++ {
+				if c.AggregationBits.GetBit(i) && bits.GetBit(offset+i) {
+					continue contribLoop
+				}
+			}
+			for i := uint64(0); i < subSize; i++ {
+				if c.AggregationBits.GetBit(i) {
+					bits.SetBit(offset+i, true)
+				}
+			}
+			sigs = append(sigs, c.Signature)
+		}
+		for i := uint64(0); i < subSize; i++ {
+			if bits.GetBit(offset + i) {
+				continue
+			}
+			msg, ok := msgs[syncCommittee[offset+i]]
+			if ok && msg.Slot == slot && msg.BeaconBlockRoot == beaconBlockRoot {
+				bits.SetBit(offset+i, true)
+				sigs = append(sigs, msg.Signature)
+			}
+		}
+	}
+	if len(sigs) == 0 {
+		return &altair.SyncAggregate{SyncCommitteeBits: bits, SyncCommitteeSignature: common.G2_POINT_AT_INFINITY}, nil
+	}
+	sig, err := common.AggregateSignatures(sigs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to aggregate sync committee signatures: %w", err)
+	}
+	return &altair.SyncAggregate{SyncCommitteeBits: bits, SyncCommitteeSignature: sig}, nil
 }
 
 func (sp *SyncCommitteePool) Reset(slot common.Slot) {
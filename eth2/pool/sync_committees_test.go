@@ -0,0 +1,213 @@
+package pool
+
+import (
+	"context"
+	"math/big"
+	"testing"
+
+	blsu "github.com/protolambda/bls12-381-util"
+
+	"github.com/protolambda/zrnt/eth2/beacon/altair"
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/protolambda/zrnt/eth2/configs"
+	"github.com/protolambda/ztyp/view"
+)
+
+// testSyncCommittee has 16 validators, each occupying a position in two of the subnets of the minimal sync committee.
+type testSyncCommittee struct {
+	t       *testing.T
+	keys    []blsu.SecretKey
+	pubs    []*blsu.Pubkey
+	members []common.ValidatorIndex
+}
+
+func newTestSyncCommittee(t *testing.T) *testSyncCommittee {
+	c := &testSyncCommittee{t: t}
+	c.keys = make([]blsu.SecretKey, 16)
+	c.pubs = make([]*blsu.Pubkey, 16)
+	for i := range c.keys {
+		var raw [32]byte
+		big.NewInt(int64(i + 1)).FillBytes(raw[:])
+		if err := c.keys[i].Deserialize(&raw); err != nil {
+			t.Fatal(err)
+		}
+		pub, err := blsu.SkToPk(&c.keys[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.pubs[i] = pub
+	}
+	c.members = make([]common.ValidatorIndex, configs.Minimal.SYNC_COMMITTEE_SIZE)
+	for i := range c.members {
+		c.members[i] = common.ValidatorIndex(i % len(c.keys))
+	}
+	return c
+}
+
+func (c *testSyncCommittee) subcommittee(subnet uint64) []common.ValidatorIndex {
+	subSize := uint64(len(c.members)) / common.SYNC_COMMITTEE_SUBNET_COUNT
+	return c.members[subnet*subSize : (subnet+1)*subSize]
+}
+
+func (c *testSyncCommittee) message(slot common.Slot, root common.Root, index common.ValidatorIndex) *altair.SyncCommitteeMessage {
+	return &altair.SyncCommitteeMessage{
+		Slot:            slot,
+		BeaconBlockRoot: root,
+		ValidatorIndex:  index,
+		Signature:       blsu.Sign(&c.keys[index], root[:]).Serialize(),
+	}
+}
+
+func (c *testSyncCommittee) contribution(slot common.Slot, root common.Root, subnet uint64, positions ...uint64) *altair.SyncCommitteeContribution {
+	subComm := c.subcommittee(subnet)
+	bits := make(altair.SyncCommitteeSubnetBits, (len(subComm)+7)/8)
+	sigs := make([]*blsu.Signature, 0, len(positions))
+	for _, p := range positions {
+		bits.SetBit(p, true)
+		sigs = append(sigs, blsu.Sign(&c.keys[subComm[p]], root[:]))
+	}
+	sig, err := blsu.Aggregate(sigs)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	return &altair.SyncCommitteeContribution{
+		Slot:              slot,
+		BeaconBlockRoot:   root,
+		SubcommitteeIndex: view.Uint64View(subnet),
+		AggregationBits:   bits,
+		Signature:         sig.Serialize(),
+	}
+}
+
+// verify checks the signature against the pubkeys of the given members, one pubkey per participating position.
+func (c *testSyncCommittee) verify(root common.Root, members []common.ValidatorIndex, sig common.BLSSignature) bool {
+	pubs := make([]*blsu.Pubkey, 0, len(members))
+	for _, vi := range members {
+		pubs = append(pubs, c.pubs[vi])
+	}
+	var s blsu.Signature
+	if err := s.Deserialize((*[96]byte)(&sig)); err != nil {
+		c.t.Fatal(err)
+	}
+	return blsu.FastAggregateVerify(pubs, root[:], &s)
+}
+
+func TestPackContribution(t *testing.T) {
+	ctx := context.Background()
+	spec := configs.Minimal
+	c := newTestSyncCommittee(t)
+	sp := NewSyncCommitteePool(spec)
+	sp.Reset(5)
+	root := common.Root{1}
+	for _, msg := range []*altair.SyncCommitteeMessage{
+		c.message(5, root, 0),
+		c.message(5, root, 2),
+		c.message(5, root, 3),
+		// other block root
+		c.message(5, common.Root{2}, 4),
+		// next slot
+		c.message(6, root, 5),
+	} {
+		if err := sp.AddSyncCommitteeMessage(ctx, msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Validators 0 to 7 are members of both subnet 0 and 2.
+	for _, subnet := range []uint64{0, 2} {
+		contrib, err := sp.PackContribution(ctx, 5, root, subnet, c.subcommittee(subnet))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if contrib.Slot != 5 || contrib.BeaconBlockRoot != root || uint64(contrib.SubcommitteeIndex) != subnet {
+			t.Errorf("subnet %d: unexpected contribution slot %d, root %s, subnet %d",
+				subnet, contrib.Slot, contrib.BeaconBlockRoot, contrib.SubcommitteeIndex)
+		}
+		for i := uint64(0); i < 8; i++ {
+			if expected := i == 0 || i == 2 || i == 3; contrib.AggregationBits.GetBit(i) != expected {
+				t.Errorf("subnet %d: expected bit %d to be %v", subnet, i, expected)
+			}
+		}
+		if !c.verify(root, []common.ValidatorIndex{0, 2, 3}, contrib.Signature) {
+			t.Errorf("subnet %d: contribution signature does not verify", subnet)
+		}
+	}
+
+	if _, err := sp.PackContribution(ctx, 5, root, 1, c.subcommittee(1)); err == nil {
+		t.Error("expected error for subnet without messages")
+	}
+	if _, err := sp.PackContribution(ctx, 5, root, 0, c.subcommittee(0)[:4]); err == nil {
+		t.Error("expected error for subcommittee of the wrong size")
+	}
+	if _, err := sp.PackContribution(ctx, 8, root, 0, c.subcommittee(0)); err == nil {
+		t.Error("expected error for slot that is not buffered")
+	}
+}
+
+func TestPackAggregate(t *testing.T) {
+	ctx := context.Background()
+	spec := configs.Minimal
+	c := newTestSyncCommittee(t)
+	sp := NewSyncCommitteePool(spec)
+	sp.Reset(5)
+	root := common.Root{1}
+
+	empty, err := sp.PackAggregate(ctx, 5, root, c.members)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if empty.SyncCommitteeBits.OnesCount() != 0 || empty.SyncCommitteeSignature != common.G2_POINT_AT_INFINITY {
+		t.Errorf("expected empty aggregate, got bits %s, signature %s", empty.SyncCommitteeBits, empty.SyncCommitteeSignature)
+	}
+
+	for _, contrib := range []*altair.SyncCommitteeContribution{
+		// validators 0, 1, 2: the largest contribution of subnet 0
+		c.contribution(5, root, 0, 0, 1, 2),
+		// validators 2, 3: overlaps with the largest contribution, and must not be counted twice
+		c.contribution(5, root, 0, 2, 3),
+		// validators 4, 5: combined with the largest contribution
+		c.contribution(5, root, 0, 4, 5),
+		// validators 8, 9 in subnet 1, for another block root
+		c.contribution(5, common.Root{2}, 1, 0, 1),
+	} {
+		if err := sp.AddSyncCommitteeContribution(ctx, contrib); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, msg := range []*altair.SyncCommitteeMessage{
+		// fills the gap of validator 3 in subnet 0, and its position in subnet 2
+		c.message(5, root, 3),
+		// fills the gap of validator 6 in subnet 0, and its position in subnet 2
+		c.message(5, root, 6),
+		// already covered by a contribution in subnet 0, fills its position in subnet 2
+		c.message(5, root, 0),
+		// other block root
+		c.message(5, common.Root{2}, 9),
+	} {
+		if err := sp.AddSyncCommitteeMessage(ctx, msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	agg, err := sp.PackAggregate(ctx, 5, root, c.members)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[uint64]bool{0: true, 1: true, 2: true, 3: true, 4: true, 5: true, 6: true, 16: true, 19: true, 22: true}
+	var participants []common.ValidatorIndex
+	for i := uint64(0); i < uint64(len(c.members)); i++ {
+		if agg.SyncCommitteeBits.GetBit(i) != expected[i] {
+			t.Errorf("expected bit %d to be %v", i, expected[i])
+		}
+		if expected[i] {
+			participants = append(participants, c.members[i])
+		}
+	}
+	if !c.verify(root, participants, agg.SyncCommitteeSignature) {
+		t.Error("sync aggregate signature does not verify")
+	}
+
+	if _, err := sp.PackAggregate(ctx, 5, root, c.members[:16]); err == nil {
+		t.Error("expected error for sync committee of the wrong size")
+	}
+}
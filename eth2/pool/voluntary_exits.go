@@ -3,6 +3,7 @@ package pool
 import (
 	"context"
 	"fmt"
+	"sort"
 	"sync"
 
 	"github.com/protolambda/zrnt/eth2/beacon/common"
@@ -15,6 +16,9 @@ type VoluntaryExitPool struct {
 	exits map[common.ValidatorIndex]*phase0.SignedVoluntaryExit
 }
 
+// NewVoluntaryExitPool creates an empty pool. The pool is not pruned by itself:
+// the chain must call PruneFinalized with the pool whenever its finalized checkpoint changes,
+// to drop the exits that were included by other proposers.
 func NewVoluntaryExitPool(spec *common.Spec) *VoluntaryExitPool {
 	return &VoluntaryExitPool{
 		spec:  spec,
@@ -43,9 +47,96 @@ func (vep *VoluntaryExitPool) All() []*phase0.SignedVoluntaryExit {
 	return out
 }
 
-// Pack n exits, removes the exits from the pool. A ranking function is used to pick the best exits.
-// Exits with negative rank function outputs will not be packed.
-func (vep *VoluntaryExitPool) Pack(rank func(sl *phase0.SignedVoluntaryExit) int, n uint) []*phase0.SignedVoluntaryExit {
-	// TODO
+// exitability checks if the exit can be processed in a state of the given epoch, excluding the signature check.
+// If not, done tells if the exit can never be processed anymore, i.e. the validator already initiated an exit.
+func exitability(spec *common.Spec, epoch common.Epoch, validators common.ValidatorRegistry,
+	exit *phase0.VoluntaryExit) (ok bool, done bool, err error) {
+	if valid, err := validators.IsValidIndex(exit.ValidatorIndex); err != nil || !valid {
+		return false, false, err
+	}
+	v, err := validators.Validator(exit.ValidatorIndex)
+	if err != nil {
+		return false, false, err
+	}
+	if exitEpoch, err := v.ExitEpoch(); err != nil {
+		return false, false, err
+	} else if exitEpoch != common.FAR_FUTURE_EPOCH {
+		return false, true, nil
+	}
+	activationEpoch, err := v.ActivationEpoch()
+	if err != nil {
+		return false, false, err
+	}
+	// The validator must be active, for at least SHARD_COMMITTEE_PERIOD,
+	// and the exit must not be for a future epoch.
+	if activationEpoch > epoch || epoch < exit.Epoch || epoch < activationEpoch+spec.SHARD_COMMITTEE_PERIOD {
+		return false, false, nil
+	}
+	return true, false, nil
+}
+
+// Pack up to n exits that are valid in the given state, the state the block is built on.
+// Exits are packed in order of exit epoch and validator index, packed exits are removed from the pool.
+// Exits of validators that already initiated an exit are removed from the pool as well.
+// Validators for which exclude returns true are not packed, exclude may be nil.
+// Use exclude to skip validators that are slashed in the same block, their exit would be invalid.
+func (vep *VoluntaryExitPool) Pack(epc *common.EpochsContext, state common.BeaconState, n uint,
+	exclude func(index common.ValidatorIndex) bool) ([]*phase0.SignedVoluntaryExit, error) {
+	vep.Lock()
+	defer vep.Unlock()
+	if max := uint(vep.spec.MAX_VOLUNTARY_EXITS); n > max {
+		n = max
+	}
+	validators, err := state.Validators()
+	if err != nil {
+		return nil, err
+	}
+	var candidates []*phase0.SignedVoluntaryExit
+	for index, exit := range vep.exits {
+		if exclude != nil && exclude(index) {
+			continue
+		}
+		ok, done, err := exitability(vep.spec, epc.CurrentEpoch.Epoch, validators, &exit.Message)
+		if err != nil {
+			return nil, err
+		}
+		if done {
+			delete(vep.exits, index)
+		}
+		if ok {
+			candidates = append(candidates, exit)
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		a, b := &candidates[i].Message, &candidates[j].Message
+		if a.Epoch != b.Epoch {
+			return a.Epoch < b.Epoch
+		}
+		return a.ValidatorIndex < b.ValidatorIndex
+	})
+	if uint(len(candidates)) > n {
+		candidates = candidates[:n]
+	}
+	for _, exit := range candidates {
+		delete(vep.exits, exit.Message.ValidatorIndex)
+	}
+	return candidates, nil
+}
+
+// OnFinalized removes the exits of validators that already initiated an exit in the finalized state.
+func (vep *VoluntaryExitPool) OnFinalized(epc *common.EpochsContext, state common.BeaconState) error {
+	vep.Lock()
+	defer vep.Unlock()
+	validators, err := state.Validators()
+	if err != nil {
+		return err
+	}
+	for index, exit := range vep.exits {
+		if _, done, err := exitability(vep.spec, epc.CurrentEpoch.Epoch, validators, &exit.Message); err != nil {
+			return err
+		} else if done {
+			delete(vep.exits, index)
+		}
+	}
 	return nil
 }
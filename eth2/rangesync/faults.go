@@ -0,0 +1,78 @@
+package rangesync
+
+import (
+	"fmt"
+
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+)
+
+// PeerID identifies the peer that served a batch. The format is up to the transport.
+type PeerID string
+
+// Fault classifies why a batch was rejected.
+type Fault uint8
+
+const (
+	// NoFault is used for local errors, e.g. a cancelled context or a failing backend.
+	// The peer that served the batch should not be penalized.
+	NoFault Fault = iota
+	// FaultBadRange: a block is outside of the requested slot range, or blocks are not in ascending slot order.
+	FaultBadRange
+	// FaultBrokenLinkage: a block does not build on the previous block.
+	FaultBrokenLinkage
+	// FaultFinalizedConflict: the blocks do not descend from the finalized checkpoint.
+	FaultFinalizedConflict
+	// FaultInvalidSignature: a block proposer signature, or a signature of the block contents, is invalid.
+	FaultInvalidSignature
+	// FaultInvalidBlock: a block failed the state transition, or does not match the fork of its slot.
+	FaultInvalidBlock
+	// FaultMissingBlobs: blob sidecars of a block within the blob retention window are missing.
+	FaultMissingBlobs
+	// FaultInvalidBlobs: a blob sidecar does not match its block, or fails verification.
+	FaultInvalidBlobs
+)
+
+func (f Fault) String() string {
+	switch f {
+	case NoFault:
+		return "no fault"
+	case FaultBadRange:
+		return "bad range"
+	case FaultBrokenLinkage:
+		return "broken linkage"
+	case FaultFinalizedConflict:
+		return "finalized conflict"
+	case FaultInvalidSignature:
+		return "invalid signature"
+	case FaultInvalidBlock:
+		return "invalid block"
+	case FaultMissingBlobs:
+		return "missing blobs"
+	case FaultInvalidBlobs:
+		return "invalid blobs"
+	default:
+		return fmt.Sprintf("unknown fault %d", uint8(f))
+	}
+}
+
+// BatchError is returned when a batch could not be imported, and attributes the failure to the peer that served it.
+type BatchError struct {
+	// Peer that served the batch.
+	Peer PeerID
+	// Fault of the peer, NoFault if the error is local.
+	Fault Fault
+	// Slot of the offending block, or the start slot of the batch if no single block is at fault.
+	Slot common.Slot
+	// PrevPeer is set when the first block of the batch does not build on the previous batch:
+	// the previous batch may have been served from a different fork, and is suspect as well.
+	PrevPeer PeerID
+	Err      error
+}
+
+func (e *BatchError) Error() string {
+	return fmt.Sprintf("batch from peer %q rejected at slot %d (%s): %v", e.Peer, e.Slot, e.Fault, e.Err)
+}
+
+func (e *BatchError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,499 @@
+package rangesync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	blsu "github.com/protolambda/bls12-381-util"
+	"github.com/protolambda/ztyp/tree"
+
+	"github.com/protolambda/zrnt/eth2/beacon"
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/protolambda/zrnt/eth2/beacon/deneb"
+)
+
+// Batch is the response to a range request: the blocks, and blob sidecars, of the slots
+// [StartSlot, StartSlot + Count), as served by a single peer.
+type Batch struct {
+	Peer      PeerID
+	StartSlot common.Slot
+	Count     uint64
+	// Blocks, in ascending slot order. Empty slots have no block.
+	Blocks []*common.BeaconBlockEnvelope
+	// BlobSidecars of the blocks, in any order.
+	BlobSidecars []*deneb.BlobSidecar
+}
+
+// EndSlot returns the first slot after the range of the batch.
+func (b *Batch) EndSlot() common.Slot {
+	return b.StartSlot + common.Slot(b.Count)
+}
+
+type Backend interface {
+	// CurrentSlot is used to determine which blocks are within the blob retention window.
+	CurrentSlot() common.Slot
+
+	// VerifyBlobKZGProofBatch checks that the blobs match the commitments, with the given proofs.
+	// I.e. verify_blob_kzg_proof_batch(blobs, commitments, proofs).
+	VerifyBlobKZGProofBatch(blobs []deneb.Blob, commitments []common.KZGCommitment, proofs []common.KZGProof) error
+
+	// ImportBlock is called for every block that passed the state transition, in order,
+	// with the blob sidecars of the block (sorted by index), and the post-state.
+	// The state and epochs-context must not be modified.
+	ImportBlock(ctx context.Context, benv *common.BeaconBlockEnvelope, blobs []*deneb.BlobSidecar,
+		post common.BeaconState, epc *common.EpochsContext) error
+}
+
+// Progress is a snapshot of the sync progress.
+type Progress struct {
+	HeadSlot common.Slot
+	HeadRoot common.Root
+	// NextSlot is the start slot of the next batch to import.
+	NextSlot common.Slot
+	// QueuedBatches is the number of batches that are waiting for preceding batches to be imported.
+	QueuedBatches        int
+	ImportedBlocks       uint64
+	ImportedBlobSidecars uint64
+}
+
+// Syncer imports batches of blocks on top of an anchor state, in order of slot ranges.
+// Batches may be added out of order, e.g. when downloaded from multiple peers in parallel,
+// and are imported once all preceding slots have been imported.
+//
+// Before a batch is imported, the blocks are checked to be within the requested range,
+// to link to each other and to the current head, and to descend from the finalized checkpoint.
+// Blob sidecars are matched to their blocks and verified.
+// The state transition of the blocks runs without signature checks: the proposer, RANDAO, attestation
+// and sync aggregate signatures of the whole batch are collected, and verified in a single batch check,
+// before any block of the batch is imported. Other operations, like slashings and exits, are rare,
+// and verified by the state transition.
+type Syncer struct {
+	spec      *common.Spec
+	backend   Backend
+	finalized common.Checkpoint
+
+	// import state, only accessed by ImportReady
+	importLock sync.Mutex
+	state      *beacon.StandardUpgradeableBeaconState
+	epc        *common.EpochsContext
+	genValRoot common.Root
+
+	// queue and progress
+	mu         sync.Mutex
+	pending    map[common.Slot]*Batch
+	nextSlot   common.Slot
+	headSlot   common.Slot
+	headRoot   common.Root
+	lastPeer   PeerID
+	blocks     uint64
+	blobs      uint64
+	peerFaults map[PeerID]uint64
+}
+
+// NewSyncer creates a syncer that imports blocks on top of the anchor, the post-state of the latest imported block.
+// The epochs-context of the anchor is created if epc is nil.
+// All imported blocks must descend from the finalized checkpoint, e.g. the local finalized checkpoint,
+// or a trusted weak subjectivity checkpoint.
+func NewSyncer(spec *common.Spec, backend Backend, anchor common.BeaconState,
+	epc *common.EpochsContext, finalized common.Checkpoint) (*Syncer, error) {
+	state, err := anchor.CopyState()
+	if err != nil {
+		return nil, err
+	}
+	if epc == nil {
+		epc, err = common.NewEpochsContext(spec, state)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create epochs context of anchor: %w", err)
+		}
+	} else {
+		epc = epc.Clone()
+	}
+	header, err := state.LatestBlockHeader()
+	if err != nil {
+		return nil, err
+	}
+	// The latest header does not have the state root filled in yet, until the next slot is processed.
+	if header.StateRoot == (common.Root{}) {
+		header.StateRoot = state.HashTreeRoot(tree.GetHashFn())
+	}
+	slot, err := state.Slot()
+	if err != nil {
+		return nil, err
+	}
+	genValRoot, err := state.GenesisValidatorsRoot()
+	if err != nil {
+		return nil, err
+	}
+	return &Syncer{
+		spec:       spec,
+		backend:    backend,
+		finalized:  finalized,
+		state:      &beacon.StandardUpgradeableBeaconState{BeaconState: state},
+		epc:        epc,
+		genValRoot: genValRoot,
+		pending:    make(map[common.Slot]*Batch),
+		nextSlot:   slot + 1,
+		headSlot:   header.Slot,
+		headRoot:   header.HashTreeRoot(tree.GetHashFn()),
+		peerFaults: make(map[PeerID]uint64),
+	}, nil
+}
+
+// Progress returns a snapshot of the sync progress.
+func (s *Syncer) Progress() Progress {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return Progress{
+		HeadSlot:             s.headSlot,
+		HeadRoot:             s.headRoot,
+		NextSlot:             s.nextSlot,
+		QueuedBatches:        len(s.pending),
+		ImportedBlocks:       s.blocks,
+		ImportedBlobSidecars: s.blobs,
+	}
+}
+
+// PeerFaults returns the number of rejected batches per peer, excluding rejections without peer fault.
+func (s *Syncer) PeerFaults() map[PeerID]uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make(map[PeerID]uint64, len(s.peerFaults))
+	for p, n := range s.peerFaults {
+		out[p] = n
+	}
+	return out
+}
+
+// AddBatch queues a batch for import. The range of the batch may not be empty, may not start before NextSlot,
+// and may not overlap with any queued batch.
+func (s *Syncer) AddBatch(b *Batch) error {
+	if b.Count == 0 {
+		return errors.New("batch has empty range")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if b.StartSlot < s.nextSlot {
+		return fmt.Errorf("batch starts at slot %d, before next slot %d", b.StartSlot, s.nextSlot)
+	}
+	for _, p := range s.pending {
+		if b.StartSlot < p.EndSlot() && p.StartSlot < b.EndSlot() {
+			return fmt.Errorf("batch [%d, %d) overlaps with queued batch [%d, %d)",
+				b.StartSlot, b.EndSlot(), p.StartSlot, p.EndSlot())
+		}
+	}
+	s.pending[b.StartSlot] = b
+	return nil
+}
+
+// ImportReady imports the queued batches that continue from NextSlot, in order.
+// If a batch is rejected, a *BatchError is returned and the batch is dropped:
+// its remaining range, from the updated NextSlot, has to be requested again.
+// ImportReady must not be called concurrently.
+func (s *Syncer) ImportReady(ctx context.Context) error {
+	s.importLock.Lock()
+	defer s.importLock.Unlock()
+	for {
+		s.mu.Lock()
+		b, ok := s.pending[s.nextSlot]
+		if ok {
+			delete(s.pending, b.StartSlot)
+		}
+		s.mu.Unlock()
+		if !ok {
+			return nil
+		}
+		if err := s.importBatch(ctx, b); err != nil {
+			s.mu.Lock()
+			if err.Fault != NoFault {
+				s.peerFaults[err.Peer] += 1
+			}
+			s.mu.Unlock()
+			return err
+		}
+	}
+}
+
+func (s *Syncer) importBatch(ctx context.Context, b *Batch) *BatchError {
+	s.mu.Lock()
+	headSlot, headRoot, prevPeer := s.headSlot, s.headRoot, s.lastPeer
+	s.mu.Unlock()
+
+	if err := s.checkLinkage(b, headSlot, headRoot); err != nil {
+		if err.Fault == FaultBrokenLinkage && len(b.Blocks) > 0 && err.Slot == b.Blocks[0].Slot {
+			err.PrevPeer = prevPeer
+		}
+		return err
+	}
+	blobs, err := s.matchBlobs(b)
+	if err != nil {
+		return err
+	}
+	if err := s.checkForkDigests(b); err != nil {
+		return err
+	}
+
+	var sigs batchSignatures
+	processed, procErr := s.processBlocks(ctx, b, &sigs)
+	if procErr != nil {
+		procErr.Peer = b.Peer
+	}
+	// The blocks are only imported if all their signatures are valid, the batch is rejected otherwise.
+	if err := sigs.verify(b); err != nil {
+		return err
+	}
+	for _, p := range processed {
+		if err := s.backend.ImportBlock(ctx, p.benv, blobs[p.benv.BlockRoot], p.state.BeaconState, p.epc); err != nil {
+			procErr = &BatchError{Peer: b.Peer, Fault: NoFault, Slot: p.benv.Slot, Err: fmt.Errorf("failed to import block: %w", err)}
+			break
+		}
+		s.state = p.state
+		s.epc = p.epc
+		s.mu.Lock()
+		s.headSlot = p.benv.Slot
+		s.headRoot = p.benv.BlockRoot
+		s.blocks += 1
+		s.blobs += uint64(len(blobs[p.benv.BlockRoot]))
+		s.mu.Unlock()
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if procErr != nil {
+		// Continue after the last imported block when the range is requested again.
+		if s.headSlot >= b.StartSlot {
+			s.nextSlot = s.headSlot + 1
+		}
+		return procErr
+	}
+	// Empty slots at the end of the range are processed when the next block is imported.
+	s.nextSlot = b.EndSlot()
+	s.lastPeer = b.Peer
+	return nil
+}
+
+// checkLinkage checks that the blocks are within the range of the batch, in order,
+// build on the head and each other, and descend from the finalized checkpoint.
+func (s *Syncer) checkLinkage(b *Batch, headSlot common.Slot, headRoot common.Root) *BatchError {
+	fault := func(f Fault, slot common.Slot, err error) *BatchError {
+		return &BatchError{Peer: b.Peer, Fault: f, Slot: slot, Err: err}
+	}
+	finSlot, err := s.spec.EpochStartSlot(s.finalized.Epoch)
+	if err != nil {
+		return fault(NoFault, b.StartSlot, err)
+	}
+	prevSlot, prevRoot := headSlot, headRoot
+	for i, benv := range b.Blocks {
+		if benv.Slot < b.StartSlot || benv.Slot >= b.EndSlot() {
+			return fault(FaultBadRange, benv.Slot, fmt.Errorf("block %d is outside of range [%d, %d)", i, b.StartSlot, b.EndSlot()))
+		}
+		if benv.Slot <= prevSlot {
+			return fault(FaultBadRange, benv.Slot, fmt.Errorf("block %d is not after previous block slot %d", i, prevSlot))
+		}
+		if benv.ParentRoot != prevRoot {
+			return fault(FaultBrokenLinkage, benv.Slot, fmt.Errorf("block %d has parent %s, expected %s", i, benv.ParentRoot, prevRoot))
+		}
+		// The latest block at or before the finalized slot must be the finalized block.
+		if prevSlot <= finSlot && benv.Slot > finSlot && prevRoot != s.finalized.Root {
+			return fault(FaultFinalizedConflict, benv.Slot, fmt.Errorf("block %d does not descend from finalized checkpoint %s", i, s.finalized))
+		}
+		prevSlot, prevRoot = benv.Slot, benv.BlockRoot
+	}
+	// The range may cover the finalized slot without any later block in it.
+	if prevSlot <= finSlot && b.EndSlot() > finSlot && prevRoot != s.finalized.Root {
+		return fault(FaultFinalizedConflict, b.StartSlot, fmt.Errorf("blocks up to slot %d do not descend from finalized checkpoint %s", finSlot, s.finalized))
+	}
+	return nil
+}
+
+// matchBlobs groups the blob sidecars by block root and verifies them against their blocks.
+// Blocks within the blob retention window must have all their blob sidecars.
+func (s *Syncer) matchBlobs(b *Batch) (map[common.Root][]*deneb.BlobSidecar, *BatchError) {
+	fault := func(f Fault, slot common.Slot, err error) *BatchError {
+		return &BatchError{Peer: b.Peer, Fault: f, Slot: slot, Err: err}
+	}
+	byRoot := make(map[common.Root][]*deneb.BlobSidecar)
+	for _, sidecar := range b.BlobSidecars {
+		root := sidecar.BlockRoot()
+		byRoot[root] = append(byRoot[root], sidecar)
+	}
+	currentEpoch := s.spec.SlotToEpoch(s.backend.CurrentSlot())
+	var (
+		blobs       []deneb.Blob
+		commitments []common.KZGCommitment
+		proofs      []common.KZGProof
+	)
+	matched := 0
+	for _, benv := range b.Blocks {
+		sidecars := byRoot[benv.BlockRoot]
+		body, ok := benv.Body.(*deneb.BeaconBlockBody)
+		if !ok {
+			if len(sidecars) > 0 {
+				return nil, fault(FaultInvalidBlobs, benv.Slot, errors.New("blob sidecars for block without blob commitments"))
+			}
+			continue
+		}
+		expected := body.GetBlobKZGCommitments()
+		if len(sidecars) == 0 {
+			if len(expected) > 0 && s.spec.SlotToEpoch(benv.Slot)+common.Epoch(s.spec.MIN_EPOCHS_FOR_BLOB_SIDECARS_REQUESTS) >= currentEpoch {
+				return nil, fault(FaultMissingBlobs, benv.Slot, fmt.Errorf("missing %d blob sidecars", len(expected)))
+			}
+			continue
+		}
+		if len(sidecars) != len(expected) {
+			return nil, fault(FaultMissingBlobs, benv.Slot, fmt.Errorf("got %d blob sidecars, expected %d", len(sidecars), len(expected)))
+		}
+		sort.Slice(sidecars, func(i, j int) bool {
+			return sidecars[i].Index < sidecars[j].Index
+		})
+		for i, sidecar := range sidecars {
+			if uint64(sidecar.Index) != uint64(i) {
+				return nil, fault(FaultInvalidBlobs, benv.Slot, fmt.Errorf("unexpected blob sidecar index %d", sidecar.Index))
+			}
+			if sidecar.KZGCommitment != expected[i] {
+				return nil, fault(FaultInvalidBlobs, benv.Slot, fmt.Errorf("blob sidecar %d does not match block commitment", i))
+			}
+			if sidecar.SignedBlockHeader.Signature != benv.Signature {
+				return nil, fault(FaultInvalidBlobs, benv.Slot, fmt.Errorf("blob sidecar %d has different block signature", i))
+			}
+			if err := sidecar.VerifyInclusionProof(s.spec); err != nil {
+				return nil, fault(FaultInvalidBlobs, benv.Slot, fmt.Errorf("blob sidecar %d: %w", i, err))
+			}
+			blobs = append(blobs, sidecar.Blob)
+			commitments = append(commitments, sidecar.KZGCommitment)
+			proofs = append(proofs, sidecar.KZGProof)
+		}
+		matched += len(sidecars)
+	}
+	if matched != len(b.BlobSidecars) {
+		return nil, fault(FaultInvalidBlobs, b.StartSlot, fmt.Errorf("%d blob sidecars do not belong to any block", len(b.BlobSidecars)-matched))
+	}
+	if len(blobs) > 0 {
+		if err := s.backend.VerifyBlobKZGProofBatch(blobs, commitments, proofs); err != nil {
+			return nil, fault(FaultInvalidBlobs, b.StartSlot, fmt.Errorf("invalid blob KZG proofs: %w", err))
+		}
+	}
+	return byRoot, nil
+}
+
+// checkForkDigests checks that the fork digest of each block matches the fork of its slot.
+func (s *Syncer) checkForkDigests(b *Batch) *BatchError {
+	for _, benv := range b.Blocks {
+		if digest := common.ComputeForkDigest(s.spec.ForkVersion(benv.Slot), s.genValRoot); benv.ForkDigest != digest {
+			return &BatchError{Peer: b.Peer, Fault: FaultInvalidBlock, Slot: benv.Slot,
+				Err: fmt.Errorf("block has fork digest %s, expected %s", benv.ForkDigest, digest)}
+		}
+	}
+	return nil
+}
+
+// batchSignatures collects the signatures of the blocks of a batch, to verify them all at once.
+// The signatures are also kept per block, to find the offending block if the batch is invalid.
+type batchSignatures struct {
+	batch  blsu.SignatureSet
+	blocks []blsu.SignatureSet
+}
+
+var _ common.SignatureCollector = (*batchSignatures)(nil)
+
+// nextBlock starts collecting the signatures of the next block.
+func (bs *batchSignatures) nextBlock() {
+	bs.blocks = append(bs.blocks, blsu.SignatureSet{})
+}
+
+func (bs *batchSignatures) Add(pubkey *blsu.Pubkey, message []byte, signature *blsu.Signature) {
+	bs.batch.Add(pubkey, message, signature)
+	bs.blocks[len(bs.blocks)-1].Add(pubkey, message, signature)
+}
+
+// verify verifies the signatures of all processed blocks in a single batch check.
+// If the batch check fails, the signatures are checked per block, to find the offending block.
+func (bs *batchSignatures) verify(b *Batch) *BatchError {
+	if bs.batch.Verify() {
+		return nil
+	}
+	for i := range bs.blocks {
+		if !bs.blocks[i].Verify() {
+			return &BatchError{Peer: b.Peer, Fault: FaultInvalidSignature, Slot: b.Blocks[i].Slot,
+				Err: errors.New("block has invalid signature")}
+		}
+	}
+	return &BatchError{Peer: b.Peer, Fault: FaultInvalidSignature, Slot: b.StartSlot, Err: errors.New("invalid batch signature")}
+}
+
+type processedBlock struct {
+	benv  *common.BeaconBlockEnvelope
+	state *beacon.StandardUpgradeableBeaconState
+	epc   *common.EpochsContext
+}
+
+// processBlocks runs the state transition of the blocks, starting from the head state, without verifying signatures:
+// the proposer, RANDAO, attestation and sync aggregate signatures are collected in sigs instead.
+// It returns the blocks that passed the transition, with their post-states, and the error of the first block that did not.
+func (s *Syncer) processBlocks(ctx context.Context, b *Batch, sigs *batchSignatures) ([]processedBlock, *BatchError) {
+	out := make([]processedBlock, 0, len(b.Blocks))
+	state, epc := s.state, s.epc
+	for _, benv := range b.Blocks {
+		sigs.nextBlock()
+		next, nextEpc, err := s.processBlock(ctx, benv, state, epc, sigs)
+		if err != nil {
+			return out, err
+		}
+		out = append(out, processedBlock{benv: benv, state: next, epc: nextEpc})
+		state, epc = next, nextEpc
+	}
+	return out, nil
+}
+
+// processBlock runs the state transition of the block on a copy of the given state,
+// and adds the signatures of the block to sigs.
+func (s *Syncer) processBlock(ctx context.Context, benv *common.BeaconBlockEnvelope, pre *beacon.StandardUpgradeableBeaconState,
+	preEpc *common.EpochsContext, sigs *batchSignatures) (*beacon.StandardUpgradeableBeaconState, *common.EpochsContext, *BatchError) {
+	fault := func(f Fault, err error) *BatchError {
+		return &BatchError{Slot: benv.Slot, Fault: f, Err: err}
+	}
+	copied, err := pre.CopyState()
+	if err != nil {
+		return nil, nil, fault(NoFault, err)
+	}
+	state := &beacon.StandardUpgradeableBeaconState{BeaconState: copied}
+	epc := preEpc.Clone()
+	if err := common.ProcessSlots(ctx, s.spec, epc, state, benv.Slot); err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, nil, fault(NoFault, ctxErr)
+		}
+		return nil, nil, fault(FaultInvalidBlock, err)
+	}
+	// The proposer may have been deposited by an earlier block of the batch.
+	cachedPub, ok := epc.ValidatorPubkeyCache.Pubkey(benv.ProposerIndex)
+	if !ok {
+		return nil, nil, fault(FaultInvalidBlock, fmt.Errorf("unknown proposer %d", benv.ProposerIndex))
+	}
+	pub, err := cachedPub.Pubkey()
+	if err != nil {
+		return nil, nil, fault(FaultInvalidBlock, fmt.Errorf("invalid proposer pubkey: %w", err))
+	}
+	sig, err := benv.Signature.Signature()
+	if err != nil {
+		return nil, nil, fault(FaultInvalidSignature, fmt.Errorf("failed to deserialize block signature: %w", err))
+	}
+	dom := common.ComputeDomain(common.DOMAIN_BEACON_PROPOSER, s.spec.ForkVersion(benv.Slot), s.genValRoot)
+	signingRoot := common.ComputeSigningRoot(benv.BlockRoot, dom)
+	sigs.Add(pub, signingRoot[:], sig)
+
+	// The signatures of the block contents are collected, the state root is checked separately.
+	epc.BlockSignatures = sigs
+	err = common.PostSlotTransition(ctx, s.spec, epc, state, benv, false)
+	epc.BlockSignatures = nil
+	if err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, nil, fault(NoFault, ctxErr)
+		}
+		return nil, nil, fault(FaultInvalidBlock, err)
+	}
+	if root := state.HashTreeRoot(tree.GetHashFn()); root != benv.StateRoot {
+		return nil, nil, fault(FaultInvalidBlock, fmt.Errorf("block has invalid state root %s, expected %s", benv.StateRoot, root))
+	}
+	return state, epc, nil
+}
@@ -0,0 +1,556 @@
+package rangesync
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"testing"
+
+	blsu "github.com/protolambda/bls12-381-util"
+	"github.com/protolambda/ztyp/tree"
+
+	"github.com/protolambda/zrnt/eth2/beacon"
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/protolambda/zrnt/eth2/beacon/deneb"
+	"github.com/protolambda/zrnt/eth2/beacon/phase0"
+	"github.com/protolambda/zrnt/eth2/configs"
+)
+
+type testBackend struct {
+	currentSlot common.Slot
+	imported    []common.Slot
+}
+
+func (b *testBackend) CurrentSlot() common.Slot {
+	return b.currentSlot
+}
+
+func (b *testBackend) VerifyBlobKZGProofBatch(blobs []deneb.Blob, commitments []common.KZGCommitment, proofs []common.KZGProof) error {
+	return nil
+}
+
+func (b *testBackend) ImportBlock(ctx context.Context, benv *common.BeaconBlockEnvelope, blobs []*deneb.BlobSidecar,
+	post common.BeaconState, epc *common.EpochsContext) error {
+	b.imported = append(b.imported, benv.Slot)
+	return nil
+}
+
+// testChain builds a phase0 chain with valid blocks, signed by the kickstart validators.
+type testChain struct {
+	spec       *common.Spec
+	keys       []blsu.SecretKey
+	genesis    common.BeaconState
+	genValRoot common.Root
+	digest     common.ForkDigest
+	state      *beacon.StandardUpgradeableBeaconState
+	epc        *common.EpochsContext
+	blocks     map[common.Slot]*phase0.SignedBeaconBlock
+}
+
+func newTestChain(t *testing.T) *testChain {
+	spec := *configs.Minimal
+	spec.ALTAIR_FORK_EPOCH = common.FAR_FUTURE_EPOCH
+	spec.BELLATRIX_FORK_EPOCH = common.FAR_FUTURE_EPOCH
+	spec.CAPELLA_FORK_EPOCH = common.FAR_FUTURE_EPOCH
+	spec.DENEB_FORK_EPOCH = common.FAR_FUTURE_EPOCH
+	c := &testChain{spec: &spec, blocks: make(map[common.Slot]*phase0.SignedBeaconBlock)}
+	validators := make([]phase0.KickstartValidatorData, 64)
+	c.keys = make([]blsu.SecretKey, len(validators))
+	for i := range validators {
+		var raw [32]byte
+		big.NewInt(int64(i + 1)).FillBytes(raw[:])
+		if err := c.keys[i].Deserialize(&raw); err != nil {
+			t.Fatal(err)
+		}
+		pub, err := blsu.SkToPk(&c.keys[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		validators[i] = phase0.KickstartValidatorData{Pubkey: pub.Serialize(), Balance: spec.MAX_EFFECTIVE_BALANCE}
+	}
+	state, epc, err := phase0.KickStartState(c.spec, common.Root{0x42}, 1000, validators)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.genesis = state
+	c.genValRoot, err = state.GenesisValidatorsRoot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.digest = common.ComputeForkDigest(spec.GENESIS_FORK_VERSION, c.genValRoot)
+	pre, err := state.CopyState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.state = &beacon.StandardUpgradeableBeaconState{BeaconState: pre}
+	c.epc = epc.Clone()
+	return c
+}
+
+// extend adds valid blocks at the given slots to the chain.
+func (c *testChain) extend(t *testing.T, slots ...common.Slot) {
+	c.extendWith(t, blockOptions{}, slots...)
+}
+
+// blockOptions change the contents of the blocks added by extendWith.
+type blockOptions struct {
+	// attest includes an attestation of the first committee of the previous slot, signed by all its members.
+	attest bool
+	// badRandao signs the RANDAO reveal with the key of another validator.
+	badRandao bool
+	// badAttestation signs the attestation with the key of another validator.
+	badAttestation bool
+}
+
+// extendWith adds blocks at the given slots to the chain. The state root matches the block contents,
+// also if the signatures of the contents are invalid.
+func (c *testChain) extendWith(t *testing.T, opts blockOptions, slots ...common.Slot) {
+	ctx := context.Background()
+	for _, slot := range slots {
+		if err := common.ProcessSlots(ctx, c.spec, c.epc, c.state, slot); err != nil {
+			t.Fatal(err)
+		}
+		proposer, err := c.epc.GetBeaconProposer(slot)
+		if err != nil {
+			t.Fatal(err)
+		}
+		header, err := c.state.LatestBlockHeader()
+		if err != nil {
+			t.Fatal(err)
+		}
+		eth1Data, err := c.state.Eth1Data()
+		if err != nil {
+			t.Fatal(err)
+		}
+		epoch := c.spec.SlotToEpoch(slot)
+		dom := common.ComputeDomain(common.DOMAIN_RANDAO, c.spec.GENESIS_FORK_VERSION, c.genValRoot)
+		randaoRoot := common.ComputeSigningRoot(epoch.HashTreeRoot(tree.GetHashFn()), dom)
+		randaoKey := &c.keys[proposer]
+		if opts.badRandao {
+			randaoKey = &c.keys[(int(proposer)+1)%len(c.keys)]
+		}
+		block := &phase0.SignedBeaconBlock{Message: phase0.BeaconBlock{
+			Slot:          slot,
+			ProposerIndex: proposer,
+			ParentRoot:    header.HashTreeRoot(tree.GetHashFn()),
+			Body: phase0.BeaconBlockBody{
+				RandaoReveal: blsu.Sign(randaoKey, randaoRoot[:]).Serialize(),
+				Eth1Data:     eth1Data,
+			},
+		}}
+		if opts.attest {
+			block.Message.Body.Attestations = phase0.Attestations{*c.attestation(t, slot-1, block.Message.ParentRoot, opts.badAttestation)}
+		}
+		// The signatures of the block contents are collected and ignored, to build blocks with invalid signatures.
+		c.epc.BlockSignatures = new(blsu.SignatureSet)
+		err = common.PostSlotTransition(ctx, c.spec, c.epc, c.state, block.Envelope(c.spec, c.digest), false)
+		c.epc.BlockSignatures = nil
+		if err != nil {
+			t.Fatal(err)
+		}
+		block.Message.StateRoot = c.state.HashTreeRoot(tree.GetHashFn())
+		c.blocks[slot] = block
+	}
+}
+
+// attestation creates an attestation of the first committee at the slot, for the given head,
+// signed by all members of the committee, or by other validators if bad is true.
+func (c *testChain) attestation(t *testing.T, slot common.Slot, head common.Root, bad bool) *phase0.Attestation {
+	committee, err := c.epc.GetBeaconCommittee(slot, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	source, err := c.state.CurrentJustifiedCheckpoint()
+	if err != nil {
+		t.Fatal(err)
+	}
+	epoch := c.spec.SlotToEpoch(slot)
+	epochStart, err := c.spec.EpochStartSlot(epoch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	targetRoot, err := common.GetBlockRootAtSlot(c.spec, c.state, epochStart)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := phase0.AttestationData{
+		Slot:            slot,
+		Index:           0,
+		BeaconBlockRoot: head,
+		Source:          source,
+		Target:          common.Checkpoint{Epoch: epoch, Root: targetRoot},
+	}
+	dom, err := common.GetDomain(c.state, common.DOMAIN_BEACON_ATTESTER, epoch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signingRoot := common.ComputeSigningRoot(data.HashTreeRoot(tree.GetHashFn()), dom)
+	bits := phase0.NewAttestationBits(uint64(len(committee)))
+	sigs := make([]*blsu.Signature, 0, len(committee))
+	for i, vi := range committee {
+		bits.SetBit(uint64(i), true)
+		if bad {
+			vi = (vi + 1) % common.ValidatorIndex(len(c.keys))
+		}
+		sigs = append(sigs, blsu.Sign(&c.keys[vi], signingRoot[:]))
+	}
+	sig, err := blsu.Aggregate(sigs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &phase0.Attestation{AggregationBits: bits, Data: data, Signature: sig.Serialize()}
+}
+
+// envelope signs the block, with the key of its proposer, and returns the envelope of it.
+func (c *testChain) envelope(block *phase0.SignedBeaconBlock) *common.BeaconBlockEnvelope {
+	benv := block.Envelope(c.spec, c.digest)
+	dom := common.ComputeDomain(common.DOMAIN_BEACON_PROPOSER, c.spec.GENESIS_FORK_VERSION, c.genValRoot)
+	signingRoot := common.ComputeSigningRoot(benv.BlockRoot, dom)
+	benv.Signature = blsu.Sign(&c.keys[block.Message.ProposerIndex], signingRoot[:]).Serialize()
+	return benv
+}
+
+func (c *testChain) batch(peer PeerID, start common.Slot, count uint64) *Batch {
+	b := &Batch{Peer: peer, StartSlot: start, Count: count}
+	for slot := start; slot < b.EndSlot(); slot++ {
+		if block, ok := c.blocks[slot]; ok {
+			b.Blocks = append(b.Blocks, c.envelope(block))
+		}
+	}
+	return b
+}
+
+func (c *testChain) genesisCheckpoint(t *testing.T) common.Checkpoint {
+	header, err := c.genesis.LatestBlockHeader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	header.StateRoot = c.genesis.HashTreeRoot(tree.GetHashFn())
+	return common.Checkpoint{Epoch: 0, Root: header.HashTreeRoot(tree.GetHashFn())}
+}
+
+func (c *testChain) syncer(t *testing.T, backend Backend) *Syncer {
+	s, err := NewSyncer(c.spec, backend, c.genesis, nil, c.genesisCheckpoint(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func batchError(t *testing.T, err error) *BatchError {
+	t.Helper()
+	var bErr *BatchError
+	if !errors.As(err, &bErr) {
+		t.Fatalf("expected batch error, got: %v", err)
+	}
+	return bErr
+}
+
+func expectSlots(t *testing.T, got []common.Slot, expected ...common.Slot) {
+	t.Helper()
+	if len(got) != len(expected) {
+		t.Fatalf("expected slots %v, got %v", expected, got)
+	}
+	for i := range got {
+		if got[i] != expected[i] {
+			t.Fatalf("expected slots %v, got %v", expected, got)
+		}
+	}
+}
+
+// testEnvelope is a block that is only used for the checks before the state transition.
+func testEnvelope(slot common.Slot, parent common.Root, root common.Root) *common.BeaconBlockEnvelope {
+	return &common.BeaconBlockEnvelope{
+		BeaconBlockHeader: common.BeaconBlockHeader{Slot: slot, ParentRoot: parent},
+		Body:              &phase0.BeaconBlockBody{},
+		BlockRoot:         root,
+	}
+}
+
+func TestCheckLinkage(t *testing.T) {
+	head := common.Root{0x01}
+	fin := common.Root{0xf1}
+	a, b := common.Root{0xa0}, common.Root{0xb0}
+	// the finalized checkpoint is at epoch 1, slot 8 in the minimal preset
+	s := &Syncer{spec: configs.Minimal, finalized: common.Checkpoint{Epoch: 1, Root: fin}}
+	cases := []struct {
+		name   string
+		start  common.Slot
+		count  uint64
+		blocks []*common.BeaconBlockEnvelope
+		fault  Fault
+		slot   common.Slot
+	}{
+		{"valid", 1, 16, []*common.BeaconBlockEnvelope{testEnvelope(3, head, fin), testEnvelope(9, fin, a)}, NoFault, 0},
+		{"before range", 2, 4, []*common.BeaconBlockEnvelope{testEnvelope(1, head, a)}, FaultBadRange, 1},
+		{"after range", 1, 4, []*common.BeaconBlockEnvelope{testEnvelope(5, head, a)}, FaultBadRange, 5},
+		{"not ascending", 1, 8, []*common.BeaconBlockEnvelope{testEnvelope(4, head, a), testEnvelope(4, a, b)}, FaultBadRange, 4},
+		{"not after head", 0, 8, []*common.BeaconBlockEnvelope{testEnvelope(0, head, a)}, FaultBadRange, 0},
+		{"first block not on head", 1, 8, []*common.BeaconBlockEnvelope{testEnvelope(2, b, a)}, FaultBrokenLinkage, 2},
+		{"broken linkage", 1, 8, []*common.BeaconBlockEnvelope{testEnvelope(2, head, a), testEnvelope(3, b, b)}, FaultBrokenLinkage, 3},
+		{"block after finalized slot on other fork", 1, 16,
+			[]*common.BeaconBlockEnvelope{testEnvelope(3, head, a), testEnvelope(9, a, b)}, FaultFinalizedConflict, 9},
+		{"range covers finalized slot on other fork", 1, 8,
+			[]*common.BeaconBlockEnvelope{testEnvelope(3, head, a)}, FaultFinalizedConflict, 1},
+		{"range covers finalized slot at finalized block", 1, 8,
+			[]*common.BeaconBlockEnvelope{testEnvelope(8, head, fin)}, NoFault, 0},
+		{"range before finalized slot", 1, 4, []*common.BeaconBlockEnvelope{testEnvelope(3, head, a)}, NoFault, 0},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			err := s.checkLinkage(&Batch{Peer: "p", StartSlot: c.start, Count: c.count, Blocks: c.blocks}, 0, head)
+			if c.fault == NoFault {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("expected %s fault", c.fault)
+			}
+			if err.Fault != c.fault || err.Slot != c.slot || err.Peer != "p" {
+				t.Fatalf("expected %s fault at slot %d, got: %v", c.fault, c.slot, err)
+			}
+		})
+	}
+}
+
+func TestMatchBlobsRetention(t *testing.T) {
+	spec := configs.Minimal
+	block := &common.BeaconBlockEnvelope{
+		BeaconBlockHeader: common.BeaconBlockHeader{Slot: 10},
+		Body:              &deneb.BeaconBlockBody{BlobKZGCommitments: deneb.KZGCommitments{common.KZGCommitment{0x01}}},
+		BlockRoot:         common.Root{0xa0},
+	}
+	// The block is in epoch 1, blobs are retained up to MIN_EPOCHS_FOR_BLOB_SIDECARS_REQUESTS epochs after it.
+	windowEnd := common.Slot(spec.MIN_EPOCHS_FOR_BLOB_SIDECARS_REQUESTS+2)*spec.SLOTS_PER_EPOCH - 1
+	for _, c := range []struct {
+		name        string
+		currentSlot common.Slot
+		missing     bool
+	}{
+		{"same epoch", 10, true},
+		{"last epoch of window", windowEnd, true},
+		{"after window", windowEnd + 1, false},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			s := &Syncer{spec: spec, backend: &testBackend{currentSlot: c.currentSlot}}
+			blobs, err := s.matchBlobs(&Batch{Peer: "p", StartSlot: 8, Count: 8, Blocks: []*common.BeaconBlockEnvelope{block}})
+			if c.missing {
+				if err == nil || err.Fault != FaultMissingBlobs || err.Slot != 10 {
+					t.Fatalf("expected missing blobs fault at slot 10, got: %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(blobs[block.BlockRoot]) != 0 {
+				t.Fatal("expected no blobs")
+			}
+		})
+	}
+}
+
+func TestImportOutOfOrder(t *testing.T) {
+	c := newTestChain(t)
+	c.extend(t, 1, 2, 3, 5, 6, 7, 9, 12)
+	backend := &testBackend{}
+	s := c.syncer(t, backend)
+	ctx := context.Background()
+	for _, b := range []*Batch{c.batch("c", 9, 4), c.batch("b", 5, 4)} {
+		if err := s.AddBatch(b); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.AddBatch(c.batch("d", 8, 2)); err == nil {
+		t.Fatal("expected overlapping batch to be refused")
+	}
+	if err := s.ImportReady(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if p := s.Progress(); p.NextSlot != 1 || p.QueuedBatches != 2 || len(backend.imported) != 0 {
+		t.Fatalf("expected batches to wait for slot 1: %+v", p)
+	}
+	if err := s.AddBatch(c.batch("a", 1, 4)); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.ImportReady(ctx); err != nil {
+		t.Fatal(err)
+	}
+	expectSlots(t, backend.imported, 1, 2, 3, 5, 6, 7, 9, 12)
+	p := s.Progress()
+	if p.NextSlot != 13 || p.HeadSlot != 12 || p.QueuedBatches != 0 || p.ImportedBlocks != 8 {
+		t.Fatalf("unexpected progress: %+v", p)
+	}
+	if p.HeadRoot != c.blocks[12].Message.HashTreeRoot(c.spec, tree.GetHashFn()) {
+		t.Fatal("unexpected head root")
+	}
+	if err := s.AddBatch(c.batch("a", 4, 4)); err == nil {
+		t.Fatal("expected batch before next slot to be refused")
+	}
+}
+
+func TestImportBrokenLinkage(t *testing.T) {
+	c := newTestChain(t)
+	c.extend(t, 1, 2, 3, 4)
+	backend := &testBackend{}
+	s := c.syncer(t, backend)
+	ctx := context.Background()
+	if err := s.AddBatch(c.batch("a", 1, 2)); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.ImportReady(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// The first block does not build on the batch of peer a.
+	b := c.batch("b", 3, 2)
+	b.Blocks[0].ParentRoot = common.Root{0xff}
+	if err := s.AddBatch(b); err != nil {
+		t.Fatal(err)
+	}
+	bErr := batchError(t, s.ImportReady(ctx))
+	if bErr.Fault != FaultBrokenLinkage || bErr.Peer != "b" || bErr.PrevPeer != "a" || bErr.Slot != 3 {
+		t.Fatalf("unexpected error: %v (prev peer %q)", bErr, bErr.PrevPeer)
+	}
+
+	// A broken link within the batch is not attributed to the previous peer.
+	b = c.batch("b", 3, 2)
+	b.Blocks[1].ParentRoot = common.Root{0xff}
+	if err := s.AddBatch(b); err != nil {
+		t.Fatal(err)
+	}
+	bErr = batchError(t, s.ImportReady(ctx))
+	if bErr.Fault != FaultBrokenLinkage || bErr.PrevPeer != "" || bErr.Slot != 4 {
+		t.Fatalf("unexpected error: %v (prev peer %q)", bErr, bErr.PrevPeer)
+	}
+	if faults := s.PeerFaults(); faults["b"] != 2 || faults["a"] != 0 {
+		t.Fatalf("unexpected peer faults: %v", faults)
+	}
+	expectSlots(t, backend.imported, 1, 2)
+}
+
+func TestImportResumeAfterFailure(t *testing.T) {
+	c := newTestChain(t)
+	c.extend(t, 1, 2, 3, 4, 5, 6)
+	backend := &testBackend{}
+	s := c.syncer(t, backend)
+	ctx := context.Background()
+
+	// A correctly signed block with an invalid state root fails the state transition.
+	invalid := *c.blocks[4]
+	invalid.Message.StateRoot = common.Root{0xff}
+	b := c.batch("a", 1, 8)
+	b.Blocks[3] = c.envelope(&invalid)
+	b.Blocks[4].ParentRoot = b.Blocks[3].BlockRoot
+	if err := s.AddBatch(b); err != nil {
+		t.Fatal(err)
+	}
+	bErr := batchError(t, s.ImportReady(ctx))
+	if bErr.Fault != FaultInvalidBlock || bErr.Peer != "a" || bErr.Slot != 4 {
+		t.Fatalf("unexpected error: %v", bErr)
+	}
+	expectSlots(t, backend.imported, 1, 2, 3)
+	if p := s.Progress(); p.NextSlot != 4 || p.HeadSlot != 3 {
+		t.Fatalf("expected to resume after the last imported block: %+v", p)
+	}
+
+	if err := s.AddBatch(c.batch("b", 4, 5)); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.ImportReady(ctx); err != nil {
+		t.Fatal(err)
+	}
+	expectSlots(t, backend.imported, 1, 2, 3, 4, 5, 6)
+	if p := s.Progress(); p.NextSlot != 9 || p.HeadSlot != 6 {
+		t.Fatalf("unexpected progress: %+v", p)
+	}
+}
+
+func TestImportInvalidSignature(t *testing.T) {
+	c := newTestChain(t)
+	c.extend(t, 1, 2, 3)
+	backend := &testBackend{}
+	s := c.syncer(t, backend)
+	b := c.batch("a", 1, 4)
+	b.Blocks[1].Signature = b.Blocks[0].Signature
+	if err := s.AddBatch(b); err != nil {
+		t.Fatal(err)
+	}
+	bErr := batchError(t, s.ImportReady(context.Background()))
+	if bErr.Fault != FaultInvalidSignature || bErr.Slot != 2 {
+		t.Fatalf("unexpected error: %v", bErr)
+	}
+	expectSlots(t, backend.imported)
+}
+
+func TestImportUnknownProposer(t *testing.T) {
+	c := newTestChain(t)
+	c.extend(t, 1, 2)
+	backend := &testBackend{}
+	s := c.syncer(t, backend)
+	b := c.batch("a", 1, 4)
+	// The proposer is not known at the head, it is looked up after the blocks before it in the batch are processed.
+	unknown := testEnvelope(3, b.Blocks[1].BlockRoot, common.Root{0xa0})
+	unknown.ForkDigest = c.digest
+	unknown.ProposerIndex = 1000
+	b.Blocks = append(b.Blocks, unknown)
+	if err := s.AddBatch(b); err != nil {
+		t.Fatal(err)
+	}
+	bErr := batchError(t, s.ImportReady(context.Background()))
+	if bErr.Fault != FaultInvalidBlock || bErr.Slot != 3 {
+		t.Fatalf("unexpected error: %v", bErr)
+	}
+	expectSlots(t, backend.imported, 1, 2)
+}
+
+func TestImportAttestations(t *testing.T) {
+	c := newTestChain(t)
+	c.extendWith(t, blockOptions{attest: true}, 1, 2, 3, 5)
+	backend := &testBackend{}
+	s := c.syncer(t, backend)
+	if err := s.AddBatch(c.batch("a", 1, 8)); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.ImportReady(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	expectSlots(t, backend.imported, 1, 2, 3, 5)
+	if p := s.Progress(); p.NextSlot != 9 || p.HeadSlot != 5 {
+		t.Fatalf("unexpected progress: %+v", p)
+	}
+}
+
+func TestImportInvalidContentSignature(t *testing.T) {
+	for _, c := range []struct {
+		name string
+		opts blockOptions
+	}{
+		{"randao", blockOptions{attest: true, badRandao: true}},
+		{"attestation", blockOptions{attest: true, badAttestation: true}},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			chain := newTestChain(t)
+			chain.extendWith(t, blockOptions{attest: true}, 1, 2)
+			// The block is signed by its proposer, and passes the state transition without signature checks.
+			chain.extendWith(t, c.opts, 3)
+			chain.extendWith(t, blockOptions{attest: true}, 4)
+			backend := &testBackend{}
+			s := chain.syncer(t, backend)
+			if err := s.AddBatch(chain.batch("a", 1, 4)); err != nil {
+				t.Fatal(err)
+			}
+			bErr := batchError(t, s.ImportReady(context.Background()))
+			if bErr.Fault != FaultInvalidSignature || bErr.Peer != "a" || bErr.Slot != 3 {
+				t.Fatalf("unexpected error: %v", bErr)
+			}
+			// None of the blocks of the batch are imported.
+			expectSlots(t, backend.imported)
+			if p := s.Progress(); p.NextSlot != 1 || p.HeadSlot != 0 {
+				t.Fatalf("unexpected progress: %+v", p)
+			}
+		})
+	}
+}
@@ -0,0 +1,402 @@
+package reqresp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+
+	"github.com/golang/snappy"
+	"github.com/protolambda/ztyp/codec"
+
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+)
+
+// The ssz_snappy encoding: every payload is prefixed with the unsigned varint of the SSZ-encoded length,
+// followed by the SSZ bytes compressed with the snappy framing format.
+// Response chunks are prefixed with a result byte and, for some protocols, 4 context bytes.
+
+type ResponseCode uint8
+
+const (
+	SuccessCode ResponseCode = iota
+	InvalidRequestCode
+	ServerErrorCode
+	ResourceUnavailableCode
+)
+
+func (c ResponseCode) String() string {
+	switch c {
+	case SuccessCode:
+		return "success"
+	case InvalidRequestCode:
+		return "invalid request"
+	case ServerErrorCode:
+		return "server error"
+	case ResourceUnavailableCode:
+		return "resource unavailable"
+	default:
+		return fmt.Sprintf("unknown response code %d", uint8(c))
+	}
+}
+
+// MAX_ERROR_MESSAGE_LENGTH is the limit of the ErrorMessage byte list in error response chunks.
+const MAX_ERROR_MESSAGE_LENGTH = 256
+
+// ErrorResponse is a response chunk with a non-success response code.
+type ErrorResponse struct {
+	Code    ResponseCode
+	Message string
+}
+
+func (e *ErrorResponse) Error() string {
+	return fmt.Sprintf("%s: %s", e.Code, e.Message)
+}
+
+// Snappy framing format, see https://github.com/google/snappy/blob/main/framing_format.txt
+const (
+	chunkTypeCompressed       = 0x00
+	chunkTypeUncompressed     = 0x01
+	chunkTypeStreamIdentifier = 0xff
+	chunkHeaderLen            = 4
+	chunkChecksumLen          = 4
+	maxBlockSize              = 65536
+	streamIdentifierFrameLen  = chunkHeaderLen + 6
+)
+
+var streamIdentifier = []byte("sNaPpY")
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+func maskedCRC(b []byte) uint32 {
+	c := crc32.Update(0, crcTable, b)
+	return (c>>15 | c<<17) + 0xa282ead8
+}
+
+// MaxEncodedLen is the maximum snappy-framed length of a payload of n bytes,
+// the stream identifier and the worst-case compressed size of every chunk.
+func MaxEncodedLen(n uint64) uint64 {
+	chunks := (n + maxBlockSize - 1) / maxBlockSize
+	return streamIdentifierFrameLen + chunks*(chunkHeaderLen+chunkChecksumLen+32) + n + n/6
+}
+
+// readFramed reads snappy-framed data until exactly len(dst) bytes are decoded.
+// It does not read past the last frame, so the next response chunk can be read from r.
+func readFramed(r io.Reader, dst []byte) error {
+	lr := &io.LimitedReader{R: r, N: int64(MaxEncodedLen(uint64(len(dst))))}
+	var header [chunkHeaderLen]byte
+	seenStreamIdentifier := false
+	n := 0
+	for n < len(dst) {
+		if _, err := io.ReadFull(lr, header[:]); err != nil {
+			return fmt.Errorf("failed to read snappy frame header: %w", err)
+		}
+		chunkType := header[0]
+		chunkLen := int(header[1]) | int(header[2])<<8 | int(header[3])<<16
+		if !seenStreamIdentifier && chunkType != chunkTypeStreamIdentifier {
+			return errors.New("missing snappy stream identifier")
+		}
+		switch {
+		case chunkType == chunkTypeStreamIdentifier:
+			if chunkLen != len(streamIdentifier) {
+				return fmt.Errorf("invalid snappy stream identifier length %d", chunkLen)
+			}
+			var id [6]byte
+			if _, err := io.ReadFull(lr, id[:]); err != nil {
+				return fmt.Errorf("failed to read snappy stream identifier: %w", err)
+			}
+			if !bytes.Equal(id[:], streamIdentifier) {
+				return errors.New("invalid snappy stream identifier")
+			}
+			seenStreamIdentifier = true
+		case chunkType == chunkTypeCompressed || chunkType == chunkTypeUncompressed:
+			if chunkLen < chunkChecksumLen || chunkLen > chunkChecksumLen+snappy.MaxEncodedLen(maxBlockSize) {
+				return fmt.Errorf("invalid snappy chunk length %d", chunkLen)
+			}
+			buf := make([]byte, chunkLen)
+			if _, err := io.ReadFull(lr, buf); err != nil {
+				return fmt.Errorf("failed to read snappy chunk: %w", err)
+			}
+			checksum := binary.LittleEndian.Uint32(buf[:chunkChecksumLen])
+			data := buf[chunkChecksumLen:]
+			var decoded []byte
+			if chunkType == chunkTypeCompressed {
+				size, err := snappy.DecodedLen(data)
+				if err != nil {
+					return fmt.Errorf("invalid snappy chunk: %w", err)
+				}
+				if size > maxBlockSize || size > len(dst)-n {
+					return fmt.Errorf("snappy chunk of %d bytes exceeds payload length", size)
+				}
+				decoded, err = snappy.Decode(dst[n:n+size], data)
+				if err != nil {
+					return fmt.Errorf("invalid snappy chunk: %w", err)
+				}
+			} else {
+				if len(data) > maxBlockSize || len(data) > len(dst)-n {
+					return fmt.Errorf("snappy chunk of %d bytes exceeds payload length", len(data))
+				}
+				decoded = dst[n : n+len(data)]
+				copy(decoded, data)
+			}
+			if maskedCRC(decoded) != checksum {
+				return errors.New("invalid snappy chunk checksum")
+			}
+			n += len(decoded)
+		case chunkType >= 0x80 && chunkType <= 0xfe:
+			// skippable chunk, e.g. padding
+			if _, err := io.CopyN(io.Discard, lr, int64(chunkLen)); err != nil {
+				return fmt.Errorf("failed to skip snappy chunk: %w", err)
+			}
+		default:
+			return fmt.Errorf("unsupported unskippable snappy chunk type %d", chunkType)
+		}
+	}
+	return nil
+}
+
+func encodeSSZ(spec *common.Spec, obj interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	w := codec.NewEncodingWriter(&buf)
+	var err error
+	switch x := obj.(type) {
+	case common.SpecObj:
+		err = x.Serialize(spec, w)
+	case codec.Serializable:
+		err = x.Serialize(w)
+	default:
+		return nil, fmt.Errorf("cannot encode %T", obj)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode %T: %w", obj, err)
+	}
+	return buf.Bytes(), nil
+}
+
+func decodeSSZ(spec *common.Spec, data []byte, dst interface{}) error {
+	dr := codec.NewDecodingReader(bytes.NewReader(data), uint64(len(data)))
+	var err error
+	switch x := dst.(type) {
+	case common.SpecObj:
+		err = x.Deserialize(spec, dr)
+	case codec.Deserializable:
+		err = x.Deserialize(dr)
+	default:
+		return fmt.Errorf("cannot decode %T", dst)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to decode %T: %w", dst, err)
+	}
+	return nil
+}
+
+// fixedLength returns the SSZ length of fixed-size types, or 0 for dynamic types.
+func fixedLength(spec *common.Spec, obj interface{}) uint64 {
+	switch x := obj.(type) {
+	case common.SpecObj:
+		return x.FixedLength(spec)
+	case codec.FixedLength:
+		return x.FixedLength()
+	default:
+		return 0
+	}
+}
+
+func writePayload(spec *common.Spec, w io.Writer, data []byte) error {
+	if size := uint64(len(data)); size > uint64(spec.MAX_CHUNK_SIZE) {
+		return fmt.Errorf("payload of %d bytes exceeds MAX_CHUNK_SIZE %d", size, spec.MAX_CHUNK_SIZE)
+	}
+	var prefix [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(prefix[:], uint64(len(data)))
+	if _, err := w.Write(prefix[:n]); err != nil {
+		return err
+	}
+	if len(data) == 0 {
+		return nil
+	}
+	sw := snappy.NewBufferedWriter(w)
+	if _, err := sw.Write(data); err != nil {
+		return err
+	}
+	return sw.Close()
+}
+
+// readPayload reads the length-prefixed snappy-framed payload, within MAX_CHUNK_SIZE and the given maximum.
+func readPayload(spec *common.Spec, r *bufio.Reader, maxLen uint64) ([]byte, error) {
+	size, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read payload length: %w", err)
+	}
+	if size > uint64(spec.MAX_CHUNK_SIZE) {
+		return nil, fmt.Errorf("payload of %d bytes exceeds MAX_CHUNK_SIZE %d", size, spec.MAX_CHUNK_SIZE)
+	}
+	if size > maxLen {
+		return nil, fmt.Errorf("payload of %d bytes exceeds limit of %d", size, maxLen)
+	}
+	data := make([]byte, size)
+	if size == 0 {
+		// Some encoders still write the stream identifier for an empty payload.
+		if err := skipStreamIdentifier(r); err != nil {
+			return nil, err
+		}
+		return data, nil
+	}
+	if err := readFramed(r, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// skipStreamIdentifier consumes the snappy stream identifier frame, if the stream continues with one.
+func skipStreamIdentifier(r *bufio.Reader) error {
+	if first, err := r.Peek(1); err != nil || first[0] != chunkTypeStreamIdentifier {
+		return nil
+	}
+	frame, err := r.Peek(streamIdentifierFrameLen)
+	if err != nil {
+		return fmt.Errorf("failed to read snappy stream identifier: %w", err)
+	}
+	if frame[1] != byte(len(streamIdentifier)) || frame[2] != 0 || frame[3] != 0 ||
+		!bytes.Equal(frame[chunkHeaderLen:], streamIdentifier) {
+		return errors.New("invalid snappy stream identifier")
+	}
+	_, err = r.Discard(streamIdentifierFrameLen)
+	return err
+}
+
+func readObject(spec *common.Spec, r *bufio.Reader, dst interface{}) error {
+	maxLen := uint64(spec.MAX_CHUNK_SIZE)
+	fixed := fixedLength(spec, dst)
+	if fixed != 0 {
+		maxLen = fixed
+	}
+	data, err := readPayload(spec, r, maxLen)
+	if err != nil {
+		return err
+	}
+	if fixed != 0 && uint64(len(data)) != fixed {
+		return fmt.Errorf("payload of %d bytes does not match %T length %d", len(data), dst, fixed)
+	}
+	return decodeSSZ(spec, data, dst)
+}
+
+// WriteRequest writes the request payload. The request must be a SpecObj or codec.Serializable,
+// or nil for protocols without request payload, like MetaData, to not write anything.
+func WriteRequest(spec *common.Spec, w io.Writer, req interface{}) error {
+	if req == nil {
+		return nil
+	}
+	data, err := encodeSSZ(spec, req)
+	if err != nil {
+		return err
+	}
+	return writePayload(spec, w, data)
+}
+
+// ReadRequest reads the request payload into dst. The request must be a SpecObj or codec.Deserializable.
+// List limits are enforced by the SSZ decoding of the request type.
+func ReadRequest(spec *common.Spec, r io.Reader, dst interface{}) error {
+	return readObject(spec, bufio.NewReader(r), dst)
+}
+
+// ResponseWriter writes the response chunks of a single response stream.
+type ResponseWriter struct {
+	spec *common.Spec
+	w    io.Writer
+}
+
+func NewResponseWriter(spec *common.Spec, w io.Writer) *ResponseWriter {
+	return &ResponseWriter{spec: spec, w: w}
+}
+
+// WriteChunk writes a success response chunk. The context is the fork digest for protocols with context bytes,
+// and must be nil otherwise.
+func (rw *ResponseWriter) WriteChunk(context *common.ForkDigest, obj interface{}) error {
+	data, err := encodeSSZ(rw.spec, obj)
+	if err != nil {
+		return err
+	}
+	if size := uint64(len(data)); size > uint64(rw.spec.MAX_CHUNK_SIZE) {
+		return fmt.Errorf("payload of %d bytes exceeds MAX_CHUNK_SIZE %d", size, rw.spec.MAX_CHUNK_SIZE)
+	}
+	prefix := []byte{byte(SuccessCode)}
+	if context != nil {
+		prefix = append(prefix, context[:]...)
+	}
+	if _, err := rw.w.Write(prefix); err != nil {
+		return err
+	}
+	return writePayload(rw.spec, rw.w, data)
+}
+
+// WriteError writes an error response chunk. Messages longer than MAX_ERROR_MESSAGE_LENGTH are truncated.
+func (rw *ResponseWriter) WriteError(code ResponseCode, msg string) error {
+	if code == SuccessCode {
+		return errors.New("cannot write error chunk with success code")
+	}
+	if len(msg) > MAX_ERROR_MESSAGE_LENGTH {
+		msg = msg[:MAX_ERROR_MESSAGE_LENGTH]
+	}
+	if _, err := rw.w.Write([]byte{byte(code)}); err != nil {
+		return err
+	}
+	return writePayload(rw.spec, rw.w, []byte(msg))
+}
+
+// ResponseReader reads the response chunks of a single response stream.
+type ResponseReader struct {
+	spec         *common.Spec
+	r            *bufio.Reader
+	contextBytes bool
+	maxChunks    uint64
+	count        uint64
+}
+
+// NewResponseReader reads the response of the given protocol. If maxChunks is not 0,
+// then reading more than maxChunks chunks is an error, e.g. to limit the response to the requested count.
+func NewResponseReader(spec *common.Spec, r io.Reader, protocolID string, maxChunks uint64) *ResponseReader {
+	return &ResponseReader{
+		spec:         spec,
+		r:            bufio.NewReader(r),
+		contextBytes: HasContextBytes(protocolID),
+		maxChunks:    maxChunks,
+	}
+}
+
+// ReadChunk reads the next response chunk, into the object allocated for the fork digest of the chunk context.
+// For protocols without context bytes the digest is zero.
+// It returns io.EOF if the stream ended before the next chunk, and an *ErrorResponse for error chunks.
+func (rr *ResponseReader) ReadChunk(alloc func(digest common.ForkDigest) (interface{}, error)) (interface{}, error) {
+	code, err := rr.r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	if rr.maxChunks != 0 && rr.count >= rr.maxChunks {
+		return nil, fmt.Errorf("response has more than %d chunks", rr.maxChunks)
+	}
+	rr.count += 1
+	if ResponseCode(code) != SuccessCode {
+		msg, err := readPayload(rr.spec, rr.r, MAX_ERROR_MESSAGE_LENGTH)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read error message: %w", err)
+		}
+		return nil, &ErrorResponse{Code: ResponseCode(code), Message: string(msg)}
+	}
+	var digest common.ForkDigest
+	if rr.contextBytes {
+		if _, err := io.ReadFull(rr.r, digest[:]); err != nil {
+			return nil, fmt.Errorf("failed to read context bytes: %w", err)
+		}
+	}
+	dst, err := alloc(digest)
+	if err != nil {
+		return nil, err
+	}
+	if err := readObject(rr.spec, rr.r, dst); err != nil {
+		return nil, err
+	}
+	return dst, nil
+}
@@ -0,0 +1,313 @@
+package reqresp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/golang/snappy"
+
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/protolambda/zrnt/eth2/configs"
+)
+
+// Snappy frames are built by hand here, following the framing format, to not depend on the encoder under test.
+
+var testStreamIdentifier = []byte{0xff, 0x06, 0x00, 0x00, 's', 'N', 'a', 'P', 'p', 'Y'}
+
+func testChecksum(data []byte) []byte {
+	c := crc32.Checksum(data, crc32.MakeTable(crc32.Castagnoli))
+	var out [4]byte
+	binary.LittleEndian.PutUint32(out[:], ((c>>15)|(c<<17))+0xa282ead8)
+	return out[:]
+}
+
+func testFrame(chunkType byte, body ...[]byte) []byte {
+	var content []byte
+	for _, b := range body {
+		content = append(content, b...)
+	}
+	out := []byte{chunkType, byte(len(content)), byte(len(content) >> 8), byte(len(content) >> 16)}
+	return append(out, content...)
+}
+
+func concat(parts ...[]byte) (out []byte) {
+	for _, p := range parts {
+		out = append(out, p...)
+	}
+	return out
+}
+
+func TestWriteRequest(t *testing.T) {
+	spec := configs.Mainnet
+	var buf bytes.Buffer
+	ping := common.Ping(0x0102030405060708)
+	if err := WriteRequest(spec, &buf, &ping); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.Bytes()
+	// uvarint length prefix 8, then the snappy stream identifier
+	if expected := concat([]byte{0x08}, testStreamIdentifier); !bytes.HasPrefix(out, expected) {
+		t.Fatalf("expected prefix %x, got %x", expected, out)
+	}
+	decoded, err := io.ReadAll(snappy.NewReader(bytes.NewReader(out[1:])))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []byte{8, 7, 6, 5, 4, 3, 2, 1}; !bytes.Equal(decoded, expected) {
+		t.Fatalf("expected payload %x, got %x", expected, decoded)
+	}
+
+	buf.Reset()
+	if err := WriteRequest(spec, &buf, nil); err != nil {
+		t.Fatal(err)
+	}
+	if buf.Len() != 0 {
+		t.Fatalf("expected no payload, got %x", buf.Bytes())
+	}
+}
+
+func TestReadRequest(t *testing.T) {
+	spec := configs.Mainnet
+	data := []byte{8, 7, 6, 5, 4, 3, 2, 1}
+	// A snappy block of a single literal: the uvarint decoded length, the literal tag, and the literal.
+	block := concat([]byte{byte(len(data)), byte(len(data)-1) << 2}, data)
+	cases := []struct {
+		name  string
+		input []byte
+		err   string
+	}{
+		{"uncompressed", concat([]byte{0x08}, testStreamIdentifier, testFrame(0x01, testChecksum(data), data)), ""},
+		{"compressed", concat([]byte{0x08}, testStreamIdentifier, testFrame(0x00, testChecksum(data), block)), ""},
+		{"split over chunks", concat([]byte{0x08}, testStreamIdentifier,
+			testFrame(0x01, testChecksum(data[:3]), data[:3]), testFrame(0x01, testChecksum(data[3:]), data[3:])), ""},
+		{"skippable padding", concat([]byte{0x08}, testStreamIdentifier, testFrame(0xfe, []byte{0, 0}),
+			testFrame(0x01, testChecksum(data), data)), ""},
+		{"bad checksum", concat([]byte{0x08}, testStreamIdentifier, testFrame(0x01, testChecksum(data[1:]), data)),
+			"checksum"},
+		{"bad compressed checksum", concat([]byte{0x08}, testStreamIdentifier, testFrame(0x00, testChecksum(nil), block)),
+			"checksum"},
+		{"missing stream identifier", concat([]byte{0x08}, testFrame(0x01, testChecksum(data), data)),
+			"missing snappy stream identifier"},
+		{"bad stream identifier", concat([]byte{0x08}, testFrame(0xff, []byte("sNaPpX")), testFrame(0x01, testChecksum(data), data)),
+			"invalid snappy stream identifier"},
+		{"oversize chunk", concat([]byte{0x08}, testStreamIdentifier,
+			[]byte{0x00, 0xff, 0xff, 0xff}), "invalid snappy chunk length"},
+		{"chunk exceeds payload", concat([]byte{0x08}, testStreamIdentifier,
+			testFrame(0x01, testChecksum(append(data, 0)), append(data, 0))), "exceeds payload length"},
+		{"length prefix larger than frames", concat([]byte{0x08}, testStreamIdentifier,
+			testFrame(0x01, testChecksum(data[:4]), data[:4])), "failed to read snappy frame header"},
+		{"length prefix smaller than type", concat([]byte{0x04}, testStreamIdentifier,
+			testFrame(0x01, testChecksum(data[:4]), data[:4])), "does not match"},
+		{"length prefix larger than type", concat([]byte{0x09}, testStreamIdentifier,
+			testFrame(0x01, testChecksum(append(data, 0)), append(data, 0))), "exceeds limit"},
+		{"unskippable chunk type", concat([]byte{0x08}, testStreamIdentifier, testFrame(0x02, data)),
+			"unsupported unskippable snappy chunk type"},
+		{"empty", nil, "failed to read payload length"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var ping common.Ping
+			err := ReadRequest(spec, bytes.NewReader(c.input), &ping)
+			if c.err == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if ping != 0x0102030405060708 {
+					t.Fatalf("unexpected ping %x", uint64(ping))
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("expected error containing %q", c.err)
+			}
+			if !strings.Contains(err.Error(), c.err) {
+				t.Fatalf("expected error containing %q, got: %v", c.err, err)
+			}
+		})
+	}
+}
+
+func TestReadEmptyPayload(t *testing.T) {
+	spec := configs.Mainnet
+	cases := []struct {
+		name  string
+		input []byte
+		rest  []byte
+		err   string
+	}{
+		{"no stream identifier", []byte{0x00}, nil, ""},
+		{"stream identifier", concat([]byte{0x00}, testStreamIdentifier), nil, ""},
+		{"stream identifier before next chunk", concat([]byte{0x00}, testStreamIdentifier, []byte{0x00, 0x08}), []byte{0x00, 0x08}, ""},
+		{"next chunk", []byte{0x00, 0x01, 0x00}, []byte{0x01, 0x00}, ""},
+		{"bad stream identifier", concat([]byte{0x00}, testFrame(0xff, []byte("sNaPpX"))), nil, "invalid snappy stream identifier"},
+		{"truncated stream identifier", concat([]byte{0x00}, testStreamIdentifier[:6]), nil, "failed to read snappy stream identifier"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			r := bufio.NewReader(bytes.NewReader(c.input))
+			data, err := readPayload(spec, r, 100)
+			if c.err != "" {
+				if err == nil || !strings.Contains(err.Error(), c.err) {
+					t.Fatalf("expected error containing %q, got: %v", c.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(data) != 0 {
+				t.Fatalf("expected empty payload, got %x", data)
+			}
+			rest, err := io.ReadAll(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(rest, c.rest) {
+				t.Fatalf("expected remaining %x, got %x", c.rest, rest)
+			}
+		})
+	}
+}
+
+// pingAllocator allocates pings, and records the fork digest of every chunk.
+type pingAllocator struct {
+	digests []common.ForkDigest
+}
+
+func (a *pingAllocator) alloc(digest common.ForkDigest) (interface{}, error) {
+	a.digests = append(a.digests, digest)
+	return new(common.Ping), nil
+}
+
+func TestResponseRoundTrip(t *testing.T) {
+	spec := configs.Mainnet
+	digestA, digestB := common.ForkDigest{1, 2, 3, 4}, common.ForkDigest{5, 6, 7, 8}
+	longMsg := strings.Repeat("x", MAX_ERROR_MESSAGE_LENGTH+10)
+
+	cases := []struct {
+		name       string
+		protocolID string
+		contexts   []*common.ForkDigest
+	}{
+		{"context bytes", BeaconBlocksByRangeProtocolIDV2, []*common.ForkDigest{&digestA, &digestB}},
+		{"no context bytes", PingProtocolID, []*common.ForkDigest{nil, nil}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			rw := NewResponseWriter(spec, &buf)
+			ping1, ping2 := common.Ping(1), common.Ping(2)
+			if err := rw.WriteChunk(c.contexts[0], &ping1); err != nil {
+				t.Fatal(err)
+			}
+			if err := rw.WriteError(ResourceUnavailableCode, longMsg); err != nil {
+				t.Fatal(err)
+			}
+			if err := rw.WriteError(ServerErrorCode, ""); err != nil {
+				t.Fatal(err)
+			}
+			if err := rw.WriteChunk(c.contexts[1], &ping2); err != nil {
+				t.Fatal(err)
+			}
+
+			rr := NewResponseReader(spec, &buf, c.protocolID, 0)
+			var a pingAllocator
+			out, err := rr.ReadChunk(a.alloc)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if *out.(*common.Ping) != 1 {
+				t.Errorf("unexpected first chunk %d", *out.(*common.Ping))
+			}
+			var errResp *ErrorResponse
+			if _, err := rr.ReadChunk(a.alloc); !errors.As(err, &errResp) {
+				t.Fatalf("expected error response, got: %v", err)
+			}
+			if errResp.Code != ResourceUnavailableCode || errResp.Message != longMsg[:MAX_ERROR_MESSAGE_LENGTH] {
+				t.Errorf("unexpected error response %d with message of %d bytes", errResp.Code, len(errResp.Message))
+			}
+			if _, err := rr.ReadChunk(a.alloc); !errors.As(err, &errResp) {
+				t.Fatalf("expected error response, got: %v", err)
+			}
+			if errResp.Code != ServerErrorCode || errResp.Message != "" {
+				t.Errorf("unexpected error response %d with message %q", errResp.Code, errResp.Message)
+			}
+			out, err = rr.ReadChunk(a.alloc)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if *out.(*common.Ping) != 2 {
+				t.Errorf("unexpected last chunk %d", *out.(*common.Ping))
+			}
+			if _, err := rr.ReadChunk(a.alloc); err != io.EOF {
+				t.Fatalf("expected EOF, got: %v", err)
+			}
+			var expected []common.ForkDigest
+			for _, ctx := range c.contexts {
+				if ctx != nil {
+					expected = append(expected, *ctx)
+				} else {
+					expected = append(expected, common.ForkDigest{})
+				}
+			}
+			if len(a.digests) != 2 || a.digests[0] != expected[0] || a.digests[1] != expected[1] {
+				t.Errorf("expected digests %v, got %v", expected, a.digests)
+			}
+		})
+	}
+
+	var buf bytes.Buffer
+	if err := NewResponseWriter(spec, &buf).WriteError(SuccessCode, "fail"); err == nil {
+		t.Error("expected error for error chunk with success code")
+	}
+}
+
+func TestReadChunkErrors(t *testing.T) {
+	spec := configs.Mainnet
+	data := []byte{1, 0, 0, 0, 0, 0, 0, 0}
+	pingChunk := concat([]byte{0x00, 0x08}, testStreamIdentifier, testFrame(0x01, testChecksum(data), data))
+	digest := []byte{1, 2, 3, 4}
+	longMsg := []byte(strings.Repeat("x", MAX_ERROR_MESSAGE_LENGTH+1))
+	cases := []struct {
+		name       string
+		protocolID string
+		input      []byte
+		chunks     int
+		err        string
+	}{
+		{"truncated payload", PingProtocolID, pingChunk[:len(pingChunk)-2], 0, "failed to read snappy chunk"},
+		{"truncated length", PingProtocolID, []byte{0x00}, 0, "failed to read payload length"},
+		{"truncated context bytes", BeaconBlocksByRangeProtocolIDV2, []byte{0x00, 1, 2}, 0, "failed to read context bytes"},
+		{"truncated error message", PingProtocolID, concat([]byte{0x02, 0x04}, testStreamIdentifier, testFrame(0x01, testChecksum([]byte("ab")), []byte("ab"))),
+			0, "failed to read error message"},
+		{"error message too long", PingProtocolID, concat([]byte{0x02, 0x81, 0x02}, testStreamIdentifier, testFrame(0x01, testChecksum(longMsg), longMsg)),
+			0, "exceeds limit"},
+		{"more chunks than requested", BeaconBlocksByRangeProtocolIDV2,
+			concat([]byte{0x00}, digest, pingChunk[1:], []byte{0x00}, digest, pingChunk[1:], []byte{0x00}, digest, pingChunk[1:]),
+			2, "more than 2 chunks"},
+		{"error chunk counts to the limit", PingProtocolID, concat(pingChunk, []byte{0x03, 0x00}, pingChunk), 2, "more than 2 chunks"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			rr := NewResponseReader(spec, bytes.NewReader(c.input), c.protocolID, uint64(c.chunks))
+			var a pingAllocator
+			var err error
+			for err == nil {
+				_, err = rr.ReadChunk(a.alloc)
+				var errResp *ErrorResponse
+				if errors.As(err, &errResp) {
+					err = nil
+				}
+			}
+			if !strings.Contains(err.Error(), c.err) {
+				t.Fatalf("expected error containing %q, got: %v", c.err, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,337 @@
+package reqresp
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/protolambda/zrnt/eth2/beacon"
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/protolambda/zrnt/eth2/beacon/deneb"
+)
+
+// BlockStore provides the blocks and blob sidecars to serve, the chain itself only tracks states.
+type BlockStore interface {
+	// Block returns the block with the given root, or false if it is not available.
+	Block(root common.Root) (*common.BeaconBlockEnvelope, bool)
+	// BlobSidecars returns the blob sidecars of the block with the given root, or false if they are not available.
+	BlobSidecars(root common.Root) ([]*deneb.BlobSidecar, bool)
+}
+
+// ChainHandlers answers req/resp requests from a chain and block store.
+type ChainHandlers struct {
+	spec   *common.Spec
+	chain  beacon.Chain
+	blocks BlockStore
+	dec    *beacon.ForkDecoder
+
+	// Now returns the current time. Defaults to time.Now, can be replaced for testing.
+	Now func() time.Time
+
+	// MetaData returns the local MetaData, older protocol versions serve a subset of it.
+	// If nil, a zero MetaData is served.
+	MetaData func() common.MetaDataV3
+}
+
+func NewChainHandlers(spec *common.Spec, chain beacon.Chain, blocks BlockStore) *ChainHandlers {
+	return &ChainHandlers{
+		spec:   spec,
+		chain:  chain,
+		blocks: blocks,
+		dec:    beacon.NewForkDecoder(spec, chain.Genesis().ValidatorsRoot),
+		Now:    time.Now,
+	}
+}
+
+// CurrentSlot returns the current slot, based on the genesis time of the chain.
+func (h *ChainHandlers) CurrentSlot() common.Slot {
+	genesis := time.Unix(int64(h.chain.Genesis().Time), 0)
+	since := h.Now().Sub(genesis)
+	if since < 0 {
+		return 0
+	}
+	return common.Slot(since / (time.Duration(h.spec.SECONDS_PER_SLOT) * time.Second))
+}
+
+func (h *ChainHandlers) currentEpoch() common.Epoch {
+	return h.spec.SlotToEpoch(h.CurrentSlot())
+}
+
+// minEpoch returns the start of the epoch range [max(minEpoch, current - epochs), current]
+// that must be served.
+func (h *ChainHandlers) minEpoch(minEpoch common.Epoch, epochs uint64) common.Epoch {
+	current := h.currentEpoch()
+	if uint64(current) > epochs && current-common.Epoch(epochs) > minEpoch {
+		return current - common.Epoch(epochs)
+	}
+	return minEpoch
+}
+
+// LocalStatus returns the Status of the local node, with the fork digest of the current epoch.
+func (h *ChainHandlers) LocalStatus() (*common.Status, error) {
+	head, err := h.chain.Head()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get head: %w", err)
+	}
+	headRoot, err := head.BlockRoot()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get head block root: %w", err)
+	}
+	finalized := h.chain.FinalizedCheckpoint()
+	return &common.Status{
+		ForkDigest:     h.dec.ForkDigest(h.currentEpoch()),
+		FinalizedRoot:  finalized.Root,
+		FinalizedEpoch: finalized.Epoch,
+		HeadRoot:       headRoot,
+		HeadSlot:       head.Step().Slot(),
+	}, nil
+}
+
+// respondError writes an error response chunk, and returns the original error.
+func respondError(rw *ResponseWriter, code ResponseCode, err error) error {
+	if werr := rw.WriteError(code, err.Error()); werr != nil {
+		return fmt.Errorf("failed to write error response (%v): %w", err, werr)
+	}
+	return err
+}
+
+// HandleRequest reads a request of the given protocol from r, and writes the response to w.
+func (h *ChainHandlers) HandleRequest(ctx context.Context, protocolID string, r io.Reader, w io.Writer) error {
+	switch protocolID {
+	case StatusProtocolID:
+		_, err := h.HandleStatus(r, w)
+		return err
+	case MetaDataProtocolIDV1, MetaDataProtocolIDV2, MetaDataProtocolIDV3:
+		return h.HandleMetaData(protocolID, w)
+	case BeaconBlocksByRangeProtocolIDV2:
+		return h.HandleBlocksByRange(ctx, r, w)
+	case BeaconBlocksByRootProtocolIDV2:
+		return h.HandleBlocksByRoot(ctx, r, w)
+	case BlobSidecarsByRangeProtocolID:
+		return h.HandleBlobSidecarsByRange(ctx, r, w)
+	case BlobSidecarsByRootProtocolID:
+		return h.HandleBlobSidecarsByRoot(ctx, r, w)
+	default:
+		return fmt.Errorf("unsupported protocol %s", protocolID)
+	}
+}
+
+// HandleStatus responds with the local status, and returns the status of the peer.
+func (h *ChainHandlers) HandleStatus(r io.Reader, w io.Writer) (*common.Status, error) {
+	rw := NewResponseWriter(h.spec, w)
+	var peer common.Status
+	if err := ReadRequest(h.spec, r, &peer); err != nil {
+		return nil, respondError(rw, InvalidRequestCode, err)
+	}
+	status, err := h.LocalStatus()
+	if err != nil {
+		return nil, respondError(rw, ServerErrorCode, err)
+	}
+	if err := rw.WriteChunk(nil, status); err != nil {
+		return nil, err
+	}
+	return &peer, nil
+}
+
+// HandleMetaData responds with the local MetaData, in the version of the protocol.
+// The request has no payload.
+func (h *ChainHandlers) HandleMetaData(protocolID string, w io.Writer) error {
+	var md common.MetaDataV3
+	if h.MetaData != nil {
+		md = h.MetaData()
+	}
+	rw := NewResponseWriter(h.spec, w)
+	switch protocolID {
+	case MetaDataProtocolIDV1:
+		v2 := md.V2()
+		v1 := v2.V1()
+		return rw.WriteChunk(nil, &v1)
+	case MetaDataProtocolIDV2:
+		v2 := md.V2()
+		return rw.WriteChunk(nil, &v2)
+	case MetaDataProtocolIDV3:
+		return rw.WriteChunk(nil, &md)
+	default:
+		return fmt.Errorf("unsupported metadata protocol %s", protocolID)
+	}
+}
+
+// canonicalBlock returns the root of the canonical block at the slot, or false if the slot is empty or unknown.
+func (h *ChainHandlers) canonicalBlock(slot common.Slot) (common.Root, bool, error) {
+	step := common.AsStep(slot, true)
+	entry, ok := h.chain.ByCanonStep(step)
+	if !ok || entry == nil || entry.Step() != step {
+		return common.Root{}, false, nil
+	}
+	root, err := entry.BlockRoot()
+	if err != nil {
+		return common.Root{}, false, err
+	}
+	return root, true, nil
+}
+
+func (h *ChainHandlers) writeBlock(rw *ResponseWriter, benv *common.BeaconBlockEnvelope) error {
+	block, err := beacon.EnvelopeToSignedBeaconBlock(benv)
+	if err != nil {
+		return err
+	}
+	digest := h.dec.ForkDigest(h.spec.SlotToEpoch(benv.Slot))
+	return rw.WriteChunk(&digest, block)
+}
+
+// HandleBlocksByRange responds with the canonical blocks in the requested slot range.
+// Blocks before the MIN_EPOCHS_FOR_BLOCK_REQUESTS range that are not available result in ResourceUnavailable.
+func (h *ChainHandlers) HandleBlocksByRange(ctx context.Context, r io.Reader, w io.Writer) error {
+	rw := NewResponseWriter(h.spec, w)
+	var req BeaconBlocksByRangeRequest
+	if err := ReadRequest(h.spec, r, &req); err != nil {
+		return respondError(rw, InvalidRequestCode, err)
+	}
+	if err := req.Validate(h.spec, h.currentEpoch()); err != nil {
+		return respondError(rw, InvalidRequestCode, err)
+	}
+	minSlot, err := h.spec.EpochStartSlot(h.minEpoch(common.GENESIS_EPOCH, uint64(h.spec.MIN_EPOCHS_FOR_BLOCK_REQUESTS)))
+	if err != nil {
+		return respondError(rw, ServerErrorCode, err)
+	}
+	for i := uint64(0); i < uint64(req.Count); i++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		slot := req.StartSlot + common.Slot(i)
+		root, ok, err := h.canonicalBlock(slot)
+		if err != nil {
+			return respondError(rw, ServerErrorCode, err)
+		}
+		if !ok {
+			continue
+		}
+		benv, ok := h.blocks.Block(root)
+		if !ok {
+			if slot < minSlot {
+				return respondError(rw, ResourceUnavailableCode, fmt.Errorf("block at slot %d is not available", slot))
+			}
+			return respondError(rw, ServerErrorCode, fmt.Errorf("missing canonical block %s at slot %d", root, slot))
+		}
+		if err := h.writeBlock(rw, benv); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// HandleBlocksByRoot responds with the requested blocks that are available, unknown blocks are skipped.
+func (h *ChainHandlers) HandleBlocksByRoot(ctx context.Context, r io.Reader, w io.Writer) error {
+	rw := NewResponseWriter(h.spec, w)
+	var req BeaconBlocksByRootRequest
+	if err := ReadRequest(h.spec, r, &req); err != nil {
+		return respondError(rw, InvalidRequestCode, err)
+	}
+	if err := req.Validate(h.spec, h.currentEpoch()); err != nil {
+		return respondError(rw, InvalidRequestCode, err)
+	}
+	for _, root := range req {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		benv, ok := h.blocks.Block(root)
+		if !ok {
+			continue
+		}
+		if err := h.writeBlock(rw, benv); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (h *ChainHandlers) writeBlobSidecar(rw *ResponseWriter, sidecar *deneb.BlobSidecar) error {
+	digest := h.dec.ForkDigest(h.spec.SlotToEpoch(sidecar.SignedBlockHeader.Message.Slot))
+	return rw.WriteChunk(&digest, sidecar)
+}
+
+// HandleBlobSidecarsByRange responds with the blob sidecars of the canonical blocks in the requested slot range,
+// ordered by slot and index. Blob sidecars before the MIN_EPOCHS_FOR_BLOB_SIDECARS_REQUESTS range
+// that are not available result in ResourceUnavailable.
+func (h *ChainHandlers) HandleBlobSidecarsByRange(ctx context.Context, r io.Reader, w io.Writer) error {
+	rw := NewResponseWriter(h.spec, w)
+	var req BlobSidecarsByRangeRequest
+	if err := ReadRequest(h.spec, r, &req); err != nil {
+		return respondError(rw, InvalidRequestCode, err)
+	}
+	if err := req.Validate(h.spec); err != nil {
+		return respondError(rw, InvalidRequestCode, err)
+	}
+	minSlot, err := h.spec.EpochStartSlot(h.minEpoch(h.spec.DENEB_FORK_EPOCH, uint64(h.spec.MIN_EPOCHS_FOR_BLOB_SIDECARS_REQUESTS)))
+	if err != nil {
+		return respondError(rw, ServerErrorCode, err)
+	}
+	for i := uint64(0); i < uint64(req.Count); i++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		slot := req.StartSlot + common.Slot(i)
+		if h.spec.SlotToEpoch(slot) < h.spec.DENEB_FORK_EPOCH {
+			continue
+		}
+		root, ok, err := h.canonicalBlock(slot)
+		if err != nil {
+			return respondError(rw, ServerErrorCode, err)
+		}
+		if !ok {
+			continue
+		}
+		sidecars, ok := h.blocks.BlobSidecars(root)
+		if !ok {
+			if slot < minSlot {
+				return respondError(rw, ResourceUnavailableCode, fmt.Errorf("blob sidecars at slot %d are not available", slot))
+			}
+			return respondError(rw, ServerErrorCode, fmt.Errorf("missing blob sidecars of canonical block %s at slot %d", root, slot))
+		}
+		sorted := append([]*deneb.BlobSidecar(nil), sidecars...)
+		sort.Slice(sorted, func(i, j int) bool {
+			return sorted[i].Index < sorted[j].Index
+		})
+		for _, sidecar := range sorted {
+			if err := h.writeBlobSidecar(rw, sidecar); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// HandleBlobSidecarsByRoot responds with the requested blob sidecars that are available,
+// within the MIN_EPOCHS_FOR_BLOB_SIDECARS_REQUESTS range. Unknown blob sidecars are skipped.
+func (h *ChainHandlers) HandleBlobSidecarsByRoot(ctx context.Context, r io.Reader, w io.Writer) error {
+	rw := NewResponseWriter(h.spec, w)
+	var req BlobSidecarsByRootRequest
+	if err := ReadRequest(h.spec, r, &req); err != nil {
+		return respondError(rw, InvalidRequestCode, err)
+	}
+	minEpoch := h.minEpoch(h.spec.DENEB_FORK_EPOCH, uint64(h.spec.MIN_EPOCHS_FOR_BLOB_SIDECARS_REQUESTS))
+	for _, id := range req {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		sidecars, ok := h.blocks.BlobSidecars(id.BlockRoot)
+		if !ok {
+			continue
+		}
+		for _, sidecar := range sidecars {
+			if sidecar.Index != id.Index {
+				continue
+			}
+			if h.spec.SlotToEpoch(sidecar.SignedBlockHeader.Message.Slot) < minEpoch {
+				break
+			}
+			if err := h.writeBlobSidecar(rw, sidecar); err != nil {
+				return err
+			}
+			break
+		}
+	}
+	return nil
+}
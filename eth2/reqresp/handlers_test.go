@@ -0,0 +1,384 @@
+package reqresp
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/protolambda/ztyp/view"
+
+	"github.com/protolambda/zrnt/eth2/beacon"
+	"github.com/protolambda/zrnt/eth2/beacon/altair"
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/protolambda/zrnt/eth2/beacon/deneb"
+	"github.com/protolambda/zrnt/eth2/configs"
+)
+
+type testEntry struct {
+	step       common.Step
+	blockRoot  common.Root
+	parentRoot common.Root
+}
+
+func (e *testEntry) Step() common.Step                { return e.step }
+func (e *testEntry) BlockRoot() (common.Root, error)  { return e.blockRoot, nil }
+func (e *testEntry) ParentRoot() (common.Root, error) { return e.parentRoot, nil }
+func (e *testEntry) StateRoot() (common.Root, error)  { return common.Root{}, nil }
+func (e *testEntry) State(context.Context) (common.BeaconState, error) {
+	return nil, errors.New("no state")
+}
+func (e *testEntry) EpochsContext(context.Context) (*common.EpochsContext, error) {
+	return nil, errors.New("no epochs context")
+}
+
+// testChain is a linear chain of blocks, only the parts of the chain used by the handlers are implemented.
+type testChain struct {
+	genesis   beacon.GenesisInfo
+	finalized common.Checkpoint
+	canonical map[common.Slot]*testEntry
+	head      *testEntry
+}
+
+func (c *testChain) ByStateRoot(common.Root) (beacon.ChainEntry, bool) { return nil, false }
+func (c *testChain) ByBlock(root common.Root) (beacon.ChainEntry, bool) {
+	for _, e := range c.canonical {
+		if e.blockRoot == root {
+			return e, true
+		}
+	}
+	return nil, false
+}
+func (c *testChain) ByBlockSlot(common.Root, common.Slot) (beacon.ChainEntry, bool) {
+	return nil, false
+}
+func (c *testChain) Search(*common.Root, *common.Slot) ([]beacon.SearchEntry, error) {
+	return nil, errors.New("not supported")
+}
+func (c *testChain) Closest(common.Root, common.Slot) (beacon.ChainEntry, bool) { return nil, false }
+func (c *testChain) InSubtree(common.Root, common.Root) (bool, bool)            { return true, false }
+func (c *testChain) ByCanonStep(step common.Step) (beacon.ChainEntry, bool) {
+	e, ok := c.canonical[step.Slot()]
+	if !ok || !step.Block() {
+		return nil, false
+	}
+	return e, true
+}
+func (c *testChain) Iter() (beacon.ChainIter, error)        { return nil, errors.New("not supported") }
+func (c *testChain) JustifiedCheckpoint() common.Checkpoint { return c.finalized }
+func (c *testChain) FinalizedCheckpoint() common.Checkpoint { return c.finalized }
+func (c *testChain) Justified() (beacon.ChainEntry, error)  { return nil, errors.New("not supported") }
+func (c *testChain) Finalized() (beacon.ChainEntry, error)  { return nil, errors.New("not supported") }
+func (c *testChain) Head() (beacon.ChainEntry, error)       { return c.head, nil }
+func (c *testChain) Genesis() beacon.GenesisInfo            { return c.genesis }
+func (c *testChain) Towards(context.Context, common.Root, common.Slot) (beacon.ChainEntry, error) {
+	return nil, errors.New("not supported")
+}
+
+type testBlockStore struct {
+	blocks   map[common.Root]*common.BeaconBlockEnvelope
+	sidecars map[common.Root][]*deneb.BlobSidecar
+}
+
+func (s *testBlockStore) Block(root common.Root) (*common.BeaconBlockEnvelope, bool) {
+	b, ok := s.blocks[root]
+	return b, ok
+}
+
+func (s *testBlockStore) BlobSidecars(root common.Root) ([]*deneb.BlobSidecar, bool) {
+	b, ok := s.sidecars[root]
+	return b, ok
+}
+
+type testSetup struct {
+	spec  *common.Spec
+	dec   *beacon.ForkDecoder
+	chain *testChain
+	store *testBlockStore
+	h     *ChainHandlers
+	roots map[common.Slot]common.Root
+}
+
+// newTestSetup creates a Deneb chain with blocks at the given slots, and 2 blob sidecars per block.
+func newTestSetup(t *testing.T, slots ...common.Slot) *testSetup {
+	spec := *configs.Minimal
+	spec.ALTAIR_FORK_EPOCH = 0
+	spec.BELLATRIX_FORK_EPOCH = 0
+	spec.CAPELLA_FORK_EPOCH = 0
+	spec.DENEB_FORK_EPOCH = 0
+	s := &testSetup{
+		spec: &spec,
+		chain: &testChain{
+			genesis:   beacon.GenesisInfo{Time: 1000, ValidatorsRoot: common.Root{0xaa}},
+			canonical: make(map[common.Slot]*testEntry),
+		},
+		store: &testBlockStore{
+			blocks:   make(map[common.Root]*common.BeaconBlockEnvelope),
+			sidecars: make(map[common.Root][]*deneb.BlobSidecar),
+		},
+		roots: make(map[common.Slot]common.Root),
+	}
+	s.dec = beacon.NewForkDecoder(s.spec, s.chain.genesis.ValidatorsRoot)
+	var parent common.Root
+	for _, slot := range slots {
+		block := &deneb.SignedBeaconBlock{Message: deneb.BeaconBlock{Slot: slot, ParentRoot: parent}}
+		block.Message.Body.SyncAggregate.SyncCommitteeBits = make(altair.SyncCommitteeBits, s.spec.SYNC_COMMITTEE_SIZE/8)
+		benv := block.Envelope(s.spec, s.dec.Deneb)
+		entry := &testEntry{step: common.AsStep(slot, true), blockRoot: benv.BlockRoot, parentRoot: parent}
+		s.chain.canonical[slot] = entry
+		s.chain.head = entry
+		s.store.blocks[benv.BlockRoot] = benv
+		for _, i := range []deneb.BlobIndex{1, 0} {
+			s.store.sidecars[benv.BlockRoot] = append(s.store.sidecars[benv.BlockRoot], &deneb.BlobSidecar{
+				Index:                       i,
+				Blob:                        make(deneb.Blob, uint64(s.spec.FIELD_ELEMENTS_PER_BLOB)*deneb.BYTES_PER_FIELD_ELEMENT),
+				SignedBlockHeader:           common.SignedBeaconBlockHeader{Message: benv.BeaconBlockHeader},
+				KZGCommitmentInclusionProof: make(deneb.KZGCommitmentInclusionProof, s.spec.KZG_COMMITMENT_INCLUSION_PROOF_DEPTH),
+			})
+		}
+		s.roots[slot] = benv.BlockRoot
+		parent = benv.BlockRoot
+	}
+	s.h = NewChainHandlers(s.spec, s.chain, s.store)
+	s.h.Now = func() time.Time {
+		return time.Unix(int64(s.chain.genesis.Time)+100*int64(s.spec.SECONDS_PER_SLOT), 0)
+	}
+	return s
+}
+
+// request runs the handler over in-memory pipes, and reads all response chunks.
+// It returns the chunks, the error of the response reader, and the error of the handler.
+func (s *testSetup) request(t *testing.T, protocolID string, req interface{}) ([]interface{}, error, error) {
+	reqR, reqW := io.Pipe()
+	respR, respW := io.Pipe()
+	handlerErr := make(chan error, 1)
+	go func() {
+		err := s.h.HandleRequest(context.Background(), protocolID, reqR, respW)
+		respW.Close()
+		handlerErr <- err
+	}()
+	if req != nil {
+		if err := WriteRequest(s.spec, reqW, req); err != nil {
+			t.Fatal(err)
+		}
+	}
+	reqW.Close()
+	alloc, err := ChunkAllocator(s.dec, protocolID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := NewResponseReader(s.spec, respR, protocolID, 0)
+	var chunks []interface{}
+	for {
+		chunk, err := rr.ReadChunk(alloc)
+		if err == io.EOF {
+			return chunks, nil, <-handlerErr
+		}
+		if err != nil {
+			// drain the response, so the handler can finish
+			io.Copy(io.Discard, respR)
+			return chunks, err, <-handlerErr
+		}
+		chunks = append(chunks, chunk)
+	}
+}
+
+func expectErrorCode(t *testing.T, err error, code ResponseCode) {
+	t.Helper()
+	var resp *ErrorResponse
+	if !errors.As(err, &resp) {
+		t.Fatalf("expected error response, got %v", err)
+	}
+	if resp.Code != code {
+		t.Fatalf("expected %s response, got %s", code, resp.Code)
+	}
+}
+
+func TestHandleStatus(t *testing.T) {
+	s := newTestSetup(t, 1, 2, 3)
+	s.chain.finalized = common.Checkpoint{Epoch: 0, Root: s.roots[1]}
+	peer := &common.Status{ForkDigest: s.dec.Deneb, HeadSlot: 42}
+	chunks, err, _ := s.request(t, StatusProtocolID, peer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chunks) != 1 {
+		t.Fatalf("expected 1 chunk, got %d", len(chunks))
+	}
+	status := chunks[0].(*common.Status)
+	if status.ForkDigest != s.dec.Deneb {
+		t.Errorf("unexpected fork digest %s", status.ForkDigest)
+	}
+	if status.HeadRoot != s.roots[3] || status.HeadSlot != 3 {
+		t.Errorf("unexpected head %s at slot %d", status.HeadRoot, status.HeadSlot)
+	}
+	if status.FinalizedRoot != s.roots[1] {
+		t.Errorf("unexpected finalized root %s", status.FinalizedRoot)
+	}
+
+	// the handler returns the status of the peer
+	reqR, reqW := io.Pipe()
+	go func() {
+		WriteRequest(s.spec, reqW, peer)
+		reqW.Close()
+	}()
+	got, err := s.h.HandleStatus(reqR, io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *got != *peer {
+		t.Errorf("unexpected peer status %s", got)
+	}
+}
+
+func TestHandleMetaData(t *testing.T) {
+	s := newTestSetup(t, 1)
+	s.h.MetaData = func() common.MetaDataV3 {
+		return common.MetaDataV3{
+			SeqNumber:          7,
+			Attnets:            common.AttnetBitsOf([]uint64{3}),
+			Syncnets:           common.SyncnetBitsOf([]uint64{1}),
+			CustodySubnetCount: 4,
+		}
+	}
+	for _, protocolID := range []string{MetaDataProtocolIDV1, MetaDataProtocolIDV2, MetaDataProtocolIDV3} {
+		chunks, err, _ := s.request(t, protocolID, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(chunks) != 1 {
+			t.Fatalf("expected 1 chunk, got %d", len(chunks))
+		}
+		switch md := chunks[0].(type) {
+		case *common.MetaDataV1:
+			if protocolID != MetaDataProtocolIDV1 || md.SeqNumber != 7 || !md.Attnets.GetBit(3) {
+				t.Errorf("unexpected metadata %s", md)
+			}
+		case *common.MetaData:
+			if protocolID != MetaDataProtocolIDV2 || md.SeqNumber != 7 || !md.Attnets.GetBit(3) || !md.Syncnets.GetBit(1) {
+				t.Errorf("unexpected metadata %s", md)
+			}
+		case *common.MetaDataV3:
+			if protocolID != MetaDataProtocolIDV3 || md.SeqNumber != 7 || !md.Syncnets.GetBit(1) || md.CustodySubnetCount != 4 {
+				t.Errorf("unexpected metadata %s", md)
+			}
+		default:
+			t.Fatalf("unexpected chunk type %T", md)
+		}
+	}
+}
+
+func TestHandleBlocksByRange(t *testing.T) {
+	s := newTestSetup(t, 1, 2, 4, 5)
+	chunks, err, _ := s.request(t, BeaconBlocksByRangeProtocolIDV2, &BeaconBlocksByRangeRequest{StartSlot: 0, Count: 5, Step: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []common.Slot{1, 2, 4}
+	if len(chunks) != len(expected) {
+		t.Fatalf("expected %d blocks, got %d", len(expected), len(chunks))
+	}
+	for i, chunk := range chunks {
+		block, ok := chunk.(*deneb.SignedBeaconBlock)
+		if !ok {
+			t.Fatalf("unexpected chunk type %T", chunk)
+		}
+		if block.Message.Slot != expected[i] {
+			t.Errorf("block %d: expected slot %d, got %d", i, expected[i], block.Message.Slot)
+		}
+	}
+
+	_, err, handlerErr := s.request(t, BeaconBlocksByRangeProtocolIDV2, &BeaconBlocksByRangeRequest{StartSlot: 0, Count: 5, Step: 0})
+	expectErrorCode(t, err, InvalidRequestCode)
+	if handlerErr == nil {
+		t.Error("expected handler error")
+	}
+	count := view.Uint64View(s.spec.MAX_REQUEST_BLOCKS_DENEB) + 1
+	_, err, _ = s.request(t, BeaconBlocksByRangeProtocolIDV2, &BeaconBlocksByRangeRequest{StartSlot: 0, Count: count, Step: 1})
+	expectErrorCode(t, err, InvalidRequestCode)
+}
+
+func TestHandleBlocksByRangeUnavailable(t *testing.T) {
+	s := newTestSetup(t, 1, 2)
+	delete(s.store.blocks, s.roots[2])
+	// missing blocks within the MIN_EPOCHS_FOR_BLOCK_REQUESTS range are a server error
+	chunks, err, _ := s.request(t, BeaconBlocksByRangeProtocolIDV2, &BeaconBlocksByRangeRequest{StartSlot: 0, Count: 3, Step: 1})
+	expectErrorCode(t, err, ServerErrorCode)
+	if len(chunks) != 1 {
+		t.Errorf("expected 1 block before the error, got %d", len(chunks))
+	}
+	// blocks before the range may be unavailable
+	s.h.Now = func() time.Time {
+		slots := (uint64(s.spec.MIN_EPOCHS_FOR_BLOCK_REQUESTS) + 10) * uint64(s.spec.SLOTS_PER_EPOCH)
+		return time.Unix(int64(s.chain.genesis.Time)+int64(slots)*int64(s.spec.SECONDS_PER_SLOT), 0)
+	}
+	_, err, _ = s.request(t, BeaconBlocksByRangeProtocolIDV2, &BeaconBlocksByRangeRequest{StartSlot: 0, Count: 3, Step: 1})
+	expectErrorCode(t, err, ResourceUnavailableCode)
+}
+
+func TestHandleBlocksByRoot(t *testing.T) {
+	s := newTestSetup(t, 1, 2, 3)
+	chunks, err, _ := s.request(t, BeaconBlocksByRootProtocolIDV2, &BeaconBlocksByRootRequest{s.roots[3], {0x42}, s.roots[1]})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []common.Slot{3, 1}
+	if len(chunks) != len(expected) {
+		t.Fatalf("expected %d blocks, got %d", len(expected), len(chunks))
+	}
+	for i, chunk := range chunks {
+		if slot := chunk.(*deneb.SignedBeaconBlock).Message.Slot; slot != expected[i] {
+			t.Errorf("block %d: expected slot %d, got %d", i, expected[i], slot)
+		}
+	}
+}
+
+func TestHandleBlobSidecarsByRange(t *testing.T) {
+	s := newTestSetup(t, 1, 3)
+	chunks, err, _ := s.request(t, BlobSidecarsByRangeProtocolID, &BlobSidecarsByRangeRequest{StartSlot: 1, Count: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	type id struct {
+		slot  common.Slot
+		index deneb.BlobIndex
+	}
+	expected := []id{{1, 0}, {1, 1}, {3, 0}, {3, 1}}
+	if len(chunks) != len(expected) {
+		t.Fatalf("expected %d blob sidecars, got %d", len(expected), len(chunks))
+	}
+	for i, chunk := range chunks {
+		sidecar := chunk.(*deneb.BlobSidecar)
+		if got := (id{sidecar.SignedBlockHeader.Message.Slot, sidecar.Index}); got != expected[i] {
+			t.Errorf("blob sidecar %d: expected %v, got %v", i, expected[i], got)
+		}
+	}
+	count := view.Uint64View(s.spec.MAX_REQUEST_BLOCKS_DENEB) + 1
+	_, err, _ = s.request(t, BlobSidecarsByRangeProtocolID, &BlobSidecarsByRangeRequest{StartSlot: 1, Count: count})
+	expectErrorCode(t, err, InvalidRequestCode)
+}
+
+func TestHandleBlobSidecarsByRoot(t *testing.T) {
+	s := newTestSetup(t, 1, 2)
+	req := &BlobSidecarsByRootRequest{
+		{BlockRoot: s.roots[2], Index: 1},
+		{BlockRoot: s.roots[1], Index: 5},
+		{BlockRoot: common.Root{0x42}, Index: 0},
+		{BlockRoot: s.roots[1], Index: 0},
+	}
+	chunks, err, _ := s.request(t, BlobSidecarsByRootProtocolID, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chunks) != 2 {
+		t.Fatalf("expected 2 blob sidecars, got %d", len(chunks))
+	}
+	first, second := chunks[0].(*deneb.BlobSidecar), chunks[1].(*deneb.BlobSidecar)
+	if first.BlockRoot() != s.roots[2] || first.Index != 1 {
+		t.Errorf("unexpected first blob sidecar %d of %s", first.Index, first.BlockRoot())
+	}
+	if second.BlockRoot() != s.roots[1] || second.Index != 0 {
+		t.Errorf("unexpected second blob sidecar %d of %s", second.Index, second.BlockRoot())
+	}
+}
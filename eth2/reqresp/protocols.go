@@ -0,0 +1,328 @@
+package reqresp
+
+import (
+	"fmt"
+
+	"github.com/protolambda/ztyp/codec"
+	"github.com/protolambda/ztyp/tree"
+	. "github.com/protolambda/ztyp/view"
+
+	"github.com/protolambda/zrnt/eth2/beacon"
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/protolambda/zrnt/eth2/beacon/deneb"
+)
+
+// Req/resp protocol IDs, see the p2p-interface spec.
+// All protocols use the ssz_snappy encoding: /eth2/beacon_chain/req/{name}/{version}/ssz_snappy
+const (
+	StatusProtocolID                      = "/eth2/beacon_chain/req/status/1/ssz_snappy"
+	GoodbyeProtocolID                     = "/eth2/beacon_chain/req/goodbye/1/ssz_snappy"
+	PingProtocolID                        = "/eth2/beacon_chain/req/ping/1/ssz_snappy"
+	MetaDataProtocolIDV1                  = "/eth2/beacon_chain/req/metadata/1/ssz_snappy"
+	MetaDataProtocolIDV2                  = "/eth2/beacon_chain/req/metadata/2/ssz_snappy"
+	MetaDataProtocolIDV3                  = "/eth2/beacon_chain/req/metadata/3/ssz_snappy"
+	BeaconBlocksByRangeProtocolIDV2       = "/eth2/beacon_chain/req/beacon_blocks_by_range/2/ssz_snappy"
+	BeaconBlocksByRootProtocolIDV2        = "/eth2/beacon_chain/req/beacon_blocks_by_root/2/ssz_snappy"
+	BlobSidecarsByRangeProtocolID         = "/eth2/beacon_chain/req/blob_sidecars_by_range/1/ssz_snappy"
+	BlobSidecarsByRootProtocolID          = "/eth2/beacon_chain/req/blob_sidecars_by_root/1/ssz_snappy"
+	LightClientBootstrapProtocolID        = "/eth2/beacon_chain/req/light_client_bootstrap/1/ssz_snappy"
+	LightClientUpdatesByRangeProtocolID   = "/eth2/beacon_chain/req/light_client_updates_by_range/1/ssz_snappy"
+	LightClientFinalityUpdateProtocolID   = "/eth2/beacon_chain/req/light_client_finality_update/1/ssz_snappy"
+	LightClientOptimisticUpdateProtocolID = "/eth2/beacon_chain/req/light_client_optimistic_update/1/ssz_snappy"
+)
+
+// MAX_REQUEST_LIGHT_CLIENT_UPDATES is the maximum number of light client updates in a single request.
+const MAX_REQUEST_LIGHT_CLIENT_UPDATES = 128
+
+// HasContextBytes returns true if the success response chunks of the protocol are prefixed
+// with the 4-byte fork digest of the fork of the response object.
+func HasContextBytes(protocolID string) bool {
+	switch protocolID {
+	case BeaconBlocksByRangeProtocolIDV2, BeaconBlocksByRootProtocolIDV2,
+		BlobSidecarsByRangeProtocolID, BlobSidecarsByRootProtocolID,
+		LightClientBootstrapProtocolID, LightClientUpdatesByRangeProtocolID,
+		LightClientFinalityUpdateProtocolID, LightClientOptimisticUpdateProtocolID:
+		return true
+	default:
+		return false
+	}
+}
+
+// ChunkAllocator returns a function to allocate the object of a success response chunk of the protocol,
+// to decode the chunk into. For protocols with context bytes the type depends on the fork digest,
+// for other protocols the fork digest is ignored.
+func ChunkAllocator(dec *beacon.ForkDecoder, protocolID string) (func(digest common.ForkDigest) (interface{}, error), error) {
+	switch protocolID {
+	case StatusProtocolID:
+		return func(common.ForkDigest) (interface{}, error) { return new(common.Status), nil }, nil
+	case PingProtocolID:
+		return func(common.ForkDigest) (interface{}, error) { return new(common.Pong), nil }, nil
+	case MetaDataProtocolIDV1:
+		return func(common.ForkDigest) (interface{}, error) { return new(common.MetaDataV1), nil }, nil
+	case MetaDataProtocolIDV2:
+		return func(common.ForkDigest) (interface{}, error) { return new(common.MetaData), nil }, nil
+	case MetaDataProtocolIDV3:
+		return func(common.ForkDigest) (interface{}, error) { return new(common.MetaDataV3), nil }, nil
+	case BeaconBlocksByRangeProtocolIDV2, BeaconBlocksByRootProtocolIDV2:
+		return func(digest common.ForkDigest) (interface{}, error) {
+			alloc, err := dec.BlockAllocator(digest)
+			if err != nil {
+				return nil, err
+			}
+			return alloc(), nil
+		}, nil
+	case BlobSidecarsByRangeProtocolID, BlobSidecarsByRootProtocolID:
+		return func(digest common.ForkDigest) (interface{}, error) {
+			if digest != dec.Deneb {
+				return nil, fmt.Errorf("unrecognized fork digest for blob sidecar: %s", digest)
+			}
+			return new(deneb.BlobSidecar), nil
+		}, nil
+	case LightClientBootstrapProtocolID:
+		return func(digest common.ForkDigest) (interface{}, error) {
+			alloc, err := dec.LightClientBootstrapAllocator(digest)
+			if err != nil {
+				return nil, err
+			}
+			return alloc(), nil
+		}, nil
+	case LightClientUpdatesByRangeProtocolID:
+		return func(digest common.ForkDigest) (interface{}, error) {
+			alloc, err := dec.LightClientUpdateAllocator(digest)
+			if err != nil {
+				return nil, err
+			}
+			return alloc(), nil
+		}, nil
+	case LightClientFinalityUpdateProtocolID:
+		return func(digest common.ForkDigest) (interface{}, error) {
+			alloc, err := dec.LightClientFinalityUpdateAllocator(digest)
+			if err != nil {
+				return nil, err
+			}
+			return alloc(), nil
+		}, nil
+	case LightClientOptimisticUpdateProtocolID:
+		return func(digest common.ForkDigest) (interface{}, error) {
+			alloc, err := dec.LightClientOptimisticUpdateAllocator(digest)
+			if err != nil {
+				return nil, err
+			}
+			return alloc(), nil
+		}, nil
+	default:
+		return nil, fmt.Errorf("no response chunks for protocol %s", protocolID)
+	}
+}
+
+// MaxRequestBlocks returns the maximum number of blocks in a single request, at the given epoch.
+func MaxRequestBlocks(spec *common.Spec, epoch common.Epoch) uint64 {
+	if epoch >= spec.DENEB_FORK_EPOCH {
+		return uint64(spec.MAX_REQUEST_BLOCKS_DENEB)
+	}
+	return uint64(spec.MAX_REQUEST_BLOCKS)
+}
+
+type BeaconBlocksByRangeRequest struct {
+	StartSlot common.Slot `json:"start_slot" yaml:"start_slot"`
+	Count     Uint64View  `json:"count" yaml:"count"`
+	// Step is deprecated, and must be 1.
+	Step Uint64View `json:"step" yaml:"step"`
+}
+
+func (r *BeaconBlocksByRangeRequest) Deserialize(dr *codec.DecodingReader) error {
+	return dr.FixedLenContainer(&r.StartSlot, &r.Count, &r.Step)
+}
+
+func (r *BeaconBlocksByRangeRequest) Serialize(w *codec.EncodingWriter) error {
+	return w.FixedLenContainer(&r.StartSlot, &r.Count, &r.Step)
+}
+
+func (r BeaconBlocksByRangeRequest) ByteLength() uint64 {
+	return 8 + 8 + 8
+}
+
+func (*BeaconBlocksByRangeRequest) FixedLength() uint64 {
+	return 8 + 8 + 8
+}
+
+func (r *BeaconBlocksByRangeRequest) HashTreeRoot(hFn tree.HashFn) common.Root {
+	return hFn.HashTreeRoot(&r.StartSlot, &r.Count, &r.Step)
+}
+
+// Validate checks the request against the request limits at the given epoch.
+func (r *BeaconBlocksByRangeRequest) Validate(spec *common.Spec, epoch common.Epoch) error {
+	if r.Step != 1 {
+		return fmt.Errorf("unsupported step %d", r.Step)
+	}
+	if max := MaxRequestBlocks(spec, epoch); uint64(r.Count) > max {
+		return fmt.Errorf("requested %d blocks, more than the limit of %d", r.Count, max)
+	}
+	return nil
+}
+
+// BeaconBlocksByRootRequest is a list of block roots, limited to MAX_REQUEST_BLOCKS.
+// After Deneb the limit is MAX_REQUEST_BLOCKS_DENEB, which is checked with Validate.
+type BeaconBlocksByRootRequest []common.Root
+
+func (r *BeaconBlocksByRootRequest) Deserialize(spec *common.Spec, dr *codec.DecodingReader) error {
+	return tree.ReadRootsLimited(dr, (*[]common.Root)(r), uint64(spec.MAX_REQUEST_BLOCKS))
+}
+
+func (r BeaconBlocksByRootRequest) Serialize(spec *common.Spec, w *codec.EncodingWriter) error {
+	return tree.WriteRoots(w, r)
+}
+
+func (r BeaconBlocksByRootRequest) ByteLength(spec *common.Spec) uint64 {
+	return uint64(len(r)) * 32
+}
+
+func (r *BeaconBlocksByRootRequest) FixedLength(spec *common.Spec) uint64 {
+	return 0
+}
+
+func (r BeaconBlocksByRootRequest) HashTreeRoot(spec *common.Spec, hFn tree.HashFn) common.Root {
+	length := uint64(len(r))
+	return hFn.ComplexListHTR(func(i uint64) tree.HTR {
+		if i < length {
+			return &r[i]
+		}
+		return nil
+	}, length, uint64(spec.MAX_REQUEST_BLOCKS))
+}
+
+// Validate checks the request against the request limits at the given epoch.
+func (r BeaconBlocksByRootRequest) Validate(spec *common.Spec, epoch common.Epoch) error {
+	if max := MaxRequestBlocks(spec, epoch); uint64(len(r)) > max {
+		return fmt.Errorf("requested %d blocks, more than the limit of %d", len(r), max)
+	}
+	return nil
+}
+
+type BlobSidecarsByRangeRequest struct {
+	StartSlot common.Slot `json:"start_slot" yaml:"start_slot"`
+	Count     Uint64View  `json:"count" yaml:"count"`
+}
+
+func (r *BlobSidecarsByRangeRequest) Deserialize(dr *codec.DecodingReader) error {
+	return dr.FixedLenContainer(&r.StartSlot, &r.Count)
+}
+
+func (r *BlobSidecarsByRangeRequest) Serialize(w *codec.EncodingWriter) error {
+	return w.FixedLenContainer(&r.StartSlot, &r.Count)
+}
+
+func (r BlobSidecarsByRangeRequest) ByteLength() uint64 {
+	return 8 + 8
+}
+
+func (*BlobSidecarsByRangeRequest) FixedLength() uint64 {
+	return 8 + 8
+}
+
+func (r *BlobSidecarsByRangeRequest) HashTreeRoot(hFn tree.HashFn) common.Root {
+	return hFn.HashTreeRoot(&r.StartSlot, &r.Count)
+}
+
+// Validate checks the request against the request limits.
+func (r *BlobSidecarsByRangeRequest) Validate(spec *common.Spec) error {
+	if max := uint64(spec.MAX_REQUEST_BLOCKS_DENEB); uint64(r.Count) > max {
+		return fmt.Errorf("requested blob sidecars of %d blocks, more than the limit of %d", r.Count, max)
+	}
+	return nil
+}
+
+type BlobIdentifier struct {
+	BlockRoot common.Root     `json:"block_root" yaml:"block_root"`
+	Index     deneb.BlobIndex `json:"index" yaml:"index"`
+}
+
+func (b *BlobIdentifier) Deserialize(dr *codec.DecodingReader) error {
+	return dr.FixedLenContainer(&b.BlockRoot, &b.Index)
+}
+
+func (b *BlobIdentifier) Serialize(w *codec.EncodingWriter) error {
+	return w.FixedLenContainer(&b.BlockRoot, &b.Index)
+}
+
+const BlobIdentifierByteLen = 32 + 8
+
+func (b BlobIdentifier) ByteLength() uint64 {
+	return BlobIdentifierByteLen
+}
+
+func (*BlobIdentifier) FixedLength() uint64 {
+	return BlobIdentifierByteLen
+}
+
+func (b *BlobIdentifier) HashTreeRoot(hFn tree.HashFn) common.Root {
+	return hFn.HashTreeRoot(&b.BlockRoot, &b.Index)
+}
+
+// BlobSidecarsByRootRequest is a list of blob identifiers, limited to MAX_REQUEST_BLOB_SIDECARS.
+type BlobSidecarsByRootRequest []BlobIdentifier
+
+func (r *BlobSidecarsByRootRequest) Deserialize(spec *common.Spec, dr *codec.DecodingReader) error {
+	return dr.List(func() codec.Deserializable {
+		i := len(*r)
+		*r = append(*r, BlobIdentifier{})
+		return &(*r)[i]
+	}, BlobIdentifierByteLen, uint64(spec.MAX_REQUEST_BLOB_SIDECARS))
+}
+
+func (r BlobSidecarsByRootRequest) Serialize(spec *common.Spec, w *codec.EncodingWriter) error {
+	return w.List(func(i uint64) codec.Serializable {
+		return &r[i]
+	}, BlobIdentifierByteLen, uint64(len(r)))
+}
+
+func (r BlobSidecarsByRootRequest) ByteLength(spec *common.Spec) uint64 {
+	return BlobIdentifierByteLen * uint64(len(r))
+}
+
+func (*BlobSidecarsByRootRequest) FixedLength(*common.Spec) uint64 {
+	return 0
+}
+
+func (r BlobSidecarsByRootRequest) HashTreeRoot(spec *common.Spec, hFn tree.HashFn) common.Root {
+	length := uint64(len(r))
+	return hFn.ComplexListHTR(func(i uint64) tree.HTR {
+		if i < length {
+			return &r[i]
+		}
+		return nil
+	}, length, uint64(spec.MAX_REQUEST_BLOB_SIDECARS))
+}
+
+type LightClientUpdatesByRangeRequest struct {
+	StartPeriod Uint64View `json:"start_period" yaml:"start_period"`
+	Count       Uint64View `json:"count" yaml:"count"`
+}
+
+func (r *LightClientUpdatesByRangeRequest) Deserialize(dr *codec.DecodingReader) error {
+	return dr.FixedLenContainer(&r.StartPeriod, &r.Count)
+}
+
+func (r *LightClientUpdatesByRangeRequest) Serialize(w *codec.EncodingWriter) error {
+	return w.FixedLenContainer(&r.StartPeriod, &r.Count)
+}
+
+func (r LightClientUpdatesByRangeRequest) ByteLength() uint64 {
+	return 8 + 8
+}
+
+func (*LightClientUpdatesByRangeRequest) FixedLength() uint64 {
+	return 8 + 8
+}
+
+func (r *LightClientUpdatesByRangeRequest) HashTreeRoot(hFn tree.HashFn) common.Root {
+	return hFn.HashTreeRoot(&r.StartPeriod, &r.Count)
+}
+
+// Validate checks the request against the request limits.
+func (r *LightClientUpdatesByRangeRequest) Validate() error {
+	if r.Count > MAX_REQUEST_LIGHT_CLIENT_UPDATES {
+		return fmt.Errorf("requested %d light client updates, more than the limit of %d", r.Count, MAX_REQUEST_LIGHT_CLIENT_UPDATES)
+	}
+	return nil
+}
@@ -0,0 +1,377 @@
+package slashprotection
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/protolambda/zrnt/eth2/beacon/phase0"
+)
+
+// ErrSlashable is wrapped by the errors of signing requests that are refused.
+var ErrSlashable = errors.New("refusing to sign slashable message")
+
+// DB records the signed blocks and attestations of validators, and refuses to sign slashable messages,
+// following the EIP-3076 conditions. The records are persisted to a file in the complete interchange format,
+// with the low watermarks of each validator as additional fields.
+type DB struct {
+	mu                    sync.Mutex
+	path                  string
+	genesisValidatorsRoot common.Root
+	validators            map[common.BLSPubkey]*validator
+}
+
+// validator is the slashing protection state of a single validator.
+// The records may not be a full history, e.g. when imported in the minimal format.
+// The low watermarks are raised to the lowest imported records, nothing at or below them is signed,
+// and records below them are pruned.
+type validator struct {
+	ValidatorRecords
+	// MinSlot is the slot that a block proposal must be after, if any.
+	MinSlot *common.Slot `json:"min_slot,omitempty"`
+	// MinSourceEpoch is the lowest source epoch of an attestation, if any.
+	MinSourceEpoch *common.Epoch `json:"min_source_epoch,omitempty"`
+	// MinTargetEpoch is the target epoch that an attestation must be after, if any.
+	MinTargetEpoch *common.Epoch `json:"min_target_epoch,omitempty"`
+}
+
+// dbData is the format of the DB file. Interchange readers ignore the additional watermark fields.
+type dbData struct {
+	Metadata InterchangeMetadata `json:"metadata"`
+	Data     []*validator        `json:"data"`
+}
+
+// Open loads the slashing protection DB from the file at path, or starts an empty DB if the file does not exist.
+// The DB is only kept in memory if the path is empty.
+func Open(path string, genesisValidatorsRoot common.Root) (*DB, error) {
+	db := &DB{
+		path:                  path,
+		genesisValidatorsRoot: genesisValidatorsRoot,
+		validators:            make(map[common.BLSPubkey]*validator),
+	}
+	if path == "" {
+		return db, nil
+	}
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return db, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if err := db.load(f, false); err != nil {
+		return nil, fmt.Errorf("failed to load slashing protection DB %s: %w", path, err)
+	}
+	return db, nil
+}
+
+// load merges the records of the interchange data, or of a DB file, into the DB, without persisting.
+// The watermarks are raised to the watermarks of the data, if any,
+// and, if the data is imported, to its lowest records: the data may not be a full history.
+func (db *DB) load(r io.Reader, imported bool) error {
+	var data dbData
+	if err := json.NewDecoder(r).Decode(&data); err != nil {
+		return fmt.Errorf("failed to decode interchange data: %w", err)
+	}
+	if v := data.Metadata.InterchangeFormatVersion; v != INTERCHANGE_FORMAT_VERSION {
+		return fmt.Errorf("unsupported interchange format version %q", v)
+	}
+	if data.Metadata.GenesisValidatorsRoot != db.genesisValidatorsRoot {
+		return fmt.Errorf("interchange data is for genesis validators root %s, expected %s",
+			data.Metadata.GenesisValidatorsRoot, db.genesisValidatorsRoot)
+	}
+	for _, in := range data.Data {
+		if in == nil {
+			return errors.New("missing validator records")
+		}
+		v := db.validator(in.Pubkey)
+		for _, b := range in.SignedBlocks {
+			if !containsBlock(v.SignedBlocks, b) {
+				v.SignedBlocks = append(v.SignedBlocks, b)
+			}
+		}
+		for _, a := range in.SignedAttestations {
+			if !containsAttestation(v.SignedAttestations, a) {
+				v.SignedAttestations = append(v.SignedAttestations, a)
+			}
+		}
+		if slot, ok := in.minSlot(); ok && imported {
+			v.MinSlot = maxSlot(v.MinSlot, slot)
+		}
+		if source, target, ok := in.minEpochs(); ok && imported {
+			v.MinSourceEpoch = maxEpoch(v.MinSourceEpoch, source)
+			v.MinTargetEpoch = maxEpoch(v.MinTargetEpoch, target)
+		}
+		if in.MinSlot != nil {
+			v.MinSlot = maxSlot(v.MinSlot, *in.MinSlot)
+		}
+		if in.MinSourceEpoch != nil {
+			v.MinSourceEpoch = maxEpoch(v.MinSourceEpoch, *in.MinSourceEpoch)
+		}
+		if in.MinTargetEpoch != nil {
+			v.MinTargetEpoch = maxEpoch(v.MinTargetEpoch, *in.MinTargetEpoch)
+		}
+		v.prune()
+	}
+	return nil
+}
+
+func maxSlot(watermark *common.Slot, slot common.Slot) *common.Slot {
+	if watermark != nil && *watermark >= slot {
+		return watermark
+	}
+	return &slot
+}
+
+func maxEpoch(watermark *common.Epoch, epoch common.Epoch) *common.Epoch {
+	if watermark != nil && *watermark >= epoch {
+		return watermark
+	}
+	return &epoch
+}
+
+// prune drops the records below the watermarks, which cannot conflict with any message that may still be signed:
+// blocks before the slot watermark, and attestations with a target before the target watermark,
+// that are not after the source watermark.
+func (v *validator) prune() {
+	if v.MinSlot != nil {
+		blocks := v.SignedBlocks[:0]
+		for _, b := range v.SignedBlocks {
+			if b.Slot >= *v.MinSlot {
+				blocks = append(blocks, b)
+			}
+		}
+		v.SignedBlocks = blocks
+	}
+	if v.MinSourceEpoch != nil && v.MinTargetEpoch != nil {
+		atts := v.SignedAttestations[:0]
+		for _, a := range v.SignedAttestations {
+			if a.TargetEpoch >= *v.MinTargetEpoch || a.SourceEpoch > *v.MinSourceEpoch {
+				atts = append(atts, a)
+			}
+		}
+		v.SignedAttestations = atts
+	}
+}
+
+func (db *DB) validator(pubkey common.BLSPubkey) *validator {
+	v, ok := db.validators[pubkey]
+	if !ok {
+		v = &validator{ValidatorRecords: ValidatorRecords{Pubkey: pubkey, SignedBlocks: []SignedBlock{}, SignedAttestations: []SignedAttestation{}}}
+		db.validators[pubkey] = v
+	}
+	return v
+}
+
+func sameRoot(a, b *common.Root) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+func containsBlock(blocks []SignedBlock, b SignedBlock) bool {
+	for _, x := range blocks {
+		if x.Slot == b.Slot && sameRoot(x.SigningRoot, b.SigningRoot) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsAttestation(atts []SignedAttestation, a SignedAttestation) bool {
+	for _, x := range atts {
+		if x.SourceEpoch == a.SourceEpoch && x.TargetEpoch == a.TargetEpoch && sameRoot(x.SigningRoot, a.SigningRoot) {
+			return true
+		}
+	}
+	return false
+}
+
+func (db *DB) metadata() InterchangeMetadata {
+	return InterchangeMetadata{
+		InterchangeFormatVersion: INTERCHANGE_FORMAT_VERSION,
+		GenesisValidatorsRoot:    db.genesisValidatorsRoot,
+	}
+}
+
+// sortedValidators returns the state of all validators, sorted by pubkey.
+func (db *DB) sortedValidators() []*validator {
+	out := make([]*validator, 0, len(db.validators))
+	for _, v := range db.validators {
+		out = append(out, v)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return bytes.Compare(out[i].Pubkey[:], out[j].Pubkey[:]) < 0
+	})
+	return out
+}
+
+// interchange returns the records of all validators, sorted by pubkey.
+func (db *DB) interchange(minimal bool) *Interchange {
+	out := &Interchange{Metadata: db.metadata(), Data: make([]ValidatorRecords, 0, len(db.validators))}
+	for _, v := range db.sortedValidators() {
+		if minimal {
+			out.Data = append(out.Data, v.minimal())
+		} else {
+			out.Data = append(out.Data, v.ValidatorRecords)
+		}
+	}
+	return out
+}
+
+// persist writes the DB to a temporary file, and then replaces the DB file with it,
+// to never leave a partially written DB behind.
+// The whole DB is written on every signed message, the cost grows with the history: see Compact.
+func (db *DB) persist() error {
+	if db.path == "" {
+		return nil
+	}
+	f, err := os.CreateTemp(filepath.Dir(db.path), filepath.Base(db.path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	tmpPath := f.Name()
+	defer os.Remove(tmpPath)
+	if err := json.NewEncoder(f).Encode(&dbData{Metadata: db.metadata(), Data: db.sortedValidators()}); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, db.path)
+}
+
+// Import merges EIP-3076 interchange data, in the complete or minimal format, into the DB.
+// The genesis validators root of the data must match the DB.
+func (db *DB) Import(r io.Reader) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if err := db.load(r, true); err != nil {
+		return err
+	}
+	return db.persist()
+}
+
+// Compact raises the watermarks of every validator to its latest signed messages, like an import of the
+// minimal format does, and prunes the records below them. This bounds the size of the DB,
+// at the cost of refusing messages before the latest signed messages that would not be slashable.
+func (db *DB) Compact() error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	for _, v := range db.validators {
+		m := v.minimal()
+		if len(m.SignedBlocks) > 0 {
+			v.MinSlot = maxSlot(v.MinSlot, m.SignedBlocks[0].Slot)
+		}
+		if len(m.SignedAttestations) > 0 {
+			v.MinSourceEpoch = maxEpoch(v.MinSourceEpoch, m.SignedAttestations[0].SourceEpoch)
+			v.MinTargetEpoch = maxEpoch(v.MinTargetEpoch, m.SignedAttestations[0].TargetEpoch)
+		}
+		v.prune()
+	}
+	return db.persist()
+}
+
+// Export writes the records of all validators as EIP-3076 interchange data,
+// in the minimal format if minimal is true, in the complete format otherwise.
+func (db *DB) Export(w io.Writer, minimal bool) error {
+	db.mu.Lock()
+	data := db.interchange(minimal)
+	db.mu.Unlock()
+	return json.NewEncoder(w).Encode(data)
+}
+
+// CheckAndRecordBlock checks that the validator may sign a block proposal at the slot, and records it.
+// Signing the same block again is allowed, the error wraps ErrSlashable if the proposal is refused.
+func (db *DB) CheckAndRecordBlock(pubkey common.BLSPubkey, slot common.Slot, signingRoot common.Root) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	v := db.validator(pubkey)
+	for _, b := range v.SignedBlocks {
+		if b.Slot == slot {
+			// Without signing root, a previous proposal cannot be confirmed to be the same block.
+			if b.SigningRoot != nil && *b.SigningRoot == signingRoot {
+				return nil
+			}
+			return fmt.Errorf("%w: double block proposal at slot %d", ErrSlashable, slot)
+		}
+	}
+	if v.MinSlot != nil && slot <= *v.MinSlot {
+		return fmt.Errorf("%w: block slot %d is not after the low watermark slot %d", ErrSlashable, slot, *v.MinSlot)
+	}
+	root := signingRoot
+	v.SignedBlocks = append(v.SignedBlocks, SignedBlock{Slot: slot, SigningRoot: &root})
+	if err := db.persist(); err != nil {
+		v.SignedBlocks = v.SignedBlocks[:len(v.SignedBlocks)-1]
+		return fmt.Errorf("failed to persist signed block: %w", err)
+	}
+	return nil
+}
+
+// recordData represents an attestation record as attestation data, to check it with the slashing conditions.
+// The signing root commits to the full attestation data, so it stands in for the data that is not recorded.
+func recordData(source common.Epoch, target common.Epoch, signingRoot common.Root) phase0.AttestationData {
+	return phase0.AttestationData{
+		BeaconBlockRoot: signingRoot,
+		Source:          common.Checkpoint{Epoch: source},
+		Target:          common.Checkpoint{Epoch: target},
+	}
+}
+
+// CheckAndRecordAttestation checks that the validator may sign the attestation data, and records it.
+// Signing the same attestation again is allowed, the error wraps ErrSlashable if the attestation is refused.
+func (db *DB) CheckAndRecordAttestation(pubkey common.BLSPubkey, data *phase0.AttestationData, signingRoot common.Root) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	source, target := data.Source.Epoch, data.Target.Epoch
+	if source > target {
+		return fmt.Errorf("%w: source epoch %d is after target epoch %d", ErrSlashable, source, target)
+	}
+	v := db.validator(pubkey)
+	next := recordData(source, target, signingRoot)
+	for _, a := range v.SignedAttestations {
+		if a.SigningRoot == nil {
+			// Without signing root, a previous attestation cannot be confirmed to be the same.
+			if a.TargetEpoch == target {
+				return fmt.Errorf("%w: double vote for target epoch %d", ErrSlashable, target)
+			}
+		} else if a.SourceEpoch == source && a.TargetEpoch == target && *a.SigningRoot == signingRoot {
+			return nil
+		}
+		var prevRoot common.Root
+		if a.SigningRoot != nil {
+			prevRoot = *a.SigningRoot
+		}
+		prev := recordData(a.SourceEpoch, a.TargetEpoch, prevRoot)
+		if phase0.IsSlashableAttestationData(&prev, &next) || phase0.IsSlashableAttestationData(&next, &prev) {
+			return fmt.Errorf("%w: attestation (source %d, target %d) conflicts with (source %d, target %d)",
+				ErrSlashable, source, target, a.SourceEpoch, a.TargetEpoch)
+		}
+	}
+	if v.MinSourceEpoch != nil && source < *v.MinSourceEpoch {
+		return fmt.Errorf("%w: source epoch %d is before the low watermark source epoch %d", ErrSlashable, source, *v.MinSourceEpoch)
+	}
+	if v.MinTargetEpoch != nil && target <= *v.MinTargetEpoch {
+		return fmt.Errorf("%w: target epoch %d is not after the low watermark target epoch %d", ErrSlashable, target, *v.MinTargetEpoch)
+	}
+	root := signingRoot
+	v.SignedAttestations = append(v.SignedAttestations, SignedAttestation{SourceEpoch: source, TargetEpoch: target, SigningRoot: &root})
+	if err := db.persist(); err != nil {
+		v.SignedAttestations = v.SignedAttestations[:len(v.SignedAttestations)-1]
+		return fmt.Errorf("failed to persist signed attestation: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,291 @@
+package slashprotection
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/protolambda/zrnt/eth2/beacon/phase0"
+)
+
+var (
+	testGenesisValidatorsRoot = common.Root{0x42}
+	testPubkey                = common.BLSPubkey{0x01}
+)
+
+func testRoot(b byte) *common.Root {
+	return &common.Root{b}
+}
+
+// testStep signs a block if it has no target epoch, or an attestation otherwise.
+type testStep struct {
+	slot   common.Slot
+	source common.Epoch
+	target common.Epoch
+	root   byte
+	refuse bool
+}
+
+func block(slot common.Slot, root byte, refuse bool) testStep {
+	return testStep{slot: slot, root: root, refuse: refuse}
+}
+
+func attestation(source, target common.Epoch, root byte, refuse bool) testStep {
+	return testStep{source: source, target: target, root: root, refuse: refuse}
+}
+
+func (s testStep) apply(db *DB) error {
+	if s.target == 0 {
+		return db.CheckAndRecordBlock(testPubkey, s.slot, *testRoot(s.root))
+	}
+	data := phase0.AttestationData{
+		Source: common.Checkpoint{Epoch: s.source},
+		Target: common.Checkpoint{Epoch: s.target},
+	}
+	return db.CheckAndRecordAttestation(testPubkey, &data, *testRoot(s.root))
+}
+
+func interchangeJSON(t *testing.T, genesisValidatorsRoot common.Root, records ...ValidatorRecords) []byte {
+	data, err := json.Marshal(&Interchange{
+		Metadata: InterchangeMetadata{
+			InterchangeFormatVersion: INTERCHANGE_FORMAT_VERSION,
+			GenesisValidatorsRoot:    genesisValidatorsRoot,
+		},
+		Data: records,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func runSteps(t *testing.T, db *DB, steps []testStep) {
+	t.Helper()
+	for i, s := range steps {
+		err := s.apply(db)
+		if s.refuse {
+			if !errors.Is(err, ErrSlashable) {
+				t.Fatalf("step %d: expected refusal, got: %v", i, err)
+			}
+		} else if err != nil {
+			t.Fatalf("step %d: unexpected error: %v", i, err)
+		}
+	}
+}
+
+func TestCheckAndRecord(t *testing.T) {
+	cases := []struct {
+		name     string
+		imported *ValidatorRecords
+		steps    []testStep
+	}{
+		{"double block", nil, []testStep{block(10, 0xa, false), block(10, 0xb, true)}},
+		{"repeat block", nil, []testStep{block(10, 0xa, false), block(10, 0xa, false), block(11, 0xa, false)}},
+		{"earlier local block", nil, []testStep{block(10, 0xa, false), block(5, 0xb, false)}},
+		{"double vote", nil, []testStep{attestation(1, 2, 0xa, false), attestation(1, 2, 0xb, true), attestation(0, 2, 0xa, true)}},
+		{"repeat attestation", nil, []testStep{attestation(1, 2, 0xa, false), attestation(1, 2, 0xa, false)}},
+		{"surround vote", nil, []testStep{attestation(2, 3, 0xa, false), attestation(1, 4, 0xb, true)}},
+		{"surrounded vote", nil, []testStep{attestation(1, 4, 0xa, false), attestation(2, 3, 0xb, true)}},
+		{"consecutive votes", nil, []testStep{attestation(1, 2, 0xa, false), attestation(2, 3, 0xb, false), attestation(2, 4, 0xc, false)}},
+		{"source after target", nil, []testStep{attestation(3, 2, 0xa, true)}},
+		{"nil root block", &ValidatorRecords{SignedBlocks: []SignedBlock{{Slot: 5, SigningRoot: testRoot(0xa)}, {Slot: 10}}},
+			[]testStep{block(10, 0xa, true), block(5, 0xa, false), block(11, 0xa, false)}},
+		{"nil root attestation", &ValidatorRecords{SignedAttestations: []SignedAttestation{
+			{SourceEpoch: 2, TargetEpoch: 3, SigningRoot: testRoot(0xa)}, {SourceEpoch: 4, TargetEpoch: 6}}},
+			[]testStep{attestation(4, 6, 0xa, true), attestation(5, 6, 0xa, true), attestation(4, 5, 0xa, false)}},
+		{"block watermark", &ValidatorRecords{SignedBlocks: []SignedBlock{{Slot: 100}}},
+			[]testStep{block(50, 0xa, true), block(100, 0xa, true), block(101, 0xa, false)}},
+		{"attestation watermarks", &ValidatorRecords{SignedAttestations: []SignedAttestation{{SourceEpoch: 10, TargetEpoch: 20}}},
+			[]testStep{attestation(9, 30, 0xa, true), attestation(10, 20, 0xa, true), attestation(11, 15, 0xa, true),
+				attestation(10, 21, 0xa, false)}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			db, err := Open("", testGenesisValidatorsRoot)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if c.imported != nil {
+				rec := *c.imported
+				rec.Pubkey = testPubkey
+				if err := db.Import(bytes.NewReader(interchangeJSON(t, testGenesisValidatorsRoot, rec))); err != nil {
+					t.Fatal(err)
+				}
+			}
+			runSteps(t, db, c.steps)
+		})
+	}
+}
+
+func TestImportRaisesWatermarks(t *testing.T) {
+	db, err := Open("", testGenesisValidatorsRoot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Local history before the imported data does not lower the watermarks of the import.
+	runSteps(t, db, []testStep{block(5, 0xa, false), attestation(1, 2, 0xa, false), attestation(12, 14, 0xb, false)})
+	minimal := ValidatorRecords{
+		Pubkey:             testPubkey,
+		SignedBlocks:       []SignedBlock{{Slot: 100}},
+		SignedAttestations: []SignedAttestation{{SourceEpoch: 10, TargetEpoch: 20}},
+	}
+	if err := db.Import(bytes.NewReader(interchangeJSON(t, testGenesisValidatorsRoot, minimal))); err != nil {
+		t.Fatal(err)
+	}
+	runSteps(t, db, []testStep{block(50, 0xb, true), block(101, 0xb, false),
+		attestation(10, 13, 0xc, true), attestation(10, 20, 0xc, true), attestation(20, 21, 0xc, false)})
+
+	// Records below the watermarks are pruned, the attestation with a later source is kept.
+	var out Interchange
+	var buf bytes.Buffer
+	if err := db.Export(&buf, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(buf.Bytes(), &out); err != nil {
+		t.Fatal(err)
+	}
+	rec := out.Data[0]
+	if len(rec.SignedBlocks) != 2 || rec.SignedBlocks[0].Slot != 100 || rec.SignedBlocks[1].Slot != 101 {
+		t.Fatalf("unexpected blocks: %+v", rec.SignedBlocks)
+	}
+	if len(rec.SignedAttestations) != 3 || rec.SignedAttestations[0].SourceEpoch != 12 ||
+		rec.SignedAttestations[1].TargetEpoch != 20 || rec.SignedAttestations[2].TargetEpoch != 21 {
+		t.Fatalf("unexpected attestations: %+v", rec.SignedAttestations)
+	}
+}
+
+func TestInterchangeRoundTrip(t *testing.T) {
+	db, err := Open("", testGenesisValidatorsRoot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	runSteps(t, db, []testStep{block(3, 0xa, false), block(7, 0xb, false),
+		attestation(0, 1, 0xa, false), attestation(1, 2, 0xb, false), attestation(2, 3, 0xc, false)})
+	if err := db.CheckAndRecordBlock(common.BLSPubkey{0x02}, 4, common.Root{0xc}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, minimal := range []bool{false, true} {
+		var exported bytes.Buffer
+		if err := db.Export(&exported, minimal); err != nil {
+			t.Fatal(err)
+		}
+		other, err := Open("", testGenesisValidatorsRoot)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := other.Import(bytes.NewReader(exported.Bytes())); err != nil {
+			t.Fatal(err)
+		}
+		var reexported bytes.Buffer
+		if err := other.Export(&reexported, minimal); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(exported.Bytes(), reexported.Bytes()) {
+			t.Fatalf("minimal %v: round trip changed data:\n%s\n%s", minimal, exported.Bytes(), reexported.Bytes())
+		}
+		// The imported DB refuses anything at or below the exported data.
+		runSteps(t, other, []testStep{block(7, 0xc, true), attestation(2, 3, 0xd, true), block(8, 0xc, false)})
+	}
+
+	var exported bytes.Buffer
+	if err := db.Export(&exported, true); err != nil {
+		t.Fatal(err)
+	}
+	var out Interchange
+	if err := json.Unmarshal(exported.Bytes(), &out); err != nil {
+		t.Fatal(err)
+	}
+	if len(out.Data) != 2 || out.Data[0].Pubkey != testPubkey || out.Data[1].Pubkey != (common.BLSPubkey{0x02}) {
+		t.Fatalf("expected both validators, sorted by pubkey: %+v", out.Data)
+	}
+	rec := out.Data[0]
+	if len(rec.SignedBlocks) != 1 || rec.SignedBlocks[0].Slot != 7 || rec.SignedBlocks[0].SigningRoot != nil {
+		t.Fatalf("unexpected minimal blocks: %+v", rec.SignedBlocks)
+	}
+	if len(rec.SignedAttestations) != 1 || rec.SignedAttestations[0].SourceEpoch != 2 || rec.SignedAttestations[0].TargetEpoch != 3 {
+		t.Fatalf("unexpected minimal attestations: %+v", rec.SignedAttestations)
+	}
+}
+
+func TestImportMismatch(t *testing.T) {
+	db, err := Open("", testGenesisValidatorsRoot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := ValidatorRecords{Pubkey: testPubkey, SignedBlocks: []SignedBlock{{Slot: 100}}}
+	if err := db.Import(bytes.NewReader(interchangeJSON(t, common.Root{0x43}, rec))); err == nil {
+		t.Fatal("expected error for wrong genesis validators root")
+	}
+	var data Interchange
+	if err := json.Unmarshal(interchangeJSON(t, testGenesisValidatorsRoot, rec), &data); err != nil {
+		t.Fatal(err)
+	}
+	data.Metadata.InterchangeFormatVersion = "4"
+	raw, err := json.Marshal(&data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Import(bytes.NewReader(raw)); err == nil {
+		t.Fatal("expected error for unsupported format version")
+	}
+	// Nothing of the refused data is used.
+	runSteps(t, db, []testStep{block(50, 0xa, false)})
+
+	path := filepath.Join(t.TempDir(), "slashing_protection.json")
+	if err := os.WriteFile(path, interchangeJSON(t, common.Root{0x43}, rec), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Open(path, testGenesisValidatorsRoot); err == nil {
+		t.Fatal("expected error for DB file of other genesis validators root")
+	}
+}
+
+func TestPersistReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "slashing_protection.json")
+	db, err := Open(path, testGenesisValidatorsRoot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	runSteps(t, db, []testStep{block(5, 0xa, false), attestation(1, 2, 0xa, false)})
+	db, err = Open(path, testGenesisValidatorsRoot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The local history is complete, reopening the DB does not add watermarks.
+	runSteps(t, db, []testStep{block(5, 0xa, false), block(5, 0xb, true), block(3, 0xb, false),
+		attestation(1, 2, 0xa, false), attestation(0, 3, 0xb, true), attestation(2, 3, 0xb, false)})
+
+	// The watermarks are kept in the DB file, also when no record is at the watermark anymore.
+	if err := os.WriteFile(path, []byte(`{"metadata":{"interchange_format_version":"5",
+		"genesis_validators_root":"0x4200000000000000000000000000000000000000000000000000000000000000"},
+		"data":[{"pubkey":"`+testPubkey.String()+`","signed_blocks":[],"signed_attestations":[],
+		"min_slot":"100","min_source_epoch":"10","min_target_epoch":"20"}]}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	db, err = Open(path, testGenesisValidatorsRoot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	runSteps(t, db, []testStep{block(100, 0xa, true), attestation(9, 21, 0xa, true), attestation(10, 20, 0xa, true),
+		block(101, 0xa, false), attestation(10, 21, 0xa, false)})
+	db, err = Open(path, testGenesisValidatorsRoot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	runSteps(t, db, []testStep{block(101, 0xa, false), block(50, 0xa, true), attestation(9, 22, 0xa, true)})
+
+	if err := db.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	db, err = Open(path, testGenesisValidatorsRoot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	runSteps(t, db, []testStep{block(101, 0xa, false), block(101, 0xb, true), attestation(10, 21, 0xb, true),
+		attestation(21, 22, 0xa, false)})
+}
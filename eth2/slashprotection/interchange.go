@@ -0,0 +1,92 @@
+package slashprotection
+
+import (
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+)
+
+// INTERCHANGE_FORMAT_VERSION is the supported version of the EIP-3076 interchange format.
+const INTERCHANGE_FORMAT_VERSION = "5"
+
+// Interchange is the EIP-3076 slashing protection interchange format.
+// The complete format lists every signed message,
+// the minimal format only the latest block and attestation per validator.
+type Interchange struct {
+	Metadata InterchangeMetadata `json:"metadata"`
+	Data     []ValidatorRecords  `json:"data"`
+}
+
+type InterchangeMetadata struct {
+	InterchangeFormatVersion string      `json:"interchange_format_version"`
+	GenesisValidatorsRoot    common.Root `json:"genesis_validators_root"`
+}
+
+// ValidatorRecords are the signed messages of a single validator.
+type ValidatorRecords struct {
+	Pubkey             common.BLSPubkey    `json:"pubkey"`
+	SignedBlocks       []SignedBlock       `json:"signed_blocks"`
+	SignedAttestations []SignedAttestation `json:"signed_attestations"`
+}
+
+// SignedBlock records a block proposal. The signing root is optional.
+type SignedBlock struct {
+	Slot        common.Slot  `json:"slot"`
+	SigningRoot *common.Root `json:"signing_root,omitempty"`
+}
+
+// SignedAttestation records an attestation. The signing root is optional.
+type SignedAttestation struct {
+	SourceEpoch common.Epoch `json:"source_epoch"`
+	TargetEpoch common.Epoch `json:"target_epoch"`
+	SigningRoot *common.Root `json:"signing_root,omitempty"`
+}
+
+// minSlot returns the lowest slot of the signed blocks, if any.
+func (v *ValidatorRecords) minSlot() (slot common.Slot, ok bool) {
+	for i, b := range v.SignedBlocks {
+		if i == 0 || b.Slot < slot {
+			slot = b.Slot
+		}
+	}
+	return slot, len(v.SignedBlocks) > 0
+}
+
+// minEpochs returns the lowest source and target epochs of the signed attestations, if any.
+func (v *ValidatorRecords) minEpochs() (source common.Epoch, target common.Epoch, ok bool) {
+	for i, a := range v.SignedAttestations {
+		if i == 0 || a.SourceEpoch < source {
+			source = a.SourceEpoch
+		}
+		if i == 0 || a.TargetEpoch < target {
+			target = a.TargetEpoch
+		}
+	}
+	return source, target, len(v.SignedAttestations) > 0
+}
+
+// minimal returns the records reduced to the minimal format:
+// the highest block slot, and the highest attestation source and target epochs.
+func (v *ValidatorRecords) minimal() ValidatorRecords {
+	out := ValidatorRecords{Pubkey: v.Pubkey, SignedBlocks: []SignedBlock{}, SignedAttestations: []SignedAttestation{}}
+	if len(v.SignedBlocks) > 0 {
+		var b SignedBlock
+		for _, x := range v.SignedBlocks {
+			if x.Slot > b.Slot {
+				b.Slot = x.Slot
+			}
+		}
+		out.SignedBlocks = append(out.SignedBlocks, b)
+	}
+	if len(v.SignedAttestations) > 0 {
+		var a SignedAttestation
+		for _, x := range v.SignedAttestations {
+			if x.SourceEpoch > a.SourceEpoch {
+				a.SourceEpoch = x.SourceEpoch
+			}
+			if x.TargetEpoch > a.TargetEpoch {
+				a.TargetEpoch = x.TargetEpoch
+			}
+		}
+		out.SignedAttestations = append(out.SignedAttestations, a)
+	}
+	return out
+}
@@ -18,3 +18,49 @@ func VerifyMerkleBranch(leaf tree.Root, branch []tree.Root, depth uint64, index
 	}
 	return value == root
 }
+
+// ComputeMerkleProof computes the merkle branch of the node at the given generalized index in the tree.
+// The branch is ordered from the bottom up, i.e. the sibling of the leaf comes first, as in VerifyMerkleBranch.
+func ComputeMerkleProof(root tree.Node, gindex tree.Gindex64, hFn tree.HashFn) ([]tree.Root, error) {
+	iter, depth := gindex.BitIter()
+	branch := make([]tree.Root, depth)
+	node := root
+	for i := int(depth) - 1; i >= 0; i-- {
+		right, _ := iter.Next()
+		left, err := node.Left()
+		if err != nil {
+			return nil, err
+		}
+		rightNode, err := node.Right()
+		if err != nil {
+			return nil, err
+		}
+		if right {
+			branch[i] = left.MerkleRoot(hFn)
+			node = rightNode
+		} else {
+			branch[i] = rightNode.MerkleRoot(hFn)
+			node = left
+		}
+	}
+	return branch, nil
+}
+
+// ComputeFieldsProof computes the merkle branch of a leaf in a tree of the given leaf roots, e.g. the fields of a container.
+// The leaves are padded with zero roots to the next power of 2.
+func ComputeFieldsProof(leaves []tree.Root, index uint64, hFn tree.HashFn) ([]tree.Root, error) {
+	depth := tree.CoverDepth(uint64(len(leaves)))
+	nodes := make([]tree.Node, len(leaves))
+	for i := range leaves {
+		nodes[i] = &leaves[i]
+	}
+	root, err := tree.SubtreeFillToContents(nodes, depth)
+	if err != nil {
+		return nil, err
+	}
+	gindex, err := tree.ToGindex64(index, depth)
+	if err != nil {
+		return nil, err
+	}
+	return ComputeMerkleProof(root, gindex, hFn)
+}
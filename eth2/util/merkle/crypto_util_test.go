@@ -0,0 +1,97 @@
+package merkle_test
+
+import (
+	"testing"
+
+	"github.com/protolambda/ztyp/tree"
+
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/protolambda/zrnt/eth2/configs"
+	"github.com/protolambda/zrnt/eth2/util/hashing"
+	"github.com/protolambda/zrnt/eth2/util/merkle"
+	"github.com/protolambda/zrnt/tests/benches"
+)
+
+func hashPair(a, b tree.Root) tree.Root {
+	return hashing.Hash(append(a[:], b[:]...))
+}
+
+func TestComputeFieldsProof(t *testing.T) {
+	hFn := tree.GetHashFn()
+	leaves := []tree.Root{{1}, {2}, {3}, {4}, {5}}
+	// 5 leaves are padded to 8
+	var zero tree.Root
+	root := hashPair(
+		hashPair(hashPair(leaves[0], leaves[1]), hashPair(leaves[2], leaves[3])),
+		hashPair(hashPair(leaves[4], zero), hashPair(zero, zero)),
+	)
+	for i, leaf := range leaves {
+		branch, err := merkle.ComputeFieldsProof(leaves, uint64(i), hFn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(branch) != 3 {
+			t.Fatalf("leaf %d: expected branch of depth 3, got %d", i, len(branch))
+		}
+		if !merkle.VerifyMerkleBranch(leaf, branch, 3, uint64(i), root) {
+			t.Errorf("leaf %d: branch does not verify", i)
+		}
+		if merkle.VerifyMerkleBranch(leaf, branch, 3, uint64(i)^1, root) {
+			t.Errorf("leaf %d: branch verifies at the index of the sibling", i)
+		}
+		branch[len(branch)-1][0] ^= 0xff
+		if merkle.VerifyMerkleBranch(leaf, branch, 3, uint64(i), root) {
+			t.Errorf("leaf %d: tampered branch verifies", i)
+		}
+	}
+	if _, err := merkle.ComputeFieldsProof(leaves, 8, hFn); err == nil {
+		t.Error("expected error for index out of range")
+	}
+}
+
+func TestComputeMerkleProof(t *testing.T) {
+	hFn := tree.GetHashFn()
+	state, _ := benches.CreateTestState(64, configs.Mainnet.MAX_EFFECTIVE_BALANCE)
+	stateRoot := state.HashTreeRoot(hFn)
+	genesisValRoot, err := state.GenesisValidatorsRoot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	header, err := state.LatestBlockHeader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	testCases := []struct {
+		name   string
+		leaf   tree.Root
+		gindex tree.Gindex64
+		depth  uint64
+	}{
+		// the phase0 state has 21 fields, padded to 32: field 1 is genesis_validators_root
+		{"state field", genesisValRoot, 32 + 1, 5},
+		// field 4 is latest_block_header, with 5 fields padded to 8: field 4 is the body root
+		{"nested field", header.BodyRoot, (32+4)*8 + 4, 8},
+		// field 3 of the block header is the state root, zeroed until the next slot is processed
+		{"zero leaf", common.Root{}, (32+4)*8 + 3, 8},
+	}
+	for _, c := range testCases {
+		branch, err := merkle.ComputeMerkleProof(state.Backing(), c.gindex, hFn)
+		if err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		if uint64(len(branch)) != c.depth {
+			t.Fatalf("%s: expected branch of depth %d, got %d", c.name, c.depth, len(branch))
+		}
+		index := uint64(c.gindex) ^ (1 << c.depth)
+		if !merkle.VerifyMerkleBranch(c.leaf, branch, c.depth, index, stateRoot) {
+			t.Errorf("%s: branch does not verify against the state root", c.name)
+		}
+		if merkle.VerifyMerkleBranch(tree.Root{0xff}, branch, c.depth, index, stateRoot) {
+			t.Errorf("%s: branch verifies another leaf", c.name)
+		}
+	}
+	// The slot is a leaf of the block header, there is no subtree to prove into.
+	if _, err := merkle.ComputeMerkleProof(state.Backing(), (32+4)*8*2, hFn); err == nil {
+		t.Error("expected error for gindex below a leaf")
+	}
+}
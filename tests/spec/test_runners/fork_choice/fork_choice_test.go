@@ -0,0 +1,791 @@
+package fork_choice
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"testing"
+
+	"github.com/protolambda/ztyp/tree"
+	"gopkg.in/yaml.v3"
+
+	"github.com/protolambda/zrnt/eth2/beacon"
+	"github.com/protolambda/zrnt/eth2/beacon/altair"
+	"github.com/protolambda/zrnt/eth2/beacon/bellatrix"
+	"github.com/protolambda/zrnt/eth2/beacon/capella"
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/protolambda/zrnt/eth2/beacon/deneb"
+	"github.com/protolambda/zrnt/eth2/beacon/phase0"
+	"github.com/protolambda/zrnt/eth2/configs"
+	"github.com/protolambda/zrnt/eth2/execution"
+	"github.com/protolambda/zrnt/eth2/forkchoice"
+	"github.com/protolambda/zrnt/eth2/forkchoice/proto"
+	"github.com/protolambda/zrnt/tests/spec/test_util"
+)
+
+type HeadCheck struct {
+	Slot common.Slot `yaml:"slot"`
+	Root common.Root `yaml:"root"`
+}
+
+type Checks struct {
+	Time                *common.Timestamp  `yaml:"time"`
+	GenesisTime         *common.Timestamp  `yaml:"genesis_time"`
+	Head                *HeadCheck         `yaml:"head"`
+	JustifiedCheckpoint *common.Checkpoint `yaml:"justified_checkpoint"`
+	FinalizedCheckpoint *common.Checkpoint `yaml:"finalized_checkpoint"`
+	ProposerBoostRoot   *common.Root       `yaml:"proposer_boost_root"`
+}
+
+type PayloadStatus struct {
+	BlockHash       common.Root  `yaml:"block_hash"`
+	Status          string       `yaml:"status"`
+	LatestValidHash *common.Root `yaml:"latest_valid_hash"`
+}
+
+type Step struct {
+	Tick             *common.Timestamp `yaml:"tick"`
+	Block            *string           `yaml:"block"`
+	Attestation      *string           `yaml:"attestation"`
+	AttesterSlashing *string           `yaml:"attester_slashing"`
+	PayloadStatus    *PayloadStatus    `yaml:"payload_status"`
+	Checks           *Checks           `yaml:"checks"`
+	// Valid is optional, and defaults to true.
+	Valid *bool `yaml:"valid"`
+}
+
+func (s *Step) ExpectValid() bool {
+	return s.Valid == nil || *s.Valid
+}
+
+// latestMessage is the latest vote of a validator, as tracked by the spec store.
+// The vote that is applied to the fork-choice scores is current, the vote that replaces it on the next score update is next.
+type latestMessage struct {
+	current forkchoice.NodeRef
+	next    forkchoice.NodeRef
+	epoch   common.Epoch
+	voted   bool
+}
+
+// boostVoteStore tracks the latest messages like the spec does, by target epoch,
+// applies the proposer boost to the boosted block,
+// and discounts the votes of equivocating validators, by adjusting the fork-choice score changes.
+type boostVoteStore struct {
+	spec         *common.Spec
+	votes        []latestMessage
+	equivocating map[common.ValidatorIndex]struct{}
+	boost        forkchoice.NodeRef
+	appliedBoost forkchoice.NodeRef
+	appliedScore common.Gwei
+	// balances used in the previous score changes, with equivocating validators discounted.
+	prevBalances []common.Gwei
+	changed      bool
+}
+
+var _ forkchoice.VoteStore = (*boostVoteStore)(nil)
+
+func newBoostVoteStore(spec *common.Spec) *boostVoteStore {
+	return &boostVoteStore{
+		spec:         spec,
+		equivocating: make(map[common.ValidatorIndex]struct{}),
+		changed:      true,
+	}
+}
+
+// ProcessVote replaces the latest message of the validator with a vote for the given block node,
+// if the target epoch is newer than that of the previous latest message, or if there is none yet.
+func (st *boostVoteStore) ProcessVote(index common.ValidatorIndex, ref forkchoice.NodeRef, targetEpoch common.Epoch) {
+	if index >= common.ValidatorIndex(len(st.votes)) {
+		st.votes = append(st.votes, make([]latestMessage, index+1-common.ValidatorIndex(len(st.votes)))...)
+	}
+	vote := &st.votes[index]
+	if !vote.voted || targetEpoch > vote.epoch {
+		vote.next = ref
+		vote.epoch = targetEpoch
+		vote.voted = true
+		st.changed = true
+	}
+}
+
+// ProcessAttestation implements forkchoice.VoteInput, with the target epoch derived from the head slot.
+// The runner uses ProcessVote instead, to track votes by the target epoch of the attestation.
+func (st *boostVoteStore) ProcessAttestation(index common.ValidatorIndex, blockRoot common.Root, headSlot common.Slot) (ok bool) {
+	st.ProcessVote(index, forkchoice.NodeRef{Root: blockRoot, Slot: headSlot}, st.spec.SlotToEpoch(headSlot))
+	return true
+}
+
+// SetBoost changes the node that receives the proposer boost, the zero node to remove the boost.
+func (st *boostVoteStore) SetBoost(ref forkchoice.NodeRef) {
+	if st.boost != ref {
+		st.boost = ref
+		st.changed = true
+	}
+}
+
+// AddEquivocating discounts the votes of the validator from the fork-choice.
+func (st *boostVoteStore) AddEquivocating(index common.ValidatorIndex) {
+	if _, ok := st.equivocating[index]; !ok {
+		st.equivocating[index] = struct{}{}
+		st.changed = true
+	}
+}
+
+func (st *boostVoteStore) HasChanges() bool {
+	return st.changed
+}
+
+// ComputeDeltas ignores the given old balances, and uses the adjusted balances of the previous call instead,
+// to remove the weight of validators that became equivocating since.
+func (st *boostVoteStore) ComputeDeltas(indices map[forkchoice.NodeRef]forkchoice.NodeIndex,
+	_ []common.Gwei, newBalances []common.Gwei) []forkchoice.SignedGwei {
+	balances := make([]common.Gwei, len(newBalances))
+	totalActive := common.Gwei(0)
+	for i, bal := range newBalances {
+		totalActive += bal
+		if _, ok := st.equivocating[common.ValidatorIndex(i)]; !ok {
+			balances[i] = bal
+		}
+	}
+	deltas := make([]forkchoice.SignedGwei, len(indices))
+	for i := range st.votes {
+		vote := &st.votes[i]
+		if !vote.voted {
+			continue
+		}
+		oldBal, newBal := common.Gwei(0), common.Gwei(0)
+		if i < len(st.prevBalances) {
+			oldBal = st.prevBalances[i]
+		}
+		if i < len(balances) {
+			newBal = balances[i]
+		}
+		if vote.current == vote.next && oldBal == newBal {
+			continue
+		}
+		// Votes for nodes outside of the tree (i.e. pre-finalization) carry no weight.
+		if index, ok := indices[vote.current]; ok {
+			deltas[index] -= forkchoice.SignedGwei(oldBal)
+		}
+		if index, ok := indices[vote.next]; ok {
+			deltas[index] += forkchoice.SignedGwei(newBal)
+		}
+		vote.current = vote.next
+	}
+	st.prevBalances = balances
+
+	if index, ok := indices[st.appliedBoost]; ok && st.appliedScore != 0 {
+		deltas[index] -= forkchoice.SignedGwei(st.appliedScore)
+	}
+	st.appliedBoost, st.appliedScore = forkchoice.NodeRef{}, 0
+	if index, ok := indices[st.boost]; ok && st.boost != (forkchoice.NodeRef{}) {
+		if totalActive < st.spec.EFFECTIVE_BALANCE_INCREMENT {
+			totalActive = st.spec.EFFECTIVE_BALANCE_INCREMENT
+		}
+		committeeWeight := totalActive / common.Gwei(st.spec.SLOTS_PER_EPOCH)
+		score := committeeWeight * common.Gwei(st.spec.PROPOSER_SCORE_BOOST) / 100
+		deltas[index] += forkchoice.SignedGwei(score)
+		st.appliedBoost, st.appliedScore = st.boost, score
+	}
+	st.changed = false
+	return deltas
+}
+
+type checkpointState struct {
+	state common.BeaconState
+	epc   *common.EpochsContext
+}
+
+// Store replays the fork-choice test steps against the proto fork-choice,
+// and keeps track of all the block post-states, similar to what a chain would do.
+type Store struct {
+	spec              *common.Spec
+	genesisTime       common.Timestamp
+	time              common.Timestamp
+	fc                forkchoice.Forkchoice
+	votes             *boostVoteStore
+	justified         common.Checkpoint
+	finalized         common.Checkpoint
+	proposerBoostRoot common.Root
+
+	states           map[common.Root]common.BeaconState
+	parents          map[common.Root]common.Root
+	checkpointStates map[common.Checkpoint]*checkpointState
+	// block hash -> latest payload status from the execution engine
+	payloadStatuses map[common.Root]*PayloadStatus
+	// block hash -> block root
+	payloadBlocks map[common.Root]common.Root
+}
+
+func NewStore(spec *common.Spec, anchorState common.BeaconState, anchorHeader *common.BeaconBlockHeader) (*Store, error) {
+	anchorRoot := anchorHeader.HashTreeRoot(tree.GetHashFn())
+	stateRoot := anchorState.HashTreeRoot(tree.GetHashFn())
+	if anchorHeader.StateRoot != stateRoot {
+		return nil, fmt.Errorf("anchor block state root %s does not match anchor state %s", anchorHeader.StateRoot, stateRoot)
+	}
+	genesisTime, err := anchorState.GenesisTime()
+	if err != nil {
+		return nil, err
+	}
+	anchorEpoch := spec.SlotToEpoch(anchorHeader.Slot)
+	cp := common.Checkpoint{Epoch: anchorEpoch, Root: anchorRoot}
+	s := &Store{
+		spec:             spec,
+		genesisTime:      genesisTime,
+		time:             genesisTime + common.Timestamp(anchorHeader.Slot)*spec.SECONDS_PER_SLOT,
+		justified:        cp,
+		finalized:        cp,
+		states:           map[common.Root]common.BeaconState{anchorRoot: anchorState},
+		parents:          map[common.Root]common.Root{anchorRoot: anchorHeader.ParentRoot},
+		checkpointStates: make(map[common.Checkpoint]*checkpointState),
+		payloadStatuses:  make(map[common.Root]*PayloadStatus),
+		payloadBlocks:    make(map[common.Root]common.Root),
+	}
+	balances, err := s.justifiedBalances()
+	if err != nil {
+		return nil, err
+	}
+	s.votes = newBoostVoteStore(spec)
+	s.fc, err = forkchoice.NewForkChoice(spec, cp, cp, anchorRoot, anchorHeader.Slot,
+		proto.NewProtoArray(anchorHeader.ParentRoot, anchorRoot, anchorHeader.Slot, cp.Epoch, cp.Epoch, nil),
+		s.votes, balances)
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *Store) CurrentSlot() common.Slot {
+	return common.Slot((s.time - s.genesisTime) / s.spec.SECONDS_PER_SLOT)
+}
+
+func (s *Store) OnTick(time common.Timestamp) {
+	prevSlot := s.CurrentSlot()
+	s.time = time
+	if s.CurrentSlot() > prevSlot {
+		s.proposerBoostRoot = common.Root{}
+		s.votes.SetBoost(forkchoice.NodeRef{})
+	}
+}
+
+func (s *Store) checkpointState(cp common.Checkpoint) (*checkpointState, error) {
+	if cs, ok := s.checkpointStates[cp]; ok {
+		return cs, nil
+	}
+	blockState, ok := s.states[cp.Root]
+	if !ok {
+		return nil, fmt.Errorf("unknown checkpoint block %s", cp.Root)
+	}
+	state, err := blockState.CopyState()
+	if err != nil {
+		return nil, err
+	}
+	slot, err := state.Slot()
+	if err != nil {
+		return nil, err
+	}
+	epc, err := common.NewEpochsContext(s.spec, state)
+	if err != nil {
+		return nil, err
+	}
+	if start, _ := s.spec.EpochStartSlot(cp.Epoch); slot < start {
+		upgradeable := &beacon.StandardUpgradeableBeaconState{BeaconState: state}
+		if err := common.ProcessSlots(context.Background(), s.spec, epc, upgradeable, start); err != nil {
+			return nil, err
+		}
+		state = upgradeable.BeaconState
+	}
+	cs := &checkpointState{state: state, epc: epc}
+	s.checkpointStates[cp] = cs
+	return cs, nil
+}
+
+func (s *Store) justifiedBalances() ([]common.Gwei, error) {
+	cs, err := s.checkpointState(s.justified)
+	if err != nil {
+		return nil, err
+	}
+	vals, err := cs.state.Validators()
+	if err != nil {
+		return nil, err
+	}
+	count, err := vals.ValidatorCount()
+	if err != nil {
+		return nil, err
+	}
+	balances := make([]common.Gwei, count)
+	var flat common.FlatValidator
+	for i := uint64(0); i < count; i++ {
+		v, err := vals.Validator(common.ValidatorIndex(i))
+		if err != nil {
+			return nil, err
+		}
+		if err := v.Flatten(&flat); err != nil {
+			return nil, err
+		}
+		if flat.IsActive(s.justified.Epoch) {
+			balances[i] = flat.EffectiveBalance
+		}
+	}
+	return balances, nil
+}
+
+func (s *Store) ancestor(root common.Root, slot common.Slot) (common.Root, error) {
+	for {
+		state, ok := s.states[root]
+		if !ok {
+			return common.Root{}, fmt.Errorf("unknown block %s", root)
+		}
+		header, err := state.LatestBlockHeader()
+		if err != nil {
+			return common.Root{}, err
+		}
+		if header.Slot <= slot {
+			return root, nil
+		}
+		root = s.parents[root]
+	}
+}
+
+func (s *Store) Head() (forkchoice.NodeRef, error) {
+	slot, ok := s.fc.GetSlot(s.justified.Root)
+	if !ok {
+		return forkchoice.NodeRef{}, fmt.Errorf("justified block %s is unknown to fork-choice", s.justified.Root)
+	}
+	return s.fc.FindHead(s.justified.Root, slot)
+}
+
+func (s *Store) OnBlock(benv *common.BeaconBlockEnvelope, blockHash common.Root) error {
+	pre, ok := s.states[benv.ParentRoot]
+	if !ok {
+		return fmt.Errorf("unknown parent block %s", benv.ParentRoot)
+	}
+	if benv.Slot > s.CurrentSlot() {
+		return fmt.Errorf("block slot %d is in the future, current slot %d", benv.Slot, s.CurrentSlot())
+	}
+	if finSlot, _ := s.spec.EpochStartSlot(s.finalized.Epoch); benv.Slot <= finSlot {
+		return fmt.Errorf("block slot %d is not after finalized slot %d", benv.Slot, finSlot)
+	}
+	if _, inSubtree := s.fc.InSubtree(s.finalized.Root, benv.ParentRoot); !inSubtree {
+		return fmt.Errorf("block does not descend from finalized checkpoint %s", s.finalized)
+	}
+	status := forkchoice.ExecutionValid
+	if ps, ok := s.payloadStatuses[blockHash]; ok && blockHash != (common.Root{}) {
+		switch ps.Status {
+		case "INVALID", "INVALID_BLOCK_HASH":
+			return fmt.Errorf("invalid execution payload %s", blockHash)
+		case "SYNCING", "ACCEPTED":
+			status = forkchoice.ExecutionOptimistic
+		}
+	}
+
+	state, err := pre.CopyState()
+	if err != nil {
+		return err
+	}
+	epc, err := common.NewEpochsContext(s.spec, state)
+	if err != nil {
+		return err
+	}
+	upgradeable := &beacon.StandardUpgradeableBeaconState{BeaconState: state}
+	if err := common.StateTransition(context.Background(), s.spec, epc, upgradeable, benv, true); err != nil {
+		return err
+	}
+	post := upgradeable.BeaconState
+	s.states[benv.BlockRoot] = post
+	s.parents[benv.BlockRoot] = benv.ParentRoot
+
+	justified, err := post.CurrentJustifiedCheckpoint()
+	if err != nil {
+		return err
+	}
+	finalized, err := post.FinalizedCheckpoint()
+	if err != nil {
+		return err
+	}
+	var added bool
+	if blockHash == (common.Root{}) {
+		added = s.fc.ProcessBlock(benv.ParentRoot, benv.BlockRoot, benv.Slot, justified.Epoch, finalized.Epoch)
+	} else {
+		s.payloadBlocks[blockHash] = benv.BlockRoot
+		added = s.fc.ProcessPayloadBlock(benv.ParentRoot, benv.BlockRoot, benv.Slot, justified.Epoch, finalized.Epoch,
+			blockHash, status)
+	}
+	if !added {
+		return fmt.Errorf("fork-choice did not accept block %s", benv.BlockRoot)
+	}
+
+	// Add proposer score boost if the block is timely
+	sinceSlotStart := (s.time - s.genesisTime) % s.spec.SECONDS_PER_SLOT
+	isBeforeAttestingInterval := sinceSlotStart < s.spec.SECONDS_PER_SLOT/common.INTERVALS_PER_SLOT
+	if s.CurrentSlot() == benv.Slot && isBeforeAttestingInterval && s.proposerBoostRoot == (common.Root{}) {
+		s.proposerBoostRoot = benv.BlockRoot
+		s.votes.SetBoost(forkchoice.NodeRef{Root: benv.BlockRoot, Slot: benv.Slot})
+	}
+
+	atts := blockAttestations(benv)
+	for i := range atts {
+		if err := s.OnAttestation(&atts[i], true); err != nil {
+			return fmt.Errorf("invalid block attestation %d: %v", i, err)
+		}
+	}
+
+	if justified.Epoch > s.justified.Epoch || finalized.Epoch > s.finalized.Epoch {
+		if justified.Epoch > s.justified.Epoch {
+			s.justified = justified
+		}
+		if finalized.Epoch > s.finalized.Epoch {
+			s.finalized = finalized
+		}
+		if err := s.fc.UpdateJustified(context.Background(), benv.BlockRoot, s.justified, s.finalized,
+			s.justifiedBalances); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Store) OnAttestation(att *phase0.Attestation, isFromBlock bool) error {
+	data := &att.Data
+	target := data.Target
+	if !isFromBlock {
+		currentEpoch := s.spec.SlotToEpoch(s.CurrentSlot())
+		previousEpoch := currentEpoch.Previous()
+		if target.Epoch != currentEpoch && target.Epoch != previousEpoch {
+			return fmt.Errorf("attestation target epoch %d is not current or previous epoch", target.Epoch)
+		}
+		if s.CurrentSlot() < data.Slot+1 {
+			return fmt.Errorf("attestation slot %d is not in the past, current slot %d", data.Slot, s.CurrentSlot())
+		}
+	}
+	if target.Epoch != s.spec.SlotToEpoch(data.Slot) {
+		return errors.New("attestation target epoch does not match slot")
+	}
+	if _, ok := s.states[target.Root]; !ok {
+		return fmt.Errorf("unknown attestation target %s", target.Root)
+	}
+	blockSlot, ok := s.fc.GetSlot(data.BeaconBlockRoot)
+	if !ok {
+		return fmt.Errorf("unknown attestation head %s", data.BeaconBlockRoot)
+	}
+	if blockSlot > data.Slot {
+		return errors.New("attestation is for a block in the future")
+	}
+	targetSlot, _ := s.spec.EpochStartSlot(target.Epoch)
+	if anc, err := s.ancestor(data.BeaconBlockRoot, targetSlot); err != nil {
+		return err
+	} else if anc != target.Root {
+		return errors.New("attestation LMD vote is inconsistent with FFG target")
+	}
+	cs, err := s.checkpointState(target)
+	if err != nil {
+		return err
+	}
+	committee, err := cs.epc.GetBeaconCommittee(data.Slot, data.Index)
+	if err != nil {
+		return err
+	}
+	indexed, err := att.ConvertToIndexed(s.spec, committee)
+	if err != nil {
+		return err
+	}
+	if err := phase0.ValidateIndexedAttestation(s.spec, cs.epc, cs.state, indexed); err != nil {
+		return err
+	}
+	for _, index := range indexed.AttestingIndices {
+		// Votes are for the node of the block itself, the proto fork-choice does not track votes for empty slots.
+		// Like the spec, the latest message of a validator is the one with the newest target epoch.
+		s.votes.ProcessVote(index, forkchoice.NodeRef{Root: data.BeaconBlockRoot, Slot: blockSlot}, target.Epoch)
+	}
+	return nil
+}
+
+func (s *Store) OnAttesterSlashing(slashing *phase0.AttesterSlashing) error {
+	att1, att2 := &slashing.Attestation1, &slashing.Attestation2
+	if !phase0.IsSlashableAttestationData(&att1.Data, &att2.Data) {
+		return errors.New("attester slashing is not slashable")
+	}
+	cs, err := s.checkpointState(s.justified)
+	if err != nil {
+		return err
+	}
+	if err := phase0.ValidateIndexedAttestation(s.spec, cs.epc, cs.state, att1); err != nil {
+		return fmt.Errorf("invalid attestation 1: %v", err)
+	}
+	if err := phase0.ValidateIndexedAttestation(s.spec, cs.epc, cs.state, att2); err != nil {
+		return fmt.Errorf("invalid attestation 2: %v", err)
+	}
+	common.ValidatorSet(att1.AttestingIndices).ZigZagJoin(common.ValidatorSet(att2.AttestingIndices), func(i common.ValidatorIndex) {
+		s.votes.AddEquivocating(i)
+	}, nil)
+	return nil
+}
+
+func (s *Store) OnPayloadStatus(ps *PayloadStatus) error {
+	s.payloadStatuses[ps.BlockHash] = ps
+	root, ok := s.payloadBlocks[ps.BlockHash]
+	if !ok {
+		return nil
+	}
+	switch ps.Status {
+	case "VALID":
+		return s.fc.SetPayloadValid(root)
+	case "INVALID", "INVALID_BLOCK_HASH":
+		return s.fc.SetPayloadInvalid(root, ps.LatestValidHash)
+	}
+	return nil
+}
+
+func (s *Store) Check(t *testing.T, checks *Checks) {
+	t.Helper()
+	if checks.Time != nil && *checks.Time != s.time {
+		t.Errorf("expected time %d, got %d", *checks.Time, s.time)
+	}
+	if checks.GenesisTime != nil && *checks.GenesisTime != s.genesisTime {
+		t.Errorf("expected genesis time %d, got %d", *checks.GenesisTime, s.genesisTime)
+	}
+	if checks.Head != nil {
+		head, err := s.Head()
+		if err != nil {
+			t.Errorf("failed to get head: %v", err)
+		} else if slot, _ := s.fc.GetSlot(head.Root); head.Root != checks.Head.Root || slot != checks.Head.Slot {
+			t.Errorf("expected head %s at slot %d, got %s at slot %d", checks.Head.Root, checks.Head.Slot, head.Root, slot)
+		}
+	}
+	if checks.JustifiedCheckpoint != nil && *checks.JustifiedCheckpoint != s.justified {
+		t.Errorf("expected justified checkpoint %s, got %s", checks.JustifiedCheckpoint, &s.justified)
+	}
+	if checks.FinalizedCheckpoint != nil && *checks.FinalizedCheckpoint != s.finalized {
+		t.Errorf("expected finalized checkpoint %s, got %s", checks.FinalizedCheckpoint, &s.finalized)
+	}
+	if checks.ProposerBoostRoot != nil && *checks.ProposerBoostRoot != s.proposerBoostRoot {
+		t.Errorf("expected proposer boost root %s, got %s", *checks.ProposerBoostRoot, s.proposerBoostRoot)
+	}
+}
+
+func blockAttestations(benv *common.BeaconBlockEnvelope) phase0.Attestations {
+	switch body := benv.Body.(type) {
+	case *phase0.BeaconBlockBody:
+		return body.Attestations
+	case *altair.BeaconBlockBody:
+		return body.Attestations
+	case *bellatrix.BeaconBlockBody:
+		return body.Attestations
+	case *capella.BeaconBlockBody:
+		return body.Attestations
+	case *deneb.BeaconBlockBody:
+		return body.Attestations
+	default:
+		return nil
+	}
+}
+
+func payloadBlockHash(benv *common.BeaconBlockEnvelope) common.Root {
+	switch body := benv.Body.(type) {
+	case *bellatrix.BeaconBlockBody:
+		return body.ExecutionPayload.BlockHash
+	case *capella.BeaconBlockBody:
+		return body.ExecutionPayload.BlockHash
+	case *deneb.BeaconBlockBody:
+		return body.ExecutionPayload.BlockHash
+	default:
+		return common.Root{}
+	}
+}
+
+type anchorBlock interface {
+	common.SpecObj
+	Header(spec *common.Spec) *common.BeaconBlockHeader
+}
+
+func loadAnchorBlock(t *testing.T, forkName test_util.ForkName, readPart test_util.TestPartReader) *common.BeaconBlockHeader {
+	var dst anchorBlock
+	switch forkName {
+	case "phase0":
+		dst = new(phase0.BeaconBlock)
+	case "altair":
+		dst = new(altair.BeaconBlock)
+	case "bellatrix":
+		dst = new(bellatrix.BeaconBlock)
+	case "capella":
+		dst = new(capella.BeaconBlock)
+	case "deneb":
+		dst = new(deneb.BeaconBlock)
+	default:
+		t.Fatalf("unrecognized fork name: %s", forkName)
+		return nil
+	}
+	if !test_util.LoadSpecObj(t, "anchor_block", dst, readPart) {
+		t.Fatalf("missing anchor block")
+	}
+	return dst.Header(readPart.Spec())
+}
+
+func loadBlock(t *testing.T, forkName test_util.ForkName, name string, readPart test_util.TestPartReader,
+	genesisValRoot common.Root) *common.BeaconBlockEnvelope {
+	spec := readPart.Spec()
+	var dst beacon.OpaqueBlock
+	var version common.Version
+	switch forkName {
+	case "phase0":
+		dst, version = new(phase0.SignedBeaconBlock), spec.GENESIS_FORK_VERSION
+	case "altair":
+		dst, version = new(altair.SignedBeaconBlock), spec.ALTAIR_FORK_VERSION
+	case "bellatrix":
+		dst, version = new(bellatrix.SignedBeaconBlock), spec.BELLATRIX_FORK_VERSION
+	case "capella":
+		dst, version = new(capella.SignedBeaconBlock), spec.CAPELLA_FORK_VERSION
+	case "deneb":
+		dst, version = new(deneb.SignedBeaconBlock), spec.DENEB_FORK_VERSION
+	default:
+		t.Fatalf("unrecognized fork name: %s", forkName)
+		return nil
+	}
+	if !test_util.LoadSpecObj(t, name, dst, readPart) {
+		t.Fatalf("missing block %s", name)
+	}
+	return dst.Envelope(spec, common.ComputeForkDigest(version, genesisValRoot))
+}
+
+func runCase(t *testing.T, forkName test_util.ForkName, readPart test_util.TestPartReader) {
+	spec := readPart.Spec()
+	anchorState := test_util.LoadState(t, forkName, "anchor_state", readPart)
+	if anchorState == nil {
+		t.Fatalf("missing anchor state")
+	}
+	anchorHeader := loadAnchorBlock(t, forkName, readPart)
+	store, err := NewStore(spec, anchorState, anchorHeader)
+	test_util.Check(t, err)
+	genesisValRoot, err := anchorState.GenesisValidatorsRoot()
+	test_util.Check(t, err)
+
+	p := readPart.Part("steps.yaml")
+	var steps []Step
+	test_util.Check(t, yaml.NewDecoder(p).Decode(&steps))
+	test_util.Check(t, p.Close())
+
+	for i, step := range steps {
+		var err error
+		switch {
+		case step.Tick != nil:
+			store.OnTick(*step.Tick)
+		case step.Block != nil:
+			// Blob data availability is not checked: blobs and proofs of the step are assumed to be available,
+			// the cases with unavailable or invalid blobs are skipped.
+			benv := loadBlock(t, forkName, *step.Block, readPart, genesisValRoot)
+			err = store.OnBlock(benv, payloadBlockHash(benv))
+		case step.Attestation != nil:
+			var att phase0.Attestation
+			if !test_util.LoadSpecObj(t, *step.Attestation, &att, readPart) {
+				t.Fatalf("missing attestation %s", *step.Attestation)
+			}
+			err = store.OnAttestation(&att, false)
+		case step.AttesterSlashing != nil:
+			var slashing phase0.AttesterSlashing
+			if !test_util.LoadSpecObj(t, *step.AttesterSlashing, &slashing, readPart) {
+				t.Fatalf("missing attester slashing %s", *step.AttesterSlashing)
+			}
+			err = store.OnAttesterSlashing(&slashing)
+		case step.PayloadStatus != nil:
+			err = store.OnPayloadStatus(step.PayloadStatus)
+		case step.Checks != nil:
+			store.Check(t, step.Checks)
+			if t.Failed() {
+				t.Fatalf("checks of step %d failed", i)
+			}
+		default:
+			t.Fatalf("unrecognized step %d", i)
+		}
+		if err != nil && step.ExpectValid() {
+			t.Fatalf("step %d failed unexpectedly: %v", i, err)
+		}
+		if err == nil && !step.ExpectValid() {
+			t.Fatalf("step %d was expected to fail", i)
+		}
+	}
+}
+
+var handlers = []string{
+	"get_head",
+	"on_block",
+	"ex_ante",
+	"reorg",
+	"withholding",
+	"on_merge_block",
+	"get_proposer_head",
+	"should_override_forkchoice_update",
+}
+
+const (
+	skipUnrealized   = "unrealized justification is not implemented by the proto fork-choice"
+	skipReorg        = "proposer re-org rules are not implemented"
+	skipPowBlock     = "pow_block steps of the merge transition are not supported"
+	skipAvailability = "blob data availability is not checked"
+)
+
+// skippedCases maps the names of the test cases that are not run to the reason why.
+var skippedCases = map[string]string{
+	// get_head
+	"voting_source_within_two_epoch": skipUnrealized,
+	"voting_source_beyond_two_epoch": skipUnrealized,
+	// on_block
+	"justification_withholding":                        skipUnrealized,
+	"justification_withholding_reverse_order":          skipUnrealized,
+	"justification_update_beginning_of_epoch":          skipUnrealized,
+	"justification_update_end_of_epoch":                skipUnrealized,
+	"incompatible_justification_update_start_of_epoch": skipUnrealized,
+	"incompatible_justification_update_end_of_epoch":   skipUnrealized,
+	"pull_up_past_epoch_block":                         skipUnrealized,
+	"not_pull_up_current_epoch_block":                  skipUnrealized,
+	"pull_up_on_tick":                                  skipUnrealized,
+	"invalid_incorrect_proof":                          skipAvailability,
+	"invalid_data_unavailable":                         skipAvailability,
+	"invalid_wrong_proofs_length":                      skipAvailability,
+	"invalid_wrong_blobs_length":                       skipAvailability,
+	// reorg
+	"simple_attempted_reorg_without_enough_ffg_votes":                          skipUnrealized,
+	"simple_attempted_reorg_delayed_justification_current_epoch":               skipUnrealized,
+	"simple_attempted_reorg_delayed_justification_previous_epoch":              skipUnrealized,
+	"include_votes_another_empty_chain_with_enough_ffg_votes_current_epoch":    skipUnrealized,
+	"include_votes_another_empty_chain_with_enough_ffg_votes_previous_epoch":   skipUnrealized,
+	"include_votes_another_empty_chain_without_enough_ffg_votes_current_epoch": skipUnrealized,
+	"delayed_justification_current_epoch":                                      skipUnrealized,
+	"delayed_justification_previous_epoch":                                     skipUnrealized,
+	// withholding
+	"withholding_attack":                       skipUnrealized,
+	"withholding_attack_unviable_honest_chain": skipUnrealized,
+	// on_merge_block
+	"all_valid":           skipPowBlock,
+	"block_lookup_failed": skipPowBlock,
+	"too_early_for_merge": skipPowBlock,
+	"too_late_for_merge":  skipPowBlock,
+	// get_proposer_head
+	"basic_is_head_root":   skipReorg,
+	"basic_is_parent_root": skipReorg,
+	// should_override_forkchoice_update
+	"should_override_forkchoice_update__false": skipReorg,
+	"should_override_forkchoice_update__true":  skipReorg,
+}
+
+func TestForkChoice(t *testing.T) {
+	runner := test_util.HandleBLS(runCase)
+	caseRunner := func(t *testing.T, forkName test_util.ForkName, readPart test_util.TestPartReader) {
+		if reason, ok := skippedCases[path.Base(t.Name())]; ok {
+			t.Skip(reason)
+		}
+		runner(t, forkName, readPart)
+	}
+	for _, preset := range []*common.Spec{configs.Minimal, configs.Mainnet} {
+		spec := *preset
+		spec.ExecutionEngine = &execution.NoOpExecutionEngine{}
+		t.Run(spec.PRESET_BASE, func(t *testing.T) {
+			for _, fork := range test_util.AllForks {
+				t.Run(string(fork), func(t *testing.T) {
+					for _, handler := range handlers {
+						test_util.RunHandler(t, "fork_choice/"+handler, caseRunner, &spec, fork)
+					}
+				})
+			}
+		})
+	}
+}
@@ -0,0 +1,468 @@
+package light_client
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/protolambda/ztyp/tree"
+	"gopkg.in/yaml.v3"
+
+	"github.com/protolambda/zrnt/eth2/beacon"
+	"github.com/protolambda/zrnt/eth2/beacon/altair"
+	"github.com/protolambda/zrnt/eth2/beacon/bellatrix"
+	"github.com/protolambda/zrnt/eth2/beacon/capella"
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/protolambda/zrnt/eth2/beacon/deneb"
+	"github.com/protolambda/zrnt/eth2/beacon/phase0"
+	"github.com/protolambda/zrnt/tests/spec/test_util"
+)
+
+type LightClientData struct {
+	ForkDigest string `yaml:"fork_digest"`
+	Data       string `yaml:"data"`
+}
+
+type BootstrapCheck struct {
+	BlockRoot common.Root      `yaml:"block_root"`
+	Bootstrap *LightClientData `yaml:"bootstrap"`
+}
+
+type BestUpdateCheck struct {
+	Period uint64           `yaml:"period"`
+	Update *LightClientData `yaml:"update"`
+}
+
+type DataCollectionChecks struct {
+	LatestFinalizedCheckpoint *common.Checkpoint `yaml:"latest_finalized_checkpoint"`
+	Bootstraps                []BootstrapCheck   `yaml:"bootstraps"`
+	BestUpdates               []BestUpdateCheck  `yaml:"best_updates"`
+	LatestFinalityUpdate      *LightClientData   `yaml:"latest_finality_update"`
+	LatestOptimisticUpdate    *LightClientData   `yaml:"latest_optimistic_update"`
+}
+
+type NewHeadStep struct {
+	HeadBlockRoot common.Root          `yaml:"head_block_root"`
+	Checks        DataCollectionChecks `yaml:"checks"`
+}
+
+type DataCollectionStep struct {
+	NewBlock *LightClientData `yaml:"new_block"`
+	NewHead  *NewHeadStep     `yaml:"new_head"`
+}
+
+func newFinalityUpdate(t *testing.T, fork test_util.ForkName) lightClientUpdate {
+	switch fork {
+	case "altair", "bellatrix":
+		return new(altair.LightClientFinalityUpdate)
+	case "capella":
+		return new(capella.LightClientFinalityUpdate)
+	case "deneb":
+		return new(deneb.LightClientFinalityUpdate)
+	default:
+		t.Fatalf("unrecognized light client fork: %s", fork)
+		return nil
+	}
+}
+
+func newOptimisticUpdate(t *testing.T, fork test_util.ForkName) lightClientUpdate {
+	switch fork {
+	case "altair", "bellatrix":
+		return new(altair.LightClientOptimisticUpdate)
+	case "capella":
+		return new(capella.LightClientOptimisticUpdate)
+	case "deneb":
+		return new(deneb.LightClientOptimisticUpdate)
+	default:
+		t.Fatalf("unrecognized light client fork: %s", fork)
+		return nil
+	}
+}
+
+func newSignedBlock(t *testing.T, fork test_util.ForkName) beacon.OpaqueBlock {
+	switch fork {
+	case "phase0":
+		return new(phase0.SignedBeaconBlock)
+	case "altair":
+		return new(altair.SignedBeaconBlock)
+	case "bellatrix":
+		return new(bellatrix.SignedBeaconBlock)
+	case "capella":
+		return new(capella.SignedBeaconBlock)
+	case "deneb":
+		return new(deneb.SignedBeaconBlock)
+	default:
+		t.Fatalf("unrecognized fork name: %s", fork)
+		return nil
+	}
+}
+
+// collector replays the blocks of a data collection test, and derives the light client data
+// of the canonical chain of each new head from the replayed blocks and their post-states,
+// to compare against the data the spec collected.
+type collector struct {
+	spec           *common.Spec
+	readPart       test_util.TestPartReader
+	genesisValRoot common.Root
+
+	headers map[common.Root]common.BeaconBlockHeader
+	blocks  map[common.Root]*common.BeaconBlockEnvelope
+	states  map[common.Root]common.BeaconState
+}
+
+func (c *collector) forkOf(t *testing.T, digest string) test_util.ForkName {
+	return forkOfDigest(t, c.spec, c.genesisValRoot, digest, "")
+}
+
+func (c *collector) onBlock(t *testing.T, data *LightClientData) {
+	fork := c.forkOf(t, data.ForkDigest)
+	dst := newSignedBlock(t, fork)
+	if !test_util.LoadSpecObj(t, data.Data, dst, c.readPart) {
+		t.Fatalf("missing block %s", data.Data)
+	}
+	benv := dst.Envelope(c.spec, common.ComputeForkDigest(forkVersion(c.spec, fork), c.genesisValRoot))
+	pre, ok := c.states[benv.ParentRoot]
+	if !ok {
+		t.Fatalf("unknown parent block %s", benv.ParentRoot)
+	}
+	state, err := pre.CopyState()
+	test_util.Check(t, err)
+	epc, err := common.NewEpochsContext(c.spec, state)
+	test_util.Check(t, err)
+	upgradeable := &beacon.StandardUpgradeableBeaconState{BeaconState: state}
+	test_util.Check(t, common.StateTransition(context.Background(), c.spec, epc, upgradeable, benv, true))
+	c.headers[benv.BlockRoot] = benv.BeaconBlockHeader
+	c.blocks[benv.BlockRoot] = benv
+	c.states[benv.BlockRoot] = upgradeable.BeaconState
+}
+
+func (c *collector) syncState(t *testing.T, root common.Root) common.SyncCommitteeBeaconState {
+	state, ok := c.states[root]
+	if !ok {
+		t.Fatalf("unknown block %s", root)
+	}
+	out, ok := state.(common.SyncCommitteeBeaconState)
+	if !ok {
+		t.Fatalf("state of block %s has no sync committees", root)
+	}
+	return out
+}
+
+func (c *collector) header(t *testing.T, root common.Root) *common.BeaconBlockHeader {
+	h, ok := c.headers[root]
+	if !ok {
+		t.Fatalf("unknown block %s", root)
+	}
+	return &h
+}
+
+// capellaHeader creates the Capella light client header of a block, only the block header is used for pre-Capella blocks.
+func (c *collector) capellaHeader(t *testing.T, root common.Root) *capella.LightClientHeader {
+	header := c.header(t, root)
+	benv, ok := c.blocks[root]
+	if !ok {
+		if c.spec.SlotToEpoch(header.Slot) >= c.spec.CAPELLA_FORK_EPOCH {
+			t.Fatalf("cannot create light client header of anchor block %s without block body", root)
+		}
+		return &capella.LightClientHeader{Beacon: *header}
+	}
+	switch body := benv.Body.(type) {
+	case *capella.BeaconBlockBody:
+		h, err := capella.BlockToLightClientHeader(c.spec, &capella.BeaconBlock{
+			Slot:          header.Slot,
+			ProposerIndex: header.ProposerIndex,
+			ParentRoot:    header.ParentRoot,
+			StateRoot:     header.StateRoot,
+			Body:          *body,
+		})
+		test_util.Check(t, err)
+		return h
+	case *deneb.BeaconBlockBody:
+		t.Fatalf("cannot create capella light client header of deneb block %s", root)
+		return nil
+	default:
+		return &capella.LightClientHeader{Beacon: *header}
+	}
+}
+
+// denebHeader creates the Deneb light client header of a block, pre-Deneb blocks are upgraded from a Capella header.
+func (c *collector) denebHeader(t *testing.T, root common.Root) *deneb.LightClientHeader {
+	header := c.header(t, root)
+	if benv, ok := c.blocks[root]; ok {
+		if body, ok := benv.Body.(*deneb.BeaconBlockBody); ok {
+			h, err := deneb.BlockToLightClientHeader(c.spec, &deneb.BeaconBlock{
+				Slot:          header.Slot,
+				ProposerIndex: header.ProposerIndex,
+				ParentRoot:    header.ParentRoot,
+				StateRoot:     header.StateRoot,
+				Body:          *body,
+			})
+			test_util.Check(t, err)
+			return h
+		}
+	}
+	if c.spec.SlotToEpoch(header.Slot) >= c.spec.DENEB_FORK_EPOCH {
+		t.Fatalf("cannot create light client header of anchor block %s without block body", root)
+	}
+	return deneb.UpgradeLightClientHeader(c.capellaHeader(t, root))
+}
+
+func (c *collector) bootstrapRoot(t *testing.T, fork test_util.ForkName, root common.Root) common.Root {
+	state := c.syncState(t, root)
+	hFn := tree.GetHashFn()
+	switch fork {
+	case "altair", "bellatrix":
+		b, err := altair.NewLightClientBootstrap(c.spec, root, state)
+		test_util.Check(t, err)
+		return b.HashTreeRoot(c.spec, hFn)
+	case "capella":
+		b, err := capella.NewLightClientBootstrap(c.spec, c.capellaHeader(t, root), state)
+		test_util.Check(t, err)
+		return b.HashTreeRoot(c.spec, hFn)
+	case "deneb":
+		b, err := deneb.NewLightClientBootstrap(c.spec, c.denebHeader(t, root), state)
+		test_util.Check(t, err)
+		return b.HashTreeRoot(c.spec, hFn)
+	default:
+		t.Fatalf("unrecognized light client fork: %s", fork)
+		return common.Root{}
+	}
+}
+
+// lightClientFork is the fork of the light client data format of a block at the given slot.
+func lightClientFork(spec *common.Spec, slot common.Slot) test_util.ForkName {
+	epoch := spec.SlotToEpoch(slot)
+	switch {
+	case epoch >= spec.DENEB_FORK_EPOCH:
+		return "deneb"
+	case epoch >= spec.CAPELLA_FORK_EPOCH:
+		return "capella"
+	default:
+		return "altair"
+	}
+}
+
+func blockSyncAggregate(benv *common.BeaconBlockEnvelope) *altair.SyncAggregate {
+	switch body := benv.Body.(type) {
+	case *altair.BeaconBlockBody:
+		return &body.SyncAggregate
+	case *bellatrix.BeaconBlockBody:
+		return &body.SyncAggregate
+	case *capella.BeaconBlockBody:
+		return &body.SyncAggregate
+	case *deneb.BeaconBlockBody:
+		return &body.SyncAggregate
+	default:
+		return nil
+	}
+}
+
+// updateCandidate is a block that signs its parent block, the attested block, with enough sync committee participants.
+type updateCandidate struct {
+	attested      common.Root
+	syncAggregate *altair.SyncAggregate
+	signatureSlot common.Slot
+}
+
+// candidates lists the update candidates of the canonical chain of the head, from the head back to the anchor.
+func (c *collector) candidates(head common.Root) []updateCandidate {
+	var out []updateCandidate
+	for root := head; ; {
+		benv, ok := c.blocks[root]
+		if !ok {
+			return out
+		}
+		agg := blockSyncAggregate(benv)
+		if agg != nil && agg.SyncCommitteeBits.OnesCount() >= uint64(c.spec.MIN_SYNC_COMMITTEE_PARTICIPANTS) {
+			if _, ok := c.states[benv.ParentRoot].(common.SyncCommitteeBeaconState); ok {
+				out = append(out, updateCandidate{attested: benv.ParentRoot, syncAggregate: agg, signatureSlot: benv.Slot})
+			}
+		}
+		root = benv.ParentRoot
+	}
+}
+
+type updateRoots struct {
+	update, finality, optimistic common.Root
+}
+
+// newUpdate creates the update of the candidate in the light client data format of the given fork,
+// and returns it with the roots of the update variants.
+// The update indicates finality if the finalized block of the attested state is known.
+func (c *collector) newUpdate(t *testing.T, fork test_util.ForkName, cand *updateCandidate) (*altair.GenericLightClientUpdate, updateRoots) {
+	hFn := tree.GetHashFn()
+	state := c.syncState(t, cand.attested)
+	finalized, err := state.FinalizedCheckpoint()
+	test_util.Check(t, err)
+	_, finalizedKnown := c.headers[finalized.Root]
+	genesisFinalized := finalized.Root == (common.Root{})
+	switch fork {
+	case "altair", "bellatrix":
+		var finalizedHeader *common.BeaconBlockHeader
+		if genesisFinalized {
+			finalizedHeader = &common.BeaconBlockHeader{}
+		} else if finalizedKnown {
+			finalizedHeader = c.header(t, finalized.Root)
+		}
+		u, err := altair.NewLightClientUpdate(c.spec, state, cand.syncAggregate, cand.signatureSlot, finalizedHeader)
+		test_util.Check(t, err)
+		return u.Generic(), updateRoots{u.HashTreeRoot(c.spec, hFn), u.FinalityUpdate().HashTreeRoot(c.spec, hFn), u.OptimisticUpdate().HashTreeRoot(c.spec, hFn)}
+	case "capella":
+		var finalizedHeader *capella.LightClientHeader
+		if genesisFinalized {
+			finalizedHeader = &capella.LightClientHeader{}
+		} else if finalizedKnown {
+			finalizedHeader = c.capellaHeader(t, finalized.Root)
+		}
+		u, err := capella.NewLightClientUpdate(c.spec, c.capellaHeader(t, cand.attested), state, cand.syncAggregate, cand.signatureSlot, finalizedHeader)
+		test_util.Check(t, err)
+		return u.Generic(), updateRoots{u.HashTreeRoot(c.spec, hFn), u.FinalityUpdate().HashTreeRoot(c.spec, hFn), u.OptimisticUpdate().HashTreeRoot(c.spec, hFn)}
+	case "deneb":
+		var finalizedHeader *deneb.LightClientHeader
+		if genesisFinalized {
+			finalizedHeader = &deneb.LightClientHeader{}
+		} else if finalizedKnown {
+			finalizedHeader = c.denebHeader(t, finalized.Root)
+		}
+		u, err := deneb.NewLightClientUpdate(c.spec, c.denebHeader(t, cand.attested), state, cand.syncAggregate, cand.signatureSlot, finalizedHeader)
+		test_util.Check(t, err)
+		return u.Generic(), updateRoots{u.HashTreeRoot(c.spec, hFn), u.FinalityUpdate().HashTreeRoot(c.spec, hFn), u.OptimisticUpdate().HashTreeRoot(c.spec, hFn)}
+	default:
+		t.Fatalf("unrecognized light client fork: %s", fork)
+		return nil, updateRoots{}
+	}
+}
+
+// selectUpdates derives the light client data that a full node provides with the given head:
+// the best update of each sync committee period, ranked with is_better_update,
+// and the latest update, with the highest attested slot, and then the highest signature slot.
+// Each candidate is ranked in the data format of its attested block.
+func (c *collector) selectUpdates(t *testing.T, head common.Root) (best map[uint64]*updateCandidate, latest *updateCandidate) {
+	best = make(map[uint64]*updateCandidate)
+	bestUpdates := make(map[uint64]*altair.GenericLightClientUpdate)
+	cands := c.candidates(head)
+	for i := range cands {
+		cand := &cands[i]
+		attestedSlot := c.header(t, cand.attested).Slot
+		update, _ := c.newUpdate(t, lightClientFork(c.spec, attestedSlot), cand)
+		period := altair.ComputeSyncCommitteePeriodAtSlot(c.spec, attestedSlot)
+		if prev, ok := bestUpdates[period]; !ok || altair.IsBetterUpdate(c.spec, update, prev) {
+			best[period], bestUpdates[period] = cand, update
+		}
+		if latest == nil {
+			latest = cand
+		} else if latestSlot := c.header(t, latest.attested).Slot; attestedSlot > latestSlot ||
+			(attestedSlot == latestSlot && cand.signatureSlot > latest.signatureSlot) {
+			latest = cand
+		}
+	}
+	return best, latest
+}
+
+func (c *collector) loadUpdate(t *testing.T, data *LightClientData,
+	alloc func(t *testing.T, fork test_util.ForkName) lightClientUpdate) (test_util.ForkName, common.Root) {
+	fork := c.forkOf(t, data.ForkDigest)
+	dst := alloc(t, fork)
+	if !test_util.LoadSpecObj(t, data.Data, dst, c.readPart) {
+		t.Fatalf("missing light client data %s", data.Data)
+	}
+	return fork, dst.HashTreeRoot(c.readPart.Spec(), tree.GetHashFn())
+}
+
+func (c *collector) check(t *testing.T, head common.Root, checks *DataCollectionChecks) {
+	if checks.LatestFinalizedCheckpoint != nil {
+		finalized, err := c.syncState(t, head).FinalizedCheckpoint()
+		test_util.Check(t, err)
+		if finalized != *checks.LatestFinalizedCheckpoint {
+			t.Errorf("finalized checkpoint: expected %s, got %s", checks.LatestFinalizedCheckpoint, finalized)
+		}
+	}
+	for _, b := range checks.Bootstraps {
+		if b.Bootstrap == nil {
+			continue
+		}
+		fork := c.forkOf(t, b.Bootstrap.ForkDigest)
+		_, expected := loadBootstrap(t, fork, b.Bootstrap.Data, c.readPart)
+		if got := c.bootstrapRoot(t, fork, b.BlockRoot); got != expected {
+			t.Errorf("bootstrap of %s: expected root %s, got %s", b.BlockRoot, expected, got)
+		}
+	}
+	best, latest := c.selectUpdates(t, head)
+	for _, u := range checks.BestUpdates {
+		cand, ok := best[u.Period]
+		if u.Update == nil {
+			if ok {
+				t.Errorf("best update of period %d: expected none, got update signed at slot %d", u.Period, cand.signatureSlot)
+			}
+			continue
+		}
+		if !ok {
+			t.Errorf("best update of period %d: no update was derived", u.Period)
+			continue
+		}
+		fork, expected := c.loadUpdate(t, u.Update, newUpdate)
+		if _, got := c.newUpdate(t, fork, cand); got.update != expected {
+			t.Errorf("best update of period %d: expected root %s, got %s", u.Period, expected, got.update)
+		}
+	}
+	if data := checks.LatestFinalityUpdate; data != nil {
+		fork, expected := c.loadUpdate(t, data, newFinalityUpdate)
+		if latest == nil {
+			t.Errorf("latest finality update: no update was derived")
+		} else if _, got := c.newUpdate(t, fork, latest); got.finality != expected {
+			t.Errorf("latest finality update: expected root %s, got %s", expected, got.finality)
+		}
+	}
+	if data := checks.LatestOptimisticUpdate; data != nil {
+		fork, expected := c.loadUpdate(t, data, newOptimisticUpdate)
+		if latest == nil {
+			t.Errorf("latest optimistic update: no update was derived")
+		} else if _, got := c.newUpdate(t, fork, latest); got.optimistic != expected {
+			t.Errorf("latest optimistic update: expected root %s, got %s", expected, got.optimistic)
+		}
+	}
+}
+
+func TestDataCollection(t *testing.T) {
+	runLightClientHandler(t, "data_collection", test_util.HandleBLS(func(t *testing.T, forkName test_util.ForkName, readPart test_util.TestPartReader) {
+		spec := caseSpec(t, readPart)
+		initialState := test_util.LoadState(t, forkName, "initial_state", readPart)
+		if initialState == nil {
+			t.Fatalf("missing initial state")
+		}
+		genesisValRoot, err := initialState.GenesisValidatorsRoot()
+		test_util.Check(t, err)
+		anchor, err := initialState.LatestBlockHeader()
+		test_util.Check(t, err)
+		if anchor.StateRoot == (common.Root{}) {
+			anchor.StateRoot = initialState.HashTreeRoot(tree.GetHashFn())
+		}
+		anchorRoot := anchor.HashTreeRoot(tree.GetHashFn())
+		c := &collector{
+			spec:           spec,
+			readPart:       readPart,
+			genesisValRoot: genesisValRoot,
+			headers:        map[common.Root]common.BeaconBlockHeader{anchorRoot: *anchor},
+			blocks:         make(map[common.Root]*common.BeaconBlockEnvelope),
+			states:         map[common.Root]common.BeaconState{anchorRoot: initialState},
+		}
+
+		var steps []DataCollectionStep
+		p := readPart.Part("steps.yaml")
+		test_util.Check(t, yaml.NewDecoder(p).Decode(&steps))
+		test_util.Check(t, p.Close())
+
+		for i, step := range steps {
+			switch {
+			case step.NewBlock != nil:
+				c.onBlock(t, step.NewBlock)
+			case step.NewHead != nil:
+				t.Run(fmt.Sprintf("step_%d", i), func(t *testing.T) {
+					c.check(t, step.NewHead.HeadBlockRoot, &step.NewHead.Checks)
+				})
+			default:
+				t.Fatalf("unrecognized step %d", i)
+			}
+		}
+	}))
+}
@@ -0,0 +1,170 @@
+package light_client
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/protolambda/ztyp/tree"
+	"gopkg.in/yaml.v3"
+
+	"github.com/protolambda/zrnt/eth2/beacon/altair"
+	"github.com/protolambda/zrnt/eth2/beacon/capella"
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/protolambda/zrnt/eth2/beacon/deneb"
+	"github.com/protolambda/zrnt/eth2/configs"
+	"github.com/protolambda/zrnt/eth2/execution"
+	"github.com/protolambda/zrnt/tests/spec/test_util"
+)
+
+// The light client runners follow the test formats of the consensus-spec-tests release pinned by SPEC_VERSION
+// in the Makefile (v1.4.0). The test vectors are not part of this repository, and the runners skip without them.
+
+// Light client data starts with altair, phase0 has no light client tests.
+var lightClientForks = []test_util.ForkName{"altair", "bellatrix", "capella", "deneb"}
+
+// presetSpec returns a copy of the preset spec, as configured by the preset config.
+// Like the other runners, the fork epochs are not changed: test cases that depend on fork epochs,
+// e.g. to sign with the fork version of the test fork, include these in a config.yaml override (see caseSpec).
+func presetSpec(preset *common.Spec) *common.Spec {
+	spec := *preset
+	spec.ExecutionEngine = &execution.NoOpExecutionEngine{}
+	return &spec
+}
+
+// runLightClientHandler runs the handler for all presets and light client forks.
+func runLightClientHandler(t *testing.T, handler string, caseRunner test_util.CaseRunner) {
+	for _, preset := range []*common.Spec{configs.Minimal, configs.Mainnet} {
+		t.Run(preset.PRESET_BASE, func(t *testing.T) {
+			for _, fork := range lightClientForks {
+				t.Run(string(fork), func(t *testing.T) {
+					test_util.RunHandler(t, "light_client/"+handler, caseRunner, presetSpec(preset), fork)
+				})
+			}
+		})
+	}
+}
+
+// caseSpec applies the config overrides of the test case, if any.
+func caseSpec(t *testing.T, readPart test_util.TestPartReader) *common.Spec {
+	spec := *readPart.Spec()
+	p := readPart.Part("config.yaml")
+	if p.Exists() {
+		dec := yaml.NewDecoder(p)
+		dec.KnownFields(false)
+		test_util.Check(t, dec.Decode(&spec.Config))
+		test_util.Check(t, p.Close())
+	}
+	return &spec
+}
+
+func forkVersion(spec *common.Spec, fork test_util.ForkName) common.Version {
+	switch fork {
+	case "phase0":
+		return spec.GENESIS_FORK_VERSION
+	case "altair":
+		return spec.ALTAIR_FORK_VERSION
+	case "bellatrix":
+		return spec.BELLATRIX_FORK_VERSION
+	case "capella":
+		return spec.CAPELLA_FORK_VERSION
+	default:
+		return spec.DENEB_FORK_VERSION
+	}
+}
+
+// forkOfDigest finds the fork of an encoded fork digest. If the digest is empty, the default fork is returned.
+func forkOfDigest(t *testing.T, spec *common.Spec, genesisValRoot common.Root, digest string, def test_util.ForkName) test_util.ForkName {
+	if digest == "" {
+		return def
+	}
+	var d common.ForkDigest
+	test_util.Check(t, d.UnmarshalText([]byte(digest)))
+	for _, fork := range test_util.AllForks {
+		if common.ComputeForkDigest(forkVersion(spec, fork), genesisValRoot) == d {
+			return fork
+		}
+	}
+	t.Fatalf("unrecognized fork digest: %s", digest)
+	return ""
+}
+
+func loadBootstrap(t *testing.T, fork test_util.ForkName, name string, readPart test_util.TestPartReader) (*altair.GenericLightClientBootstrap, common.Root) {
+	spec := readPart.Spec()
+	var dst interface {
+		common.SpecObj
+		Generic() *altair.GenericLightClientBootstrap
+	}
+	switch fork {
+	case "altair", "bellatrix":
+		dst = new(altair.LightClientBootstrap)
+	case "capella":
+		dst = new(capella.LightClientBootstrap)
+	case "deneb":
+		dst = new(deneb.LightClientBootstrap)
+	default:
+		t.Fatalf("unrecognized light client fork: %s", fork)
+	}
+	if !test_util.LoadSpecObj(t, name, dst, readPart) {
+		t.Fatalf("missing bootstrap %s", name)
+	}
+	return dst.Generic(), dst.HashTreeRoot(spec, tree.GetHashFn())
+}
+
+type lightClientUpdate interface {
+	common.SpecObj
+	Generic() *altair.GenericLightClientUpdate
+}
+
+func newUpdate(t *testing.T, fork test_util.ForkName) lightClientUpdate {
+	switch fork {
+	case "altair", "bellatrix":
+		return new(altair.LightClientUpdate)
+	case "capella":
+		return new(capella.LightClientUpdate)
+	case "deneb":
+		return new(deneb.LightClientUpdate)
+	default:
+		t.Fatalf("unrecognized light client fork: %s", fork)
+		return nil
+	}
+}
+
+func loadUpdate(t *testing.T, fork test_util.ForkName, name string, readPart test_util.TestPartReader) *altair.GenericLightClientUpdate {
+	dst := newUpdate(t, fork)
+	if !test_util.LoadSpecObj(t, name, dst, readPart) {
+		t.Fatalf("missing update %s", name)
+	}
+	return dst.Generic()
+}
+
+// upgradeStore upgrades the store to the light client data format of the given fork.
+func upgradeStore(t *testing.T, store *altair.LightClientStore, fork test_util.ForkName) *altair.LightClientStore {
+	var err error
+	switch fork {
+	case "altair", "bellatrix":
+	case "capella":
+		store, err = capella.UpgradeLightClientStore(store)
+	case "deneb":
+		store, err = deneb.UpgradeLightClientStore(store)
+	default:
+		err = fmt.Errorf("unrecognized light client fork: %s", fork)
+	}
+	test_util.Check(t, err)
+	return store
+}
+
+// upgradeUpdate upgrades the update to the light client data format of the given fork.
+func upgradeUpdate(t *testing.T, update *altair.GenericLightClientUpdate, fork test_util.ForkName) *altair.GenericLightClientUpdate {
+	var err error
+	switch fork {
+	case "altair", "bellatrix":
+	case "capella":
+		update, err = capella.UpgradeGenericLightClientUpdate(update)
+	case "deneb":
+		update, err = deneb.UpgradeGenericLightClientUpdate(update)
+	default:
+		err = fmt.Errorf("unrecognized light client fork: %s", fork)
+	}
+	test_util.Check(t, err)
+	return update
+}
@@ -0,0 +1,89 @@
+package light_client
+
+import (
+	"math/bits"
+	"testing"
+
+	"github.com/protolambda/ztyp/tree"
+	"gopkg.in/yaml.v3"
+
+	"github.com/protolambda/zrnt/eth2/beacon/capella"
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/protolambda/zrnt/eth2/beacon/deneb"
+	"github.com/protolambda/zrnt/eth2/util/merkle"
+	"github.com/protolambda/zrnt/tests/spec/test_util"
+)
+
+type MerkleProof struct {
+	Leaf      common.Root   `yaml:"leaf"`
+	LeafIndex uint64        `yaml:"leaf_index"`
+	Branch    []common.Root `yaml:"branch"`
+}
+
+// loadBodyProof computes the execution payload branch of the block body, the only proof of a body in the tests.
+func loadBodyProof(t *testing.T, forkName test_util.ForkName, readPart test_util.TestPartReader) (root common.Root, branch []common.Root) {
+	spec := readPart.Spec()
+	hFn := tree.GetHashFn()
+	var execBranch capella.ExecutionBranch
+	var err error
+	switch forkName {
+	case "capella":
+		var body capella.BeaconBlockBody
+		if !test_util.LoadSpecObj(t, "object", &body, readPart) {
+			t.Fatalf("missing object")
+		}
+		root = body.HashTreeRoot(spec, hFn)
+		execBranch, err = body.ExecutionPayloadBranch(spec, hFn)
+	case "deneb":
+		var body deneb.BeaconBlockBody
+		if !test_util.LoadSpecObj(t, "object", &body, readPart) {
+			t.Fatalf("missing object")
+		}
+		root = body.HashTreeRoot(spec, hFn)
+		execBranch, err = body.ExecutionPayloadBranch(spec, hFn)
+	default:
+		t.Fatalf("no block body proofs for fork %s", forkName)
+	}
+	test_util.Check(t, err)
+	return root, execBranch[:]
+}
+
+func TestSingleMerkleProof(t *testing.T) {
+	runLightClientHandler(t, "single_merkle_proof", func(t *testing.T, forkName test_util.ForkName, readPart test_util.TestPartReader) {
+		var proof MerkleProof
+		p := readPart.Part("proof.yaml")
+		test_util.Check(t, yaml.NewDecoder(p).Decode(&proof))
+		test_util.Check(t, p.Close())
+
+		// The case runner is shared between the BeaconState and BeaconBlockBody suites,
+		// the execution payload is the only proof that is of a block body.
+		var root common.Root
+		var branch []common.Root
+		if proof.LeafIndex == uint64(capella.EXECUTION_PAYLOAD_INDEX) {
+			root, branch = loadBodyProof(t, forkName, readPart)
+		} else {
+			state := test_util.LoadState(t, forkName, "object", readPart)
+			if state == nil {
+				t.Fatalf("missing object")
+			}
+			hFn := tree.GetHashFn()
+			root = state.HashTreeRoot(hFn)
+			var err error
+			branch, err = merkle.ComputeMerkleProof(state.Backing(), tree.Gindex64(proof.LeafIndex), hFn)
+			test_util.Check(t, err)
+		}
+
+		depth := uint64(bits.Len64(proof.LeafIndex) - 1)
+		if !merkle.VerifyMerkleBranch(proof.Leaf, proof.Branch, depth, proof.LeafIndex^(1<<depth), root) {
+			t.Fatalf("expected branch does not prove leaf %s at %d", proof.Leaf, proof.LeafIndex)
+		}
+		if len(branch) != len(proof.Branch) {
+			t.Fatalf("expected branch of length %d, got %d", len(proof.Branch), len(branch))
+		}
+		for i := range branch {
+			if branch[i] != proof.Branch[i] {
+				t.Errorf("branch node %d: expected %s, got %s", i, proof.Branch[i], branch[i])
+			}
+		}
+	})
+}
@@ -0,0 +1,130 @@
+package light_client
+
+import (
+	"testing"
+
+	"github.com/protolambda/ztyp/tree"
+	"gopkg.in/yaml.v3"
+
+	"github.com/protolambda/zrnt/eth2/beacon/altair"
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/protolambda/zrnt/tests/spec/test_util"
+)
+
+type SyncMeta struct {
+	GenesisValidatorsRoot common.Root `yaml:"genesis_validators_root"`
+	TrustedBlockRoot      common.Root `yaml:"trusted_block_root"`
+	BootstrapForkDigest   string      `yaml:"bootstrap_fork_digest"`
+	StoreForkDigest       string      `yaml:"store_fork_digest"`
+}
+
+type HeaderCheck struct {
+	Slot          common.Slot  `yaml:"slot"`
+	BeaconRoot    common.Root  `yaml:"beacon_root"`
+	ExecutionRoot *common.Root `yaml:"execution_root"`
+}
+
+type Checks struct {
+	FinalizedHeader  *HeaderCheck `yaml:"finalized_header"`
+	OptimisticHeader *HeaderCheck `yaml:"optimistic_header"`
+}
+
+type ForceUpdateStep struct {
+	CurrentSlot common.Slot `yaml:"current_slot"`
+	Checks      Checks      `yaml:"checks"`
+}
+
+type ProcessUpdateStep struct {
+	UpdateForkDigest string      `yaml:"update_fork_digest"`
+	Update           string      `yaml:"update"`
+	CurrentSlot      common.Slot `yaml:"current_slot"`
+	Checks           Checks      `yaml:"checks"`
+}
+
+type UpgradeStoreStep struct {
+	StoreDataForkDigest string `yaml:"store_data_fork_digest"`
+	Checks              Checks `yaml:"checks"`
+}
+
+type SyncStep struct {
+	ForceUpdate   *ForceUpdateStep   `yaml:"force_update"`
+	ProcessUpdate *ProcessUpdateStep `yaml:"process_update"`
+	UpgradeStore  *UpgradeStoreStep  `yaml:"upgrade_store"`
+}
+
+type executionRooter interface {
+	ExecutionRoot(spec *common.Spec) common.Root
+}
+
+func checkHeader(t *testing.T, spec *common.Spec, name string, header altair.LightClientHeaderLike, expected *HeaderCheck) {
+	t.Helper()
+	if expected == nil {
+		return
+	}
+	beacon := header.BeaconHeader()
+	if beacon.Slot != expected.Slot {
+		t.Errorf("%s slot: expected %d, got %d", name, expected.Slot, beacon.Slot)
+	}
+	if root := beacon.HashTreeRoot(tree.GetHashFn()); root != expected.BeaconRoot {
+		t.Errorf("%s beacon root: expected %s, got %s", name, expected.BeaconRoot, root)
+	}
+	if expected.ExecutionRoot != nil {
+		var root common.Root
+		if h, ok := header.(executionRooter); ok {
+			root = h.ExecutionRoot(spec)
+		}
+		if root != *expected.ExecutionRoot {
+			t.Errorf("%s execution root: expected %s, got %s", name, *expected.ExecutionRoot, root)
+		}
+	}
+}
+
+func checkStore(t *testing.T, spec *common.Spec, store *altair.LightClientStore, checks *Checks) {
+	t.Helper()
+	checkHeader(t, spec, "finalized header", store.FinalizedHeader, checks.FinalizedHeader)
+	checkHeader(t, spec, "optimistic header", store.OptimisticHeader, checks.OptimisticHeader)
+}
+
+func TestSync(t *testing.T) {
+	runLightClientHandler(t, "sync", test_util.HandleBLS(func(t *testing.T, forkName test_util.ForkName, readPart test_util.TestPartReader) {
+		spec := caseSpec(t, readPart)
+
+		var meta SyncMeta
+		p := readPart.Part("meta.yaml")
+		test_util.Check(t, yaml.NewDecoder(p).Decode(&meta))
+		test_util.Check(t, p.Close())
+
+		bootstrapFork := forkOfDigest(t, spec, meta.GenesisValidatorsRoot, meta.BootstrapForkDigest, forkName)
+		storeFork := forkOfDigest(t, spec, meta.GenesisValidatorsRoot, meta.StoreForkDigest, forkName)
+
+		bootstrap, _ := loadBootstrap(t, bootstrapFork, "bootstrap", readPart)
+		store, err := altair.InitializeLightClientStore(spec, meta.TrustedBlockRoot, bootstrap)
+		test_util.Check(t, err)
+		store = upgradeStore(t, store, storeFork)
+
+		var steps []SyncStep
+		p = readPart.Part("steps.yaml")
+		test_util.Check(t, yaml.NewDecoder(p).Decode(&steps))
+		test_util.Check(t, p.Close())
+
+		for i, step := range steps {
+			switch {
+			case step.ForceUpdate != nil:
+				test_util.Check(t, store.ProcessForceUpdate(spec, step.ForceUpdate.CurrentSlot))
+				checkStore(t, spec, store, &step.ForceUpdate.Checks)
+			case step.ProcessUpdate != nil:
+				s := step.ProcessUpdate
+				updateFork := forkOfDigest(t, spec, meta.GenesisValidatorsRoot, s.UpdateForkDigest, forkName)
+				update := upgradeUpdate(t, loadUpdate(t, updateFork, s.Update, readPart), storeFork)
+				test_util.Check(t, store.ProcessUpdate(spec, update, s.CurrentSlot, meta.GenesisValidatorsRoot))
+				checkStore(t, spec, store, &s.Checks)
+			case step.UpgradeStore != nil:
+				storeFork = forkOfDigest(t, spec, meta.GenesisValidatorsRoot, step.UpgradeStore.StoreDataForkDigest, forkName)
+				store = upgradeStore(t, store, storeFork)
+				checkStore(t, spec, store, &step.UpgradeStore.Checks)
+			default:
+				t.Fatalf("unrecognized step %d", i)
+			}
+		}
+	}))
+}
@@ -0,0 +1,41 @@
+package light_client
+
+import (
+	"fmt"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/protolambda/zrnt/eth2/beacon/altair"
+	"github.com/protolambda/zrnt/tests/spec/test_util"
+)
+
+type UpdateRankingMeta struct {
+	UpdatesCount uint64 `yaml:"updates_count"`
+}
+
+func TestUpdateRanking(t *testing.T) {
+	runLightClientHandler(t, "update_ranking", test_util.HandleBLS(func(t *testing.T, forkName test_util.ForkName, readPart test_util.TestPartReader) {
+		spec := caseSpec(t, readPart)
+
+		var meta UpdateRankingMeta
+		p := readPart.Part("meta.yaml")
+		dec := yaml.NewDecoder(p)
+		dec.KnownFields(false)
+		test_util.Check(t, dec.Decode(&meta))
+		test_util.Check(t, p.Close())
+
+		updates := make([]*altair.GenericLightClientUpdate, meta.UpdatesCount)
+		for i := range updates {
+			updates[i] = loadUpdate(t, forkName, fmt.Sprintf("updates_%d", i), readPart)
+		}
+		// The updates are sorted from best to worst
+		for i := range updates {
+			for j := i + 1; j < len(updates); j++ {
+				if altair.IsBetterUpdate(spec, updates[j], updates[i]) {
+					t.Errorf("update %d is ranked better than update %d", j, i)
+				}
+			}
+		}
+	}))
+}
@@ -187,6 +187,7 @@ func init() {
 	objs["deneb"]["SignedBeaconBlock"] = func() interface{} { return new(deneb.SignedBeaconBlock) }
 	objs["deneb"]["ExecutionPayload"] = func() interface{} { return new(deneb.ExecutionPayload) }
 	objs["deneb"]["ExecutionPayloadHeader"] = func() interface{} { return new(deneb.ExecutionPayloadHeader) }
+	objs["deneb"]["BlobSidecar"] = func() interface{} { return new(deneb.BlobSidecar) }
 	objs["deneb"]["LightClientHeader"] = func() interface{} { return new(deneb.LightClientHeader) }
 	objs["deneb"]["LightClientBootstrap"] = func() interface{} { return new(deneb.LightClientBootstrap) }
 	objs["deneb"]["LightClientUpdate"] = func() interface{} { return new(deneb.LightClientUpdate) }